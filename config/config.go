@@ -1,9 +1,11 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -18,6 +20,12 @@ type Config struct {
 	RedisPort     string
 	RedisPassword string
 	JWTSecret     string
+	DevMode       bool // Relaxes the startup checks meant for production, e.g. the ledger signing key
+
+	// Ledger signing keyring: transaction hashes are signed with the key LedgerSigningKeyID
+	// names and verified with the key each transaction records
+	LedgerSigningKeys  map[string]string // Key ID to secret
+	LedgerSigningKeyID string            // Key new transactions are signed with; may be omitted with a single key
 
 	// OSS Configuration
 	OSSEndpoint        string
@@ -42,7 +50,22 @@ type Config struct {
 	AIHubMixAPIKey string
 
 	// Task Configuration
-	AutoAudit bool
+	AutoAudit             bool
+	TaskVisibilityTimeout int            // Seconds a dequeued task may stay unacknowledged before redelivery
+	TaskRetryBaseDelay    int            // Seconds before the first retry of a failed task; doubles with every attempt
+	TaskRetryMaxDelay     int            // Upper bound in seconds for the retry delay
+	TaskRolePriorities    map[string]int // Default task priority per user role, e.g. admin=3,user=2
+
+	// Worker Configuration
+	WorkerConcurrency   int            // Maximum tasks executed at once by this instance
+	ExecutorConcurrency map[string]int // Per-executor caps, e.g. jiekou_api=5 (0 or missing means only the global cap applies)
+
+	// Reconciliation Configuration
+	ReconciliationInterval int    // Hours between scheduled balance reconciliations; 0 disables them
+	ReconciliationAlertURL string // Receives a POST when a reconciliation finds critical mismatches
+
+	// Webhook Configuration
+	WebhookAllowPrivateNetworks bool // Lets webhooks and output file downloads reach loopback, private and link-local addresses; for local development only
 }
 
 func (c *Config) DSN() string {
@@ -54,6 +77,72 @@ func (c *Config) RedisFullAddr() string {
 	return fmt.Sprintf("%s:%s", c.RedisAddr, c.RedisPort)
 }
 
+// MinLedgerKeyLength is the shortest secret accepted as a ledger signing key
+const MinLedgerKeyLength = 32
+
+// LegacyLedgerKeyID is the key ID of transactions signed before the keyring existed. Its
+// secret is the JWT secret, or "default-secret" without one, as they were signed with.
+const LegacyLedgerKeyID = ""
+
+// LedgerSigningKey returns the ID and secret new transactions are signed with. Without a
+// keyring, as in dev mode, that is the legacy key.
+func (c *Config) LedgerSigningKey() (string, string, error) {
+	if len(c.LedgerSigningKeys) == 0 {
+		return LegacyLedgerKeyID, c.legacyLedgerKey(), nil
+	}
+	id := c.LedgerSigningKeyID
+	if id == "" {
+		if len(c.LedgerSigningKeys) > 1 {
+			return "", "", errors.New("LEDGER_SIGNING_KEY_ID must name one of several ledger signing keys")
+		}
+		for only := range c.LedgerSigningKeys {
+			id = only
+		}
+	}
+	secret, ok := c.LedgerSigningKeys[id]
+	if !ok {
+		return "", "", fmt.Errorf("ledger signing key %q is not in LEDGER_SIGNING_KEYS", id)
+	}
+	return id, secret, nil
+}
+
+// LedgerKey returns the secret of a ledger key ID for verification
+func (c *Config) LedgerKey(id string) (string, bool) {
+	if id == LegacyLedgerKeyID {
+		return c.legacyLedgerKey(), true
+	}
+	secret, ok := c.LedgerSigningKeys[id]
+	return secret, ok
+}
+
+func (c *Config) legacyLedgerKey() string {
+	if c.JWTSecret != "" {
+		return c.JWTSecret
+	}
+	return "default-secret"
+}
+
+// ValidateLedgerKeys checks the ledger keyring at startup. Outside dev mode a signing key of
+// at least MinLedgerKeyLength characters is required.
+func (c *Config) ValidateLedgerKeys() error {
+	for id, secret := range c.LedgerSigningKeys {
+		if id == LegacyLedgerKeyID {
+			return errors.New("ledger signing key IDs must not be empty")
+		}
+		if len(secret) < MinLedgerKeyLength && !c.DevMode {
+			return fmt.Errorf("ledger signing key %q is shorter than %d characters", id, MinLedgerKeyLength)
+		}
+	}
+	if len(c.LedgerSigningKeys) == 0 {
+		if c.DevMode {
+			return nil
+		}
+		return errors.New("LEDGER_SIGNING_KEYS is required outside dev mode")
+	}
+	_, _, err := c.LedgerSigningKey()
+	return err
+}
+
 func LoadConfig() (*Config, error) {
 	err := godotenv.Load()
 	if err != nil {
@@ -73,6 +162,10 @@ func LoadConfig() (*Config, error) {
 		RedisPort:     os.Getenv("REDIS_PORT"),
 		RedisPassword: os.Getenv("REDIS_PASSWORD"),
 		JWTSecret:     os.Getenv("JWT_SECRET"),
+		DevMode:       getEnvAsBool("DEV_MODE", false),
+
+		LedgerSigningKeys:  getEnvAsStringMap("LEDGER_SIGNING_KEYS"),
+		LedgerSigningKeyID: getEnv("LEDGER_SIGNING_KEY_ID", ""),
 
 		OSSEndpoint:        os.Getenv("OSS_ENDPOINT"),
 		OSSAccessKeyID:     os.Getenv("OSS_ACCESS_KEY_ID"),
@@ -91,7 +184,19 @@ func LoadConfig() (*Config, error) {
 		JIEKOU_API:     getEnv("JIEKOU_API", ""),
 		AIHubMixAPIKey: getEnv("AIHUBMIX_API_KEY", ""),
 
-		AutoAudit: getEnvAsBool("AUTO_AUDIT", false),
+		AutoAudit:             getEnvAsBool("AUTO_AUDIT", false),
+		TaskVisibilityTimeout: getEnvAsInt("TASK_VISIBILITY_TIMEOUT", 300),
+		TaskRetryBaseDelay:    getEnvAsInt("TASK_RETRY_BASE_DELAY", 5),
+		TaskRetryMaxDelay:     getEnvAsInt("TASK_RETRY_MAX_DELAY", 600),
+		TaskRolePriorities:    getEnvAsIntMap("TASK_ROLE_PRIORITIES"),
+
+		WorkerConcurrency:   getEnvAsInt("WORKER_CONCURRENCY", 10),
+		ExecutorConcurrency: getEnvAsIntMap("EXECUTOR_CONCURRENCY"),
+
+		ReconciliationInterval: getEnvAsInt("RECONCILIATION_INTERVAL", 24),
+		ReconciliationAlertURL: getEnv("RECONCILIATION_ALERT_URL", ""),
+
+		WebhookAllowPrivateNetworks: getEnvAsBool("WEBHOOK_ALLOW_PRIVATE_NETWORKS", false),
 	}, nil
 }
 
@@ -119,3 +224,41 @@ func getEnvAsBool(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+// getEnvAsIntMap parses a comma separated list of name=value pairs, e.g. "jiekou_api=5,remote_api=10".
+// Malformed pairs are ignored.
+func getEnvAsIntMap(key string) map[string]int {
+	result := make(map[string]int)
+	valueStr, exists := os.LookupEnv(key)
+	if !exists {
+		return result
+	}
+	for _, pair := range strings.Split(valueStr, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			result[strings.TrimSpace(name)] = n
+		}
+	}
+	return result
+}
+
+// getEnvAsStringMap parses a comma separated list of name=value pairs, e.g. "k1=secret,k2=secret".
+// Values may contain "="; malformed pairs are ignored.
+func getEnvAsStringMap(key string) map[string]string {
+	result := make(map[string]string)
+	valueStr, exists := os.LookupEnv(key)
+	if !exists {
+		return result
+	}
+	for _, pair := range strings.Split(valueStr, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		result[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return result
+}
@@ -6,15 +6,20 @@ import (
 	"aigentools-backend/internal/api/test"
 	adminOrder "aigentools-backend/internal/api/v1/admin/order"
 	adminPayment "aigentools-backend/internal/api/v1/admin/payment"
+	adminReconciliation "aigentools-backend/internal/api/v1/admin/reconciliation"
+	adminTask "aigentools-backend/internal/api/v1/admin/task"
 	adminTransaction "aigentools-backend/internal/api/v1/admin/transaction"
 	adminUser "aigentools-backend/internal/api/v1/admin/user"
+	adminWorker "aigentools-backend/internal/api/v1/admin/worker"
 	aiAssistant "aigentools-backend/internal/api/v1/ai_assistant"
 	aiModel "aigentools-backend/internal/api/v1/ai_model"
 	"aigentools-backend/internal/api/v1/auth"
 	"aigentools-backend/internal/api/v1/common/upload"
 	"aigentools-backend/internal/api/v1/payment"
+	"aigentools-backend/internal/api/v1/pipeline"
 	"aigentools-backend/internal/api/v1/task"
 	userRoutes "aigentools-backend/internal/api/v1/user"
+	"aigentools-backend/internal/api/v1/webhook"
 	"aigentools-backend/internal/database"
 	"aigentools-backend/internal/middleware"
 	"aigentools-backend/pkg/logger"
@@ -30,6 +35,9 @@ func NewRouter() (*gin.Engine, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := cfg.ValidateLedgerKeys(); err != nil {
+		return nil, err
+	}
 
 	// Initialize Logger
 	err = logger.InitLogger(&logger.Config{
@@ -65,8 +73,8 @@ func NewRouter() (*gin.Engine, error) {
 			"*", // Allow all origins for development; restrict in production
 		}, // Allow frontend origin
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Idempotency-Key"},
+		ExposeHeaders:    []string{"Content-Length", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum age for preflight requests
 	}))
@@ -84,6 +92,8 @@ func NewRouter() (*gin.Engine, error) {
 		aiModel.RegisterRoutes(v1)
 		upload.RegisterRoutes(v1)
 		task.RegisterRoutes(v1)
+		pipeline.RegisterRoutes(v1)
+		webhook.RegisterRoutes(v1)
 		payment.RegisterRoutes(v1)
 
 		authorized := v1.Group("/")
@@ -101,6 +111,9 @@ func NewRouter() (*gin.Engine, error) {
 			adminTransaction.RegisterRoutes(admin)
 			adminPayment.RegisterRoutes(admin)
 			adminOrder.RegisterRoutes(admin)
+			adminWorker.RegisterRoutes(admin)
+			adminTask.RegisterRoutes(admin)
+			adminReconciliation.RegisterRoutes(admin)
 		}
 	}
 
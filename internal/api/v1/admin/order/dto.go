@@ -1,29 +1,32 @@
 package order
 
-import "time"
+import (
+	"aigentools-backend/pkg/money"
+	"time"
+)
 
 // CreateOrderRequest 创建订单请求
 type CreateOrderRequest struct {
-	UserID uint    `json:"user_id" binding:"required"`
-	Amount float64 `json:"amount" binding:"required,gt=0"`
-	Remark string  `json:"remark"`
+	UserID uint         `json:"user_id" binding:"required"`
+	Amount money.Amount `json:"amount" binding:"required,gt=0"`
+	Remark string       `json:"remark"`
 }
 
 // OrderListItem 订单列表项
 type OrderListItem struct {
-	ID          string     `json:"id"`
-	UserID      uint       `json:"user_id"`
-	Username    string     `json:"username,omitempty"`
-	Amount      float64    `json:"amount"`
-	Status      string     `json:"status"`
-	OrderType   string     `json:"order_type"`
-	PaymentUUID string     `json:"payment_uuid,omitempty"`
-	ExternalID  string     `json:"external_id,omitempty"`
-	Remark      string     `json:"remark,omitempty"`
-	CompletedAt *time.Time `json:"completed_at,omitempty"`
-	CompletedBy uint       `json:"completed_by,omitempty"`
-	CreatedAt   time.Time  `json:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at"`
+	ID          string       `json:"id"`
+	UserID      uint         `json:"user_id"`
+	Username    string       `json:"username,omitempty"`
+	Amount      money.Amount `json:"amount"`
+	Status      string       `json:"status"`
+	OrderType   string       `json:"order_type"`
+	PaymentUUID string       `json:"payment_uuid,omitempty"`
+	ExternalID  string       `json:"external_id,omitempty"`
+	Remark      string       `json:"remark,omitempty"`
+	CompletedAt *time.Time   `json:"completed_at,omitempty"`
+	CompletedBy uint         `json:"completed_by,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
 }
 
 // OrderListResponse 订单列表响应
@@ -42,7 +45,7 @@ type OrderDetailResponse struct {
 
 // UserBrief 用户简要信息
 type UserBrief struct {
-	ID       uint    `json:"id"`
-	Username string  `json:"username"`
-	Balance  float64 `json:"balance"`
+	ID       uint         `json:"id"`
+	Username string       `json:"username"`
+	Balance  money.Amount `json:"balance"`
 }
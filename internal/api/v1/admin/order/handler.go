@@ -4,6 +4,7 @@ import (
 	"aigentools-backend/internal/models"
 	"aigentools-backend/internal/services"
 	"aigentools-backend/internal/utils"
+	"aigentools-backend/pkg/money"
 	"net/http"
 	"strconv"
 	"time"
@@ -60,12 +61,12 @@ func (h *Handler) ListOrders(c *gin.Context) {
 		}
 	}
 	if minAmountStr, exists := c.GetQuery("min_amount"); exists {
-		if minAmount, err := strconv.ParseFloat(minAmountStr, 64); err == nil {
+		if minAmount, err := money.Parse(minAmountStr); err == nil {
 			filter.MinAmount = &minAmount
 		}
 	}
 	if maxAmountStr, exists := c.GetQuery("max_amount"); exists {
-		if maxAmount, err := strconv.ParseFloat(maxAmountStr, 64); err == nil {
+		if maxAmount, err := money.Parse(maxAmountStr); err == nil {
 			filter.MaxAmount = &maxAmount
 		}
 	}
@@ -146,6 +147,10 @@ func (h *Handler) CreateOrder(c *gin.Context) {
 
 	order, err := services.CreateManualOrder(req.UserID, req.Amount, req.Remark)
 	if err != nil {
+		if err == services.ErrInvalidOrderAmount {
+			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
 		return
 	}
@@ -0,0 +1,19 @@
+package reconciliation
+
+import "aigentools-backend/internal/models"
+
+// ReportListResponse is a page of reconciliation reports
+type ReportListResponse struct {
+	Items []models.ReconciliationReport `json:"items"`
+	Total int64                         `json:"total"`
+	Page  int                           `json:"page"`
+	Limit int                           `json:"limit"`
+}
+
+// MismatchListResponse is a page of the mismatches of a report
+type MismatchListResponse struct {
+	Items []models.ReconciliationMismatch `json:"items"`
+	Total int64                           `json:"total"`
+	Page  int                             `json:"page"`
+	Limit int                             `json:"limit"`
+}
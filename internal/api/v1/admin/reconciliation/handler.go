@@ -0,0 +1,178 @@
+package reconciliation
+
+import (
+	"aigentools-backend/internal/models"
+	"aigentools-backend/internal/services"
+	"aigentools-backend/internal/utils"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListReports godoc
+// @Summary List reconciliation reports
+// @Description List the runs of the balance reconciliation, most recent first. Admin only.
+// @Tags admin
+// @Produce json
+// @Security Bearer
+// @Param page query int false "Page number (default 1)"
+// @Param limit query int false "Page size (default 20)"
+// @Success 200 {object} utils.Response{data=ReportListResponse}
+// @Failure 401 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /admin/reconciliation/reports [get]
+func ListReports(c *gin.Context) {
+	page, limit := pagination(c)
+
+	items, total, err := services.ListReconciliationReports(page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Reconciliation reports retrieved successfully", ReportListResponse{
+		Items: items,
+		Total: total,
+		Page:  page,
+		Limit: limit,
+	}))
+}
+
+// RunReconciliation godoc
+// @Summary Run a reconciliation
+// @Description Start a balance reconciliation now, in the background. Poll the returned report until it is no longer running. Admin only.
+// @Tags admin
+// @Produce json
+// @Security Bearer
+// @Success 202 {object} utils.Response{data=models.ReconciliationReport}
+// @Failure 401 {object} utils.Response
+// @Failure 409 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /admin/reconciliation/reports [post]
+func RunReconciliation(c *gin.Context) {
+	trigger := "admin"
+	if userVal, exists := c.Get("user"); exists {
+		if u, ok := userVal.(models.User); ok {
+			trigger = u.Username
+		}
+	}
+
+	report, err := services.StartReconciliation(trigger)
+	if err != nil {
+		if errors.Is(err, services.ErrReconciliationRunning) {
+			c.JSON(http.StatusConflict, utils.NewErrorResponse(http.StatusConflict, err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, utils.NewSuccessResponse("Reconciliation started", report))
+}
+
+// GetReport godoc
+// @Summary Get a reconciliation report
+// @Description Get one run of the balance reconciliation. Admin only.
+// @Tags admin
+// @Produce json
+// @Security Bearer
+// @Param id path int true "Report ID"
+// @Success 200 {object} utils.Response{data=models.ReconciliationReport}
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /admin/reconciliation/reports/{id} [get]
+func GetReport(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid report ID"))
+		return
+	}
+
+	report, err := services.GetReconciliationReport(uint(id))
+	if err != nil {
+		respondReportError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Reconciliation report retrieved successfully", report))
+}
+
+// ListMismatches godoc
+// @Summary List the mismatches of a reconciliation report
+// @Description List what a reconciliation run found, optionally filtered. Admin only.
+// @Tags admin
+// @Produce json
+// @Security Bearer
+// @Param id path int true "Report ID"
+// @Param kind query string false "Filter by kind (balance, total_consumed, held_amount, continuity, arithmetic, order_topup)"
+// @Param severity query string false "Filter by severity (warning, critical)"
+// @Param user_id query int false "Filter by user ID"
+// @Param page query int false "Page number (default 1)"
+// @Param limit query int false "Page size (default 20)"
+// @Success 200 {object} utils.Response{data=MismatchListResponse}
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /admin/reconciliation/reports/{id}/mismatches [get]
+func ListMismatches(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid report ID"))
+		return
+	}
+	if _, err := services.GetReconciliationReport(uint(id)); err != nil {
+		respondReportError(c, err)
+		return
+	}
+
+	page, limit := pagination(c)
+	filter := services.MismatchFilter{
+		Kind:     models.MismatchKind(c.Query("kind")),
+		Severity: models.MismatchSeverity(c.Query("severity")),
+		Page:     page,
+		Limit:    limit,
+	}
+	if userIDStr, exists := c.GetQuery("user_id"); exists {
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid user_id"))
+			return
+		}
+		uid := uint(userID)
+		filter.UserID = &uid
+	}
+
+	items, total, err := services.ListReconciliationMismatches(uint(id), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Reconciliation mismatches retrieved successfully", MismatchListResponse{
+		Items: items,
+		Total: total,
+		Page:  page,
+		Limit: limit,
+	}))
+}
+
+func pagination(c *gin.Context) (int, int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 20
+	}
+	return page, limit
+}
+
+func respondReportError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrReconciliationReportNotFound) {
+		c.JSON(http.StatusNotFound, utils.NewErrorResponse(http.StatusNotFound, err.Error()))
+		return
+	}
+	c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+}
@@ -0,0 +1,13 @@
+package reconciliation
+
+import "github.com/gin-gonic/gin"
+
+func RegisterRoutes(router *gin.RouterGroup) {
+	reports := router.Group("/reconciliation/reports")
+	{
+		reports.GET("", ListReports)
+		reports.POST("", RunReconciliation)
+		reports.GET("/:id", GetReport)
+		reports.GET("/:id/mismatches", ListMismatches)
+	}
+}
@@ -0,0 +1,24 @@
+package task
+
+import (
+	"aigentools-backend/internal/models"
+	"aigentools-backend/internal/services"
+)
+
+// DeadLetterListResponse is a page of the dead-letter queue
+type DeadLetterListResponse struct {
+	Items []services.DeadLetter `json:"items"`
+	Total int64                 `json:"total"`
+	Page  int                   `json:"page"`
+	Limit int                   `json:"limit"`
+}
+
+// PurgeDeadLettersResponse reports how many entries were purged
+type PurgeDeadLettersResponse struct {
+	Purged int64 `json:"purged"`
+}
+
+// SetTaskPriorityRequest changes the scheduling priority of a task
+type SetTaskPriorityRequest struct {
+	Priority models.TaskPriority `json:"priority" binding:"required,min=1,max=3" example:"3"`
+}
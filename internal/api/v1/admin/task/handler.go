@@ -0,0 +1,203 @@
+package task
+
+import (
+	"aigentools-backend/internal/models"
+	"aigentools-backend/internal/services"
+	"aigentools-backend/internal/utils"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ListDeadLetters godoc
+// @Summary List dead-lettered tasks
+// @Description List tasks that failed permanently, most recent first. Admin only.
+// @Tags admin
+// @Produce json
+// @Security Bearer
+// @Param page query int false "Page number (default 1)"
+// @Param limit query int false "Page size (default 20)"
+// @Success 200 {object} utils.Response{data=DeadLetterListResponse}
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /admin/tasks/dead-letters [get]
+func ListDeadLetters(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 20
+	}
+
+	items, total, err := services.ListDeadLetters(page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Dead letters retrieved successfully", DeadLetterListResponse{
+		Items: items,
+		Total: total,
+		Page:  page,
+		Limit: limit,
+	}))
+}
+
+// GetDeadLetter godoc
+// @Summary Inspect a dead-lettered task
+// @Description Get the dead-letter entry of a task. Admin only.
+// @Tags admin
+// @Produce json
+// @Security Bearer
+// @Param id path int true "Task ID"
+// @Success 200 {object} utils.Response{data=services.DeadLetter}
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /admin/tasks/dead-letters/{id} [get]
+func GetDeadLetter(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid task ID"))
+		return
+	}
+
+	entry, err := services.GetDeadLetter(uint(id))
+	if err != nil {
+		respondDeadLetterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Dead letter retrieved successfully", entry))
+}
+
+// RequeueDeadLetter godoc
+// @Summary Requeue a dead-lettered task
+// @Description Reset a dead-lettered task and push it to the execution queue again. Admin only.
+// @Tags admin
+// @Produce json
+// @Security Bearer
+// @Param id path int true "Task ID"
+// @Success 200 {object} utils.Response{data=models.Task}
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /admin/tasks/dead-letters/{id}/requeue [post]
+func RequeueDeadLetter(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid task ID"))
+		return
+	}
+
+	actor := services.ActorSystem
+	if userVal, exists := c.Get("user"); exists {
+		if u, ok := userVal.(models.User); ok {
+			actor = services.UserActor(u.ID)
+		}
+	}
+
+	task, err := services.RequeueDeadLetter(uint(id), actor)
+	if err != nil {
+		respondDeadLetterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Task requeued successfully", task))
+}
+
+// DeleteDeadLetter godoc
+// @Summary Remove a task from the dead-letter queue
+// @Description Drop the dead-letter entry of a task. The task itself stays failed. Admin only.
+// @Tags admin
+// @Produce json
+// @Security Bearer
+// @Param id path int true "Task ID"
+// @Success 200 {object} utils.Response
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /admin/tasks/dead-letters/{id} [delete]
+func DeleteDeadLetter(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid task ID"))
+		return
+	}
+
+	if err := services.DeleteDeadLetter(uint(id)); err != nil {
+		respondDeadLetterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Dead letter deleted successfully", nil))
+}
+
+// PurgeDeadLetters godoc
+// @Summary Purge the dead-letter queue
+// @Description Remove every entry from the dead-letter queue. The tasks themselves stay failed. Admin only.
+// @Tags admin
+// @Produce json
+// @Security Bearer
+// @Success 200 {object} utils.Response{data=PurgeDeadLettersResponse}
+// @Failure 500 {object} utils.Response
+// @Router /admin/tasks/dead-letters [delete]
+func PurgeDeadLetters(c *gin.Context) {
+	purged, err := services.PurgeDeadLetters()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Dead letters purged successfully", PurgeDeadLettersResponse{Purged: purged}))
+}
+
+// SetTaskPriority godoc
+// @Summary Change the priority of a task
+// @Description Change the scheduling priority (1 low, 2 normal, 3 high) of a task that has not started yet. A queued task moves to the new level right away. Admin only.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param id path int true "Task ID"
+// @Param request body SetTaskPriorityRequest true "New priority"
+// @Success 200 {object} utils.Response{data=models.Task}
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /admin/tasks/{id}/priority [patch]
+func SetTaskPriority(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid task ID"))
+		return
+	}
+
+	var req SetTaskPriorityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	task, err := services.SetTaskPriority(uint(id), req.Priority)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, utils.NewErrorResponse(http.StatusNotFound, "Task not found"))
+			return
+		}
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Task priority updated successfully", task))
+}
+
+func respondDeadLetterError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrDeadLetterNotFound) || errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, utils.NewErrorResponse(http.StatusNotFound, err.Error()))
+		return
+	}
+	c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+}
@@ -0,0 +1,16 @@
+package task
+
+import "github.com/gin-gonic/gin"
+
+func RegisterRoutes(router *gin.RouterGroup) {
+	deadLetters := router.Group("/tasks/dead-letters")
+	{
+		deadLetters.GET("", ListDeadLetters)
+		deadLetters.DELETE("", PurgeDeadLetters)
+		deadLetters.GET("/:id", GetDeadLetter)
+		deadLetters.POST("/:id/requeue", RequeueDeadLetter)
+		deadLetters.DELETE("/:id", DeleteDeadLetter)
+	}
+
+	router.PATCH("/tasks/:id/priority", SetTaskPriority)
+}
@@ -2,6 +2,7 @@ package transaction
 
 import (
 	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
 	"time"
 )
 
@@ -9,15 +10,21 @@ type TransactionListItem struct {
 	ID            uint                   `json:"id"`
 	CreatedAt     time.Time              `json:"created_at"`
 	UserID        uint                   `json:"user_id"`
-	Amount        float64                `json:"amount"`
-	BalanceBefore float64                `json:"balance_before"`
-	BalanceAfter  float64                `json:"balance_after"`
+	Amount        money.Amount           `json:"amount"`
+	BalanceBefore money.Amount           `json:"balance_before"`
+	BalanceAfter  money.Amount           `json:"balance_after"`
 	Reason        string                 `json:"reason"`
 	Operator      string                 `json:"operator"`
 	Type          models.TransactionType `json:"type"`
 	IPAddress     string                 `json:"ip_address"`
 	DeviceInfo    string                 `json:"device_info"`
 	Hash          string                 `json:"hash"`
+	HashVersion   int                    `json:"hash_version"`
+	PrevHash      string                 `json:"prev_hash,omitempty"`
+	KeyID         string                 `json:"key_id,omitempty"`
+	OrderID       string                 `json:"order_id,omitempty"`
+	HoldID        *uint                  `json:"hold_id,omitempty"`
+	HeldAmount    money.Amount           `json:"held_amount,omitempty"`
 }
 
 type TransactionListResponse struct {
@@ -26,3 +33,11 @@ type TransactionListResponse struct {
 	Page         int                   `json:"page"`
 	Limit        int                   `json:"limit"`
 }
+
+// LedgerMigrationListResponse is a page of ledger migrations
+type LedgerMigrationListResponse struct {
+	Items []models.LedgerMigration `json:"items"`
+	Total int64                    `json:"total"`
+	Page  int                      `json:"page"`
+	Limit int                      `json:"limit"`
+}
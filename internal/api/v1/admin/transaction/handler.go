@@ -4,6 +4,8 @@ import (
 	"aigentools-backend/internal/models"
 	"aigentools-backend/internal/services"
 	"aigentools-backend/internal/utils"
+	"aigentools-backend/pkg/money"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -86,7 +88,7 @@ func ListTransactions(c *gin.Context) {
 	}
 
 	if minAmountStr, exists := c.GetQuery("min_amount"); exists {
-		minAmount, err := strconv.ParseFloat(minAmountStr, 64)
+		minAmount, err := money.Parse(minAmountStr)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid min_amount"))
 			return
@@ -95,7 +97,7 @@ func ListTransactions(c *gin.Context) {
 	}
 
 	if maxAmountStr, exists := c.GetQuery("max_amount"); exists {
-		maxAmount, err := strconv.ParseFloat(maxAmountStr, 64)
+		maxAmount, err := money.Parse(maxAmountStr)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid max_amount"))
 			return
@@ -124,6 +126,12 @@ func ListTransactions(c *gin.Context) {
 			IPAddress:     t.IPAddress,
 			DeviceInfo:    t.DeviceInfo,
 			Hash:          t.Hash,
+			HashVersion:   t.HashVersion,
+			PrevHash:      t.PrevHash,
+			KeyID:         t.KeyID,
+			OrderID:       t.OrderID,
+			HoldID:        t.HoldID,
+			HeldAmount:    t.HeldAmount,
 		})
 	}
 
@@ -198,3 +206,149 @@ func ExportTransactions(c *gin.Context) {
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
 	c.Data(http.StatusOK, "text/csv", csvContent)
 }
+
+// VerifyTransactions godoc
+// @Summary Verify a user's transaction chain
+// @Description Walk a user's transactions in order, recompute every hash and report the first broken link. Admin only.
+// @Tags admin
+// @Produce json
+// @Security Bearer
+// @Param user_id query int true "User ID"
+// @Success 200 {object} utils.Response{data=services.ChainVerification}
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /admin/transactions/verify [get]
+func VerifyTransactions(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil || userID < 1 {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid user_id"))
+		return
+	}
+
+	result, err := services.VerifyTransactionChain(uint(userID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "Failed to verify transactions"))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Transactions verified", result))
+}
+
+// ResignTransactions godoc
+// @Summary Re-sign the ledger
+// @Description Re-sign every transaction not signed with the current ledger signing key, after a key rotation. Chains that do not verify are skipped and reported. Admin only.
+// @Tags admin
+// @Produce json
+// @Security Bearer
+// @Success 200 {object} utils.Response{data=services.ResignResult}
+// @Failure 401 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /admin/transactions/resign [post]
+func ResignTransactions(c *gin.Context) {
+	result, err := services.ResignTransactions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "Failed to re-sign transactions: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Transactions re-signed", result))
+}
+
+// MigrateLegacyTransactions godoc
+// @Summary Migrate legacy transactions into the ledger chain
+// @Description Re-sign the transactions recorded before hash chaining into their users' chains. A user whose transactions do not pass the continuity and arithmetic checks of reconciliation, or whose chained transactions do not verify, is left as they are. The run is stored and returned with one entry per user. Admin only.
+// @Tags admin
+// @Produce json
+// @Security Bearer
+// @Success 200 {object} utils.Response{data=models.LedgerMigration}
+// @Failure 401 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /admin/transactions/migrations [post]
+func MigrateLegacyTransactions(c *gin.Context) {
+	operator := "admin"
+	if userVal, exists := c.Get("user"); exists {
+		if u, ok := userVal.(models.User); ok {
+			operator = u.Username
+		}
+	}
+
+	migration, err := services.MigrateLegacyTransactions(operator)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "Failed to migrate transactions: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Legacy transactions migrated", migration))
+}
+
+// ListLedgerMigrations godoc
+// @Summary List ledger migrations
+// @Description List the runs of the legacy transaction migration without their entries, most recent first. Admin only.
+// @Tags admin
+// @Produce json
+// @Security Bearer
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20)
+// @Success 200 {object} utils.Response{data=LedgerMigrationListResponse}
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /admin/transactions/migrations [get]
+func ListLedgerMigrations(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid page number"))
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid limit number"))
+		return
+	}
+
+	migrations, total, err := services.ListLedgerMigrations(page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "Failed to list migrations"))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Ledger migrations retrieved successfully", LedgerMigrationListResponse{
+		Items: migrations,
+		Total: total,
+		Page:  page,
+		Limit: limit,
+	}))
+}
+
+// GetLedgerMigration godoc
+// @Summary Get a ledger migration
+// @Description Get one run of the legacy transaction migration with what it did for each user. Admin only.
+// @Tags admin
+// @Produce json
+// @Security Bearer
+// @Param id path int true "Migration ID"
+// @Success 200 {object} utils.Response{data=models.LedgerMigration}
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /admin/transactions/migrations/{id} [get]
+func GetLedgerMigration(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid migration ID"))
+		return
+	}
+
+	migration, err := services.GetLedgerMigration(uint(id))
+	if err != nil {
+		if errors.Is(err, services.ErrLedgerMigrationNotFound) {
+			c.JSON(http.StatusNotFound, utils.NewErrorResponse(http.StatusNotFound, err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "Failed to get migration"))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Ledger migration retrieved successfully", migration))
+}
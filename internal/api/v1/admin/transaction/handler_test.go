@@ -5,9 +5,11 @@ import (
 	"aigentools-backend/internal/database"
 	"aigentools-backend/internal/models"
 	"aigentools-backend/internal/services"
+	"aigentools-backend/pkg/money"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 	"time"
 
@@ -25,10 +27,10 @@ func setupTestDB() {
 	}
 
 	// Drop tables if exist to ensure clean state and schema update
-	db.Migrator().DropTable(&models.User{}, &models.Transaction{})
+	db.Migrator().DropTable(&models.User{}, &models.Transaction{}, &models.LedgerMigration{}, &models.LedgerMigrationEntry{})
 
 	// Migrate schema
-	err = db.AutoMigrate(&models.User{}, &models.Transaction{})
+	err = db.AutoMigrate(&models.User{}, &models.Transaction{}, &models.LedgerMigration{}, &models.LedgerMigrationEntry{})
 	if err != nil {
 		panic("failed to migrate database")
 	}
@@ -48,9 +50,9 @@ func TestListTransactions(t *testing.T) {
 	// Seed transactions
 	t1 := models.Transaction{
 		UserID:        1,
-		Amount:        100.0,
-		BalanceBefore: 0.0,
-		BalanceAfter:  100.0,
+		Amount:        money.MustParse("100"),
+		BalanceBefore: money.MustParse("0"),
+		BalanceAfter:  money.MustParse("100"),
 		Reason:        "Deposit",
 		Operator:      "admin",
 		Type:          models.TransactionTypeSystemAdmin,
@@ -59,9 +61,9 @@ func TestListTransactions(t *testing.T) {
 	}
 	t2 := models.Transaction{
 		UserID:        1,
-		Amount:        -50.0,
-		BalanceBefore: 100.0,
-		BalanceAfter:  50.0,
+		Amount:        money.MustParse("-50"),
+		BalanceBefore: money.MustParse("100"),
+		BalanceAfter:  money.MustParse("50"),
 		Reason:        "Consume",
 		Operator:      "system",
 		Type:          models.TransactionTypeUserConsume,
@@ -70,9 +72,9 @@ func TestListTransactions(t *testing.T) {
 	}
 	t3 := models.Transaction{
 		UserID:        2,
-		Amount:        200.0,
-		BalanceBefore: 0.0,
-		BalanceAfter:  200.0,
+		Amount:        money.MustParse("200"),
+		BalanceBefore: money.MustParse("0"),
+		BalanceAfter:  money.MustParse("200"),
 		Reason:        "Deposit",
 		Operator:      "admin",
 		Type:          models.TransactionTypeSystemAdmin,
@@ -146,7 +148,7 @@ func TestListTransactions(t *testing.T) {
 				json.Unmarshal(body, &resp)
 				assert.Equal(t, 200, resp.Code)
 				assert.Equal(t, int64(1), resp.Data.Total)
-				assert.Equal(t, 200.0, resp.Data.Transactions[0].Amount)
+				assert.Equal(t, money.MustParse("200"), resp.Data.Transactions[0].Amount)
 			},
 		},
 		{
@@ -161,7 +163,7 @@ func TestListTransactions(t *testing.T) {
 				json.Unmarshal(body, &resp)
 				assert.Equal(t, 200, resp.Code)
 				assert.Equal(t, int64(1), resp.Data.Total)
-				assert.Equal(t, -50.0, resp.Data.Transactions[0].Amount)
+				assert.Equal(t, money.MustParse("-50"), resp.Data.Transactions[0].Amount)
 			},
 		},
 	}
@@ -193,9 +195,9 @@ func TestExportTransactions(t *testing.T) {
 	// Seed transactions
 	t1 := models.Transaction{
 		UserID:        1,
-		Amount:        100.0,
-		BalanceBefore: 0.0,
-		BalanceAfter:  100.0,
+		Amount:        money.MustParse("100"),
+		BalanceBefore: money.MustParse("0"),
+		BalanceAfter:  money.MustParse("100"),
 		Reason:        "Deposit",
 		Operator:      "admin",
 		Type:          models.TransactionTypeSystemAdmin,
@@ -226,9 +228,9 @@ func TestGenerateTransactionCSV(t *testing.T) {
 		{
 			ID:            1,
 			UserID:        10,
-			Amount:        50.50,
-			BalanceBefore: 100,
-			BalanceAfter:  150.50,
+			Amount:        money.MustParse("50.5"),
+			BalanceBefore: money.MustParse("100"),
+			BalanceAfter:  money.MustParse("150.5"),
 			Reason:        "Test",
 			Operator:      "admin",
 			Type:          models.TransactionTypeSystemAdmin,
@@ -247,3 +249,223 @@ func TestGenerateTransactionCSV(t *testing.T) {
 	assert.Contains(t, content, "50.50")
 	assert.Contains(t, content, "127.0.0.1")
 }
+
+func TestVerifyTransactions(t *testing.T) {
+	setupTestDB()
+	gin.SetMode(gin.TestMode)
+
+	user := models.User{Username: "chained", Version: 1, IsActive: true}
+	database.DB.Create(&user)
+	meta := services.TransactionMetadata{Operator: "admin", Type: models.TransactionTypeSystemAdmin, IPAddress: "127.0.0.1"}
+	for _, amount := range []string{"100", "-30", "5.5"} {
+		_, err := services.AdjustBalance(user.ID, money.MustParse(amount), "Adjust", meta)
+		assert.NoError(t, err)
+	}
+	var transactions []models.Transaction
+	database.DB.Where("user_id = ?", user.ID).Order("id").Find(&transactions)
+	assert.Len(t, transactions, 3)
+
+	r := gin.New()
+	r.GET("/admin/transactions/verify", transaction.VerifyTransactions)
+	verify := func(query string) (int, services.ChainVerification) {
+		req, _ := http.NewRequest(http.MethodGet, "/admin/transactions/verify"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp struct {
+			Data services.ChainVerification `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Data
+	}
+
+	code, _ := verify("")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, result := verify("?user_id=" + strconv.Itoa(int(user.ID)))
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, result.Valid)
+	assert.Equal(t, 3, result.Checked)
+	assert.Nil(t, result.Break)
+
+	// Deleting a user anonymizes their transactions but keeps the chain intact
+	assert.NoError(t, services.DeleteUser(user.ID))
+	var anonymized models.Transaction
+	database.DB.First(&anonymized, transactions[0].ID)
+	assert.Empty(t, anonymized.IPAddress)
+	_, result = verify("?user_id=" + strconv.Itoa(int(user.ID)))
+	assert.True(t, result.Valid)
+
+	// Removing a transaction breaks the link of the next one
+	database.DB.Delete(&models.Transaction{}, transactions[1].ID)
+	_, result = verify("?user_id=" + strconv.Itoa(int(user.ID)))
+	assert.False(t, result.Valid)
+	assert.Equal(t, 1, result.Checked)
+	if assert.NotNil(t, result.Break) {
+		assert.Equal(t, transactions[2].ID, result.Break.TransactionID)
+		assert.Equal(t, services.ChainBreakPrevHashMismatch, result.Break.Reason)
+		assert.Equal(t, transactions[0].Hash, result.Break.ExpectedPrevHash)
+	}
+
+	// Altering an amount breaks the transaction itself
+	database.DB.Model(&models.Transaction{}).Where("id = ?", transactions[0].ID).Update("amount", money.MustParse("1000"))
+	_, result = verify("?user_id=" + strconv.Itoa(int(user.ID)))
+	if assert.NotNil(t, result.Break) {
+		assert.Equal(t, transactions[0].ID, result.Break.TransactionID)
+		assert.Equal(t, services.ChainBreakHashMismatch, result.Break.Reason)
+	}
+}
+
+func TestResignTransactions(t *testing.T) {
+	setupTestDB()
+	gin.SetMode(gin.TestMode)
+
+	// Transactions signed with the legacy key, before the keyring was configured
+	meta := services.TransactionMetadata{Operator: "admin", Type: models.TransactionTypeSystemAdmin}
+	users := make([]models.User, 2)
+	for i := range users {
+		users[i] = models.User{Username: "resign" + strconv.Itoa(i), Version: 1, IsActive: true}
+		database.DB.Create(&users[i])
+		for _, amount := range []string{"100", "-30"} {
+			_, err := services.AdjustBalance(users[i].ID, money.MustParse(amount), "Adjust", meta)
+			assert.NoError(t, err)
+		}
+	}
+	var tampered models.Transaction
+	database.DB.Where("user_id = ?", users[1].ID).Order("id").First(&tampered)
+	database.DB.Model(&tampered).Update("reason", "Changed")
+
+	t.Setenv("LEDGER_SIGNING_KEYS", "k1=0123456789abcdef0123456789abcdef")
+
+	r := gin.New()
+	r.POST("/admin/transactions/resign", transaction.ResignTransactions)
+	req, _ := http.NewRequest(http.MethodPost, "/admin/transactions/resign", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Data services.ResignResult `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, "k1", resp.Data.KeyID)
+	assert.Equal(t, 1, resp.Data.Users)
+	assert.Equal(t, 2, resp.Data.Resigned)
+	if assert.Len(t, resp.Data.Skipped, 1) {
+		assert.Equal(t, users[1].ID, resp.Data.Skipped[0].UserID)
+		assert.Equal(t, services.ChainBreakHashMismatch, resp.Data.Skipped[0].Break.Reason)
+	}
+
+	// The re-signed chain verifies with the new key, and new transactions continue it
+	_, err := services.AdjustBalance(users[0].ID, money.MustParse("1"), "Adjust", meta)
+	assert.NoError(t, err)
+	var keyIDs []string
+	database.DB.Model(&models.Transaction{}).Where("user_id = ?", users[0].ID).Order("id").Pluck("key_id", &keyIDs)
+	assert.Equal(t, []string{"k1", "k1", "k1"}, keyIDs)
+	result, err := services.VerifyTransactionChain(users[0].ID)
+	assert.NoError(t, err)
+	assert.True(t, result.Valid)
+	assert.Equal(t, 3, result.Checked)
+
+	// Dropping a key from the keyring leaves its transactions unverifiable
+	t.Setenv("LEDGER_SIGNING_KEYS", "k2=fedcba9876543210fedcba9876543210")
+	result, err = services.VerifyTransactionChain(users[0].ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, result.Break) {
+		assert.Equal(t, services.ChainBreakUnknownKey, result.Break.Reason)
+		assert.Equal(t, "k1", result.Break.KeyID)
+	}
+}
+
+func TestMigrateLegacyTransactions(t *testing.T) {
+	setupTestDB()
+	gin.SetMode(gin.TestMode)
+
+	// Hashed over a creation time in nanoseconds the database no longer has
+	users := make([]models.User, 3)
+	for i := range users {
+		users[i] = models.User{Username: "legacy" + strconv.Itoa(i), Balance: money.MustParse("70"), Version: 1, IsActive: true}
+		database.DB.Create(&users[i])
+		for _, tr := range []models.Transaction{
+			{UserID: users[i].ID, Amount: money.MustParse("100"), BalanceAfter: money.MustParse("100"), HashVersion: models.HashVersionFloat, Hash: "stale"},
+			{UserID: users[i].ID, Amount: money.MustParse("-30"), BalanceBefore: money.MustParse("100"), BalanceAfter: money.MustParse("70"), HashVersion: models.HashVersionDecimal, Hash: "stale"},
+		} {
+			database.DB.Create(&tr)
+		}
+		_, err := services.AdjustBalance(users[i].ID, money.MustParse("1"), "Adjust", services.TransactionMetadata{Operator: "admin", Type: models.TransactionTypeSystemAdmin})
+		assert.NoError(t, err)
+	}
+	result, err := services.VerifyTransactionChain(users[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, services.ChainBreakHashMismatch, result.Break.Reason)
+
+	// A chain whose chained transactions were tampered with is not covered up
+	database.DB.Model(&models.Transaction{}).Where("user_id = ? AND hash_version = ?", users[1].ID, models.HashVersionChained).Update("reason", "Changed")
+	// Neither is a legacy transaction whose balances do not follow on from the previous one
+	var edited models.Transaction
+	database.DB.Where("user_id = ? AND hash_version = ?", users[2].ID, models.HashVersionDecimal).First(&edited)
+	database.DB.Model(&edited).Updates(map[string]interface{}{"balance_before": money.MustParse("1000"), "balance_after": money.MustParse("970")})
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("user", models.User{Username: "auditor"})
+		c.Next()
+	})
+	r.POST("/admin/transactions/migrations", transaction.MigrateLegacyTransactions)
+	r.GET("/admin/transactions/migrations/:id", transaction.GetLedgerMigration)
+	migrate := func() models.LedgerMigration {
+		req, _ := http.NewRequest(http.MethodPost, "/admin/transactions/migrations", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp struct {
+			Data models.LedgerMigration `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Data
+	}
+
+	migration := migrate()
+	assert.Equal(t, "auditor", migration.Operator)
+	assert.Equal(t, 1, migration.Users)
+	assert.Equal(t, 2, migration.Migrated)
+	assert.Equal(t, 2, migration.Skipped)
+
+	// The stored report says what happened to each user
+	req, _ := http.NewRequest(http.MethodGet, "/admin/transactions/migrations/"+strconv.Itoa(int(migration.ID)), nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data models.LedgerMigration `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if assert.Len(t, resp.Data.Entries, 3) {
+		entries := resp.Data.Entries
+		assert.Equal(t, 2, entries[0].Migrated)
+		assert.Empty(t, entries[0].Skip)
+		assert.Equal(t, models.LedgerMigrationSkipChain, entries[1].Skip)
+		assert.Equal(t, 0, entries[1].Migrated)
+		assert.Equal(t, models.LedgerMigrationSkipContinuity, entries[2].Skip)
+		if assert.NotNil(t, entries[2].TransactionID) {
+			assert.Equal(t, edited.ID, *entries[2].TransactionID)
+		}
+	}
+
+	result, err = services.VerifyTransactionChain(users[0].ID)
+	assert.NoError(t, err)
+	assert.True(t, result.Valid)
+	assert.Equal(t, 3, result.Checked)
+	assert.Equal(t, 2, result.Migrated)
+	assert.Equal(t, 0, result.Legacy)
+
+	for _, user := range users[1:] {
+		var versions []int
+		database.DB.Model(&models.Transaction{}).Where("user_id = ?", user.ID).Order("id").Pluck("hash_version", &versions)
+		assert.Equal(t, []int{models.HashVersionFloat, models.HashVersionDecimal, models.HashVersionChained}, versions)
+	}
+
+	// A later run only finds the users left behind
+	migration = migrate()
+	assert.Equal(t, 0, migration.Users)
+	assert.Equal(t, 2, migration.Skipped)
+}
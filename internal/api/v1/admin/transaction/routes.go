@@ -7,4 +7,9 @@ import (
 func RegisterRoutes(router *gin.RouterGroup) {
 	router.GET("/transactions", ListTransactions)
 	router.GET("/transactions/export", ExportTransactions)
+	router.GET("/transactions/verify", VerifyTransactions)
+	router.POST("/transactions/resign", ResignTransactions)
+	router.GET("/transactions/migrations", ListLedgerMigrations)
+	router.POST("/transactions/migrations", MigrateLegacyTransactions)
+	router.GET("/transactions/migrations/:id", GetLedgerMigration)
 }
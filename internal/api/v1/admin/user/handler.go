@@ -4,6 +4,7 @@ import (
 	"aigentools-backend/internal/models"
 	"aigentools-backend/internal/services"
 	"aigentools-backend/internal/utils"
+	"aigentools-backend/pkg/money"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -13,16 +14,17 @@ import (
 )
 
 type UserListItem struct {
-	ID            uint       `json:"id"`
-	Username      string     `json:"username"`
-	Role          string     `json:"role"`
-	IsActive      bool       `json:"is_active"`
-	ActivatedAt   *time.Time `json:"activated_at,omitempty"`
-	DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
-	Balance       float64    `json:"balance"`
-	CreditLimit   float64    `json:"creditLimit"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at"`
+	ID            uint         `json:"id"`
+	Username      string       `json:"username"`
+	Role          string       `json:"role"`
+	IsActive      bool         `json:"is_active"`
+	ActivatedAt   *time.Time   `json:"activated_at,omitempty"`
+	DeactivatedAt *time.Time   `json:"deactivated_at,omitempty"`
+	Balance       money.Amount `json:"balance"`
+	CreditLimit   money.Amount `json:"creditLimit"`
+	HeldAmount    money.Amount `json:"heldAmount"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
 }
 
 type UserListResponse struct {
@@ -118,6 +120,7 @@ func ListUsers(c *gin.Context) {
 			DeactivatedAt: u.DeactivatedAt,
 			Balance:       u.Balance,
 			CreditLimit:   u.CreditLimit,
+			HeldAmount:    u.HeldAmount,
 			CreatedAt:     u.CreatedAt,
 			UpdatedAt:     u.UpdatedAt,
 		})
@@ -133,11 +136,11 @@ func ListUsers(c *gin.Context) {
 
 // UpdateUserRequest represents the request body for updating a user
 type UpdateUserRequest struct {
-	Username    *string  `json:"username,omitempty"`
-	Password    *string  `json:"password,omitempty" binding:"omitempty,min=6"`
-	Role        *string  `json:"role,omitempty" binding:"omitempty,oneof=admin user"`
-	IsActive    *bool    `json:"is_active,omitempty"`
-	CreditLimit *float64 `json:"creditLimit,omitempty"`
+	Username    *string       `json:"username,omitempty"`
+	Password    *string       `json:"password,omitempty" binding:"omitempty,min=6"`
+	Role        *string       `json:"role,omitempty" binding:"omitempty,oneof=admin user"`
+	IsActive    *bool         `json:"is_active,omitempty"`
+	CreditLimit *money.Amount `json:"creditLimit,omitempty"`
 }
 
 // UpdateUser godoc
@@ -222,6 +225,7 @@ func UpdateUser(c *gin.Context) {
 		DeactivatedAt: updatedUser.DeactivatedAt,
 		Balance:       updatedUser.Balance,
 		CreditLimit:   updatedUser.CreditLimit,
+		HeldAmount:    updatedUser.HeldAmount,
 		CreatedAt:     updatedUser.CreatedAt,
 		UpdatedAt:     updatedUser.UpdatedAt,
 	}
@@ -231,9 +235,9 @@ func UpdateUser(c *gin.Context) {
 
 // BalanceAdjustmentRequest represents the request body for adjusting user balance
 type BalanceAdjustmentRequest struct {
-	Amount float64 `json:"amount" binding:"required,gt=0"`
-	Type   string  `json:"type" binding:"required,oneof=credit debit"`
-	Reason string  `json:"reason"` // Optional as per requirement "reason: 字符串类型，记录扣减原因（可选）"
+	Amount money.Amount `json:"amount" binding:"required,gt=0"`
+	Type   string       `json:"type" binding:"required,oneof=credit debit"`
+	Reason string       `json:"reason"` // Optional as per requirement "reason: 字符串类型，记录扣减原因（可选）"
 }
 
 // AdjustBalance godoc
@@ -318,6 +322,7 @@ func AdjustBalance(c *gin.Context) {
 		DeactivatedAt: updatedUser.DeactivatedAt,
 		Balance:       updatedUser.Balance,
 		CreditLimit:   updatedUser.CreditLimit,
+		HeldAmount:    updatedUser.HeldAmount,
 		CreatedAt:     updatedUser.CreatedAt,
 		UpdatedAt:     updatedUser.UpdatedAt,
 	}
@@ -327,7 +332,7 @@ func AdjustBalance(c *gin.Context) {
 
 // DeleteUser godoc
 // @Summary Delete a user
-// @Description Delete a user permanently. Admin only.
+// @Description Delete a user permanently; their transactions are kept, anonymized, in the ledger. Admin only.
 // @Tags admin
 // @Produce json
 // @Security Bearer
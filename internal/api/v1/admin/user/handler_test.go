@@ -4,6 +4,7 @@ import (
 	"aigentools-backend/internal/api/v1/admin/user"
 	"aigentools-backend/internal/database"
 	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
 	"bytes"
 	"encoding/json"
 	"net/http"
@@ -88,7 +89,7 @@ func TestListUsers(t *testing.T) {
 				assert.NotEmpty(t, resp.Data.Users)
 				assert.Equal(t, int64(2), resp.Data.Total)
 				// Check CreditLimit field existence (default 0)
-				assert.Equal(t, 0.0, resp.Data.Users[0].CreditLimit)
+				assert.Equal(t, money.MustParse("0"), resp.Data.Users[0].CreditLimit)
 			},
 		},
 		{
@@ -144,7 +145,7 @@ func TestAdjustBalance(t *testing.T) {
 		Password:  "oldpassword",
 		Version:   1,
 		IsActive:  true,
-		Balance:   100.0,
+		Balance:   money.MustParse("100"),
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -169,20 +170,20 @@ func TestAdjustBalance(t *testing.T) {
 				}
 				json.Unmarshal(body, &resp)
 				assert.Equal(t, 200, resp.Code)
-				assert.Equal(t, 200.0, resp.Data.Balance) // 150 + 50
+				assert.Equal(t, money.MustParse("200"), resp.Data.Balance) // 150 + 50
 				assert.True(t, resp.Data.IsActive)
 
 				// Verify DB
 				var u models.User
 				database.DB.First(&u, resp.Data.ID)
-				assert.Equal(t, 200.0, u.Balance)
+				assert.Equal(t, money.MustParse("200"), u.Balance)
 
 				// Verify Transaction
 				var trans models.Transaction
 				database.DB.Last(&trans)
-				assert.Equal(t, 50.0, trans.Amount)
-				assert.Equal(t, 150.0, trans.BalanceBefore)
-				assert.Equal(t, 200.0, trans.BalanceAfter)
+				assert.Equal(t, money.MustParse("50"), trans.Amount)
+				assert.Equal(t, money.MustParse("150"), trans.BalanceBefore)
+				assert.Equal(t, money.MustParse("200"), trans.BalanceAfter)
 				assert.Equal(t, models.TransactionTypeSystemAdmin, trans.Type)
 			},
 		},
@@ -198,7 +199,7 @@ func TestAdjustBalance(t *testing.T) {
 				}
 				json.Unmarshal(body, &resp)
 				assert.Equal(t, 200, resp.Code)
-				assert.Equal(t, 0.0, resp.Data.Balance)
+				assert.Equal(t, money.MustParse("0"), resp.Data.Balance)
 				// Requirement check: AdjustBalance logic for debit might need to check if it auto-deactivates?
 				// Looking at service logic: DeductBalance doesn't explicitly deactivate unless balance becomes 0?
 				// Actually, let's check the implementation of DeductBalance/AdjustBalance logic.
@@ -218,7 +219,7 @@ func TestAdjustBalance(t *testing.T) {
 				}
 				json.Unmarshal(body, &resp)
 				assert.Equal(t, 200, resp.Code)
-				assert.Equal(t, -50.0, resp.Data.Balance)
+				assert.Equal(t, money.MustParse("-50"), resp.Data.Balance)
 			},
 		},
 		{
@@ -271,8 +272,8 @@ func TestAdjustBalance(t *testing.T) {
 				Password:    "oldpassword",
 				Version:     1,
 				IsActive:    true,
-				Balance:     150.0, // Start with 150 for consistent math
-				CreditLimit: 100.0, // Give some credit limit for negative balance tests
+				Balance:     money.MustParse("150"), // Start with 150 for consistent math
+				CreditLimit: money.MustParse("100"), // Give some credit limit for negative balance tests
 				CreatedAt:   time.Now(),
 				UpdatedAt:   time.Now(),
 			}
@@ -312,7 +313,7 @@ func TestUpdateUser(t *testing.T) {
 		Password:    "oldpassword",
 		Version:     1,
 		IsActive:    true,
-		CreditLimit: 5000.0,
+		CreditLimit: money.MustParse("5000"),
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
@@ -339,7 +340,7 @@ func TestUpdateUser(t *testing.T) {
 				assert.False(t, resp.Data.IsActive)
 				assert.NotNil(t, resp.Data.DeactivatedAt)
 				assert.Nil(t, resp.Data.ActivatedAt)
-				assert.Equal(t, 5000.0, resp.Data.CreditLimit) // Check CreditLimit
+				assert.Equal(t, money.MustParse("5000"), resp.Data.CreditLimit) // Check CreditLimit
 				// Verify DB
 				var u models.User
 				database.DB.First(&u, resp.Data.ID)
@@ -392,7 +393,7 @@ func TestUpdateUser(t *testing.T) {
 				Password:    "oldpassword",
 				Version:     1,
 				IsActive:    true,
-				CreditLimit: 5000.0,
+				CreditLimit: money.MustParse("5000"),
 				CreatedAt:   time.Now(),
 				UpdatedAt:   time.Now(),
 			}
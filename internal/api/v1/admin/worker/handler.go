@@ -0,0 +1,23 @@
+package worker
+
+import (
+	"aigentools-backend/internal/services"
+	"aigentools-backend/internal/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetWorkerStats godoc
+// @Summary Get worker pool statistics
+// @Description Get the concurrency limits and in-flight task counts per executor of the instance serving the request. Admin only.
+// @Tags admin
+// @Produce json
+// @Security Bearer
+// @Success 200 {object} utils.Response{data=services.WorkerPoolStats}
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Router /admin/workers/stats [get]
+func GetWorkerStats(c *gin.Context) {
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Worker stats retrieved successfully", services.Pool.Stats()))
+}
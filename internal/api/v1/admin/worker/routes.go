@@ -0,0 +1,7 @@
+package worker
+
+import "github.com/gin-gonic/gin"
+
+func RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("/workers/stats", GetWorkerStats)
+}
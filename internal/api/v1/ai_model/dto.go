@@ -2,6 +2,7 @@ package ai_model
 
 import (
 	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
 	"time"
 )
 
@@ -11,10 +12,15 @@ type AIModelListItem struct {
 	Description string               `json:"description"`
 	Status      models.AIModelStatus `json:"status"`
 	URL         string               `json:"url"`
-	Price       float64              `json:"price"`
+	Price       money.Amount         `json:"price"`
 	Parameters  models.JSON          `json:"parameters"`
-	CreatedAt   time.Time            `json:"created_at"`
-	UpdatedAt   time.Time            `json:"updated_at"`
+	Adapter     *models.AdapterSpec  `json:"adapter,omitempty"`
+
+	ExecutionMode  models.ExecutionMode `json:"execution_mode"`
+	Pricing        *models.PricingRule  `json:"pricing,omitempty"`
+	PricingVersion int                  `json:"pricing_version"`
+	CreatedAt      time.Time            `json:"created_at"`
+	UpdatedAt      time.Time            `json:"updated_at"`
 }
 
 type AIModelSimpleItem struct {
@@ -22,7 +28,7 @@ type AIModelSimpleItem struct {
 	Name        string               `json:"name"`
 	Description string               `json:"description"`
 	Status      models.AIModelStatus `json:"status"`
-	Price       float64              `json:"price"`
+	Price       money.Amount         `json:"price"`
 	URL         string               `json:"url"`
 	CreatedAt   time.Time            `json:"created_at"`
 	UpdatedAt   time.Time            `json:"updated_at"`
@@ -44,8 +50,12 @@ type UpdateModelRequest struct {
 	Description string               `json:"description"`
 	Status      models.AIModelStatus `json:"status" binding:"omitempty,oneof=open closed draft"`
 	URL         string               `json:"url"`
-	Price       *float64             `json:"price"`
+	Price       *money.Amount        `json:"price"`
 	Parameters  models.JSON          `json:"parameters"`
+	Adapter     *models.AdapterSpec  `json:"adapter"` // Replaces the adapter spec when set
+
+	ExecutionMode models.ExecutionMode `json:"execution_mode" binding:"omitempty,oneof=async-poll sync-inline sync-binary"`
+	Pricing       *models.PricingRule  `json:"pricing"` // Replaces the pricing rule when set
 }
 
 type CreateModelRequest struct {
@@ -53,6 +63,26 @@ type CreateModelRequest struct {
 	Description string               `json:"description"`
 	Status      models.AIModelStatus `json:"status" binding:"required,oneof=open closed draft"`
 	URL         string               `json:"url"`
-	Price       float64              `json:"price"`
+	Price       money.Amount         `json:"price"`
 	Parameters  models.JSON          `json:"parameters"`
+	Adapter     *models.AdapterSpec  `json:"adapter"` // Runs the model through the generic adapter executor
+
+	ExecutionMode models.ExecutionMode `json:"execution_mode" binding:"omitempty,oneof=async-poll sync-inline sync-binary"` // Defaults to async-poll
+	Pricing       *models.PricingRule  `json:"pricing"`                                                                     // Prices tasks by their input instead of the flat price
+}
+
+type AdapterDryRunRequest struct {
+	Adapter        *models.AdapterSpec  `json:"adapter" binding:"required"`
+	ExecutionMode  models.ExecutionMode `json:"execution_mode" binding:"omitempty,oneof=async-poll sync-inline sync-binary"` // Defaults to async-poll
+	ModelURL       string               `json:"model_url"`                                                                   // Fills {{model_url}} in the query URL
+	SubmitResponse interface{}          `json:"submit_response"`                                                             // Sample body returned on submission
+	QueryResponse  interface{}          `json:"query_response"`                                                              // Sample body returned by the status URL; not used by sync models
+}
+
+type SchemaImportRequest struct {
+	Schema map[string]interface{} `json:"schema" binding:"required"` // JSON Schema object with one property per request parameter
+}
+
+type QuoteRequest struct {
+	Body map[string]interface{} `json:"body" binding:"required"` // Task body as it would be submitted
 }
@@ -5,12 +5,15 @@ import (
 	"aigentools-backend/internal/services"
 	"aigentools-backend/internal/utils"
 	"aigentools-backend/pkg/logger"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 // GetModels godoc
@@ -91,8 +94,13 @@ func GetModels(c *gin.Context) {
 			URL:         m.URL,
 			Price:       m.Price,
 			Parameters:  m.Parameters,
-			CreatedAt:   m.CreatedAt,
-			UpdatedAt:   m.UpdatedAt,
+			Adapter:     m.Adapter,
+
+			ExecutionMode:  m.ExecutionMode,
+			Pricing:        m.Pricing,
+			PricingVersion: m.PricingVersion,
+			CreatedAt:      m.CreatedAt,
+			UpdatedAt:      m.UpdatedAt,
 		})
 	}
 
@@ -201,6 +209,10 @@ func CreateModel(c *gin.Context) {
 		URL:         req.URL,
 		Price:       req.Price,
 		Parameters:  req.Parameters,
+		Adapter:     req.Adapter,
+
+		ExecutionMode: req.ExecutionMode,
+		Pricing:       req.Pricing,
 	}
 
 	if model.Parameters == nil {
@@ -217,6 +229,14 @@ func CreateModel(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid parameters: "+err.Error()))
 		return
 	}
+	if err := services.ValidateModelExecution(&model); err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+	if err := services.ValidateModelPricing(&model); err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
 
 	// Log sensitive operation
 	log.Printf("[SECURITY AUDIT] User %s (ID: %d) is creating model %s", user.Username, user.ID, req.Name)
@@ -234,8 +254,13 @@ func CreateModel(c *gin.Context) {
 		URL:         model.URL,
 		Price:       model.Price,
 		Parameters:  model.Parameters,
-		CreatedAt:   model.CreatedAt,
-		UpdatedAt:   model.UpdatedAt,
+		Adapter:     model.Adapter,
+
+		ExecutionMode:  model.ExecutionMode,
+		Pricing:        model.Pricing,
+		PricingVersion: model.PricingVersion,
+		CreatedAt:      model.CreatedAt,
+		UpdatedAt:      model.UpdatedAt,
 	}
 
 	c.JSON(http.StatusCreated, utils.NewSuccessResponse("Model created successfully", responseItem))
@@ -383,6 +408,23 @@ func UpdateModel(c *gin.Context) {
 			return
 		}
 	}
+	if req.ExecutionMode != "" {
+		model.ExecutionMode = req.ExecutionMode
+	}
+	if req.Adapter != nil {
+		model.Adapter = req.Adapter
+	}
+	if req.Pricing != nil {
+		model.Pricing = req.Pricing
+	}
+	if err := services.ValidateModelExecution(model); err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+	if err := services.ValidateModelPricing(model); err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
 
 	log.Printf("[SECURITY AUDIT] User %s (ID: %d) is updating model %d", user.Username, user.ID, id)
 
@@ -399,9 +441,212 @@ func UpdateModel(c *gin.Context) {
 		URL:         model.URL,
 		Price:       model.Price,
 		Parameters:  model.Parameters,
-		CreatedAt:   model.CreatedAt,
-		UpdatedAt:   model.UpdatedAt,
+		Adapter:     model.Adapter,
+
+		ExecutionMode:  model.ExecutionMode,
+		Pricing:        model.Pricing,
+		PricingVersion: model.PricingVersion,
+		CreatedAt:      model.CreatedAt,
+		UpdatedAt:      model.UpdatedAt,
 	}
 
 	c.JSON(http.StatusOK, utils.NewSuccessResponse("Model updated successfully", responseItem))
 }
+
+// DryRunAdapter godoc
+// @Summary Try an adapter spec against sample responses
+// @Description Validate an adapter spec and show what it extracts from a sample submit response and a sample status response. Nothing is sent upstream. Admin only.
+// @Tags models
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param request body AdapterDryRunRequest true "Adapter spec and sample responses"
+// @Success 200 {object} utils.Response{data=services.AdapterDryRun}
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Router /models/adapter/dry-run [post]
+func DryRunAdapter(c *gin.Context) {
+	var req AdapterDryRunRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	userVal, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+	user := userVal.(models.User)
+
+	if user.Role != "admin" {
+		c.JSON(http.StatusForbidden, utils.NewErrorResponse(http.StatusForbidden, "Only admin can test adapters"))
+		return
+	}
+
+	result, err := services.DryRunAdapter(req.Adapter, req.ExecutionMode, req.ModelURL, req.SubmitResponse, req.QueryResponse)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Success", result))
+}
+
+// GetModelSchema godoc
+// @Summary Get the request parameters of a model as JSON Schema
+// @Description Render the request_body parameter definitions of a model as a JSON Schema (draft 2020-12) object, for form builders and client generators.
+// @Tags models
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param id path int true "Model ID"
+// @Success 200 {object} utils.Response{data=models.JSON}
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /models/{id}/schema [get]
+func GetModelSchema(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid model ID"))
+		return
+	}
+
+	model, err := services.GetAIModelByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, utils.NewErrorResponse(http.StatusNotFound, "Model not found"))
+		return
+	}
+
+	schema, err := services.ModelRequestSchema(model)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "Failed to render model schema"))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Success", schema))
+}
+
+// GetModelsOpenAPI godoc
+// @Summary Get an OpenAPI document for the open models
+// @Description Return an OpenAPI 3.1 document with one task submission operation per open model. The document itself is the response body, so client generators can read it directly.
+// @Tags models
+// @Produce json
+// @Security Bearer
+// @Success 200 {object} models.JSON
+// @Failure 500 {object} utils.Response
+// @Router /models/openapi.json [get]
+func GetModelsOpenAPI(c *gin.Context) {
+	modelsList, err := services.GetOpenModelsWithParameters()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "Failed to fetch models"))
+		return
+	}
+
+	serverURL := strings.TrimSuffix(c.FullPath(), "/models/openapi.json")
+	doc, err := services.BuildModelsOpenAPI(modelsList, serverURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "Failed to render OpenAPI document"))
+		return
+	}
+
+	c.JSON(http.StatusOK, doc)
+}
+
+// ImportModelSchema godoc
+// @Summary Convert a JSON Schema to model parameters
+// @Description Convert a JSON Schema object to parameter definitions, returned as a parameters value ready for creating or updating a model. Admin only.
+// @Tags models
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param request body SchemaImportRequest true "JSON Schema of the request body"
+// @Success 200 {object} utils.Response{data=models.JSON}
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Router /models/schema/import [post]
+func ImportModelSchema(c *gin.Context) {
+	var req SchemaImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	userVal, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+	user := userVal.(models.User)
+
+	if user.Role != "admin" {
+		c.JSON(http.StatusForbidden, utils.NewErrorResponse(http.StatusForbidden, "Only admin can import schemas"))
+		return
+	}
+
+	defs, err := models.ParametersFromJSONSchema(req.Schema)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid schema: "+err.Error()))
+		return
+	}
+
+	params := models.JSON{
+		"request_header":      []interface{}{},
+		"request_body":        defs,
+		"response_parameters": []interface{}{},
+	}
+	if err := models.ValidateModelParameters(params); err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid schema: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Success", params))
+}
+
+// QuoteModelTask godoc
+// @Summary Quote the price of a task
+// @Description Compute what a task body would be charged for the model when submitted now. The body is validated like on submission. Pass the price as quoted_price on submission to be charged exactly that.
+// @Tags models
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param id path int true "Model ID"
+// @Param request body QuoteRequest true "Task body"
+// @Success 200 {object} utils.Response{data=services.TaskQuote}
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /models/{id}/quote [post]
+func QuoteModelTask(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid model ID"))
+		return
+	}
+
+	var req QuoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	quote, err := services.QuoteTask(uint(id), req.Body)
+	if err != nil {
+		var inputErr *services.TaskInputError
+		switch {
+		case errors.As(err, &inputErr):
+			c.JSON(http.StatusBadRequest, utils.NewValidationErrorResponse("Invalid task input", inputErr.Errors))
+		case errors.Is(err, services.ErrPricing):
+			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, utils.NewErrorResponse(http.StatusNotFound, "Model not found"))
+		default:
+			c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "Failed to quote task"))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Success", quote))
+}
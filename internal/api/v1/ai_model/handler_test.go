@@ -453,3 +453,200 @@ func TestUpdateModelURL(t *testing.T) {
 		assert.Equal(t, "Original Model", resp.Data.Name) // Name should remain unchanged
 	})
 }
+
+func TestCreateModelWithAdapter(t *testing.T) {
+	setupTestDB()
+	gin.SetMode(gin.TestMode)
+
+	adminUser := models.User{Username: "admin", Role: "admin"}
+	database.DB.Create(&adminUser)
+
+	create := func(adapter *models.AdapterSpec) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set("user", adminUser)
+
+		payload := ai_model.CreateModelRequest{
+			Name:    "Adapter Model",
+			Status:  models.AIModelStatusDraft,
+			URL:     "https://api.example.com/v1/gen",
+			Adapter: adapter,
+		}
+		jsonBytes, _ := json.Marshal(payload)
+		c.Request, _ = http.NewRequest("POST", "/models/create", bytes.NewBuffer(jsonBytes))
+		ai_model.CreateModel(c)
+		return w
+	}
+
+	spec := &models.AdapterSpec{
+		Query:         models.AdapterRequest{URL: "{{model_url}}/{{remote_id}}"},
+		RemoteIDPath:  "$.id",
+		StatusPath:    "$.status",
+		SuccessValues: []string{"succeeded"},
+		ArtifactPaths: []string{"$.output[*]"},
+	}
+	w := create(spec)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var stored models.AIModel
+	database.DB.Where("name = ?", "Adapter Model").First(&stored)
+	if assert.NotNil(t, stored.Adapter) {
+		assert.Equal(t, "$.id", stored.Adapter.RemoteIDPath)
+	}
+
+	// Incomplete specs are rejected
+	w = create(&models.AdapterSpec{RemoteIDPath: "$.id"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "status_path is required")
+
+	// Credentials only come from provider variables, never from other server settings
+	spec.Auth = &models.AdapterAuth{Header: "Authorization", Env: "JWT_SECRET"}
+	w = create(spec)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "must start with ADAPTER_KEY_")
+}
+
+func TestDryRunAdapter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	body := `{
+		"adapter": {
+			"query": {"url": "{{model_url}}/tasks/{{remote_id}}"},
+			"remote_id_path": "$.data.task_id",
+			"status_path": "$.data.status",
+			"success_values": ["SUCCEEDED"],
+			"failure_values": ["FAILED"],
+			"error_path": "$.data.reason",
+			"artifact_paths": ["$.data.videos[*].url"]
+		},
+		"model_url": "https://api.example.com",
+		"submit_response": {"data": {"task_id": "t-1"}},
+		"query_response": {"data": {"status": "failed", "reason": "quota exceeded"}}
+	}`
+
+	tests := []struct {
+		name         string
+		user         models.User
+		expectedCode int
+	}{
+		{"Admin", models.User{Username: "admin", Role: "admin"}, http.StatusOK},
+		{"Normal user", models.User{Username: "user", Role: "user"}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("user", tt.user)
+			c.Request, _ = http.NewRequest("POST", "/models/adapter/dry-run", bytes.NewBufferString(body))
+
+			ai_model.DryRunAdapter(c)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Data map[string]interface{} `json:"data"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			assert.Equal(t, "t-1", resp.Data["remote_id"])
+			assert.Equal(t, "https://api.example.com/tasks/t-1", resp.Data["query_url"])
+			assert.Equal(t, "failed", resp.Data["outcome"])
+			assert.Equal(t, "quota exceeded", resp.Data["error"])
+		})
+	}
+}
+
+func TestModelSchemaEndpoints(t *testing.T) {
+	setupTestDB()
+	gin.SetMode(gin.TestMode)
+
+	params := models.JSON{
+		"request_header": []interface{}{},
+		"request_body": []interface{}{
+			map[string]interface{}{"name": "prompt", "type": "string", "required": true, "description": "Prompt", "example": "a cat", "max": 500},
+			map[string]interface{}{"name": "steps", "type": "integer", "required": false, "description": "Steps", "example": 20, "min": 1, "max": 50},
+		},
+		"response_parameters": []interface{}{},
+	}
+	open := models.AIModel{Name: "Open Model", Status: models.AIModelStatusOpen, Parameters: params, ExecutionMode: models.ExecutionModeSyncInline}
+	closed := models.AIModel{Name: "Closed Model", Status: models.AIModelStatusClosed, Parameters: params}
+	database.DB.Create(&open)
+	database.DB.Create(&closed)
+
+	var schema map[string]interface{}
+	t.Run("Schema of a model", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "id", Value: fmt.Sprint(open.ID)}}
+		c.Request, _ = http.NewRequest("GET", "/models/"+fmt.Sprint(open.ID)+"/schema", nil)
+
+		ai_model.GetModelSchema(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		schema = resp.Data
+		assert.Equal(t, models.JSONSchemaDialect, schema["$schema"])
+		assert.Equal(t, "Open Model", schema["title"])
+		assert.Equal(t, []interface{}{"prompt"}, schema["required"])
+		steps := schema["properties"].(map[string]interface{})["steps"].(map[string]interface{})
+		assert.Equal(t, "integer", steps["type"])
+		assert.Equal(t, 50.0, steps["maximum"])
+	})
+
+	t.Run("OpenAPI document lists open models only", func(t *testing.T) {
+		router := gin.New()
+		router.GET("/api/v1/models/openapi.json", ai_model.GetModelsOpenAPI)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/models/openapi.json", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var doc map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &doc)
+		assert.Equal(t, "3.1.0", doc["openapi"])
+		assert.Equal(t, "/api/v1", doc["servers"].([]interface{})[0].(map[string]interface{})["url"])
+
+		paths := doc["paths"].(map[string]interface{})
+		assert.Len(t, paths, 1)
+		op := paths[fmt.Sprintf("/models/%d/tasks", open.ID)].(map[string]interface{})["post"].(map[string]interface{})
+		assert.Equal(t, fmt.Sprintf("submitModel%dTask", open.ID), op["operationId"])
+		assert.Len(t, op["parameters"], 1) // wait, as the model is sync
+
+		inputs := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+		assert.Contains(t, inputs, fmt.Sprintf("Model%dInput", open.ID))
+	})
+
+	t.Run("Import the exported schema", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]interface{}{"schema": schema})
+		for _, user := range []models.User{{Username: "user", Role: "user"}, {Username: "admin", Role: "admin"}} {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("user", user)
+			c.Request, _ = http.NewRequest("POST", "/models/schema/import", bytes.NewBuffer(body))
+
+			ai_model.ImportModelSchema(c)
+
+			if user.Role != "admin" {
+				assert.Equal(t, http.StatusForbidden, w.Code)
+				continue
+			}
+			assert.Equal(t, http.StatusOK, w.Code)
+			var resp struct {
+				Data models.JSON `json:"data"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			assert.NoError(t, models.ValidateModelParameters(resp.Data))
+			assert.JSONEq(t, mustJSON(params["request_body"]), mustJSON(resp.Data["request_body"]))
+		}
+	})
+}
+
+func mustJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -12,9 +12,14 @@ func RegisterRoutes(router *gin.RouterGroup) {
 	{
 		modelGroup.GET("", GetModels)
 		modelGroup.GET("/names", GetModelNames)
+		modelGroup.GET("/openapi.json", GetModelsOpenAPI)
 		modelGroup.GET("/:id/parameters", GetModelParameters)
+		modelGroup.GET("/:id/schema", GetModelSchema)
+		modelGroup.POST("/:id/quote", QuoteModelTask)
 		modelGroup.PATCH("/:id/status", UpdateModelStatus)
 		modelGroup.PUT("/:id", UpdateModel)
 		modelGroup.POST("/create", CreateModel)
+		modelGroup.POST("/adapter/dry-run", DryRunAdapter)
+		modelGroup.POST("/schema/import", ImportModelSchema)
 	}
 }
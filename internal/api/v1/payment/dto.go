@@ -1,10 +1,12 @@
 package payment
 
+import "aigentools-backend/pkg/money"
+
 type CreatePaymentRequest struct {
-	Amount            float64 `json:"amount" binding:"required,gt=0"`
-	PaymentMethodUUID string  `json:"payment_method_uuid" binding:"required"`
-	PaymentChannel    string  `json:"payment_channel" binding:"required,oneof=alipay wxpay"` // alipay or wxpay
-	ReturnURL         string  `json:"return_url" binding:"required"`
+	Amount            money.Amount `json:"amount" binding:"required,gt=0"`
+	PaymentMethodUUID string       `json:"payment_method_uuid" binding:"required"`
+	PaymentChannel    string       `json:"payment_channel" binding:"required,oneof=alipay wxpay"` // alipay or wxpay
+	ReturnURL         string       `json:"return_url" binding:"required"`
 }
 
 type CreatePaymentResponse struct {
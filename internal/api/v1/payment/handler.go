@@ -58,6 +58,10 @@ func (h *Handler) CreatePayment(c *gin.Context) {
 
 	order, err := services.CreatePaymentOrder(userID, req.Amount, req.PaymentMethodUUID)
 	if err != nil {
+		if err == services.ErrInvalidOrderAmount {
+			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
 		return
 	}
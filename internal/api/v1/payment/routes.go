@@ -18,6 +18,6 @@ func RegisterRoutes(r *gin.RouterGroup) {
 	auth.Use(middleware.AuthMiddleware())
 	{
 		auth.GET("/methods", h.GetPaymentMethods)
-		auth.POST("/create", h.CreatePayment)
+		auth.POST("/create", middleware.Idempotency(), h.CreatePayment)
 	}
 }
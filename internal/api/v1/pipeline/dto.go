@@ -0,0 +1,13 @@
+package pipeline
+
+import "aigentools-backend/internal/models"
+
+type CreatePipelineRequest struct {
+	Name  string                `json:"name" binding:"max=255" example:"image to video"`
+	Steps []models.PipelineStep `json:"steps" binding:"required,min=1"`
+}
+
+type PipelineListResponse struct {
+	Total int64             `json:"total"`
+	Items []models.Pipeline `json:"items"`
+}
@@ -0,0 +1,139 @@
+package pipeline
+
+import (
+	"aigentools-backend/internal/models"
+	"aigentools-backend/internal/services"
+	"aigentools-backend/internal/utils"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreatePipeline godoc
+// @Summary Run a pipeline
+// @Description Submit a DAG of steps, each running one AI model. A step input may use the output of another step, e.g. "{{steps.img.result_url}}". Every step is charged up front in one transaction; steps skipped after an upstream failure are refunded.
+// @Tags pipelines
+// @Accept json
+// @Produce json
+// @Param request body CreatePipelineRequest true "Pipeline definition"
+// @Success 200 {object} utils.Response{data=services.PipelineDetail}
+// @Failure 400 {object} utils.Response
+// @Failure 402 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /pipelines [post]
+func CreatePipeline(c *gin.Context) {
+	var req CreatePipelineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	userVal, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+	user := userVal.(models.User)
+
+	pipeline, err := services.CreatePipeline(req.Name, req.Steps, user.ID, user.Username)
+	if err != nil {
+		var inputErr *services.TaskInputError
+		if errors.As(err, &inputErr) {
+			c.JSON(http.StatusBadRequest, utils.NewValidationErrorResponse("Invalid task input", inputErr.Errors))
+			return
+		}
+		if errors.Is(err, services.ErrInvalidPipeline) || errors.Is(err, services.ErrPricing) {
+			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+			return
+		}
+		if errors.Is(err, services.ErrInsufficientBalance) {
+			c.JSON(http.StatusPaymentRequired, utils.NewErrorResponse(http.StatusPaymentRequired, err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Pipeline submitted successfully", pipeline))
+}
+
+// ListPipelines godoc
+// @Summary List pipelines
+// @Description List pipelines, newest first. Users only see their own; admins can filter by creator.
+// @Tags pipelines
+// @Produce json
+// @Param page query int false "Page number (default 1)"
+// @Param page_size query int false "Page size (default 10)"
+// @Param creator_id query int false "Creator ID (admin only)"
+// @Success 200 {object} utils.Response{data=PipelineListResponse}
+// @Failure 500 {object} utils.Response
+// @Router /pipelines [get]
+func ListPipelines(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 10
+	}
+
+	userVal, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+	user := userVal.(models.User)
+
+	creatorID := user.ID
+	if user.Role == "admin" {
+		cid, _ := strconv.Atoi(c.Query("creator_id"))
+		creatorID = uint(cid)
+	}
+
+	pipelines, total, err := services.GetPipelines(page, pageSize, creatorID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Pipelines retrieved successfully", PipelineListResponse{
+		Total: total,
+		Items: pipelines,
+	}))
+}
+
+// GetPipeline godoc
+// @Summary Get a pipeline
+// @Description Get the status of a pipeline, the task and status of each step, and the outputs of completed steps. Users can only see their own pipelines.
+// @Tags pipelines
+// @Produce json
+// @Param id path int true "Pipeline ID"
+// @Success 200 {object} utils.Response{data=services.PipelineDetail}
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /pipelines/{id} [get]
+func GetPipeline(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid pipeline ID"))
+		return
+	}
+
+	userVal, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+	user := userVal.(models.User)
+
+	pipeline, err := services.GetPipeline(uint(id))
+	if err != nil || (user.Role != "admin" && pipeline.CreatorID != user.ID) {
+		c.JSON(http.StatusNotFound, utils.NewErrorResponse(http.StatusNotFound, "Pipeline not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Pipeline retrieved successfully", pipeline))
+}
@@ -0,0 +1,17 @@
+package pipeline
+
+import (
+	"aigentools-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(router *gin.RouterGroup) {
+	pipelines := router.Group("/pipelines")
+	pipelines.Use(middleware.AuthMiddleware())
+	{
+		pipelines.POST("", middleware.Idempotency(), CreatePipeline)
+		pipelines.GET("", ListPipelines)
+		pipelines.GET("/:id", GetPipeline)
+	}
+}
@@ -1,6 +1,10 @@
 package task
 
-import "aigentools-backend/internal/models"
+import (
+	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
+	"time"
+)
 
 type CreateTaskRequest struct {
 	Body map[string]interface{} `json:"body" binding:"required"`
@@ -8,6 +12,27 @@ type CreateTaskRequest struct {
 		CreatorID   uint   `json:"creatorId" binding:"required"`
 		CreatorName string `json:"creatorName" binding:"required"`
 	} `json:"user" binding:"required"`
+	ScheduledAt *time.Time    `json:"scheduled_at,omitempty"`                                 // Optional RFC 3339 start time; the task is queued once it is reached
+	CallbackURL string        `json:"callback_url,omitempty" binding:"omitempty,url,max=500"` // Notified with a signed webhook once the task finishes
+	QuotedPrice *money.Amount `json:"quoted_price,omitempty"`                                 // Price from POST /models/{id}/quote; the task is rejected if it now costs something else
+}
+
+type TaskBatchItem struct {
+	Body map[string]interface{} `json:"body" binding:"required"`
+}
+
+type CreateTaskBatchRequest struct {
+	Items []TaskBatchItem `json:"items" binding:"required,min=1,max=100,dive"`
+	User  struct {
+		CreatorID   uint   `json:"creatorId" binding:"required"`
+		CreatorName string `json:"creatorName" binding:"required"`
+	} `json:"user" binding:"required"`
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`                                 // Applies to every task of the batch
+	CallbackURL string     `json:"callback_url,omitempty" binding:"omitempty,url,max=500"` // Notified once for every task of the batch
+}
+
+type RescheduleTaskRequest struct {
+	ScheduledAt time.Time `json:"scheduled_at" binding:"required"`
 }
 
 type UpdateTaskRequest struct {
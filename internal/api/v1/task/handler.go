@@ -4,21 +4,37 @@ import (
 	"aigentools-backend/internal/models"
 	"aigentools-backend/internal/services"
 	"aigentools-backend/internal/utils"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
+// maxSubmitWaitSeconds bounds how long POST /tasks may wait for a sync model
+const maxSubmitWaitSeconds = 60
+
+// streamKeepAliveInterval is how often an idle task stream sends a comment to keep proxies from closing it
+const streamKeepAliveInterval = 25 * time.Second
+
 // SubmitTask godoc
 // @Summary Submit a new task
-// @Description Submit a new task with body and user information
+// @Description Submit a new task with body and user information. With scheduled_at the task waits until that time before it is queued; it is charged at submission.
+// @Description For models with a sync execution mode, wait keeps the request open up to that many seconds (at most 60) and returns the finished task with its artifacts.
+// @Description The task is charged the price of the model's pricing rule for its input; with quoted_price it is rejected if that price changed.
 // @Tags tasks
 // @Accept json
 // @Produce json
 // @Param request body CreateTaskRequest true "Task creation request"
-// @Success 200 {object} utils.Response{data=models.Task}
+// @Param wait query int false "Seconds to wait for a sync model to finish"
+// @Success 200 {object} utils.Response{data=services.TaskDetail}
 // @Failure 400 {object} utils.Response
+// @Failure 402 {object} utils.Response
+// @Failure 409 {object} utils.Response
 // @Failure 500 {object} utils.Response
 // @Router /tasks [post]
 func SubmitTask(c *gin.Context) {
@@ -28,17 +44,222 @@ func SubmitTask(c *gin.Context) {
 		return
 	}
 
+	submitTask(c, req)
+}
+
+// SubmitModelTask godoc
+// @Summary Submit a new task for a model
+// @Description Same as POST /tasks with the model taken from the path, so each model has its own operation in the OpenAPI document of GET /models/openapi.json.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param id path int true "Model ID"
+// @Param request body CreateTaskRequest true "Task creation request"
+// @Param wait query int false "Seconds to wait for a sync model to finish"
+// @Success 200 {object} utils.Response{data=services.TaskDetail}
+// @Failure 400 {object} utils.Response
+// @Failure 402 {object} utils.Response
+// @Failure 409 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /models/{id}/tasks [post]
+func SubmitModelTask(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid model ID"))
+		return
+	}
+
+	var req CreateTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+	delete(req.Body, "modelId")
+	req.Body["model_id"] = id
+
+	submitTask(c, req)
+}
+
+// submitTask creates the task of a bound request and answers it, waiting for sync models when asked to
+func submitTask(c *gin.Context, req CreateTaskRequest) {
+	wait := 0
+	if waitStr := c.Query("wait"); waitStr != "" {
+		var err error
+		wait, err = strconv.Atoi(waitStr)
+		if err != nil || wait < 0 || wait > maxSubmitWaitSeconds {
+			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, fmt.Sprintf("wait must be between 0 and %d seconds", maxSubmitWaitSeconds)))
+			return
+		}
+	}
+
 	var task *models.Task
 	var err error
-	task, err = services.CreateTask(req.Body, req.User.CreatorID, req.User.CreatorName)
+	task, err = services.CreateTask(req.Body, req.User.CreatorID, req.User.CreatorName, req.ScheduledAt, req.CallbackURL, req.QuotedPrice)
 	if err != nil {
+		var inputErr *services.TaskInputError
+		if errors.As(err, &inputErr) {
+			c.JSON(http.StatusBadRequest, utils.NewValidationErrorResponse("Invalid task input", inputErr.Errors))
+			return
+		}
+		if errors.Is(err, services.ErrQuoteMismatch) {
+			c.JSON(http.StatusConflict, utils.NewErrorResponse(http.StatusConflict, err.Error()))
+			return
+		}
+		if errors.Is(err, services.ErrInsufficientBalance) {
+			c.JSON(http.StatusPaymentRequired, utils.NewErrorResponse(http.StatusPaymentRequired, err.Error()))
+			return
+		}
+		if errors.Is(err, services.ErrScheduleInPast) || errors.Is(err, services.ErrInvalidWebhookURL) || errors.Is(err, services.ErrPricing) {
+			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
 		return
 	}
 
+	// Only sync models finish quickly enough to be worth waiting for
+	if wait > 0 && task.ScheduledAt == nil {
+		if model, err := services.GetAIModelByID(task.ModelID); err == nil && model.ExecutionMode.IsSync() {
+			detail, finished, err := services.WaitForTask(c.Request.Context(), task.ID, task.CreatorID, time.Duration(wait)*time.Second)
+			if err == nil {
+				if finished {
+					c.JSON(http.StatusOK, utils.NewSuccessResponse("Task finished", detail))
+				} else {
+					c.JSON(http.StatusOK, utils.NewSuccessResponse("Task submitted successfully, still running", detail))
+				}
+				return
+			}
+			if c.Request.Context().Err() != nil {
+				// The client went away; the task carries on without it
+				return
+			}
+			fmt.Printf("Failed to wait for task %d: %v\n", task.ID, err)
+		}
+	}
+
 	c.JSON(http.StatusOK, utils.NewSuccessResponse("Task submitted successfully", task))
 }
 
+// SubmitTaskBatch godoc
+// @Summary Submit a batch of tasks
+// @Description Submit up to 100 tasks at once. Every item is validated against its model and the total cost is deducted in a single transaction: either all tasks are created or none.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param request body CreateTaskBatchRequest true "Batch creation request"
+// @Success 200 {object} utils.Response{data=services.TaskBatchDetail}
+// @Failure 400 {object} utils.Response
+// @Failure 402 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /tasks/batch [post]
+func SubmitTaskBatch(c *gin.Context) {
+	var req CreateTaskBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	inputs := make([]map[string]interface{}, len(req.Items))
+	for i, item := range req.Items {
+		inputs[i] = item.Body
+	}
+
+	batch, err := services.CreateTaskBatch(inputs, req.User.CreatorID, req.User.CreatorName, req.ScheduledAt, req.CallbackURL)
+	if err != nil {
+		var inputErr *services.TaskInputError
+		if errors.As(err, &inputErr) {
+			c.JSON(http.StatusBadRequest, utils.NewValidationErrorResponse("Invalid task input", inputErr.Errors))
+			return
+		}
+		if errors.Is(err, services.ErrInvalidBatchItem) || errors.Is(err, services.ErrBatchSize) || errors.Is(err, services.ErrScheduleInPast) ||
+			errors.Is(err, services.ErrInvalidWebhookURL) || errors.Is(err, services.ErrPricing) {
+			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+			return
+		}
+		if errors.Is(err, services.ErrInsufficientBalance) {
+			c.JSON(http.StatusPaymentRequired, utils.NewErrorResponse(http.StatusPaymentRequired, err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Task batch submitted successfully", batch))
+}
+
+// GetTaskBatch godoc
+// @Summary Get a task batch
+// @Description Get the status, progress and tasks of a batch. Users can only see their own batches.
+// @Tags tasks
+// @Produce json
+// @Param id path int true "Batch ID"
+// @Success 200 {object} utils.Response{data=services.TaskBatchDetail}
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /tasks/batches/{id} [get]
+func GetTaskBatch(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid batch ID"))
+		return
+	}
+
+	userVal, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+	user := userVal.(models.User)
+
+	batch, err := services.GetTaskBatch(uint(id))
+	if err != nil || (user.Role != "admin" && batch.CreatorID != user.ID) {
+		c.JSON(http.StatusNotFound, utils.NewErrorResponse(http.StatusNotFound, "Batch not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Task batch retrieved successfully", batch))
+}
+
+// CancelTaskBatch godoc
+// @Summary Cancel a task batch
+// @Description Cancel and refund every task of the batch that has not started yet. Tasks already running are left to finish.
+// @Tags tasks
+// @Produce json
+// @Param id path int true "Batch ID"
+// @Success 200 {object} utils.Response{data=services.TaskBatchCancelResult}
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /tasks/batches/{id}/cancel [post]
+func CancelTaskBatch(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid batch ID"))
+		return
+	}
+
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	currentUser := user.(models.User)
+
+	result, err := services.CancelTaskBatch(uint(id), currentUser.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, utils.NewErrorResponse(http.StatusNotFound, "Batch not found"))
+		} else if err.Error() == "unauthorized to cancel this batch" {
+			c.JSON(http.StatusForbidden, utils.NewErrorResponse(http.StatusForbidden, err.Error()))
+		} else {
+			c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Task batch cancelled successfully", result))
+}
+
 // ApproveTask godoc
 // @Summary Approve a task
 // @Description Approve a pending audit task and push it to the execution queue
@@ -56,7 +277,14 @@ func ApproveTask(c *gin.Context) {
 		return
 	}
 
-	task, err := services.ApproveTask(uint(id))
+	actor := services.ActorSystem
+	if userVal, exists := c.Get("user"); exists {
+		if u, ok := userVal.(models.User); ok {
+			actor = services.UserActor(u.ID)
+		}
+	}
+
+	task, err := services.ApproveTask(uint(id), actor)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
 		return
@@ -92,6 +320,11 @@ func UpdateTask(c *gin.Context) {
 
 	task, err := services.UpdateTask(uint(id), req.Body)
 	if err != nil {
+		var inputErr *services.TaskInputError
+		if errors.As(err, &inputErr) {
+			c.JSON(http.StatusBadRequest, utils.NewValidationErrorResponse("Invalid task input", inputErr.Errors))
+			return
+		}
 		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
 		return
 	}
@@ -155,11 +388,11 @@ func ListTasks(c *gin.Context) {
 
 // GetTaskDetail godoc
 // @Summary Get task detail
-// @Description Get a single task by ID
+// @Description Get a single task by ID with all of its output files. result_url stays the primary (first) artifact.
 // @Tags tasks
 // @Produce json
 // @Param id path int true "Task ID"
-// @Success 200 {object} utils.Response{data=models.Task}
+// @Success 200 {object} utils.Response{data=services.TaskDetail}
 // @Failure 404 {object} utils.Response
 // @Router /tasks/{id} [get]
 func GetTaskDetail(c *gin.Context) {
@@ -204,7 +437,7 @@ func GetTaskDetail(c *gin.Context) {
 		return
 	}
 
-	task, err := services.GetTaskByID(uint(id))
+	task, err := services.GetTaskDetail(uint(id))
 	if err != nil {
 		c.JSON(http.StatusNotFound, utils.NewErrorResponse(http.StatusNotFound, "Task not found"))
 		return
@@ -220,6 +453,8 @@ func GetTaskDetail(c *gin.Context) {
 // @Produce json
 // @Param id path int true "Task ID"
 // @Success 200 {object} utils.Response{data=models.Task}
+// @Failure 400 {object} utils.Response
+// @Failure 402 {object} utils.Response
 // @Failure 403 {object} utils.Response
 // @Failure 404 {object} utils.Response
 // @Failure 500 {object} utils.Response
@@ -245,6 +480,8 @@ func RetryTask(c *gin.Context) {
 			c.JSON(http.StatusForbidden, utils.NewErrorResponse(http.StatusForbidden, err.Error()))
 		} else if err.Error() == "task is not in a failed state" {
 			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		} else if errors.Is(err, services.ErrInsufficientBalance) {
+			c.JSON(http.StatusPaymentRequired, utils.NewErrorResponse(http.StatusPaymentRequired, err.Error()))
 		} else {
 			// Assuming record not found if generic error from DB First
 			c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
@@ -295,3 +532,149 @@ func CancelTask(c *gin.Context) {
 
 	c.JSON(http.StatusOK, utils.NewSuccessResponse("Task cancelled successfully", task))
 }
+
+// RescheduleTask godoc
+// @Summary Reschedule a task
+// @Description Move the start time of a scheduled task that has not been queued yet. Use the cancel endpoint to drop it instead.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param id path int true "Task ID"
+// @Param request body RescheduleTaskRequest true "New start time"
+// @Success 200 {object} utils.Response{data=models.Task}
+// @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /tasks/{id}/schedule [patch]
+func RescheduleTask(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid task ID"))
+		return
+	}
+
+	var req RescheduleTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	currentUser := user.(models.User)
+
+	task, err := services.RescheduleTask(uint(id), currentUser.ID, req.ScheduledAt)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, utils.NewErrorResponse(http.StatusNotFound, "Task not found"))
+		} else if err.Error() == "unauthorized to reschedule this task" {
+			c.JSON(http.StatusForbidden, utils.NewErrorResponse(http.StatusForbidden, err.Error()))
+		} else if errors.Is(err, services.ErrScheduleInPast) || errors.Is(err, services.ErrNotScheduled) {
+			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		} else {
+			c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Task rescheduled successfully", task))
+}
+
+// StreamTasks godoc
+// @Summary Stream task updates
+// @Description Server-Sent Events stream of the status changes of the current user's tasks, including retry counts and the final result URL. Each update is sent as a "task" event; a comment is sent every 25 seconds to keep the connection open. Pass the JWT in the Authorization header or, for EventSource, in the access_token query parameter.
+// @Tags tasks
+// @Produce text/event-stream
+// @Param task_id query int false "Only stream updates of this task"
+// @Param access_token query string false "JWT, when the Authorization header cannot be set"
+// @Success 200 {object} services.TaskUpdate
+// @Failure 401 {object} utils.Response
+// @Router /tasks/stream [get]
+func StreamTasks(c *gin.Context) {
+	userVal, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+	user := userVal.(models.User)
+
+	var taskID uint
+	if idStr := c.Query("task_id"); idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid task ID"))
+			return
+		}
+		taskID = uint(id)
+	}
+
+	updates, unsubscribe := services.SubscribeTaskUpdates(user.ID)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Disable proxy buffering (nginx)
+
+	keepAlive := time.NewTicker(streamKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case update := <-updates:
+			if taskID == 0 || update.TaskID == taskID {
+				c.SSEvent("task", update)
+			}
+			return true
+		case <-keepAlive.C:
+			io.WriteString(w, ": ping\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// GetTaskEvents godoc
+// @Summary Get task status history
+// @Description Get every status transition of a task, oldest first. Users can only see their own tasks.
+// @Tags tasks
+// @Produce json
+// @Param id path int true "Task ID"
+// @Success 200 {object} utils.Response{data=[]models.TaskEvent}
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /tasks/{id}/events [get]
+func GetTaskEvents(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid task ID"))
+		return
+	}
+
+	userVal, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+	user := userVal.(models.User)
+
+	task, err := services.GetTaskByID(uint(id))
+	if err != nil || (user.Role != "admin" && task.CreatorID != user.ID) {
+		c.JSON(http.StatusNotFound, utils.NewErrorResponse(http.StatusNotFound, "Task not found"))
+		return
+	}
+
+	events, err := services.GetTaskEvents(task.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Task events retrieved successfully", events))
+}
@@ -7,14 +7,25 @@ import (
 )
 
 func RegisterRoutes(router *gin.RouterGroup) {
+	// Server-Sent Events; EventSource cannot send headers, so the token may come as a query parameter
+	router.GET("/tasks/stream", middleware.TokenFromQuery("access_token"), middleware.AuthMiddleware(), StreamTasks)
+
+	// One submission route per model, described by GET /models/openapi.json
+	router.POST("/models/:id/tasks", middleware.AuthMiddleware(), middleware.Idempotency(), SubmitModelTask)
+
 	tasks := router.Group("/tasks")
 	tasks.Use(middleware.AuthMiddleware())
 	{
-		tasks.POST("", SubmitTask)
+		tasks.POST("", middleware.Idempotency(), SubmitTask)
+		tasks.POST("/batch", middleware.Idempotency(), SubmitTaskBatch)
+		tasks.GET("/batches/:id", GetTaskBatch)
+		tasks.POST("/batches/:id/cancel", CancelTaskBatch)
 		tasks.GET("", ListTasks)
 		tasks.GET("/:id", GetTaskDetail)
+		tasks.GET("/:id/events", GetTaskEvents)
 		tasks.POST("/:id/retry", RetryTask)
 		tasks.POST("/:id/cancel", CancelTask)
+		tasks.PATCH("/:id/schedule", RescheduleTask)
 		tasks.PATCH("/:id/approve", ApproveTask)
 		tasks.PUT("/:id", UpdateTask)
 	}
@@ -1,25 +1,29 @@
 package user
 
-import "time"
+import (
+	"aigentools-backend/pkg/money"
+	"time"
+)
 
 // UserResponse defines the response structure for user information.
 type UserResponse struct {
-	ID            uint        `json:"id"`
-	Username      string      `json:"username"`
-	Role          string      `json:"role"`
-	IsActive      bool        `json:"is_active"`
-	ActivatedAt   *time.Time  `json:"activated_at,omitempty"`
-	DeactivatedAt *time.Time  `json:"deactivated_at,omitempty"`
-	CreditLimit   float64     `json:"creditLimit"`
-	TotalConsumed float64     `json:"total_consumed"`
-	Credit        *CreditInfo `json:"credit,omitempty"`
-	Token         string      `json:"token,omitempty"`
+	ID            uint         `json:"id"`
+	Username      string       `json:"username"`
+	Role          string       `json:"role"`
+	IsActive      bool         `json:"is_active"`
+	ActivatedAt   *time.Time   `json:"activated_at,omitempty"`
+	DeactivatedAt *time.Time   `json:"deactivated_at,omitempty"`
+	CreditLimit   money.Amount `json:"creditLimit"`
+	TotalConsumed money.Amount `json:"total_consumed"`
+	Credit        *CreditInfo  `json:"credit,omitempty"`
+	Token         string       `json:"token,omitempty"`
 }
 
 // CreditInfo defines the structure for credit details
 type CreditInfo struct {
-	Total           float64 `json:"total"`
-	Used            float64 `json:"used"`
-	Available       float64 `json:"available"`
-	UsagePercentage float64 `json:"usagePercentage"`
+	Total           money.Amount `json:"total"`
+	Used            money.Amount `json:"used"`
+	Available       money.Amount `json:"available"`
+	Held            money.Amount `json:"held"` // Reserved for unfinished tasks, included in Used
+	UsagePercentage float64      `json:"usagePercentage"`
 }
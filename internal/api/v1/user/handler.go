@@ -4,6 +4,7 @@ import (
 	"aigentools-backend/internal/database"
 	"aigentools-backend/internal/models"
 	"aigentools-backend/internal/utils"
+	"aigentools-backend/pkg/money"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -44,11 +45,12 @@ func CurrentUser(c *gin.Context) {
 	// Calculate credit info based on "Total = Balance + CreditLimit" model
 	// This supports both prepaid (Balance > 0) and postpaid/overdraft (Balance < 0) scenarios.
 
-	var total, available, used, usagePercentage float64
+	var total, available, used money.Amount
+	var usagePercentage float64
 
-	// Available = Balance + CreditLimit
-	// This represents the actual purchasing power.
-	available = u.Balance + u.CreditLimit
+	// Available = Balance + CreditLimit - HeldAmount
+	// This represents the actual purchasing power; holds of unfinished tasks are already spoken for.
+	available = u.AvailableBalance()
 
 	// Total = Max(Balance, 0) + CreditLimit
 	// This represents the total capacity (Own Funds + Credit Line).
@@ -62,12 +64,13 @@ func CurrentUser(c *gin.Context) {
 	used = total - available
 
 	if total > 0 {
-		usagePercentage = (used / total) * 100
+		usagePercentage = used.Float64() / total.Float64() * 100
 	}
 
 	creditInfo := &CreditInfo{
 		Total:           total,
 		Available:       available,
+		Held:            u.HeldAmount,
 		Used:            used,
 		UsagePercentage: usagePercentage,
 	}
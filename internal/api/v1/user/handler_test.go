@@ -4,6 +4,7 @@ import (
 	"aigentools-backend/internal/api/v1/user"
 	"aigentools-backend/internal/database"
 	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -55,9 +56,9 @@ func TestCurrentUser(t *testing.T) {
 				Username:    "credituser",
 				Role:        "user",
 				IsActive:    true,
-				Balance:       1000.0,
-				CreditLimit:   5000.0,
-				TotalConsumed: 50.0,
+				Balance:       money.MustParse("1000"),
+				CreditLimit:   money.MustParse("5000"),
+				TotalConsumed: money.MustParse("50"),
 			},
 			expectedStatus: http.StatusOK,
 			checkResponse: func(t *testing.T, body []byte) {
@@ -69,12 +70,12 @@ func TestCurrentUser(t *testing.T) {
 				assert.Equal(t, 200, resp.Code)
 				assert.NotNil(t, resp.Data.Credit)
 				// Total = 5000 (Credit) + 1000 (Own) = 6000
-				assert.Equal(t, 6000.0, resp.Data.Credit.Total)
+				assert.Equal(t, money.MustParse("6000"), resp.Data.Credit.Total)
 				// Available = 5000 + 1000 = 6000
-				assert.Equal(t, 6000.0, resp.Data.Credit.Available)
-				assert.Equal(t, 0.0, resp.Data.Credit.Used)
+				assert.Equal(t, money.MustParse("6000"), resp.Data.Credit.Available)
+				assert.Equal(t, money.MustParse("0"), resp.Data.Credit.Used)
 				assert.Equal(t, 0.0, resp.Data.Credit.UsagePercentage)
-				assert.Equal(t, 50.0, resp.Data.TotalConsumed)
+				assert.Equal(t, money.MustParse("50"), resp.Data.TotalConsumed)
 			},
 		},
 		{
@@ -83,8 +84,8 @@ func TestCurrentUser(t *testing.T) {
 				Username:    "zerouser",
 				Role:        "user",
 				IsActive:    true,
-				Balance:     0.0,
-				CreditLimit: 0.0,
+				Balance:     money.MustParse("0"),
+				CreditLimit: money.MustParse("0"),
 			},
 			expectedStatus: http.StatusOK,
 			checkResponse: func(t *testing.T, body []byte) {
@@ -95,9 +96,9 @@ func TestCurrentUser(t *testing.T) {
 				json.Unmarshal(body, &resp)
 				assert.Equal(t, 200, resp.Code)
 				assert.NotNil(t, resp.Data.Credit)
-				assert.Equal(t, 0.0, resp.Data.Credit.Total)
-				assert.Equal(t, 0.0, resp.Data.Credit.Available)
-				assert.Equal(t, 0.0, resp.Data.Credit.Used)
+				assert.Equal(t, money.MustParse("0"), resp.Data.Credit.Total)
+				assert.Equal(t, money.MustParse("0"), resp.Data.Credit.Available)
+				assert.Equal(t, money.MustParse("0"), resp.Data.Credit.Used)
 				assert.Equal(t, 0.0, resp.Data.Credit.UsagePercentage)
 			},
 		},
@@ -107,8 +108,8 @@ func TestCurrentUser(t *testing.T) {
 				Username:    "debtuser",
 				Role:        "user",
 				IsActive:    true,
-				Balance:     -500.0,
-				CreditLimit: 2000.0,
+				Balance:     money.MustParse("-500"),
+				CreditLimit: money.MustParse("2000"),
 			},
 			expectedStatus: http.StatusOK,
 			checkResponse: func(t *testing.T, body []byte) {
@@ -120,11 +121,11 @@ func TestCurrentUser(t *testing.T) {
 				assert.Equal(t, 200, resp.Code)
 				assert.NotNil(t, resp.Data.Credit)
 				// Total = 2000 (Credit) + 0 (Own) = 2000
-				assert.Equal(t, 2000.0, resp.Data.Credit.Total)
+				assert.Equal(t, money.MustParse("2000"), resp.Data.Credit.Total)
 				// Available = 2000 + (-500) = 1500
-				assert.Equal(t, 1500.0, resp.Data.Credit.Available)
+				assert.Equal(t, money.MustParse("1500"), resp.Data.Credit.Available)
 				// Used = 2000 - 1500 = 500
-				assert.Equal(t, 500.0, resp.Data.Credit.Used)
+				assert.Equal(t, money.MustParse("500"), resp.Data.Credit.Used)
 				assert.Equal(t, 25.0, resp.Data.Credit.UsagePercentage) // (500/2000)*100 = 25
 			},
 		},
@@ -134,8 +135,8 @@ func TestCurrentUser(t *testing.T) {
 				Username:    "richuser",
 				Role:        "user",
 				IsActive:    true,
-				Balance:     6000.0,
-				CreditLimit: 5000.0,
+				Balance:     money.MustParse("6000"),
+				CreditLimit: money.MustParse("5000"),
 			},
 			expectedStatus: http.StatusOK,
 			checkResponse: func(t *testing.T, body []byte) {
@@ -147,10 +148,10 @@ func TestCurrentUser(t *testing.T) {
 				assert.Equal(t, 200, resp.Code)
 				assert.NotNil(t, resp.Data.Credit)
 				// Total = 5000 + 6000 = 11000
-				assert.Equal(t, 11000.0, resp.Data.Credit.Total)
+				assert.Equal(t, money.MustParse("11000"), resp.Data.Credit.Total)
 				// Available = 5000 + 6000 = 11000
-				assert.Equal(t, 11000.0, resp.Data.Credit.Available)
-				assert.Equal(t, 0.0, resp.Data.Credit.Used)
+				assert.Equal(t, money.MustParse("11000"), resp.Data.Credit.Available)
+				assert.Equal(t, money.MustParse("0"), resp.Data.Credit.Used)
 				assert.Equal(t, 0.0, resp.Data.Credit.UsagePercentage)
 			},
 		},
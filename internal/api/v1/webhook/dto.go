@@ -0,0 +1,16 @@
+package webhook
+
+import "aigentools-backend/internal/models"
+
+type CreateEndpointRequest struct {
+	URL string `json:"url" binding:"required,url,max=500" example:"https://example.com/hooks/tasks"`
+}
+
+type SecretResponse struct {
+	Secret string `json:"secret"`
+}
+
+type DeliveryListResponse struct {
+	Total int64                    `json:"total"`
+	Items []models.WebhookDelivery `json:"items"`
+}
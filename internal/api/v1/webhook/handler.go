@@ -0,0 +1,245 @@
+package webhook
+
+import (
+	"aigentools-backend/internal/models"
+	"aigentools-backend/internal/services"
+	"aigentools-backend/internal/utils"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CreateEndpoint godoc
+// @Summary Add a webhook endpoint
+// @Description Register a URL that receives a signed POST whenever one of your tasks completes, fails or is cancelled. Up to 5 endpoints per user.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param request body CreateEndpointRequest true "Endpoint URL"
+// @Success 200 {object} utils.Response{data=models.WebhookEndpoint}
+// @Failure 400 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Router /webhooks [post]
+func CreateEndpoint(c *gin.Context) {
+	var req CreateEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	userVal, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+	user := userVal.(models.User)
+
+	endpoint, err := services.CreateWebhookEndpoint(user.ID, req.URL)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidWebhookURL) || errors.Is(err, services.ErrTooManyWebhooks) {
+			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Webhook endpoint created successfully", endpoint))
+}
+
+// ListEndpoints godoc
+// @Summary List webhook endpoints
+// @Description List your webhook endpoints
+// @Tags webhooks
+// @Produce json
+// @Success 200 {object} utils.Response{data=[]models.WebhookEndpoint}
+// @Failure 500 {object} utils.Response
+// @Router /webhooks [get]
+func ListEndpoints(c *gin.Context) {
+	userVal, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+	user := userVal.(models.User)
+
+	endpoints, err := services.GetWebhookEndpoints(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Webhook endpoints retrieved successfully", endpoints))
+}
+
+// DeleteEndpoint godoc
+// @Summary Delete a webhook endpoint
+// @Description Stop notifying an endpoint. Its past deliveries stay in the delivery log.
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Endpoint ID"
+// @Success 200 {object} utils.Response
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /webhooks/{id} [delete]
+func DeleteEndpoint(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid endpoint ID"))
+		return
+	}
+
+	userVal, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+	user := userVal.(models.User)
+
+	if err := services.DeleteWebhookEndpoint(uint(id), user.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, utils.NewErrorResponse(http.StatusNotFound, "Webhook endpoint not found"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Webhook endpoint deleted successfully", nil))
+}
+
+// GetSecret godoc
+// @Summary Get the webhook signing secret
+// @Description Get the secret your deliveries are signed with. X-Webhook-Signature is "sha256=" followed by the hex HMAC-SHA256 of "<X-Webhook-Timestamp>|<body>".
+// @Tags webhooks
+// @Produce json
+// @Success 200 {object} utils.Response{data=SecretResponse}
+// @Failure 500 {object} utils.Response
+// @Router /webhooks/secret [get]
+func GetSecret(c *gin.Context) {
+	userVal, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+	user := userVal.(models.User)
+
+	secret, err := services.GetWebhookSecret(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Webhook secret retrieved successfully", SecretResponse{Secret: secret}))
+}
+
+// RotateSecret godoc
+// @Summary Rotate the webhook signing secret
+// @Description Replace the signing secret. Every later attempt, including retries of earlier deliveries, is signed with the new one.
+// @Tags webhooks
+// @Produce json
+// @Success 200 {object} utils.Response{data=SecretResponse}
+// @Failure 500 {object} utils.Response
+// @Router /webhooks/secret/rotate [post]
+func RotateSecret(c *gin.Context) {
+	userVal, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+	user := userVal.(models.User)
+
+	secret, err := services.RotateWebhookSecret(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Webhook secret rotated successfully", SecretResponse{Secret: secret}))
+}
+
+// ListDeliveries godoc
+// @Summary List webhook deliveries
+// @Description List the deliveries of your webhooks, newest first, with the outcome of their last attempt
+// @Tags webhooks
+// @Produce json
+// @Param page query int false "Page number (default 1)"
+// @Param page_size query int false "Page size (default 10)"
+// @Param task_id query int false "Only deliveries about this task"
+// @Success 200 {object} utils.Response{data=DeliveryListResponse}
+// @Failure 500 {object} utils.Response
+// @Router /webhooks/deliveries [get]
+func ListDeliveries(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	taskID, _ := strconv.Atoi(c.Query("task_id"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 10
+	}
+
+	userVal, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+	user := userVal.(models.User)
+
+	deliveries, total, err := services.GetWebhookDeliveries(page, pageSize, user.ID, uint(taskID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Webhook deliveries retrieved successfully", DeliveryListResponse{
+		Total: total,
+		Items: deliveries,
+	}))
+}
+
+// ReplayDelivery godoc
+// @Summary Replay a webhook delivery
+// @Description Send a succeeded or failed delivery again with its original body and return the outcome. If this attempt fails, it is retried with backoff like a new delivery.
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Delivery ID"
+// @Success 200 {object} utils.Response{data=models.WebhookDelivery}
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 409 {object} utils.Response
+// @Router /webhooks/deliveries/{id}/replay [post]
+func ReplayDelivery(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Invalid delivery ID"))
+		return
+	}
+
+	userVal, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+	user := userVal.(models.User)
+
+	delivery, err := services.ReplayWebhookDelivery(uint(id), user.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, utils.NewErrorResponse(http.StatusNotFound, "Webhook delivery not found"))
+			return
+		}
+		if errors.Is(err, services.ErrDeliveryPending) {
+			c.JSON(http.StatusConflict, utils.NewErrorResponse(http.StatusConflict, err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.NewSuccessResponse("Webhook delivery replayed", delivery))
+}
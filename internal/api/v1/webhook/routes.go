@@ -0,0 +1,21 @@
+package webhook
+
+import (
+	"aigentools-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(router *gin.RouterGroup) {
+	webhooks := router.Group("/webhooks")
+	webhooks.Use(middleware.AuthMiddleware())
+	{
+		webhooks.POST("", CreateEndpoint)
+		webhooks.GET("", ListEndpoints)
+		webhooks.DELETE("/:id", DeleteEndpoint)
+		webhooks.GET("/secret", GetSecret)
+		webhooks.POST("/secret/rotate", RotateSecret)
+		webhooks.GET("/deliveries", ListDeliveries)
+		webhooks.POST("/deliveries/:id/replay", ReplayDelivery)
+	}
+}
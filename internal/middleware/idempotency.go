@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"aigentools-backend/internal/models"
+	"aigentools-backend/internal/services"
+	"aigentools-backend/internal/utils"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+// idempotencyRecorder keeps a copy of the response body so it can be replayed
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency makes a mutating endpoint safe to retry. When a request carries an
+// Idempotency-Key header, the first response is stored for the user and replayed for
+// any later request with the same key and body; reusing the key with a different body
+// is rejected. Server errors and panics are not stored, so those requests can be retried.
+// It must run after AuthMiddleware.
+func Idempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Idempotency-Key is too long"))
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Failed to read request body"))
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var userID uint
+		if userVal, exists := c.Get("user"); exists {
+			userID = userVal.(models.User).ID
+		}
+		// Keys are per user and per endpoint
+		scope := fmt.Sprintf("%d:%s %s", userID, c.Request.Method, c.FullPath())
+		sum := sha256.Sum256(body)
+		requestHash := hex.EncodeToString(sum[:])
+
+		stored, err := services.BeginIdempotentRequest(scope, key, requestHash)
+		if err != nil {
+			if errors.Is(err, services.ErrIdempotencyKeyMismatch) {
+				c.JSON(http.StatusUnprocessableEntity, utils.NewErrorResponse(http.StatusUnprocessableEntity, err.Error()))
+			} else if errors.Is(err, services.ErrIdempotencyKeyInUse) {
+				c.JSON(http.StatusConflict, utils.NewErrorResponse(http.StatusConflict, err.Error()))
+			} else {
+				c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "Failed to check idempotency key"))
+			}
+			c.Abort()
+			return
+		}
+		if stored != nil {
+			c.Header(IdempotentReplayedHeader, "true")
+			c.Data(stored.Status, stored.ContentType, stored.Body)
+			c.Abort()
+			return
+		}
+
+		release := func() {
+			if err := services.ReleaseIdempotencyKey(scope, key); err != nil {
+				fmt.Printf("Failed to release idempotency key %q: %v\n", key, err)
+			}
+		}
+		// A panicking handler answers with a server error further up, so its claim is
+		// released like one; otherwise retries would be refused until the claim expires
+		defer func() {
+			if r := recover(); r != nil {
+				release()
+				panic(r)
+			}
+		}()
+
+		recorder := &idempotencyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			release()
+			return
+		}
+		err = services.CompleteIdempotentRequest(scope, key, services.IdempotentResponse{
+			RequestHash: requestHash,
+			Status:      status,
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		})
+		if err != nil {
+			fmt.Printf("Failed to store response for idempotency key %q: %v\n", key, err)
+		}
+	}
+}
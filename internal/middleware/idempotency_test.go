@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"aigentools-backend/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	mr := setupMockRedis()
+	defer mr.Close()
+
+	gin.SetMode(gin.TestMode)
+
+	calls := 0
+	status := http.StatusOK
+	r := gin.New()
+	r.POST("/tasks", func(c *gin.Context) {
+		c.Set("user", models.User{ID: 1})
+	}, Idempotency(), func(c *gin.Context) {
+		calls++
+		c.JSON(status, gin.H{"call": calls})
+	})
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/tasks", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// The first request runs, a retry replays its response
+	first := send("key-1", `{"a":1}`)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.JSONEq(t, `{"call":1}`, first.Body.String())
+
+	retry := send("key-1", `{"a":1}`)
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.JSONEq(t, `{"call":1}`, retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, 1, calls)
+
+	// The same key with another body is rejected
+	mismatch := send("key-1", `{"a":2}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, mismatch.Code)
+	assert.Equal(t, 1, calls)
+
+	// Without a key every request runs
+	send("", `{"a":1}`)
+	assert.Equal(t, 2, calls)
+
+	// Server errors are not stored, so the request can be retried
+	status = http.StatusInternalServerError
+	assert.Equal(t, http.StatusInternalServerError, send("key-2", `{}`).Code)
+	status = http.StatusOK
+	assert.Equal(t, http.StatusOK, send("key-2", `{}`).Code)
+	assert.Equal(t, 4, calls)
+}
+
+func TestIdempotencyMiddlewarePanic(t *testing.T) {
+	mr := setupMockRedis()
+	defer mr.Close()
+
+	gin.SetMode(gin.TestMode)
+
+	panics := true
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.POST("/tasks", Idempotency(), func(c *gin.Context) {
+		if panics {
+			panic("handler bug")
+		}
+		c.Status(http.StatusCreated)
+	})
+
+	send := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{}`))
+		req.Header.Set(IdempotencyKeyHeader, "key")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// The panic still reaches the recovery middleware, and the key is free for a retry
+	assert.Equal(t, http.StatusInternalServerError, send().Code)
+	panics = false
+	assert.Equal(t, http.StatusCreated, send().Code)
+}
+
+func TestIdempotencyMiddlewareInProgress(t *testing.T) {
+	mr := setupMockRedis()
+	defer mr.Close()
+
+	gin.SetMode(gin.TestMode)
+
+	var nested *httptest.ResponseRecorder
+	r := gin.New()
+	var handler gin.HandlerFunc
+	r.POST("/payment/create", Idempotency(), func(c *gin.Context) { handler(c) })
+
+	send := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/payment/create", strings.NewReader(`{}`))
+		req.Header.Set(IdempotencyKeyHeader, "key")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// A retry arriving while the first request is still running is told to wait
+	handler = func(c *gin.Context) {
+		nested = send()
+		c.Status(http.StatusCreated)
+	}
+	assert.Equal(t, http.StatusCreated, send().Code)
+	assert.Equal(t, http.StatusConflict, nested.Code)
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"aigentools-backend/pkg/jsonpath"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Placeholders replaced in adapter URL templates
+const (
+	AdapterModelURLPlaceholder = "{{model_url}}" // AIModel.URL
+	AdapterRemoteIDPlaceholder = "{{remote_id}}" // Remote task ID returned on submission
+)
+
+// AdapterAuthEnvPrefix starts the names of the environment variables an adapter spec may
+// read its credential from. Specs are edited over the API, so they must not be able to
+// send other server settings, such as JWT_SECRET, to an upstream of their choosing.
+const AdapterAuthEnvPrefix = "ADAPTER_KEY_"
+
+// adapterAuthEnvAllowlist holds the provider credentials configured before the prefix
+var adapterAuthEnvAllowlist = map[string]bool{
+	"JIEKOU_API":       true,
+	"AIHUBMIX_API_KEY": true,
+}
+
+// AdapterAuthEnvAllowed reports whether an adapter spec may read its credential from the
+// environment variable name
+func AdapterAuthEnvAllowed(name string) bool {
+	return adapterAuthEnvAllowlist[name] || (strings.HasPrefix(name, AdapterAuthEnvPrefix) && len(name) > len(AdapterAuthEnvPrefix))
+}
+
+// AdapterSpec describes how to drive an upstream provider, so that a provider with a
+// new response shape needs configuration instead of code. Paths are JSONPath
+// expressions such as "$.data.task_id" or "$.videos[*].video_url".
+type AdapterSpec struct {
+	Submit AdapterRequest `json:"submit"`          // Defaults to POST {{model_url}}
+	Query  AdapterRequest `json:"query"`           // Defaults to GET; the URL is required unless QueryURLPath is set
+	Auth   *AdapterAuth   `json:"auth,omitempty"`  // Credential sent to the hosts of the submit and query URLs
+	Notes  string         `json:"notes,omitempty"` // Free text for admins
+
+	RemoteIDPath  string   `json:"remote_id_path"`            // Remote task ID in the submit response
+	QueryURLPath  string   `json:"query_url_path,omitempty"`  // Status URL in the submit response, overriding Query.URL
+	StatusPath    string   `json:"status_path"`               // Status in the query response
+	SuccessValues []string `json:"success_values"`            // Statuses meaning success, compared case-insensitively
+	FailureValues []string `json:"failure_values"`            // Statuses meaning failure; anything else is still running
+	ErrorPath     string   `json:"error_path,omitempty"`      // Failure reason in the query response
+	ArtifactPaths []string `json:"artifact_paths"`            // Result file URLs in the query response (the submit response for sync-inline models), in order
+	ResultPath    string   `json:"result_path,omitempty"`     // Inline result of a sync-inline model; defaults to the whole response
+	FinalCostPath string   `json:"final_cost_path,omitempty"` // What the provider charged, in the query response (the submit response for sync-inline models); a cost below the held price is charged instead
+
+	PollInterval int `json:"poll_interval,omitempty"` // Seconds between status checks (default 30)
+	PollTimeout  int `json:"poll_timeout,omitempty"`  // Seconds after submission before the task fails (default 1800)
+}
+
+// AdapterRequest is a call to the upstream provider
+type AdapterRequest struct {
+	Method  string            `json:"method,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// AdapterAuth names the header carrying the provider credential and where the credential comes from
+type AdapterAuth struct {
+	Header string `json:"header"`           // e.g. Authorization
+	Prefix string `json:"prefix,omitempty"` // e.g. "Bearer "
+	Env    string `json:"env"`              // Environment variable holding the credential, e.g. ADAPTER_KEY_ACME; see AdapterAuthEnvAllowed
+}
+
+// SubmitMethod returns the HTTP method of the submit request
+func (s *AdapterSpec) SubmitMethod() string {
+	if s.Submit.Method == "" {
+		return http.MethodPost
+	}
+	return strings.ToUpper(s.Submit.Method)
+}
+
+// QueryMethod returns the HTTP method of the status request
+func (s *AdapterSpec) QueryMethod() string {
+	if s.Query.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(s.Query.Method)
+}
+
+// SubmitURLTemplate returns the URL template of the submit request
+func (s *AdapterSpec) SubmitURLTemplate() string {
+	if s.Submit.URL == "" {
+		return AdapterModelURLPlaceholder
+	}
+	return s.Submit.URL
+}
+
+// Validate checks that the spec is complete for a model with the given execution
+// mode and every path parses. Sync models have nothing to poll, so they need no remote
+// ID, query or status settings.
+func (s *AdapterSpec) Validate(mode ExecutionMode) error {
+	var problems []string
+	async := !mode.IsSync()
+
+	for _, m := range []string{s.SubmitMethod(), s.QueryMethod()} {
+		switch m {
+		case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			problems = append(problems, fmt.Sprintf("unsupported method %q", m))
+		}
+	}
+	if async && s.Query.URL == "" && s.QueryURLPath == "" {
+		problems = append(problems, "query.url or query_url_path is required")
+	}
+	if s.Auth != nil && (s.Auth.Header == "" || s.Auth.Env == "") {
+		problems = append(problems, "auth needs both header and env")
+	} else if s.Auth != nil && !AdapterAuthEnvAllowed(s.Auth.Env) {
+		problems = append(problems, fmt.Sprintf("auth.env %q must start with %s", s.Auth.Env, AdapterAuthEnvPrefix))
+	}
+
+	type namedPath struct{ name, path string }
+	paths := []namedPath{
+		{"remote_id_path", s.RemoteIDPath},
+		{"status_path", s.StatusPath},
+		{"query_url_path", s.QueryURLPath},
+		{"error_path", s.ErrorPath},
+		{"result_path", s.ResultPath},
+		{"final_cost_path", s.FinalCostPath},
+	}
+	if async && s.RemoteIDPath == "" {
+		problems = append(problems, "remote_id_path is required")
+	}
+	if async && s.StatusPath == "" {
+		problems = append(problems, "status_path is required")
+	}
+	if async && len(s.ArtifactPaths) == 0 {
+		problems = append(problems, "artifact_paths needs at least one path")
+	}
+	for i, p := range s.ArtifactPaths {
+		paths = append(paths, namedPath{fmt.Sprintf("artifact_paths[%d]", i), p})
+	}
+	for _, p := range paths {
+		if p.path == "" {
+			continue
+		}
+		if _, err := jsonpath.Compile(p.path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", p.name, err))
+		}
+	}
+
+	if async && len(s.SuccessValues) == 0 {
+		problems = append(problems, "success_values needs at least one value")
+	}
+	if s.PollInterval < 0 || s.PollTimeout < 0 {
+		problems = append(problems, "poll_interval and poll_timeout cannot be negative")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid adapter: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
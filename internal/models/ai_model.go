@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"aigentools-backend/pkg/money"
+	"time"
+)
 
 type AIModelStatus string
 
@@ -10,6 +13,29 @@ const (
 	AIModelStatusDraft  AIModelStatus = "draft"
 )
 
+// ExecutionMode says how the upstream service of a model returns its result
+type ExecutionMode string
+
+const (
+	ExecutionModeAsyncPoll  ExecutionMode = "async-poll"  // Submission returns a remote task ID that is polled until done
+	ExecutionModeSyncInline ExecutionMode = "sync-inline" // Submission returns the result itself as JSON
+	ExecutionModeSyncBinary ExecutionMode = "sync-binary" // Submission returns the result file as the response body
+)
+
+// Valid reports whether m is a known execution mode
+func (m ExecutionMode) Valid() bool {
+	switch m {
+	case ExecutionModeAsyncPoll, ExecutionModeSyncInline, ExecutionModeSyncBinary:
+		return true
+	}
+	return false
+}
+
+// IsSync reports whether the result arrives with the submission
+func (m ExecutionMode) IsSync() bool {
+	return m == ExecutionModeSyncInline || m == ExecutionModeSyncBinary
+}
+
 type AIModel struct {
 	ID          uint          `gorm:"primarykey" json:"id"`
 	CreatedAt   time.Time     `json:"created_at"`
@@ -18,6 +44,14 @@ type AIModel struct {
 	Description string        `json:"description"`
 	URL         string        `json:"url"`
 	Status      AIModelStatus `gorm:"index;not null;default:'draft'" json:"status"`
-	Price       float64       `gorm:"not null;default:0.0" json:"price"`
+	Price       money.Amount  `gorm:"not null;default:0" json:"price"`
 	Parameters  JSON          `gorm:"type:jsonb;not null;default:'{}'" json:"parameters"`
+	Adapter     *AdapterSpec  `gorm:"type:jsonb;serializer:json" json:"adapter,omitempty"` // Runs the model through the generic adapter executor when set
+
+	ExecutionMode ExecutionMode `gorm:"type:varchar(20);not null;default:'async-poll'" json:"execution_mode"`
+
+	// Pricing replaces the flat Price when set. PricingVersion goes up with every change
+	// of either, and is recorded on the tasks charged with it.
+	Pricing        *PricingRule `gorm:"type:jsonb;serializer:json" json:"pricing,omitempty"`
+	PricingVersion int          `gorm:"not null;default:1" json:"pricing_version"`
 }
@@ -3,6 +3,10 @@ package models
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -14,6 +18,86 @@ type ParameterDefinition struct {
 	Required    *bool       `json:"required" validate:"required"` // Pointer to ensure presence
 	Description string      `json:"description" validate:"required"`
 	Example     interface{} `json:"example" validate:"required"` // Required, cannot be nil
+
+	// Constraints checked against task input. Min and Max bound the value of numbers,
+	// the length of strings and the item count of arrays.
+	Enum    []interface{} `json:"enum,omitempty"`
+	Min     *float64      `json:"min,omitempty"`
+	Max     *float64      `json:"max,omitempty"`
+	Default interface{}   `json:"default,omitempty"` // Filled in when an optional parameter is missing
+}
+
+// IsRequired reports whether the parameter must be present
+func (d ParameterDefinition) IsRequired() bool {
+	return d.Required != nil && *d.Required
+}
+
+// CheckValue checks a value against the type and constraints of the definition.
+// It returns an empty message when the value is acceptable, otherwise what is wrong
+// and what was expected. Types other than string, number, integer, boolean, array
+// and object are not checked.
+func (d ParameterDefinition) CheckValue(value interface{}) (message, expected string) {
+	typ := strings.ToLower(d.Type)
+	switch typ {
+	case "string":
+		if _, ok := value.(string); !ok {
+			return "must be a string", "string"
+		}
+	case "number", "float", "double":
+		if _, ok := value.(float64); !ok {
+			return "must be a number", "number"
+		}
+	case "integer", "int":
+		if f, ok := value.(float64); !ok || f != math.Trunc(f) {
+			return "must be an integer", "integer"
+		}
+	case "boolean", "bool":
+		if _, ok := value.(bool); !ok {
+			return "must be a boolean", "boolean"
+		}
+	case "array", "list":
+		if _, ok := value.([]interface{}); !ok {
+			return "must be an array", "array"
+		}
+	case "object", "map":
+		if _, ok := value.(map[string]interface{}); !ok {
+			return "must be an object", "object"
+		}
+	}
+
+	if len(d.Enum) > 0 {
+		found := false
+		for _, allowed := range d.Enum {
+			if reflect.DeepEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values, _ := json.Marshal(d.Enum)
+			return "must be one of " + string(values), "one of " + string(values)
+		}
+	}
+
+	var size float64
+	var unit string
+	switch v := value.(type) {
+	case float64:
+		size, unit = v, ""
+	case string:
+		size, unit = float64(utf8.RuneCountInString(v)), " characters"
+	case []interface{}:
+		size, unit = float64(len(v)), " items"
+	default:
+		return "", ""
+	}
+	if d.Min != nil && size < *d.Min {
+		return fmt.Sprintf("must be at least %g%s", *d.Min, unit), fmt.Sprintf("min %g%s", *d.Min, unit)
+	}
+	if d.Max != nil && size > *d.Max {
+		return fmt.Sprintf("must be at most %g%s", *d.Max, unit), fmt.Sprintf("max %g%s", *d.Max, unit)
+	}
+	return "", ""
 }
 
 // ModelParameters defines the top-level structure of AIModel parameters
@@ -23,21 +107,30 @@ type ModelParameters struct {
 	ResponseParameters []ParameterDefinition `json:"response_parameters" validate:"required,dive"`
 }
 
-// ValidateModelParameters validates the structure of the JSON parameters
-// It checks if the parameters conform to the defined schema using validator/v10
-func ValidateModelParameters(parameters JSON) error {
+// ParseModelParameters converts the JSON parameters of a model to ModelParameters
+func ParseModelParameters(parameters JSON) (*ModelParameters, error) {
 	// Convert JSON (map[string]interface{}) to ModelParameters struct
 	// First marshal to bytes
 	bytes, err := json.Marshal(parameters)
 	if err != nil {
-		return fmt.Errorf("failed to marshal parameters: %w", err)
+		return nil, fmt.Errorf("failed to marshal parameters: %w", err)
 	}
 
 	// Then unmarshal to struct
 	var modelParams ModelParameters
 	if err := json.Unmarshal(bytes, &modelParams); err != nil {
 		// This might happen if the structure is completely different (e.g. types don't match)
-		return fmt.Errorf("invalid parameters structure: %w", err)
+		return nil, fmt.Errorf("invalid parameters structure: %w", err)
+	}
+	return &modelParams, nil
+}
+
+// ValidateModelParameters validates the structure of the JSON parameters
+// It checks if the parameters conform to the defined schema using validator/v10
+func ValidateModelParameters(parameters JSON) error {
+	modelParams, err := ParseModelParameters(parameters)
+	if err != nil {
+		return err
 	}
 
 	// Validate the struct
@@ -46,5 +139,17 @@ func ValidateModelParameters(parameters JSON) error {
 		return fmt.Errorf("validation failed: %w", err)
 	}
 
+	// The constraints of request parameters must be satisfiable
+	for _, d := range modelParams.RequestBody {
+		if d.Min != nil && d.Max != nil && *d.Min > *d.Max {
+			return fmt.Errorf("validation failed: parameter '%s' has min greater than max", d.Name)
+		}
+		if d.Default != nil {
+			if msg, _ := d.CheckValue(d.Default); msg != "" {
+				return fmt.Errorf("validation failed: default of parameter '%s' %s", d.Name, msg)
+			}
+		}
+	}
+
 	return nil
 }
@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -122,3 +123,48 @@ func TestValidateModelParameters(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateModelParametersConstraints(t *testing.T) {
+	base := `{"request_header": [], "response_parameters": [], "request_body": [%s]}`
+	tests := []struct {
+		name    string
+		param   string
+		wantErr bool
+	}{
+		{"Valid constraints", `{"name": "n", "type": "integer", "required": false, "description": "d", "example": 2, "min": 1, "max": 4, "default": 2}`, false},
+		{"Min above max", `{"name": "n", "type": "integer", "required": false, "description": "d", "example": 2, "min": 5, "max": 4}`, true},
+		{"Default outside enum", `{"name": "s", "type": "string", "required": false, "description": "d", "example": "a", "enum": ["a", "b"], "default": "c"}`, true},
+		{"Default of wrong type", `{"name": "n", "type": "number", "required": false, "description": "d", "example": 1, "default": "1"}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var params JSON
+			assert.NoError(t, json.Unmarshal([]byte(fmt.Sprintf(base, tt.param)), &params))
+			err := ValidateModelParameters(params)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParameterDefinitionCheckValue(t *testing.T) {
+	min, max := 2.0, 3.0
+	def := ParameterDefinition{Type: "array", Min: &min, Max: &max}
+
+	msg, _ := def.CheckValue([]interface{}{"a", "b"})
+	assert.Empty(t, msg)
+	msg, expected := def.CheckValue([]interface{}{"a"})
+	assert.Equal(t, "must be at least 2 items", msg)
+	assert.Equal(t, "min 2 items", expected)
+	msg, _ = def.CheckValue("ab")
+	assert.Equal(t, "must be an array", msg)
+
+	// Unknown types only get the constraints checked
+	def = ParameterDefinition{Type: "image", Max: &max}
+	msg, _ = def.CheckValue("https://x")
+	assert.Equal(t, "must be at most 3 characters", msg)
+}
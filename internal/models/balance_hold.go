@@ -0,0 +1,29 @@
+package models
+
+import (
+	"aigentools-backend/pkg/money"
+	"time"
+)
+
+type HoldStatus string
+
+const (
+	HoldStatusActive   HoldStatus = "active"   // Reserved; counts against the available balance
+	HoldStatusCaptured HoldStatus = "captured" // Settled; the captured amount left the balance
+	HoldStatusReleased HoldStatus = "released" // Given back without charging anything
+)
+
+// BalanceHold reserves part of a user's available balance for a task until the task
+// finishes: completion captures it, possibly for less, failure or cancellation releases it
+type BalanceHold struct {
+	ID             uint         `gorm:"primarykey" json:"id"`
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+	UserID         uint         `gorm:"index;not null" json:"user_id"`
+	TaskID         *uint        `gorm:"index" json:"task_id,omitempty"`
+	Amount         money.Amount `gorm:"type:decimal(20,8);not null" json:"amount"`
+	CapturedAmount money.Amount `gorm:"type:decimal(20,8);default:0" json:"captured_amount"`
+	Status         HoldStatus   `gorm:"type:varchar(20);index;not null;default:'active'" json:"status"`
+	Reason         string       `gorm:"type:text" json:"reason"`
+	SettledAt      *time.Time   `json:"settled_at,omitempty"` // Set once captured or released
+}
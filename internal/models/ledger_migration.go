@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+// LedgerMigrationSkip names the check that kept a user's legacy transactions from being migrated
+type LedgerMigrationSkip string
+
+const (
+	LedgerMigrationSkipContinuity LedgerMigrationSkip = "continuity" // A transaction does not start from the balance the previous one ended with
+	LedgerMigrationSkipArithmetic LedgerMigrationSkip = "arithmetic" // BalanceAfter of a transaction is not BalanceBefore plus Amount
+	LedgerMigrationSkipChain      LedgerMigrationSkip = "chain"      // A chained transaction does not verify
+)
+
+// LedgerMigration is one run of the admin action re-signing the transactions recorded
+// before hash chaining into their users' chains
+type LedgerMigration struct {
+	ID        uint                   `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time              `json:"created_at"`
+	Operator  string                 `gorm:"type:text" json:"operator"`      // Admin who ran it
+	KeyID     string                 `gorm:"type:varchar(64)" json:"key_id"` // Ledger signing key the transactions were re-signed with
+	Users     int                    `json:"users"`                          // Users whose legacy transactions were migrated
+	Migrated  int                    `json:"migrated"`                       // Transactions re-signed as HashVersionMigrated
+	Skipped   int                    `json:"skipped"`                        // Users left as they are because a check failed
+	Entries   []LedgerMigrationEntry `gorm:"foreignKey:MigrationID" json:"entries,omitempty"`
+}
+
+// LedgerMigrationEntry records what a migration did with the transactions of one user
+type LedgerMigrationEntry struct {
+	ID            uint                `gorm:"primarykey" json:"id"`
+	MigrationID   uint                `gorm:"index;not null" json:"migration_id"`
+	UserID        uint                `gorm:"index" json:"user_id"`
+	Migrated      int                 `json:"migrated"`                               // Legacy transactions re-signed
+	FirstID       uint                `json:"first_id,omitempty"`                     // First migrated transaction
+	LastID        uint                `json:"last_id,omitempty"`                      // Last migrated transaction
+	Skip          LedgerMigrationSkip `gorm:"type:varchar(20)" json:"skip,omitempty"` // Set when the user was left as they are
+	TransactionID *uint               `json:"transaction_id,omitempty"`               // Transaction that failed the check
+	Detail        string              `gorm:"type:text" json:"detail,omitempty"`
+}
@@ -0,0 +1,270 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// JSONSchemaDialect is the JSON Schema draft the parameter schemas are written in
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaOrderKey lists the properties of a schema in definition order, which JSON objects do not keep
+const schemaOrderKey = "x-property-order"
+
+// schemaTypeKey keeps a parameter type that has no JSON Schema equivalent
+const schemaTypeKey = "x-type"
+
+// schemaType maps a parameter type to its JSON Schema type, or "" when there is none
+func schemaType(typ string) string {
+	switch strings.ToLower(typ) {
+	case "string":
+		return "string"
+	case "number", "float", "double":
+		return "number"
+	case "integer", "int":
+		return "integer"
+	case "boolean", "bool":
+		return "boolean"
+	case "array", "list":
+		return "array"
+	case "object", "map":
+		return "object"
+	}
+	return ""
+}
+
+// JSONSchema renders the definition as a JSON Schema property. Min and Max become
+// the bound keywords of the type; an untyped parameter gets all of them, which JSON
+// Schema applies to numbers, strings and arrays alike, as CheckValue does.
+func (d ParameterDefinition) JSONSchema() map[string]interface{} {
+	schema := map[string]interface{}{}
+	if d.Description != "" {
+		schema["description"] = d.Description
+	}
+	if d.Example != nil {
+		schema["examples"] = []interface{}{d.Example}
+	}
+	if len(d.Enum) > 0 {
+		schema["enum"] = d.Enum
+	}
+	if d.Default != nil {
+		schema["default"] = d.Default
+	}
+
+	typ := schemaType(d.Type)
+	if typ != "" {
+		schema["type"] = typ
+	} else if d.Type != "" {
+		schema[schemaTypeKey] = d.Type
+	}
+
+	bound := func(value *float64, keywords ...string) {
+		if value == nil {
+			return
+		}
+		for _, keyword := range keywords {
+			schema[keyword] = *value
+		}
+	}
+	switch typ {
+	case "number", "integer":
+		bound(d.Min, "minimum")
+		bound(d.Max, "maximum")
+	case "string":
+		bound(d.Min, "minLength")
+		bound(d.Max, "maxLength")
+	case "array":
+		bound(d.Min, "minItems")
+		bound(d.Max, "maxItems")
+	case "":
+		bound(d.Min, "minimum", "minLength", "minItems")
+		bound(d.Max, "maximum", "maxLength", "maxItems")
+	}
+	return schema
+}
+
+// ParametersJSONSchema renders parameter definitions as a JSON Schema object with
+// one property per parameter
+func ParametersJSONSchema(defs []ParameterDefinition) map[string]interface{} {
+	properties := map[string]interface{}{}
+	order := make([]string, 0, len(defs))
+	required := []string{}
+	for _, d := range defs {
+		properties[d.Name] = d.JSONSchema()
+		order = append(order, d.Name)
+		if d.IsRequired() {
+			required = append(required, d.Name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"$schema":      JSONSchemaDialect,
+		"type":         "object",
+		"properties":   properties,
+		schemaOrderKey: order,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// ParametersFromJSONSchema converts a JSON Schema object back to parameter definitions,
+// in the order of x-property-order when present and by name otherwise. Definitions
+// exported by ParametersJSONSchema come back unchanged. Missing descriptions and
+// examples, which definitions require, are filled in.
+func ParametersFromJSONSchema(schema map[string]interface{}) ([]ParameterDefinition, error) {
+	if t, ok := schema["type"]; ok && t != "object" {
+		return nil, errors.New("schema must describe an object")
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	if len(properties) == 0 {
+		return nil, errors.New("schema has no properties")
+	}
+
+	required := map[string]bool{}
+	if list, ok := schema["required"].([]interface{}); ok {
+		for _, name := range list {
+			if s, ok := name.(string); ok {
+				required[s] = true
+			}
+		}
+	}
+
+	defs := make([]ParameterDefinition, 0, len(properties))
+	for _, name := range schemaPropertyOrder(schema, properties) {
+		prop, ok := properties[name].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("property '%s' must be a schema object", name)
+		}
+		def, err := parameterFromSchema(name, prop, required[name])
+		if err != nil {
+			return nil, err
+		}
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
+
+// schemaPropertyOrder lists the property names of a schema, those of x-property-order first
+func schemaPropertyOrder(schema, properties map[string]interface{}) []string {
+	names := make([]string, 0, len(properties))
+	seen := map[string]bool{}
+	if order, ok := schema[schemaOrderKey].([]interface{}); ok {
+		for _, name := range order {
+			s, ok := name.(string)
+			if _, exists := properties[s]; ok && exists && !seen[s] {
+				names = append(names, s)
+				seen[s] = true
+			}
+		}
+	}
+
+	var rest []string
+	for name := range properties {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
+func parameterFromSchema(name string, prop map[string]interface{}, required bool) (ParameterDefinition, error) {
+	def := ParameterDefinition{Name: name, Required: &required}
+
+	switch t := prop["type"].(type) {
+	case string:
+		def.Type = t
+	case []interface{}:
+		// A nullable type such as ["string", "null"]
+		for _, v := range t {
+			if s, ok := v.(string); ok && s != "null" {
+				def.Type = s
+				break
+			}
+		}
+	}
+	if def.Type == "" {
+		if t, ok := prop[schemaTypeKey].(string); ok {
+			def.Type = t
+		} else {
+			def.Type = "any"
+		}
+	}
+	if schemaType(def.Type) == "" && prop["type"] != nil {
+		return def, fmt.Errorf("property '%s' has unsupported type %v", name, prop["type"])
+	}
+
+	def.Description, _ = prop["description"].(string)
+	if def.Description == "" {
+		def.Description, _ = prop["title"].(string)
+	}
+	if def.Description == "" {
+		def.Description = name
+	}
+
+	if enum, ok := prop["enum"].([]interface{}); ok {
+		def.Enum = enum
+	}
+	def.Default = prop["default"]
+
+	keywords := map[string][2]string{
+		"number":  {"minimum", "maximum"},
+		"integer": {"minimum", "maximum"},
+		"string":  {"minLength", "maxLength"},
+		"array":   {"minItems", "maxItems"},
+	}
+	if pair, ok := keywords[schemaType(def.Type)]; ok {
+		def.Min = schemaNumber(prop, pair[0])
+		def.Max = schemaNumber(prop, pair[1])
+	} else {
+		def.Min = schemaNumber(prop, "minimum", "minLength", "minItems")
+		def.Max = schemaNumber(prop, "maximum", "maxLength", "maxItems")
+	}
+
+	if examples, ok := prop["examples"].([]interface{}); ok && len(examples) > 0 {
+		def.Example = examples[0]
+	} else if example, ok := prop["example"]; ok && example != nil {
+		def.Example = example
+	} else {
+		def.Example = exampleValue(def)
+	}
+	return def, nil
+}
+
+// schemaNumber returns the first of the keywords the schema sets to a number
+func schemaNumber(prop map[string]interface{}, keywords ...string) *float64 {
+	for _, keyword := range keywords {
+		if v, ok := prop[keyword].(float64); ok {
+			return &v
+		}
+	}
+	return nil
+}
+
+// exampleValue makes up an example for a definition that has none
+func exampleValue(d ParameterDefinition) interface{} {
+	if d.Default != nil {
+		return d.Default
+	}
+	if len(d.Enum) > 0 {
+		return d.Enum[0]
+	}
+	switch schemaType(d.Type) {
+	case "number", "integer":
+		if d.Min != nil {
+			return *d.Min
+		}
+		return 0
+	case "boolean":
+		return false
+	case "array":
+		return []interface{}{}
+	case "object":
+		return map[string]interface{}{}
+	}
+	return ""
+}
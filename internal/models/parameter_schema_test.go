@@ -0,0 +1,69 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParametersJSONSchemaRoundTrip(t *testing.T) {
+	required, optional := true, false
+	minSteps, maxSteps, maxPrompt := 1.0, 50.0, 500.0
+	defs := []ParameterDefinition{
+		{Name: "prompt", Type: "string", Required: &required, Description: "Prompt", Example: "a cat", Max: &maxPrompt},
+		{Name: "steps", Type: "integer", Required: &optional, Description: "Steps", Example: 20.0, Min: &minSteps, Max: &maxSteps, Default: 20.0},
+		{Name: "size", Type: "string", Required: &optional, Description: "Size", Example: "512x512", Enum: []interface{}{"512x512", "1024x1024"}},
+		{Name: "image", Type: "file", Required: &optional, Description: "Reference image", Example: "https://cdn/a.png", Max: &maxPrompt},
+	}
+
+	schema := ParametersJSONSchema(defs)
+	props := schema["properties"].(map[string]interface{})
+	assert.Equal(t, JSONSchemaDialect, schema["$schema"])
+	assert.Equal(t, []string{"prompt"}, schema["required"])
+	assert.Equal(t, 500.0, props["prompt"].(map[string]interface{})["maxLength"])
+	assert.Equal(t, 1.0, props["steps"].(map[string]interface{})["minimum"])
+	assert.Equal(t, "file", props["image"].(map[string]interface{})["x-type"])
+
+	// Read it back the way a client would send it
+	raw, err := json.Marshal(schema)
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(raw, &decoded))
+
+	imported, err := ParametersFromJSONSchema(decoded)
+	require.NoError(t, err)
+	assert.Equal(t, defs, imported)
+}
+
+func TestParametersFromJSONSchema(t *testing.T) {
+	var schema map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["prompt"],
+		"properties": {
+			"prompt": {"type": "string", "title": "Prompt", "minLength": 1},
+			"seed": {"type": ["integer", "null"], "minimum": 0},
+			"tags": {"type": "array", "maxItems": 4}
+		}
+	}`), &schema)
+
+	defs, err := ParametersFromJSONSchema(schema)
+	require.NoError(t, err)
+	require.Len(t, defs, 3)
+
+	assert.Equal(t, "prompt", defs[0].Name)
+	assert.True(t, defs[0].IsRequired())
+	assert.Equal(t, "Prompt", defs[0].Description)
+	assert.Equal(t, 1.0, *defs[0].Min)
+
+	assert.Equal(t, "integer", defs[1].Type)
+	assert.Equal(t, 0.0, defs[1].Example) // Made up from the minimum
+	assert.Equal(t, 4.0, *defs[2].Max)
+
+	_, err = ParametersFromJSONSchema(map[string]interface{}{"type": "array"})
+	assert.Error(t, err)
+	_, err = ParametersFromJSONSchema(map[string]interface{}{"properties": map[string]interface{}{"x": map[string]interface{}{"type": "date"}}})
+	assert.ErrorContains(t, err, "unsupported type")
+}
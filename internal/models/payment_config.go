@@ -1,6 +1,7 @@
 package models
 
 import (
+	"aigentools-backend/pkg/money"
 	"time"
 
 	"gorm.io/datatypes"
@@ -31,12 +32,12 @@ type PaymentConfig struct {
 }
 
 type PaymentOrderRecord struct {
-	ID          string  `gorm:"primarykey;type:varchar(32)"` // Order ID
-	UserID      uint    `gorm:"index;not null"`
-	Amount      float64 `gorm:"type:decimal(20,2);not null"`
-	Status      string  `gorm:"type:varchar(20);default:'pending'"` // pending, paid, cancelled
-	PaymentUUID string  `gorm:"type:varchar(36);index"`             // Which payment config was used
-	ExternalID  string  `gorm:"type:varchar(64);index"`             // Transaction ID from payment gateway
+	ID          string       `gorm:"primarykey;type:varchar(32)"` // Order ID
+	UserID      uint         `gorm:"index;not null"`
+	Amount      money.Amount `gorm:"type:decimal(20,2);not null"`        // Whole cents
+	Status      string       `gorm:"type:varchar(20);default:'pending'"` // pending, paid, cancelled
+	PaymentUUID string       `gorm:"type:varchar(36);index"`             // Which payment config was used
+	ExternalID  string       `gorm:"type:varchar(64);index"`             // Transaction ID from payment gateway
 
 	// 新增字段
 	OrderType   string     `gorm:"type:varchar(20);default:'payment';index"` // payment, manual
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"aigentools-backend/pkg/money"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// PipelineStatus is the overall status of a pipeline
+type PipelineStatus string
+
+const (
+	PipelineStatusRunning   PipelineStatus = "running"
+	PipelineStatusCompleted PipelineStatus = "completed" // Every step completed
+	PipelineStatusFailed    PipelineStatus = "failed"    // A step failed or was cancelled; the steps after it were skipped
+)
+
+// PipelineStep is one node of a pipeline DAG. Input may reference the outputs of
+// other steps as {{steps.<id>.result_url}} or {{steps.<id>.task_id}}; every
+// referenced step becomes a dependency in addition to DependsOn.
+type PipelineStep struct {
+	ID        string                 `json:"id"`
+	ModelID   uint                   `json:"model_id"`
+	Input     map[string]interface{} `json:"input"`
+	DependsOn []string               `json:"depends_on,omitempty"`
+}
+
+// Pipeline is a submitted DAG of steps. Each step runs as its own Task.
+type Pipeline struct {
+	ID          uint           `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	CreatorID   uint           `gorm:"index" json:"creator_id"`
+	CreatorName string         `json:"creator_name"`
+	Name        string         `gorm:"type:varchar(255)" json:"name"`
+	Steps       datatypes.JSON `gorm:"type:jsonb" json:"steps" swaggertype:"array,object"` // []PipelineStep with dependencies resolved
+	Status      PipelineStatus `gorm:"type:varchar(20);index" json:"status"`
+	TotalCost   money.Amount   `json:"total_cost"`
+	FinishedAt  *time.Time     `json:"finished_at,omitempty"`
+}
+
+// TableName overrides the table name
+func (Pipeline) TableName() string {
+	return "pipelines"
+}
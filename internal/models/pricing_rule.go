@@ -0,0 +1,212 @@
+package models
+
+import (
+	"aigentools-backend/pkg/expr"
+	"aigentools-backend/pkg/money"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// PricingRule computes the price of a task from its input. It is either an expression
+// over the request parameters, or a base price plus per-unit terms, times multipliers:
+//
+//	(base + Σ per_unit.price × value) × Π multiplier factor
+//
+// The result is clamped to Min and Max and rounded to cents, half away from zero.
+// The rule itself is a formula and computes in float64; only its result is money.
+type PricingRule struct {
+	Expression  string              `json:"expression,omitempty"` // Replaces base, per_unit and multipliers when set
+	Base        float64             `json:"base,omitempty"`
+	PerUnit     []PricingTerm       `json:"per_unit,omitempty"`
+	Multipliers []PricingMultiplier `json:"multipliers,omitempty"`
+	Min         *float64            `json:"min,omitempty"`
+	Max         *float64            `json:"max,omitempty"`
+}
+
+// PricingTerm adds Price for every unit of a numeric parameter, such as every second of a video.
+// The parameter cannot be negative.
+type PricingTerm struct {
+	Param string  `json:"param"`
+	Price float64 `json:"price"`
+}
+
+// PricingMultiplier scales the price by a parameter. With Values the factor is looked up by
+// the parameter value, such as a resolution; without, the numeric value is the factor and has
+// to be a count of at least 1. Default applies when the parameter is missing or not listed, and is 1 when unset.
+type PricingMultiplier struct {
+	Param   string             `json:"param"`
+	Values  map[string]float64 `json:"values,omitempty"`
+	Default *float64           `json:"default,omitempty"`
+}
+
+// PriceComponent is one step of a computed price
+type PriceComponent struct {
+	Kind   string      `json:"kind"` // base, per_unit, multiplier, expression, min or max
+	Param  string      `json:"param,omitempty"`
+	Value  interface{} `json:"value,omitempty"`
+	Amount float64     `json:"amount,omitempty"` // Added to the price
+	Factor float64     `json:"factor,omitempty"` // Multiplied into the price
+}
+
+// Validate checks that the rule can be evaluated
+func (r *PricingRule) Validate() error {
+	if r.Expression != "" {
+		if _, err := expr.Compile(r.Expression); err != nil {
+			return fmt.Errorf("pricing expression: %w", err)
+		}
+		if r.Base != 0 || len(r.PerUnit) > 0 || len(r.Multipliers) > 0 {
+			return errors.New("pricing expression cannot be combined with base, per_unit or multipliers")
+		}
+	}
+	if r.Base < 0 {
+		return errors.New("pricing base cannot be negative")
+	}
+	for i, term := range r.PerUnit {
+		if term.Param == "" {
+			return fmt.Errorf("pricing per_unit[%d] needs a param", i)
+		}
+		if term.Price < 0 {
+			return fmt.Errorf("pricing per_unit[%d] has a negative price", i)
+		}
+	}
+	for i, m := range r.Multipliers {
+		if m.Param == "" {
+			return fmt.Errorf("pricing multipliers[%d] needs a param", i)
+		}
+		if m.Default != nil && *m.Default < 0 {
+			return fmt.Errorf("pricing multipliers[%d] has a negative default", i)
+		}
+		for value, factor := range m.Values {
+			if factor < 0 {
+				return fmt.Errorf("pricing multipliers[%d] has a negative factor for %q", i, value)
+			}
+		}
+	}
+	if r.Min != nil && *r.Min < 0 {
+		return errors.New("pricing min cannot be negative")
+	}
+	if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+		return errors.New("pricing min is greater than max")
+	}
+	return nil
+}
+
+// Params returns the request parameters the rule reads
+func (r *PricingRule) Params() []string {
+	if r.Expression != "" {
+		e, err := expr.Compile(r.Expression)
+		if err != nil {
+			return nil
+		}
+		return e.Vars()
+	}
+	var params []string
+	for _, term := range r.PerUnit {
+		params = append(params, term.Param)
+	}
+	for _, m := range r.Multipliers {
+		params = append(params, m.Param)
+	}
+	return params
+}
+
+// Evaluate computes the price for a request body, with the steps that led to it
+func (r *PricingRule) Evaluate(body map[string]interface{}) (money.Amount, []PriceComponent, error) {
+	var price float64
+	var steps []PriceComponent
+
+	if r.Expression != "" {
+		e, err := expr.Compile(r.Expression)
+		if err != nil {
+			return 0, nil, err
+		}
+		if price, err = e.EvalNumber(body); err != nil {
+			return 0, nil, fmt.Errorf("pricing expression: %w", err)
+		}
+		steps = append(steps, PriceComponent{Kind: "expression", Amount: price})
+	} else {
+		price = r.Base
+		steps = append(steps, PriceComponent{Kind: "base", Amount: r.Base})
+		for _, term := range r.PerUnit {
+			n, err := pricingNumber(body, term.Param)
+			if err != nil {
+				return 0, nil, err
+			}
+			if n < 0 {
+				return 0, nil, fmt.Errorf("pricing parameter '%s' cannot be negative", term.Param)
+			}
+			price += n * term.Price
+			steps = append(steps, PriceComponent{Kind: "per_unit", Param: term.Param, Value: n, Amount: n * term.Price})
+		}
+		for _, m := range r.Multipliers {
+			factor, value, err := m.factor(body)
+			if err != nil {
+				return 0, nil, err
+			}
+			price *= factor
+			steps = append(steps, PriceComponent{Kind: "multiplier", Param: m.Param, Value: value, Factor: factor})
+		}
+	}
+
+	if r.Min != nil && price < *r.Min {
+		steps = append(steps, PriceComponent{Kind: "min", Amount: *r.Min - price})
+		price = *r.Min
+	}
+	if r.Max != nil && price > *r.Max {
+		steps = append(steps, PriceComponent{Kind: "max", Amount: *r.Max - price})
+		price = *r.Max
+	}
+	if math.IsNaN(price) || math.IsInf(price, 0) {
+		return 0, nil, fmt.Errorf("pricing gives an invalid price %g", price)
+	}
+	if price < 0 {
+		return 0, nil, fmt.Errorf("pricing gives a negative price %g", price)
+	}
+	amount, err := money.FromFloat(price)
+	if err == nil {
+		amount, err = amount.Round(2)
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("pricing gives an out-of-range price %g", price)
+	}
+	return amount, steps, nil
+}
+
+// factor returns the factor of the multiplier for a request body and the value it was chosen by
+func (m PricingMultiplier) factor(body map[string]interface{}) (float64, interface{}, error) {
+	fallback := 1.0
+	if m.Default != nil {
+		fallback = *m.Default
+	}
+	value, ok := body[m.Param]
+	if !ok || value == nil {
+		return fallback, nil, nil
+	}
+
+	if m.Values == nil {
+		n, err := pricingNumber(body, m.Param)
+		if err == nil && (n < 1 || n != math.Trunc(n)) {
+			err = fmt.Errorf("pricing parameter '%s' must be a whole number of at least 1", m.Param)
+		}
+		return n, value, err
+	}
+	if factor, ok := m.Values[strings.TrimSpace(fmt.Sprint(value))]; ok {
+		return factor, value, nil
+	}
+	return fallback, value, nil
+}
+
+// pricingNumber reads a numeric parameter; a missing one counts as zero
+func pricingNumber(body map[string]interface{}, param string) (float64, error) {
+	switch v := body[param].(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	}
+	return 0, fmt.Errorf("pricing parameter '%s' must be a number", param)
+}
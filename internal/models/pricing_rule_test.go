@@ -0,0 +1,76 @@
+package models
+
+import (
+	"aigentools-backend/pkg/money"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPricingRuleEvaluate(t *testing.T) {
+	minPrice, hd := 0.5, 1.5
+	rule := &PricingRule{
+		Base:    0.2,
+		PerUnit: []PricingTerm{{Param: "duration", Price: 0.1}},
+		Multipliers: []PricingMultiplier{
+			{Param: "resolution", Values: map[string]float64{"720p": 1, "1080p": hd}},
+			{Param: "n"},
+		},
+		Min: &minPrice,
+	}
+	require.NoError(t, rule.Validate())
+	assert.Equal(t, []string{"duration", "resolution", "n"}, rule.Params())
+
+	// (0.2 + 0.1 × 10) × 1.5 × 2
+	price, steps, err := rule.Evaluate(map[string]interface{}{"duration": 10.0, "resolution": "1080p", "n": 2.0})
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("3.6"), price)
+	assert.Len(t, steps, 4)
+
+	// A missing count keeps the factor 1, and the minimum applies
+	price, steps, err = rule.Evaluate(map[string]interface{}{"duration": 1.0, "resolution": "720p"})
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("0.5"), price)
+	assert.Equal(t, "min", steps[len(steps)-1].Kind)
+
+	_, _, err = rule.Evaluate(map[string]interface{}{"duration": "long"})
+	assert.ErrorContains(t, err, "must be a number")
+
+	// Prices that do not fit an amount are rejected rather than clamped
+	_, _, err = rule.Evaluate(map[string]interface{}{"duration": 1e300, "resolution": "720p"})
+	assert.ErrorContains(t, err, "out-of-range price")
+	_, _, err = rule.Evaluate(map[string]interface{}{"duration": math.Inf(1), "resolution": "720p"})
+	assert.ErrorContains(t, err, "invalid price")
+
+	// Quantities cannot lower the price below base, and counts are whole
+	_, _, err = rule.Evaluate(map[string]interface{}{"duration": -4.0, "resolution": "720p"})
+	assert.ErrorContains(t, err, "cannot be negative")
+	for _, n := range []float64{0, 0.01, 1.5, -2} {
+		_, _, err = rule.Evaluate(map[string]interface{}{"duration": 1.0, "resolution": "720p", "n": n})
+		assert.ErrorContains(t, err, "whole number", "n=%g", n)
+	}
+
+	negative := -1.0
+	assert.Error(t, (&PricingRule{PerUnit: []PricingTerm{{Param: "duration", Price: -0.1}}}).Validate())
+	assert.Error(t, (&PricingRule{Multipliers: []PricingMultiplier{{Param: "n", Default: &negative}}}).Validate())
+}
+
+func TestPricingRuleExpression(t *testing.T) {
+	rule := &PricingRule{Expression: "0.05 * duration * (resolution == '1080p' ? 2 : 1)"}
+	require.NoError(t, rule.Validate())
+
+	price, _, err := rule.Evaluate(map[string]interface{}{"duration": 5.0, "resolution": "1080p"})
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("0.5"), price)
+
+	_, _, err = rule.Evaluate(map[string]interface{}{"resolution": "1080p"})
+	assert.ErrorContains(t, err, "unknown variable")
+
+	assert.Error(t, (&PricingRule{Expression: "duration *"}).Validate())
+	assert.Error(t, (&PricingRule{Expression: "duration", Base: 1}).Validate())
+
+	_, _, err = (&PricingRule{Expression: "-1"}).Evaluate(nil)
+	assert.ErrorContains(t, err, "negative")
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"aigentools-backend/pkg/money"
+	"time"
+)
+
+type ReconciliationStatus string
+
+const (
+	ReconciliationStatusRunning   ReconciliationStatus = "running"
+	ReconciliationStatusCompleted ReconciliationStatus = "completed" // Every check ran; mismatches may still have been found
+	ReconciliationStatusFailed    ReconciliationStatus = "failed"    // The run stopped on an error
+)
+
+// MismatchKind names the reconciliation check a mismatch comes from
+type MismatchKind string
+
+const (
+	MismatchBalance       MismatchKind = "balance"        // User.Balance differs from the sum of the user's transaction amounts
+	MismatchTotalConsumed MismatchKind = "total_consumed" // User.TotalConsumed differs from the consumption in the ledger
+	MismatchHeldAmount    MismatchKind = "held_amount"    // User.HeldAmount differs from the user's active balance holds
+	MismatchContinuity    MismatchKind = "continuity"     // A transaction does not start from the balance the previous one ended with
+	MismatchArithmetic    MismatchKind = "arithmetic"     // BalanceAfter of a transaction is not BalanceBefore plus Amount
+	MismatchOrderTopup    MismatchKind = "order_topup"    // A paid order without exactly one matching topup transaction, or the reverse
+)
+
+type MismatchSeverity string
+
+const (
+	MismatchSeverityWarning  MismatchSeverity = "warning"
+	MismatchSeverityCritical MismatchSeverity = "critical" // Money is unaccounted for; raises an alert
+)
+
+// ReconciliationReport is one run of the balance reconciliation job
+type ReconciliationReport struct {
+	ID            uint                 `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time            `json:"created_at"`
+	UpdatedAt     time.Time            `json:"updated_at"`
+	Trigger       string               `gorm:"type:text" json:"trigger"` // "scheduled" or the username of the admin who started it
+	Status        ReconciliationStatus `gorm:"type:varchar(20);index;not null;default:'running'" json:"status"`
+	UsersChecked  int                  `json:"users_checked"`
+	OrdersChecked int                  `json:"orders_checked"`
+	Mismatches    int                  `json:"mismatches"`
+	Critical      int                  `json:"critical"`
+	Error         string               `gorm:"type:text" json:"error,omitempty"`
+	FinishedAt    *time.Time           `json:"finished_at,omitempty"`
+}
+
+// ReconciliationMismatch is one discrepancy a reconciliation run found
+type ReconciliationMismatch struct {
+	ID            uint             `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time        `json:"created_at"`
+	ReportID      uint             `gorm:"index;not null" json:"report_id"`
+	Kind          MismatchKind     `gorm:"type:varchar(30);index" json:"kind"`
+	Severity      MismatchSeverity `gorm:"type:varchar(20)" json:"severity"`
+	UserID        uint             `gorm:"index" json:"user_id,omitempty"`
+	TransactionID *uint            `json:"transaction_id,omitempty"`
+	OrderID       string           `gorm:"type:varchar(64)" json:"order_id,omitempty"`
+	Expected      money.Amount     `gorm:"type:decimal(20,8)" json:"expected"` // What the ledger says
+	Actual        money.Amount     `gorm:"type:decimal(20,8)" json:"actual"`   // What is stored
+	Detail        string           `gorm:"type:text" json:"detail"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"aigentools-backend/pkg/money"
 	"time"
 
 	"gorm.io/datatypes"
@@ -16,8 +17,25 @@ const (
 	TaskStatusCompleted        TaskStatus = 4
 	TaskStatusFailed           TaskStatus = 5
 	TaskStatusCancelled        TaskStatus = 6
+	TaskStatusScheduled        TaskStatus = 7
+	TaskStatusBlocked          TaskStatus = 8
 )
 
+// TaskPriority defines the scheduling priority of a task. Higher levels get a
+// larger share of the workers, but every level keeps making progress.
+type TaskPriority int
+
+const (
+	TaskPriorityLow    TaskPriority = 1
+	TaskPriorityNormal TaskPriority = 2
+	TaskPriorityHigh   TaskPriority = 3
+)
+
+// Valid reports whether p is a known priority level
+func (p TaskPriority) Valid() bool {
+	return p >= TaskPriorityLow && p <= TaskPriorityHigh
+}
+
 // Task represents a task in the system
 type Task struct {
 	ID           uint           `gorm:"primarykey" json:"id"`
@@ -26,14 +44,32 @@ type Task struct {
 	DeletedAt    *time.Time     `gorm:"index" json:"deleted_at,omitempty"`
 	InputData    datatypes.JSON `gorm:"type:jsonb" json:"input_data" swaggertype:"object"`
 	CreatorID    uint           `json:"creator_id"`
+	ModelID      uint           `gorm:"index" json:"model_id,omitempty"` // AIModel the task was priced and is run with
 	CreatorName  string         `json:"creator_name"`
 	Status       TaskStatus     `json:"status"`
+	Priority     TaskPriority   `json:"priority" gorm:"default:2"`
 	ResultURL    string         `json:"result_url"`
+	ResultData   datatypes.JSON `gorm:"type:jsonb" json:"result_data,omitempty" swaggertype:"object"` // Inline result of a sync-inline model
 	RetryCount   int            `json:"retry_count" gorm:"default:0"`
 	MaxRetries   int            `json:"max_retries" gorm:"default:3"`
 	ErrorLog     string         `json:"error_log"`
 	RemoteTaskID string         `json:"remote_task_id"`
-	Cost         float64        `json:"cost"`
+	Cost         money.Amount   `json:"cost"`
+	RefundedAt   *time.Time     `json:"refunded_at,omitempty"`                           // Set once Cost has been returned to the creator or its hold released
+	ScheduledAt  *time.Time     `gorm:"index" json:"scheduled_at,omitempty"`             // Earliest start time; the task waits as Scheduled until then
+	BatchID      *uint          `gorm:"index" json:"batch_id,omitempty"`                 // Set when the task was submitted as part of a TaskBatch
+	PipelineID   *uint          `gorm:"index" json:"pipeline_id,omitempty"`              // Set when the task runs a step of a Pipeline
+	PipelineStep string         `gorm:"type:varchar(64)" json:"pipeline_step,omitempty"` // Step ID within the pipeline
+	CallbackURL  string         `gorm:"type:varchar(500)" json:"callback_url,omitempty"` // Notified with a signed webhook once the task finishes
+
+	PricingVersion int   `json:"pricing_version,omitempty"`      // PricingVersion of the model Cost was computed with
+	HoldID         *uint `gorm:"index" json:"hold_id,omitempty"` // BalanceHold reserving Cost until the task finishes
+
+	// Polling state, set once the task has been submitted upstream
+	RemoteQueryURL string     `json:"remote_query_url,omitempty"`
+	SubmittedAt    *time.Time `json:"submitted_at,omitempty"`
+	NextPollAt     *time.Time `gorm:"index" json:"next_poll_at,omitempty"`
+	PollErrorCount int        `json:"-" gorm:"default:0"`
 }
 
 // TableName overrides the table name
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// TaskArtifact is one output file of a task, usually copied to OSS.
+// Width, Height and Duration are only set when known.
+type TaskArtifact struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	TaskID      uint      `gorm:"index;not null" json:"task_id"`
+	Position    int       `gorm:"not null;default:0" json:"position"` // Order in the upstream output; 0 is the primary artifact
+	URL         string    `gorm:"type:text;not null" json:"url"`
+	OSSKey      string    `gorm:"type:varchar(500)" json:"oss_key,omitempty"`
+	OriginalURL string    `gorm:"type:text" json:"original_url,omitempty"` // Where the upstream service served the file
+	MimeType    string    `gorm:"type:varchar(100)" json:"mime_type,omitempty"`
+	Size        int64     `json:"size,omitempty"`                             // Bytes
+	Checksum    string    `gorm:"type:varchar(64)" json:"checksum,omitempty"` // Hex SHA-256 of the content
+	Width       int       `json:"width,omitempty"`
+	Height      int       `json:"height,omitempty"`
+	Duration    float64   `json:"duration,omitempty"` // Seconds, for video and audio
+}
+
+// TableName overrides the table name
+func (TaskArtifact) TableName() string {
+	return "task_artifacts"
+}
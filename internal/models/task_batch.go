@@ -0,0 +1,34 @@
+package models
+
+import (
+	"aigentools-backend/pkg/money"
+	"time"
+)
+
+// TaskBatchStatus is the overall status of a batch, derived from its tasks
+type TaskBatchStatus string
+
+const (
+	TaskBatchStatusPending   TaskBatchStatus = "pending"   // No task has started yet
+	TaskBatchStatusRunning   TaskBatchStatus = "running"   // Some tasks are still to finish
+	TaskBatchStatusCompleted TaskBatchStatus = "completed" // Every task completed
+	TaskBatchStatusCancelled TaskBatchStatus = "cancelled" // Every task was cancelled
+	TaskBatchStatusFinished  TaskBatchStatus = "finished"  // Every task ended, with mixed outcomes
+)
+
+// TaskBatch groups tasks submitted and paid for together
+type TaskBatch struct {
+	ID          uint         `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+	CreatorID   uint         `gorm:"index" json:"creator_id"`
+	CreatorName string       `json:"creator_name"`
+	TaskCount   int          `json:"task_count"`
+	TotalCost   money.Amount `json:"total_cost"`
+	CancelledAt *time.Time   `json:"cancelled_at,omitempty"`
+}
+
+// TableName overrides the table name
+func (TaskBatch) TableName() string {
+	return "task_batches"
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// TaskEvent records a single status transition of a task
+type TaskEvent struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time  `gorm:"precision:3" json:"created_at"`
+	TaskID     uint       `gorm:"index;not null" json:"task_id"`
+	FromStatus TaskStatus `json:"from_status"`
+	ToStatus   TaskStatus `json:"to_status"`
+	Actor      string     `gorm:"type:varchar(100)" json:"actor"` // "system" or "user:<id>"
+	Reason     string     `gorm:"type:text" json:"reason"`
+}
+
+// TableName overrides the table name
+func (TaskEvent) TableName() string {
+	return "task_events"
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"aigentools-backend/pkg/money"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
@@ -17,31 +18,82 @@ const (
 	TransactionTypeUserRefund  TransactionType = "user_refund"
 	TransactionTypeUserTopup   TransactionType = "user_topup"   // 用户在线充值
 	TransactionTypeManualTopup TransactionType = "manual_topup" // 管理员手动充值
+	TransactionTypeHold        TransactionType = "balance_hold" // 任务提交时冻结余额
+	TransactionTypeHoldRelease TransactionType = "hold_release" // 任务失败或取消时解冻
+)
+
+// Hash versions say how the amounts of a transaction are written into its hash
+const (
+	HashVersionFloat   = 1 // float64 amounts formatted with %.8f, for transactions recorded before amounts were exact
+	HashVersionDecimal = 2 // exact decimal amounts with 8 places, under a "v2" marker
+	HashVersionChained = 3 // as version 2 under a "v3" marker, chained to the user's previous transaction by PrevHash
+	// As version 3 under a "v4" marker, for a transaction recorded before chaining that was
+	// re-signed into its user's chain by an admin's LedgerMigration. Hashes of those covered the creation
+	// time in nanoseconds, which the database does not store, so they cannot be verified.
+	HashVersionMigrated = 4
+
+	CurrentHashVersion = HashVersionChained
 )
 
 type Transaction struct {
 	ID            uint            `gorm:"primarykey"`
 	CreatedAt     time.Time       `gorm:"precision:3"` // Millisecond precision
 	UserID        uint            `gorm:"index;not null"`
-	Amount        float64         `gorm:"type:decimal(20,8);not null"`
-	BalanceBefore float64         `gorm:"type:decimal(20,8);not null"`
-	BalanceAfter  float64         `gorm:"type:decimal(20,8);not null"`
+	Amount        money.Amount    `gorm:"type:decimal(20,8);not null"`
+	BalanceBefore money.Amount    `gorm:"type:decimal(20,8);not null"`
+	BalanceAfter  money.Amount    `gorm:"type:decimal(20,8);not null"`
 	Reason        string          `gorm:"type:text"`
 	Operator      string          `gorm:"type:varchar(100)"` // Username or 'system'
 	OperatorID    uint            `gorm:"index;default:0"`   // 0 for system, otherwise UserID
 	Type          TransactionType `gorm:"type:varchar(50);index;default:'system_auto'"`
 	IPAddress     string          `gorm:"type:varchar(50)"`
 	DeviceInfo    string          `gorm:"type:varchar(255)"`
-	Hash          string          `gorm:"type:varchar(64);default:''"` // HMAC SHA256
+	Hash          string          `gorm:"type:varchar(64);default:''"`       // HMAC SHA256
+	HashVersion   int             `gorm:"not null;default:1"`                // Existing rows keep the version they were hashed with
+	PrevHash      string          `gorm:"type:varchar(64);default:''"`       // Hash of the user's previous transaction, from version 3
+	KeyID         string          `gorm:"type:varchar(64);default:''"`       // Ledger signing key of the hash; empty for the legacy key
+	OrderID       string          `gorm:"type:varchar(32);index;default:''"` // Payment order a topup pays for; not part of the hash
+
+	// Set on the records of a BalanceHold. HeldAmount is the change of the user's held
+	// amount, while Amount stays the change of the balance itself.
+	HoldID     *uint        `gorm:"index"`
+	HeldAmount money.Amount `gorm:"type:decimal(20,8);default:0"`
 }
 
-// GenerateHash generates a tamper-proof hash for the transaction
+// GenerateHash generates a tamper-proof hash for the transaction with its HashVersion.
+// A transaction without one is hashed with, and marked as, the current version.
 func (t *Transaction) GenerateHash(secret string) string {
-	data := fmt.Sprintf("%d|%d|%.8f|%.8f|%.8f|%s|%s|%s|%d",
-		t.UserID, t.CreatedAt.UnixNano(), t.Amount, t.BalanceBefore, t.BalanceAfter,
+	if t.HashVersion == 0 {
+		t.HashVersion = CurrentHashVersion
+	}
+
+	format := func(a money.Amount) string { return a.StringFixed(money.Scale) }
+	prefix := fmt.Sprintf("v%d|", t.HashVersion)
+	if t.HashVersion == HashVersionFloat {
+		format = func(a money.Amount) string { return fmt.Sprintf("%.8f", a.Float64()) }
+		prefix = ""
+	}
+
+	data := fmt.Sprintf("%s%d|%d|%s|%s|%s|%s|%s|%s|%d", prefix,
+		t.UserID, t.CreatedAt.UnixNano(), format(t.Amount), format(t.BalanceBefore), format(t.BalanceAfter),
 		t.Reason, t.Operator, t.Type, t.OperatorID)
+	if t.HoldID != nil {
+		// Only hold records cover the hold fields, so older hashes stay valid
+		data += fmt.Sprintf("|%d|%s", *t.HoldID, format(t.HeldAmount))
+	}
+	if t.HashVersion >= HashVersionChained {
+		data += "|" + t.PrevHash
+	}
 
 	h := hmac.New(sha256.New, []byte(secret))
 	h.Write([]byte(data))
 	return hex.EncodeToString(h.Sum(nil))
 }
+
+// VerifyHash reports whether the hash matches the transaction under its HashVersion
+func (t *Transaction) VerifyHash(secret string) bool {
+	if t.HashVersion == 0 {
+		return false
+	}
+	return hmac.Equal([]byte(t.Hash), []byte(t.GenerateHash(secret)))
+}
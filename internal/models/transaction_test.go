@@ -0,0 +1,51 @@
+package models
+
+import (
+	"aigentools-backend/pkg/money"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionHashVersions(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr := Transaction{
+		UserID:        7,
+		CreatedAt:     createdAt,
+		Amount:        money.MustParse("-0.1"),
+		BalanceBefore: money.MustParse("0.3"),
+		BalanceAfter:  money.MustParse("0.2"),
+		Reason:        "Task 1",
+		Operator:      "system",
+		Type:          TransactionTypeUserConsume,
+	}
+
+	// A transaction recorded with float64 amounts keeps verifying as version 1
+	legacyData := fmt.Sprintf("%d|%d|%.8f|%.8f|%.8f|%s|%s|%s|%d",
+		7, createdAt.UnixNano(), -0.1, 0.3, 0.2, "Task 1", "system", TransactionTypeUserConsume, 0)
+	h := hmac.New(sha256.New, []byte("secret"))
+	h.Write([]byte(legacyData))
+	legacy := tr
+	legacy.HashVersion = HashVersionFloat
+	legacy.Hash = hex.EncodeToString(h.Sum(nil))
+	assert.True(t, legacy.VerifyHash("secret"))
+
+	// New transactions are hashed with the current version, which differs from version 1
+	tr.Hash = tr.GenerateHash("secret")
+	assert.Equal(t, CurrentHashVersion, tr.HashVersion)
+	assert.True(t, tr.VerifyHash("secret"))
+	assert.NotEqual(t, legacy.Hash, tr.Hash)
+
+	chained := tr
+	chained.PrevHash = legacy.Hash
+	assert.False(t, chained.VerifyHash("secret"))
+
+	tr.Amount = money.MustParse("-0.10000001")
+	assert.False(t, tr.VerifyHash("secret"))
+	assert.False(t, tr.VerifyHash("other"))
+}
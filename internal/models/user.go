@@ -1,19 +1,28 @@
 package models
 
-import "time"
+import (
+	"aigentools-backend/pkg/money"
+	"time"
+)
 
 type User struct {
 	ID            uint `gorm:"primarykey"`
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
-	Username      string     `gorm:"uniqueIndex;not null"`
-	Password      string     `gorm:"not null"`
-	Role          string     `gorm:"not null;default:'user'"`
-	Version       int        `gorm:"default:1"`
-	IsActive      bool       `gorm:"default:true"`
-	ActivatedAt   *time.Time `json:"activated_at,omitempty"`
-	DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
-	Balance       float64    `gorm:"default:0;type:decimal(20,8)"`
-	CreditLimit   float64    `gorm:"default:0;type:decimal(20,8)"`
-	TotalConsumed float64    `gorm:"default:0;type:decimal(20,8)"`
+	Username      string       `gorm:"uniqueIndex;not null"`
+	Password      string       `gorm:"not null"`
+	Role          string       `gorm:"not null;default:'user'"`
+	Version       int          `gorm:"default:1"`
+	IsActive      bool         `gorm:"default:true"`
+	ActivatedAt   *time.Time   `json:"activated_at,omitempty"`
+	DeactivatedAt *time.Time   `json:"deactivated_at,omitempty"`
+	Balance       money.Amount `gorm:"default:0;type:decimal(20,8)"`
+	CreditLimit   money.Amount `gorm:"default:0;type:decimal(20,8)"`
+	TotalConsumed money.Amount `gorm:"default:0;type:decimal(20,8)"`
+	HeldAmount    money.Amount `gorm:"default:0;type:decimal(20,8)"` // Sum of the active balance holds
+}
+
+// AvailableBalance is what the user can still spend: balance plus credit limit, minus holds
+func (u User) AvailableBalance() money.Amount {
+	return u.Balance + u.CreditLimit - u.HeldAmount
 }
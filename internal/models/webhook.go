@@ -0,0 +1,87 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// WebhookEvent names the task outcome a webhook reports
+type WebhookEvent string
+
+const (
+	WebhookEventTaskCompleted WebhookEvent = "task.completed"
+	WebhookEventTaskFailed    WebhookEvent = "task.failed"
+	WebhookEventTaskCancelled WebhookEvent = "task.cancelled"
+)
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"   // Waiting for its first or next attempt
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded" // The receiver answered with a 2xx status
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"    // Every attempt failed; can still be replayed
+)
+
+// WebhookSecret is the key a user's webhook deliveries are signed with
+type WebhookSecret struct {
+	UserID    uint      `gorm:"primarykey;autoIncrement:false" json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Secret    string    `gorm:"type:varchar(64);not null" json:"secret"`
+}
+
+// TableName overrides the table name
+func (WebhookSecret) TableName() string {
+	return "webhook_secrets"
+}
+
+// WebhookEndpoint is a URL notified when any task of its user finishes
+type WebhookEndpoint struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	URL       string    `gorm:"type:varchar(500);not null" json:"url"`
+}
+
+// TableName overrides the table name
+func (WebhookEndpoint) TableName() string {
+	return "webhook_endpoints"
+}
+
+// WebhookDelivery is one notification about a finished task, sent to one URL
+type WebhookDelivery struct {
+	ID             uint                  `gorm:"primarykey" json:"id"`
+	CreatedAt      time.Time             `json:"created_at"`
+	UpdatedAt      time.Time             `json:"updated_at"`
+	UserID         uint                  `gorm:"index;not null" json:"user_id"`
+	TaskID         uint                  `gorm:"index;not null" json:"task_id"`
+	EndpointID     *uint                 `json:"endpoint_id,omitempty"` // Empty for the task's own callback_url
+	URL            string                `gorm:"type:varchar(500);not null" json:"url"`
+	Event          WebhookEvent          `gorm:"type:varchar(50)" json:"event"`
+	Payload        string                `gorm:"type:text" json:"payload"` // Exact request body, resent unchanged on retries and replays
+	Status         WebhookDeliveryStatus `gorm:"type:varchar(20);index;default:'pending'" json:"status"`
+	Attempts       int                   `gorm:"default:0" json:"attempts"`
+	NextAttemptAt  *time.Time            `gorm:"index" json:"next_attempt_at,omitempty"`
+	ResponseStatus int                   `json:"response_status,omitempty"` // HTTP status of the last attempt
+	LastError      string                `gorm:"type:text" json:"last_error,omitempty"`
+	DeliveredAt    *time.Time            `json:"delivered_at,omitempty"`
+}
+
+// TableName overrides the table name
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// GenerateSignature signs the payload sent at the given Unix time, so receivers can
+// check that a delivery came from us and is not a replay of an old one
+func (d *WebhookDelivery) GenerateSignature(secret string, timestamp int64) string {
+	data := fmt.Sprintf("%d|%s", timestamp, d.Payload)
+
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -1,6 +1,7 @@
 package epay
 
 import (
+	"aigentools-backend/pkg/money"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
@@ -50,7 +51,7 @@ func (d *EpayDriver) SetConfig(config map[string]interface{}) error {
 	return nil
 }
 
-func (d *EpayDriver) Pay(orderID string, amount float64, notifyURL string, returnURL string, params map[string]interface{}) (string, error) {
+func (d *EpayDriver) Pay(orderID string, amount money.Amount, notifyURL string, returnURL string, params map[string]interface{}) (string, error) {
 	// Construct params
 	data := map[string]string{
 		"pid":          d.PID,
@@ -59,7 +60,7 @@ func (d *EpayDriver) Pay(orderID string, amount float64, notifyURL string, retur
 		"notify_url":   notifyURL,
 		"return_url":   returnURL,
 		"name":         "Topup " + orderID,
-		"money":        fmt.Sprintf("%.2f", amount),
+		"money":        amount.StringFixed(2),
 	}
 
 	if params != nil {
@@ -0,0 +1,456 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/jsonpath"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adapterExecutorName identifies tasks whose model carries an adapter spec
+const adapterExecutorName = "adapter"
+
+// adapterControlKeys are task input entries that steer the platform and are not sent upstream
+var adapterControlKeys = []string{"model_id", "modelId", "model", "executor"}
+
+// AdapterExecutor drives any upstream provider described by the adapter spec of the
+// task's model, instead of code written for one response shape
+type AdapterExecutor struct {
+	Uploader func(localPath string, objectKey string) (string, error)
+}
+
+// loadTaskAdapter returns the model of a task when it is configured with an adapter spec
+func loadTaskAdapter(task *models.Task) (*models.AIModel, error) {
+	if task.ModelID == 0 {
+		return nil, errors.New("task has no model")
+	}
+	var model models.AIModel
+	if err := database.DB.Select("id", "url", "adapter", "execution_mode").First(&model, task.ModelID).Error; err != nil {
+		return nil, err
+	}
+	if model.Adapter == nil {
+		return nil, fmt.Errorf("model %d has no adapter", model.ID)
+	}
+	return &model, nil
+}
+
+// hasAdapter reports whether a task should run through the AdapterExecutor
+func hasAdapter(task *models.Task) bool {
+	if task.ModelID == 0 {
+		return false
+	}
+	_, err := loadTaskAdapter(task)
+	return err == nil
+}
+
+// PollSchedule uses the poll interval and timeout of the adapter spec
+func (e AdapterExecutor) PollSchedule(task *models.Task) (time.Duration, time.Duration) {
+	model, err := loadTaskAdapter(task)
+	if err != nil {
+		return 0, 0
+	}
+	return time.Duration(model.Adapter.PollInterval) * time.Second, time.Duration(model.Adapter.PollTimeout) * time.Second
+}
+
+// adapterURL fills the placeholders of a URL template. The remote ID is escaped
+// because it usually ends up in a path segment or query parameter.
+func adapterURL(template, modelURL, remoteID string) string {
+	return strings.NewReplacer(
+		models.AdapterModelURLPlaceholder, modelURL,
+		models.AdapterRemoteIDPlaceholder, url.QueryEscape(remoteID),
+	).Replace(template)
+}
+
+// adapterBody returns the payload sent upstream: the data section of the input when
+// it has one, otherwise the input without the platform's own keys
+func adapterBody(task *models.Task) (map[string]interface{}, error) {
+	var input map[string]interface{}
+	if err := json.Unmarshal(task.InputData, &input); err != nil {
+		return nil, fmt.Errorf("failed to parse input data: %v", err)
+	}
+	if data, ok := input["data"].(map[string]interface{}); ok {
+		return data, nil
+	}
+	for _, k := range adapterControlKeys {
+		delete(input, k)
+	}
+	return input, nil
+}
+
+// uploader returns the function storing result files
+func (e AdapterExecutor) uploader() func(localPath, objectKey string) (string, error) {
+	if e.Uploader == nil {
+		return UploadFile
+	}
+	return e.Uploader
+}
+
+// adapterAuthAllowed reports whether the credential of the spec may be sent to target: only
+// to the scheme and host of its configured submit or query URL, never to a host that
+// merely came back in an upstream response
+func adapterAuthAllowed(spec *models.AdapterSpec, modelURL string, target *url.URL) bool {
+	for _, template := range []string{spec.SubmitURLTemplate(), spec.Query.URL} {
+		if template == "" {
+			continue
+		}
+		configured, err := url.Parse(adapterURL(template, modelURL, ""))
+		if err == nil && strings.EqualFold(configured.Scheme, target.Scheme) && strings.EqualFold(configured.Host, target.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// send makes one request described by the spec to a model with the given execution mode.
+// The caller closes the response body.
+func (e AdapterExecutor) send(ctx context.Context, spec *models.AdapterSpec, modelURL string, mode models.ExecutionMode, method, target string, headers map[string]string, payload interface{}) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(payloadBytes)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var redact []string
+	if spec.Auth != nil && adapterAuthAllowed(spec, modelURL, req.URL) {
+		// Specs saved before the restriction are not revalidated, so check again here
+		if !models.AdapterAuthEnvAllowed(spec.Auth.Env) {
+			return nil, Terminal(fmt.Errorf("adapter auth may not read environment variable %q", spec.Auth.Env))
+		}
+		req.Header.Set(spec.Auth.Header, spec.Auth.Prefix+os.Getenv(spec.Auth.Env))
+		redact = append(redact, spec.Auth.Header)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	client := upstreamClient(mode, redact...)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %v", err)
+	}
+	if resp.StatusCode >= 400 {
+		respBytes, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, upstreamStatusError(resp.StatusCode, string(respBytes))
+	}
+	return resp, nil
+}
+
+// decodeAdapterResponse reads a JSON response body
+func decodeAdapterResponse(resp *http.Response) (interface{}, error) {
+	respBytes, _ := io.ReadAll(resp.Body)
+	var doc interface{}
+	if err := json.Unmarshal(respBytes, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return doc, nil
+}
+
+// Submit sends the task to the URL of the adapter spec. Async models return the remote
+// task ID to poll; sync models return the result, which is stored right away.
+func (e AdapterExecutor) Submit(ctx context.Context, task *models.Task) (*RemoteHandle, error) {
+	model, err := loadTaskAdapter(task)
+	if err != nil {
+		return nil, Terminal(err)
+	}
+	spec := model.Adapter
+
+	payload, err := adapterBody(task)
+	if err != nil {
+		return nil, Terminal(err)
+	}
+
+	target := adapterURL(spec.SubmitURLTemplate(), model.URL, "")
+	resp, err := e.send(ctx, spec, model.URL, model.ExecutionMode, spec.SubmitMethod(), target, spec.Submit.Headers, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if model.ExecutionMode == models.ExecutionModeSyncBinary {
+		result, err := binaryResult(e.uploader(), task, resp)
+		if err != nil {
+			return nil, err
+		}
+		return &RemoteHandle{Result: result}, nil
+	}
+
+	doc, err := decodeAdapterResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if model.ExecutionMode == models.ExecutionModeSyncInline {
+		inline, urls := ReadAdapterInlineResponse(spec, doc)
+		result, err := inlineResult(ctx, e.uploader(), task, inline, sourcesFromURLs(urls))
+		if err != nil {
+			return nil, err
+		}
+		if cost := adapterFirst(doc, spec.FinalCostPath); cost != "" && result.Output != nil {
+			result.Output[FinalCostOutputKey] = cost
+		}
+		return &RemoteHandle{Result: result}, nil
+	}
+
+	remoteID, queryURL, err := ReadAdapterSubmitResponse(spec, doc)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteHandle{RemoteTaskID: remoteID, QueryURL: queryURL}, nil
+}
+
+// Poll checks the remote task once and uploads every result file when it has finished
+func (e AdapterExecutor) Poll(ctx context.Context, task *models.Task) (*PollResult, error) {
+	if task.RemoteTaskID == "" {
+		return nil, errors.New("task has not been submitted")
+	}
+	model, err := loadTaskAdapter(task)
+	if err != nil {
+		return nil, err
+	}
+	spec := model.Adapter
+
+	target := task.RemoteQueryURL
+	if target == "" {
+		target = adapterURL(spec.Query.URL, model.URL, task.RemoteTaskID)
+	}
+	// Only async-poll models are polled
+	resp, err := e.send(ctx, spec, model.URL, models.ExecutionModeAsyncPoll, spec.QueryMethod(), target, spec.Query.Headers, nil)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := decodeAdapterResponse(resp)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	status := ReadAdapterStatusResponse(spec, doc)
+	switch status.State {
+	case PollStateSucceeded:
+		if len(status.ArtifactURLs) == 0 {
+			return &PollResult{State: PollStateFailed, Err: fmt.Errorf("completed but no artifact found in response (status: %s)", status.Status)}, nil
+		}
+		output, err := e.download(ctx, task, status.ArtifactURLs)
+		if err != nil {
+			return nil, err
+		}
+		if status.FinalCost != "" {
+			output[FinalCostOutputKey] = status.FinalCost
+		}
+		return &PollResult{State: PollStateSucceeded, Output: output}, nil
+
+	case PollStateFailed:
+		return &PollResult{State: PollStateFailed, Err: fmt.Errorf("remote task failed: %s (status: %s)", status.Error, status.Status)}, nil
+
+	default:
+		return &PollResult{State: PollStatePending}, nil
+	}
+}
+
+// download fetches every result file and uploads it to OSS under the task ID
+func (e AdapterExecutor) download(ctx context.Context, task *models.Task, fileURLs []string) (map[string]interface{}, error) {
+	artifacts := make([]models.TaskArtifact, 0, len(fileURLs))
+	for i, fileURL := range fileURLs {
+		ossKey := fmt.Sprintf("tasks/%d/%d%s", task.ID, i, artifactExt(fileURL, ".bin"))
+		artifact, err := fetchArtifact(ctx, e.uploader(), artifactSource{URL: fileURL}, ossKey)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, *artifact)
+	}
+
+	return map[string]interface{}{
+		"oss_url":          artifacts[0].URL,
+		"original_url":     artifacts[0].OriginalURL,
+		"remote_task_id":   task.RemoteTaskID,
+		ArtifactsOutputKey: artifacts,
+	}, nil
+}
+
+// adapterString renders a JSON scalar found by a path, so IDs and statuses may be numbers
+func adapterString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	return ""
+}
+
+// adapterFirst returns the first non-empty string found by a path
+func adapterFirst(doc interface{}, expr string) string {
+	if expr == "" {
+		return ""
+	}
+	values, err := jsonpath.Lookup(doc, expr)
+	if err != nil {
+		return ""
+	}
+	for _, v := range values {
+		if s := adapterString(v); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// ReadAdapterSubmitResponse extracts the remote task ID and the optional status URL from a submit response
+func ReadAdapterSubmitResponse(spec *models.AdapterSpec, doc interface{}) (remoteID, queryURL string, err error) {
+	remoteID = adapterFirst(doc, spec.RemoteIDPath)
+	if remoteID == "" {
+		return "", "", fmt.Errorf("no remote task id at %s in response", spec.RemoteIDPath)
+	}
+	return remoteID, adapterFirst(doc, spec.QueryURLPath), nil
+}
+
+// AdapterStatus is what an adapter spec reads from a status response
+type AdapterStatus struct {
+	Status       string    `json:"status"`
+	State        PollState `json:"-"`
+	Error        string    `json:"error,omitempty"`
+	ArtifactURLs []string  `json:"artifact_urls"`
+	FinalCost    string    `json:"final_cost,omitempty"` // What the provider charged, when the spec says where to find it
+}
+
+// Outcome names the state for API responses
+func (s AdapterStatus) Outcome() string {
+	switch s.State {
+	case PollStateSucceeded:
+		return "succeeded"
+	case PollStateFailed:
+		return "failed"
+	}
+	return "pending"
+}
+
+func matchesAny(value string, candidates []string) bool {
+	for _, c := range candidates {
+		if strings.EqualFold(value, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// adapterArtifactURLs collects the file URLs found by the artifact paths, in order
+func adapterArtifactURLs(spec *models.AdapterSpec, doc interface{}) []string {
+	urls := []string{}
+	for _, p := range spec.ArtifactPaths {
+		values, err := jsonpath.Lookup(doc, p)
+		if err != nil {
+			continue
+		}
+		for _, v := range values {
+			if u, ok := v.(string); ok && u != "" {
+				urls = append(urls, u)
+			}
+		}
+	}
+	return urls
+}
+
+// ReadAdapterInlineResponse reads the inline result and the file URLs from the response of a sync-inline model
+func ReadAdapterInlineResponse(spec *models.AdapterSpec, doc interface{}) (interface{}, []string) {
+	result := doc
+	if spec.ResultPath != "" {
+		if values, err := jsonpath.Lookup(doc, spec.ResultPath); err == nil && len(values) > 0 {
+			result = values[0]
+		}
+	}
+	return result, adapterArtifactURLs(spec, doc)
+}
+
+// ReadAdapterStatusResponse reads the status, failure reason, artifact URLs and final cost from
+// a status response. Artifacts and the cost are only read once the status is a success value.
+func ReadAdapterStatusResponse(spec *models.AdapterSpec, doc interface{}) AdapterStatus {
+	status := AdapterStatus{Status: adapterFirst(doc, spec.StatusPath), ArtifactURLs: []string{}}
+	switch {
+	case matchesAny(status.Status, spec.SuccessValues):
+		status.State = PollStateSucceeded
+		status.ArtifactURLs = adapterArtifactURLs(spec, doc)
+		status.FinalCost = adapterFirst(doc, spec.FinalCostPath)
+	case matchesAny(status.Status, spec.FailureValues):
+		status.State = PollStateFailed
+		status.Error = adapterFirst(doc, spec.ErrorPath)
+	default:
+		status.State = PollStatePending
+	}
+	return status
+}
+
+// AdapterDryRun is the result of applying an adapter spec to sample responses
+type AdapterDryRun struct {
+	RemoteID     string      `json:"remote_id,omitempty"`
+	QueryURL     string      `json:"query_url,omitempty"` // Status URL the executor would call
+	Status       string      `json:"status,omitempty"`
+	Outcome      string      `json:"outcome"` // pending, succeeded or failed
+	Error        string      `json:"error,omitempty"`
+	Result       interface{} `json:"result,omitempty"` // Inline result of a sync-inline model
+	ArtifactURLs []string    `json:"artifact_urls"`
+	FinalCost    string      `json:"final_cost,omitempty"`
+}
+
+// DryRunAdapter validates a spec and shows what it extracts from a sample submit
+// response and a sample status response, without calling the provider. Sync-inline
+// models only read the submit response; sync-binary responses have nothing to extract.
+func DryRunAdapter(spec *models.AdapterSpec, mode models.ExecutionMode, modelURL string, submitResponse, queryResponse interface{}) (*AdapterDryRun, error) {
+	if mode == "" {
+		mode = models.ExecutionModeAsyncPoll
+	}
+	if err := spec.Validate(mode); err != nil {
+		return nil, err
+	}
+
+	switch mode {
+	case models.ExecutionModeSyncBinary:
+		return &AdapterDryRun{Outcome: "succeeded", ArtifactURLs: []string{}}, nil
+	case models.ExecutionModeSyncInline:
+		result, urls := ReadAdapterInlineResponse(spec, submitResponse)
+		return &AdapterDryRun{Outcome: "succeeded", Result: result, ArtifactURLs: urls, FinalCost: adapterFirst(submitResponse, spec.FinalCostPath)}, nil
+	}
+
+	remoteID, queryURL, err := ReadAdapterSubmitResponse(spec, submitResponse)
+	if err != nil {
+		return nil, err
+	}
+	if queryURL == "" {
+		queryURL = adapterURL(spec.Query.URL, modelURL, remoteID)
+	}
+
+	status := ReadAdapterStatusResponse(spec, queryResponse)
+	return &AdapterDryRun{
+		RemoteID:     remoteID,
+		QueryURL:     queryURL,
+		Status:       status.Status,
+		Outcome:      status.Outcome(),
+		Error:        status.Error,
+		ArtifactURLs: status.ArtifactURLs,
+		FinalCost:    status.FinalCost,
+	}, nil
+}
+
+func init() {
+	RegisterExecutor(adapterExecutorName, AdapterExecutor{})
+}
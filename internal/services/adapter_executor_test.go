@@ -0,0 +1,188 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupAdapterTestDB() {
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	if err != nil {
+		panic("failed to connect database")
+	}
+	db.Migrator().DropTable(&models.Task{}, &models.TaskEvent{}, &models.TaskArtifact{}, &models.AIModel{})
+	db.AutoMigrate(&models.Task{}, &models.TaskEvent{}, &models.TaskArtifact{}, &models.AIModel{})
+	database.DB = db
+}
+
+func testAdapterSpec() *models.AdapterSpec {
+	return &models.AdapterSpec{
+		Auth:          &models.AdapterAuth{Header: "X-Api-Key", Env: "ADAPTER_KEY_TEST"},
+		Query:         models.AdapterRequest{URL: "{{model_url}}/jobs/{{remote_id}}"},
+		RemoteIDPath:  "$.result.job",
+		StatusPath:    "$.state",
+		SuccessValues: []string{"done"},
+		FailureValues: []string{"error", "rejected"},
+		ErrorPath:     "$.message",
+		ArtifactPaths: []string{"$.outputs[*].url"},
+		FinalCostPath: "$.billing.cost",
+		PollInterval:  5,
+		PollTimeout:   600,
+	}
+}
+
+func TestAdapterExecutor_SubmitAndPoll(t *testing.T) {
+	setupAdapterTestDB()
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true") // The file servers listen on loopback
+	t.Setenv("ADAPTER_KEY_TEST", "secret-key")
+
+	state := "running"
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gen":
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "secret-key", r.Header.Get("X-Api-Key"))
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			assert.Equal(t, "a cat", body["prompt"])
+			assert.NotContains(t, body, "model_id")
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"job": 42}})
+		case "/gen/jobs/42":
+			assert.Equal(t, http.MethodGet, r.Method)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"state":   state,
+				"billing": map[string]interface{}{"cost": 0.25},
+				"outputs": []map[string]string{
+					{"url": "http://" + r.Host + "/files/a.png"},
+					{"url": "http://" + r.Host + "/files/b.png"},
+				},
+			})
+		case "/files/a.png", "/files/b.png":
+			w.Write([]byte("image bytes"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mockServer.Close()
+
+	model := models.AIModel{Name: "Adapter Model", Status: models.AIModelStatusOpen, URL: mockServer.URL + "/gen", Parameters: models.JSON{}, Adapter: testAdapterSpec()}
+	require.NoError(t, database.DB.Create(&model).Error)
+
+	input, _ := json.Marshal(map[string]interface{}{"model_id": model.ID, "prompt": "a cat"})
+	task := &models.Task{ID: 300, ModelID: model.ID, InputData: datatypes.JSON(input)}
+	require.NoError(t, database.DB.Create(task).Error)
+
+	assert.Equal(t, adapterExecutorName, resolveExecutorName(task))
+	ex := getExecutor(adapterExecutorName)
+	assert.Equal(t, 5*time.Second, executorPollInterval(ex, task))
+	assert.Equal(t, 10*time.Minute, executorPollTimeout(ex, task))
+
+	var keys []string
+	executor := AdapterExecutor{Uploader: func(localPath, objectKey string) (string, error) {
+		keys = append(keys, objectKey)
+		return "https://oss.example.com/" + objectKey, nil
+	}}
+
+	handle, err := executor.Submit(context.Background(), task)
+	require.NoError(t, err)
+	assert.Equal(t, "42", handle.RemoteTaskID)
+	task.RemoteTaskID = handle.RemoteTaskID
+
+	result, err := executor.Poll(context.Background(), task)
+	require.NoError(t, err)
+	assert.Equal(t, PollStatePending, result.State)
+
+	state = "DONE"
+	result, err = executor.Poll(context.Background(), task)
+	require.NoError(t, err)
+	assert.Equal(t, PollStateSucceeded, result.State)
+	assert.Equal(t, []string{"tasks/300/0.png", "tasks/300/1.png"}, keys)
+	assert.Equal(t, "https://oss.example.com/tasks/300/0.png", result.Output["oss_url"])
+	assert.Len(t, result.Output[ArtifactsOutputKey], 2)
+	assert.Equal(t, "0.25", result.Output[FinalCostOutputKey])
+}
+
+func TestAdapterExecutor_AuthOnlyToConfiguredHosts(t *testing.T) {
+	setupAdapterTestDB()
+	t.Setenv("ADAPTER_KEY_TEST", "secret-key")
+
+	// A status URL returned by the provider names a host the spec does not configure
+	var leaked string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get("X-Api-Key")
+		json.NewEncoder(w).Encode(map[string]interface{}{"state": "running"})
+	}))
+	defer other.Close()
+
+	model := models.AIModel{Name: "Adapter Model", Status: models.AIModelStatusOpen, URL: "http://provider.example.com/gen", Parameters: models.JSON{}, Adapter: testAdapterSpec()}
+	require.NoError(t, database.DB.Create(&model).Error)
+	task := &models.Task{ModelID: model.ID, InputData: datatypes.JSON(`{}`), RemoteTaskID: "42", RemoteQueryURL: other.URL + "/jobs/42"}
+
+	result, err := AdapterExecutor{}.Poll(context.Background(), task)
+	require.NoError(t, err)
+	assert.Equal(t, PollStatePending, result.State)
+	assert.Empty(t, leaked)
+}
+
+func TestAdapterExecutor_NotUsedWithoutSpec(t *testing.T) {
+	setupAdapterTestDB()
+
+	model := models.AIModel{Name: "Plain Model", Status: models.AIModelStatusOpen, Parameters: models.JSON{}}
+	require.NoError(t, database.DB.Create(&model).Error)
+
+	input, _ := json.Marshal(map[string]interface{}{"model_id": model.ID, "prompt": "hi"})
+	task := &models.Task{ModelID: model.ID, InputData: datatypes.JSON(input)}
+	assert.Equal(t, builtinExecutorName, resolveExecutorName(task))
+	assert.Equal(t, pollInterval, executorPollInterval(getExecutor(builtinExecutorName), task))
+}
+
+func TestReadAdapterStatusResponse(t *testing.T) {
+	spec := testAdapterSpec()
+
+	var doc interface{}
+	json.Unmarshal([]byte(`{"state":"Rejected","message":"nsfw","outputs":[{"url":"x"}]}`), &doc)
+	status := ReadAdapterStatusResponse(spec, doc)
+	assert.Equal(t, PollStateFailed, status.State)
+	assert.Equal(t, "nsfw", status.Error)
+	assert.Empty(t, status.ArtifactURLs)
+
+	json.Unmarshal([]byte(`{"state":"queued"}`), &doc)
+	assert.Equal(t, "pending", ReadAdapterStatusResponse(spec, doc).Outcome())
+}
+
+func TestDryRunAdapter(t *testing.T) {
+	spec := testAdapterSpec()
+
+	var submit, query interface{}
+	json.Unmarshal([]byte(`{"result":{"job":"abc"}}`), &submit)
+	json.Unmarshal([]byte(`{"state":"done","outputs":[{"url":"https://cdn/1.mp4"},{"url":"https://cdn/2.mp4"}]}`), &query)
+
+	result, err := DryRunAdapter(spec, models.ExecutionModeAsyncPoll, "https://api.example.com/v1", submit, query)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", result.RemoteID)
+	assert.Equal(t, "https://api.example.com/v1/jobs/abc", result.QueryURL)
+	assert.Equal(t, "succeeded", result.Outcome)
+	assert.Equal(t, []string{"https://cdn/1.mp4", "https://cdn/2.mp4"}, result.ArtifactURLs)
+
+	// The remote ID must be found
+	json.Unmarshal([]byte(`{"id":"abc"}`), &submit)
+	_, err = DryRunAdapter(spec, models.ExecutionModeAsyncPoll, "", submit, query)
+	assert.Error(t, err)
+
+	// Invalid specs are rejected before anything is extracted
+	spec.StatusPath = "$.["
+	_, err = DryRunAdapter(spec, models.ExecutionModeAsyncPoll, "", submit, query)
+	assert.ErrorContains(t, err, "status_path")
+}
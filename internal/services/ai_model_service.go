@@ -6,6 +6,7 @@ import (
 	"aigentools-backend/pkg/logger"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -51,6 +52,13 @@ func CreateAIModel(model *models.AIModel) error {
 	if err := models.ValidateModelParameters(model.Parameters); err != nil {
 		return err
 	}
+	if err := ValidateModelExecution(model); err != nil {
+		return err
+	}
+	if err := ValidateModelPricing(model); err != nil {
+		return err
+	}
+	model.PricingVersion = 1
 	return database.DB.Create(model).Error
 }
 
@@ -59,6 +67,17 @@ func UpdateAIModel(model *models.AIModel) error {
 	if err := models.ValidateModelParameters(model.Parameters); err != nil {
 		return err
 	}
+	if err := ValidateModelExecution(model); err != nil {
+		return err
+	}
+	if err := ValidateModelPricing(model); err != nil {
+		return err
+	}
+	version, err := nextPricingVersion(model)
+	if err != nil {
+		return err
+	}
+	model.PricingVersion = version
 	if err := database.DB.Save(model).Error; err != nil {
 		return err
 	}
@@ -72,6 +91,48 @@ func UpdateAIModel(model *models.AIModel) error {
 	return nil
 }
 
+// ValidateModelExecution checks the execution mode and the adapter spec of a model.
+// An empty mode becomes async-poll.
+func ValidateModelExecution(model *models.AIModel) error {
+	if model.ExecutionMode == "" {
+		model.ExecutionMode = models.ExecutionModeAsyncPoll
+	}
+	if !model.ExecutionMode.Valid() {
+		return fmt.Errorf("invalid execution_mode %q", model.ExecutionMode)
+	}
+	if model.Adapter != nil {
+		return model.Adapter.Validate(model.ExecutionMode)
+	}
+	return nil
+}
+
+// ValidateModelPricing checks the pricing rule of a model. When the model defines its
+// request parameters, the rule may only read those.
+func ValidateModelPricing(model *models.AIModel) error {
+	if model.Pricing == nil {
+		return nil
+	}
+	if err := model.Pricing.Validate(); err != nil {
+		return err
+	}
+
+	params, err := models.ParseModelParameters(model.Parameters)
+	if err != nil || len(params.RequestBody) == 0 {
+		return nil
+	}
+	known := make(map[string]bool, len(params.RequestBody))
+	for _, def := range params.RequestBody {
+		known[def.Name] = true
+	}
+	for _, name := range model.Pricing.Params() {
+		// Expressions may read into object parameters, as in camera.moves
+		if !known[strings.SplitN(name, ".", 2)[0]] {
+			return fmt.Errorf("pricing reads '%s', which is not a request parameter of the model", name)
+		}
+	}
+	return nil
+}
+
 // GetAIModelByID retrieves a model by ID
 func GetAIModelByID(id uint) (*models.AIModel, error) {
 	var model models.AIModel
@@ -4,13 +4,13 @@ import (
 	"aigentools-backend/internal/models"
 	"aigentools-backend/internal/utils"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -22,47 +22,55 @@ type RemoteAPITaskExecutor struct {
 	Uploader func(localPath string, objectKey string) (string, error)
 }
 
-// Execute performs the task logic
-func (e RemoteAPITaskExecutor) Execute(task *models.Task) (map[string]interface{}, error) {
-	// Use default uploader if nil
-	uploader := e.Uploader
-	if uploader == nil {
-		uploader = UploadFile
+// PollTimeout is a safety timeout for generic remote APIs
+func (e RemoteAPITaskExecutor) PollTimeout() time.Duration {
+	return 10 * time.Minute
+}
+
+func remoteAPIHeaders() map[string]string {
+	return map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %s", os.Getenv("JIEKOU_API")),
+		"Content-Type":  "application/json",
 	}
+}
 
-	// 1. Parse Input Data
+func parseRemoteAPIInput(task *models.Task) (map[string]interface{}, error) {
 	var input map[string]interface{}
 	if err := json.Unmarshal(task.InputData, &input); err != nil {
 		return nil, fmt.Errorf("failed to parse input data: %v", err)
 	}
+	return input, nil
+}
+
+// Submit sends the payload to target_url and returns the remote task ID
+func (e RemoteAPITaskExecutor) Submit(ctx context.Context, task *models.Task) (*RemoteHandle, error) {
+	// 1. Parse Input Data
+	input, err := parseRemoteAPIInput(task)
+	if err != nil {
+		return nil, Terminal(err)
+	}
 
 	targetURL, _ := input["target_url"].(string)
 	method, _ := input["method"].(string)
 	if method == "" {
 		method = "POST"
 	}
-	headers := map[string]interface{}{}
-	headers["Authorization"] = fmt.Sprintf("Bearer %s", os.Getenv("JIEKOU_API"))
-	headers["Content-Type"] = "application/json"
 
 	payload, _ := input["payload"].(map[string]interface{})
 
 	if targetURL == "" {
-		return nil, errors.New("missing target_url")
+		return nil, Terminal(errors.New("missing target_url"))
 	}
 
 	// 2. Send Request
 	payloadBytes, _ := json.Marshal(payload)
-	req, err := http.NewRequest(method, targetURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, method, targetURL, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	for k, v := range headers {
-		if s, ok := v.(string); ok {
-			req.Header.Set(k, s)
-		}
+	for k, v := range remoteAPIHeaders() {
+		req.Header.Set(k, v)
 	}
 
 	client := utils.NewHTTPClient(30 * time.Second)
@@ -73,7 +81,7 @@ func (e RemoteAPITaskExecutor) Execute(task *models.Task) (map[string]interface{
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("api returned error status: %d", resp.StatusCode)
+		return nil, upstreamStatusError(resp.StatusCode, "")
 	}
 
 	var respData map[string]interface{}
@@ -93,7 +101,7 @@ func (e RemoteAPITaskExecutor) Execute(task *models.Task) (map[string]interface{
 		}
 	}
 
-	// 4. Poll for Status
+	// 4. Build the status URL
 	queryTemplate, _ := input["query_url_template"].(string)
 	if queryTemplate == "" {
 		// Default assumption: target_url/{task_id}
@@ -105,119 +113,150 @@ func (e RemoteAPITaskExecutor) Execute(task *models.Task) (map[string]interface{
 		queryURL = fmt.Sprintf(queryTemplate, remoteTaskID)
 	}
 
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+	return &RemoteHandle{RemoteTaskID: remoteTaskID, QueryURL: queryURL}, nil
+}
 
-	timeout := time.After(10 * time.Minute) // Safety timeout
-	var fileURL string
+// Poll checks the remote task once and uploads the result file when it has finished
+func (e RemoteAPITaskExecutor) Poll(ctx context.Context, task *models.Task) (*PollResult, error) {
+	if task.RemoteTaskID == "" || task.RemoteQueryURL == "" {
+		return nil, errors.New("task has not been submitted")
+	}
 
-	for {
-		select {
-		case <-timeout:
-			return nil, errors.New("task polling timed out")
-		case <-ticker.C:
-			// Check status
-			statusReq, _ := http.NewRequest("GET", queryURL, nil)
-			for k, v := range headers {
-				if s, ok := v.(string); ok {
-					statusReq.Header.Set(k, s)
-				}
-			}
+	input, err := parseRemoteAPIInput(task)
+	if err != nil {
+		return nil, err
+	}
 
-			statusResp, err := client.Do(statusReq)
-			if err != nil {
-				fmt.Printf("Polling error: %v\n", err)
-				continue
-			}
-			defer statusResp.Body.Close() // Close immediately for loop
-
-			var statusData map[string]interface{}
-			// Read body to reuse later if needed, or decode directly
-			bodyBytes, _ := io.ReadAll(statusResp.Body)
-			json.Unmarshal(bodyBytes, &statusData)
-
-			// Determine status. User might configure status field and success value.
-			// Defaults: status field "status", success value "completed" or "success"
-			statusField := "status"
-			statusVal, _ := statusData[statusField].(string)
-			statusVal = strings.ToLower(statusVal)
-
-			if statusVal == "completed" || statusVal == "success" || statusVal == "succeeded" {
-				// 5. Extract File URL
-				// User can specify result_file_key
-				fileKey, _ := input["result_file_key"].(string)
-				if fileKey == "" {
-					fileKey = "file_url"
-				}
+	// Check status
+	statusReq, err := http.NewRequestWithContext(ctx, "GET", task.RemoteQueryURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	for k, v := range remoteAPIHeaders() {
+		statusReq.Header.Set(k, v)
+	}
 
-				if url, ok := statusData[fileKey].(string); ok {
-					fileURL = url
-					goto Download
-				} else if url, ok := statusData["result_url"].(string); ok {
-					fileURL = url
-					goto Download
-				} else if url, ok := statusData["url"].(string); ok {
-					fileURL = url
-					goto Download
-				}
+	client := utils.NewHTTPClient(30 * time.Second)
+	statusResp, err := client.Do(statusReq)
+	if err != nil {
+		return nil, fmt.Errorf("polling request failed: %v", err)
+	}
+	bodyBytes, _ := io.ReadAll(statusResp.Body)
+	statusResp.Body.Close()
+
+	var statusData map[string]interface{}
+	json.Unmarshal(bodyBytes, &statusData)
+
+	// Determine status. User might configure status field and success value.
+	// Defaults: status field "status", success value "completed" or "success"
+	statusField := "status"
+	statusVal, _ := statusData[statusField].(string)
+	statusVal = strings.ToLower(statusVal)
+
+	if statusVal == "completed" || statusVal == "success" || statusVal == "succeeded" {
+		// 5. Extract File URL
+		// User can specify result_file_key
+		fileKey, _ := input["result_file_key"].(string)
+		if fileKey == "" {
+			fileKey = "file_url"
+		}
 
-				return nil, errors.New("completed but file url not found")
-			} else if statusVal == "failed" || statusVal == "error" {
-				return nil, fmt.Errorf("remote task failed: %v", statusData)
+		// The key may hold a single URL or a list of them
+		var fileURLs []string
+		if urls, ok := statusData[fileKey].([]interface{}); ok {
+			for _, u := range urls {
+				if url, ok := u.(string); ok && url != "" {
+					fileURLs = append(fileURLs, url)
+				}
 			}
-			// Continue polling
+		} else if url, ok := statusData[fileKey].(string); ok && url != "" {
+			fileURLs = []string{url}
+		} else if url, ok := statusData["result_url"].(string); ok && url != "" {
+			fileURLs = []string{url}
+		} else if url, ok := statusData["url"].(string); ok && url != "" {
+			fileURLs = []string{url}
+		}
+
+		if len(fileURLs) == 0 {
+			return &PollResult{State: PollStateFailed, Err: errors.New("completed but file url not found")}, nil
+		}
+
+		output, err := e.download(ctx, task, fileURLs)
+		if err != nil {
+			return nil, err
 		}
+		return &PollResult{State: PollStateSucceeded, Output: output}, nil
+	} else if statusVal == "failed" || statusVal == "error" {
+		return &PollResult{State: PollStateFailed, Err: fmt.Errorf("remote task failed: %v", statusData)}, nil
 	}
 
-Download:
-	// 6. Download File
-	if fileURL == "" {
-		return nil, errors.New("empty file url")
+	// Continue polling
+	return &PollResult{State: PollStatePending}, nil
+}
+
+// Cancel stops a submitted task through cancel_url_template, if the input has one
+func (e RemoteAPITaskExecutor) Cancel(ctx context.Context, task *models.Task) error {
+	if task.RemoteTaskID == "" {
+		return nil
 	}
 
-	fmt.Printf("Downloading file from %s...\n", fileURL)
-	fileResp, err := http.Get(fileURL)
+	input, err := parseRemoteAPIInput(task)
 	if err != nil {
-		return nil, fmt.Errorf("failed to download file: %v", err)
-	}
-	defer fileResp.Body.Close()
-
-	// Create temp file
-	tmpName := filepath.Join(os.TempDir(), fmt.Sprintf("task_%d_%s", task.ID, uuid.New().String()))
-	// Try to guess extension from URL or Content-Type
-	// Simple approach: look at URL
-	if idx := strings.LastIndex(fileURL, "."); idx != -1 {
-		ext := fileURL[idx:]
-		if len(ext) < 10 && !strings.Contains(ext, "/") { // sanity check
-			tmpName += ext
-		}
+		return err
+	}
+	cancelTemplate, _ := input["cancel_url_template"].(string)
+	if cancelTemplate == "" {
+		return ErrCancelNotSupported
+	}
+	cancelURL := cancelTemplate
+	if strings.Contains(cancelTemplate, "%s") {
+		cancelURL = fmt.Sprintf(cancelTemplate, task.RemoteTaskID)
 	}
 
-	out, err := os.Create(tmpName)
+	req, err := http.NewRequestWithContext(ctx, "POST", cancelURL, nil)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	for k, v := range remoteAPIHeaders() {
+		req.Header.Set(k, v)
 	}
 
-	_, err = io.Copy(out, fileResp.Body)
-	out.Close()
+	client := utils.NewHTTPClient(30 * time.Second)
+	resp, err := client.Do(req)
 	if err != nil {
-		os.Remove(tmpName)
-		return nil, err
+		return fmt.Errorf("cancel request failed: %v", err)
 	}
-	defer os.Remove(tmpName) // Cleanup after upload
+	defer resp.Body.Close()
 
-	// 7. Upload to OSS
-	ossKey := fmt.Sprintf("tasks/%d/%s", task.ID, filepath.Base(tmpName))
-	ossURL, err := uploader(tmpName, ossKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to upload to oss: %v", err)
+	if resp.StatusCode >= 400 {
+		return upstreamStatusError(resp.StatusCode, "")
+	}
+	return nil
+}
+
+// download fetches every result file and uploads it to OSS under the task's folder
+func (e RemoteAPITaskExecutor) download(ctx context.Context, task *models.Task, fileURLs []string) (map[string]interface{}, error) {
+	// Use default uploader if nil
+	uploader := e.Uploader
+	if uploader == nil {
+		uploader = UploadFile
+	}
+
+	artifacts := make([]models.TaskArtifact, 0, len(fileURLs))
+	for _, fileURL := range fileURLs {
+		ossKey := fmt.Sprintf("tasks/%d/task_%d_%s%s", task.ID, task.ID, uuid.New().String(), artifactExt(fileURL, ""))
+		artifact, err := fetchArtifact(ctx, uploader, artifactSource{URL: fileURL}, ossKey)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, *artifact)
 	}
 
-	// 8. Return Result
 	return map[string]interface{}{
-		"oss_url":        ossURL,
-		"original_url":   fileURL,
-		"remote_task_id": remoteTaskID,
+		"oss_url":          artifacts[0].URL,
+		"original_url":     artifacts[0].OriginalURL,
+		"remote_task_id":   task.RemoteTaskID,
+		ArtifactsOutputKey: artifacts,
 	}, nil
 }
 
@@ -2,6 +2,7 @@ package services
 
 import (
 	"aigentools-backend/internal/models"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -13,6 +14,7 @@ import (
 
 func TestRemoteAPITaskExecutor_Execute(t *testing.T) {
 	// 1. Mock External API
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true") // The file servers listen on loopback
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case "/submit":
@@ -71,10 +73,18 @@ func TestRemoteAPITaskExecutor_Execute(t *testing.T) {
 		Uploader: mockUploader,
 	}
 
-	// 4. Execute
-	result, err := executor.Execute(task)
+	// 4. Submit
+	handle, err := executor.Submit(context.Background(), task)
 	assert.NoError(t, err)
-	assert.NotNil(t, result)
-	assert.Equal(t, "remote-123", result["remote_task_id"])
-	assert.Contains(t, result["oss_url"], "https://oss.example.com/tasks/1/")
+	assert.Equal(t, "remote-123", handle.RemoteTaskID)
+	assert.Equal(t, mockServer.URL+"/status/remote-123", handle.QueryURL)
+	task.RemoteTaskID = handle.RemoteTaskID
+	task.RemoteQueryURL = handle.QueryURL
+
+	// 5. Poll
+	result, err := executor.Poll(context.Background(), task)
+	assert.NoError(t, err)
+	assert.Equal(t, PollStateSucceeded, result.State)
+	assert.Equal(t, "remote-123", result.Output["remote_task_id"])
+	assert.Contains(t, result.Output["oss_url"], "https://oss.example.com/tasks/1/")
 }
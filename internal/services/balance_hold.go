@@ -0,0 +1,243 @@
+package services
+
+import (
+	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FinalCostOutputKey is the executor output entry holding what a task actually cost, when
+// the provider reports it; adapter specs read it with final_cost_path. A task is charged at
+// most its hold, so only a lower cost counts.
+const FinalCostOutputKey = "final_cost"
+
+// ErrHoldSettled is returned when a balance hold has already been captured or released
+var ErrHoldSettled = errors.New("balance hold is already settled")
+
+// PlaceHoldTx reserves amount of the user's available balance inside tx. The balance itself
+// is left as is until the hold is captured; the hold is recorded as a transaction of amount
+// zero whose HeldAmount is the reserved amount.
+func PlaceHoldTx(tx *gorm.DB, userID uint, amount money.Amount, taskID *uint, reason string) (*models.BalanceHold, error) {
+	if amount <= 0 {
+		return nil, errors.New("amount must be positive")
+	}
+
+	var user models.User
+	if err := tx.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if user.AvailableBalance() < amount {
+		return nil, ErrInsufficientBalance
+	}
+
+	result := tx.Model(&user).Where("version = ?", user.Version).Updates(map[string]interface{}{
+		"held_amount": user.HeldAmount + amount,
+		"version":     user.Version + 1,
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrOptimisticLock
+	}
+
+	hold := models.BalanceHold{
+		UserID: userID,
+		TaskID: taskID,
+		Amount: amount,
+		Status: models.HoldStatusActive,
+		Reason: reason,
+	}
+	if err := tx.Create(&hold).Error; err != nil {
+		return nil, err
+	}
+
+	if err := recordHoldTransactionTx(tx, &hold, user.Balance, 0, amount, models.TransactionTypeHold, reason); err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// CaptureHoldTx settles an active hold by charging amount, at most the held amount, inside tx.
+// The rest of the hold goes back to the available balance. It returns the amount charged.
+func CaptureHoldTx(tx *gorm.DB, hold *models.BalanceHold, amount money.Amount, reason string) (money.Amount, error) {
+	if amount > hold.Amount {
+		amount = hold.Amount
+	}
+	if amount < 0 {
+		amount = 0
+	}
+	if err := settleHoldTx(tx, hold, models.HoldStatusCaptured, amount, models.TransactionTypeUserConsume, reason); err != nil {
+		return 0, err
+	}
+	return amount, nil
+}
+
+// ReleaseHoldTx settles an active hold without charging anything, inside tx
+func ReleaseHoldTx(tx *gorm.DB, hold *models.BalanceHold, reason string) error {
+	return settleHoldTx(tx, hold, models.HoldStatusReleased, 0, models.TransactionTypeHoldRelease, reason)
+}
+
+// settleHoldTx claims an active hold with a conditional update, so it is settled at most
+// once, then charges captured and gives the whole hold back to the available balance
+func settleHoldTx(tx *gorm.DB, hold *models.BalanceHold, status models.HoldStatus, captured money.Amount, txType models.TransactionType, reason string) error {
+	now := time.Now()
+	res := tx.Model(&models.BalanceHold{}).
+		Where("id = ? AND status = ?", hold.ID, models.HoldStatusActive).
+		Updates(map[string]interface{}{
+			"status":          status,
+			"captured_amount": captured,
+			"settled_at":      now,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrHoldSettled
+	}
+
+	var user models.User
+	if err := tx.First(&user, hold.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	updates := map[string]interface{}{
+		"held_amount": user.HeldAmount - hold.Amount,
+		"version":     user.Version + 1,
+	}
+	if captured > 0 {
+		updates["balance"] = user.Balance - captured
+		updates["total_consumed"] = user.TotalConsumed + captured
+	}
+	result := tx.Model(&user).Where("version = ?", user.Version).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOptimisticLock
+	}
+
+	if err := recordHoldTransactionTx(tx, hold, user.Balance, -captured, -hold.Amount, txType, reason); err != nil {
+		return err
+	}
+	hold.Status = status
+	hold.CapturedAmount = captured
+	hold.SettledAt = &now
+	return nil
+}
+
+// recordHoldTransactionTx writes the hashed transaction of a step of a hold: amount is the
+// change of the balance, held the change of the held amount
+func recordHoldTransactionTx(tx *gorm.DB, hold *models.BalanceHold, balanceBefore, amount, held money.Amount, txType models.TransactionType, reason string) error {
+	transaction := models.Transaction{
+		UserID:        hold.UserID,
+		Amount:        amount,
+		BalanceBefore: balanceBefore,
+		BalanceAfter:  balanceBefore + amount,
+		Reason:        reason,
+		Operator:      "system",
+		Type:          txType,
+		CreatedAt:     time.Now(),
+		HoldID:        &hold.ID,
+		HeldAmount:    held,
+	}
+	return recordTransactionTx(tx, &transaction)
+}
+
+// holdAttempts is how often holdTaskCostTx places a hold when concurrent balance changes
+// keep winning the optimistic lock
+const holdAttempts = 3
+
+// holdTaskCostTx places the hold paying for an inserted task inside tx. Tasks without a cost,
+// such as those created before pricing was required, get none. A hold losing the optimistic
+// lock reads the balance again and is tried again, so a concurrent top-up or charge does
+// not fail the submission.
+func holdTaskCostTx(tx *gorm.DB, task *models.Task, reason string) error {
+	if task.Cost <= 0 {
+		return nil
+	}
+	var hold *models.BalanceHold
+	var err error
+	for attempt := 1; attempt <= holdAttempts; attempt++ {
+		hold, err = PlaceHoldTx(tx, task.CreatorID, task.Cost, &task.ID, reason)
+		if !errors.Is(err, ErrOptimisticLock) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	task.HoldID = &hold.ID
+	return tx.Model(&models.Task{}).Where("id = ?", task.ID).Update("hold_id", hold.ID).Error
+}
+
+// captureTaskHoldTx charges a completed task inside tx: its hold is captured for the final
+// cost the executor reported, or in full, and Cost becomes what was charged. Tasks paid
+// up-front before holds existed have nothing to capture.
+func captureTaskHoldTx(tx *gorm.DB, task *models.Task, output map[string]interface{}) error {
+	if task.HoldID == nil {
+		return nil
+	}
+	var hold models.BalanceHold
+	if err := tx.First(&hold, *task.HoldID).Error; err != nil {
+		return err
+	}
+
+	amount := hold.Amount
+	if cost, ok := finalTaskCost(output); ok {
+		amount = cost
+	}
+	charged, err := CaptureHoldTx(tx, &hold, amount, fmt.Sprintf("Task %d completed", task.ID))
+	if err != nil {
+		return err
+	}
+	task.Cost = charged
+	return tx.Model(&models.Task{}).Where("id = ?", task.ID).Update("cost", charged).Error
+}
+
+// releaseTaskHoldTx gives the hold of a task back without charging it
+func releaseTaskHoldTx(tx *gorm.DB, task *models.Task, reason string) error {
+	var hold models.BalanceHold
+	if err := tx.First(&hold, *task.HoldID).Error; err != nil {
+		return err
+	}
+	return ReleaseHoldTx(tx, &hold, reason)
+}
+
+// finalTaskCost reads FinalCostOutputKey from an executor output, rounded to cents like prices
+func finalTaskCost(output map[string]interface{}) (money.Amount, bool) {
+	switch v := output[FinalCostOutputKey].(type) {
+	case money.Amount:
+		return v, true
+	case float64:
+		if a, err := money.FromFloat(v); err == nil {
+			return roundedCost(a)
+		}
+	case int:
+		if a, err := money.Parse(strconv.Itoa(v)); err == nil {
+			return a, true
+		}
+	case string:
+		if a, err := money.Parse(v); err == nil {
+			return roundedCost(a)
+		}
+	}
+	return 0, false
+}
+
+// roundedCost rounds a final cost to cents; costs that do not fit are ignored
+func roundedCost(a money.Amount) (money.Amount, bool) {
+	r, err := a.Round(2)
+	return r, err == nil
+}
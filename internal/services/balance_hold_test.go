@@ -0,0 +1,149 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestBalanceHold_CaptureReleaseRetry(t *testing.T) {
+	setupPaymentTestDB()
+	mr := setupPaymentTestRedis()
+	defer mr.Close()
+	t.Setenv("AUTO_AUDIT", "true")
+
+	model := models.AIModel{Name: "Metered Model", Price: money.MustParse("10"), Status: models.AIModelStatusOpen}
+	database.DB.Create(&model)
+	user := models.User{Username: "holder", Balance: money.MustParse("15"), CreditLimit: money.MustParse("5"), Version: 1, IsActive: true}
+	database.DB.Create(&user)
+	input := map[string]interface{}{"model_id": float64(model.ID)}
+
+	first, err := CreateTask(input, user.ID, user.Username, nil, "", nil)
+	require.NoError(t, err)
+	second, err := CreateTask(input, user.ID, user.Username, nil, "", nil)
+	require.NoError(t, err)
+
+	// Available = 15 + 5 - 20 = 0: holds count against the balance before anything is charged
+	_, err = CreateTask(input, user.ID, user.Username, nil, "", nil)
+	assert.ErrorIs(t, err, ErrInsufficientBalance)
+	var stored models.User
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("15"), stored.Balance)
+	assert.Equal(t, money.MustParse("20"), stored.HeldAmount)
+	assert.Equal(t, money.MustParse("0"), stored.AvailableBalance())
+
+	// The provider reports a lower final cost: only that is charged
+	require.NoError(t, TransitionTask(first, models.TaskStatusProcessing, ActorSystem, "picked up by worker"))
+	completeTask(first, map[string]interface{}{"result_url": "https://oss/a.png", FinalCostOutputKey: 4.0})
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("11"), stored.Balance)
+	assert.Equal(t, money.MustParse("10"), stored.HeldAmount)
+	assert.Equal(t, money.MustParse("4"), stored.TotalConsumed)
+	var task models.Task
+	database.DB.First(&task, first.ID)
+	assert.Equal(t, money.MustParse("4"), task.Cost)
+	var hold models.BalanceHold
+	database.DB.First(&hold, *first.HoldID)
+	assert.Equal(t, models.HoldStatusCaptured, hold.Status)
+	assert.Equal(t, money.MustParse("4"), hold.CapturedAmount)
+
+	// A captured hold cannot be settled again
+	assert.ErrorIs(t, ReleaseHoldTx(database.DB, &hold, "again"), ErrHoldSettled)
+
+	// Failing releases the hold without charging
+	require.NoError(t, TransitionTask(second, models.TaskStatusProcessing, ActorSystem, "picked up by worker"))
+	failTask(second, Terminal(errors.New("content policy")))
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("11"), stored.Balance)
+	assert.Equal(t, money.MustParse("0"), stored.HeldAmount)
+
+	// Retrying places a new hold for the new run
+	retried, err := RetryTask(second.ID, user.ID)
+	require.NoError(t, err)
+	assert.Nil(t, retried.RefundedAt)
+	require.NotNil(t, retried.HoldID)
+	assert.NotEqual(t, *second.HoldID, *retried.HoldID)
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("10"), stored.HeldAmount)
+
+	// Every step is a hashed transaction of the hold
+	var transactions []models.Transaction
+	database.DB.Where("user_id = ?", user.ID).Order("id").Find(&transactions)
+	types := make([]models.TransactionType, 0, len(transactions))
+	for _, tr := range transactions {
+		types = append(types, tr.Type)
+		assert.NotNil(t, tr.HoldID)
+		assert.NotEmpty(t, tr.Hash)
+	}
+	assert.Equal(t, []models.TransactionType{
+		models.TransactionTypeHold,
+		models.TransactionTypeHold,
+		models.TransactionTypeUserConsume,
+		models.TransactionTypeHoldRelease,
+		models.TransactionTypeHold,
+	}, types)
+	assert.Equal(t, money.MustParse("-4"), transactions[2].Amount)
+	assert.Equal(t, money.MustParse("-10"), transactions[2].HeldAmount)
+	chain, err := VerifyTransactionChain(user.ID)
+	require.NoError(t, err)
+	assert.True(t, chain.Valid)
+	assert.Equal(t, len(transactions), chain.Checked)
+}
+
+func TestFinalTaskCost(t *testing.T) {
+	for _, v := range []interface{}{"1.234", 1.234, money.MustParse("1.23")} {
+		cost, ok := finalTaskCost(map[string]interface{}{FinalCostOutputKey: v})
+		assert.True(t, ok)
+		assert.Equal(t, money.MustParse("1.23"), cost)
+	}
+	cost, ok := finalTaskCost(map[string]interface{}{FinalCostOutputKey: 3})
+	assert.True(t, ok)
+	assert.Equal(t, money.MustParse("3"), cost)
+
+	// Costs that do not fit in an amount are ignored rather than wrapped around
+	_, ok = finalTaskCost(map[string]interface{}{FinalCostOutputKey: math.MaxInt64})
+	assert.False(t, ok)
+	_, ok = finalTaskCost(map[string]interface{}{FinalCostOutputKey: "1e30"})
+	assert.False(t, ok)
+	_, ok = finalTaskCost(map[string]interface{}{})
+	assert.False(t, ok)
+}
+
+func TestHoldTaskCost_RetriesOptimisticLock(t *testing.T) {
+	setupPaymentTestDB()
+	mr := setupPaymentTestRedis()
+	defer mr.Close()
+
+	user := models.User{Username: "contended", Balance: money.MustParse("10"), Version: 1, IsActive: true}
+	require.NoError(t, database.DB.Create(&user).Error)
+	task := models.Task{CreatorID: user.ID, Status: models.TaskStatusPendingExecution, Cost: money.MustParse("3")}
+	require.NoError(t, database.DB.Create(&task).Error)
+
+	// A concurrent balance change lands between reading the user and updating it, twice
+	races := 2
+	require.NoError(t, database.DB.Callback().Update().Before("gorm:update").Register("test:race_hold", func(db *gorm.DB) {
+		if db.Statement.Table == "users" && races > 0 {
+			races--
+			db.Session(&gorm.Session{NewDB: true}).Exec("UPDATE users SET version = version + 1 WHERE id = ?", user.ID)
+		}
+	}))
+	defer database.DB.Callback().Update().Remove("test:race_hold")
+
+	require.NoError(t, holdTaskCostTx(database.DB, &task, "contended hold"))
+	assert.NotNil(t, task.HoldID)
+	var stored models.User
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("3"), stored.HeldAmount)
+
+	// Losing every attempt gives up
+	races = holdAttempts
+	task.HoldID = nil
+	assert.ErrorIs(t, holdTaskCostTx(database.DB, &task, "contended hold"), ErrOptimisticLock)
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"aigentools-backend/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// BuiltinExecutor handles tasks that do not target an upstream API.
+// It finishes at submission and simulates a failure when the prompt contains "fail".
+type BuiltinExecutor struct{}
+
+// Submit completes the task inline
+func (e BuiltinExecutor) Submit(ctx context.Context, task *models.Task) (*RemoteHandle, error) {
+	var input map[string]interface{}
+	json.Unmarshal(task.InputData, &input)
+
+	if prompt, ok := input["prompt"].(string); ok && strings.Contains(prompt, "fail") {
+		return nil, errors.New("simulated failure")
+	}
+
+	return &RemoteHandle{
+		Result: &PollResult{State: PollStateSucceeded, Output: map[string]interface{}{"status": "ok"}},
+	}, nil
+}
+
+// Poll is never needed because Submit always finishes the task
+func (e BuiltinExecutor) Poll(ctx context.Context, task *models.Task) (*PollResult, error) {
+	return nil, errors.New("builtin tasks have nothing to poll")
+}
+
+func init() {
+	RegisterExecutor(builtinExecutorName, BuiltinExecutor{})
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis keys of the dead-letter queue. DeadLetterKey orders the failed task IDs
+// by failure time, DeadLetterEntriesKey holds the JSON entry of each ID.
+const (
+	DeadLetterKey        = "task_queue:dead"
+	DeadLetterEntriesKey = "task_queue:dead:entries"
+)
+
+var ErrDeadLetterNotFound = errors.New("task is not in the dead-letter queue")
+
+// DeadLetter records a task that failed permanently
+type DeadLetter struct {
+	TaskID     uint      `json:"task_id"`
+	CreatorID  uint      `json:"creator_id"`
+	Executor   string    `json:"executor"`
+	Error      string    `json:"error"`
+	Retryable  bool      `json:"retryable"` // False when the error was terminal, true when retries ran out
+	RetryCount int       `json:"retry_count"`
+	FailedAt   time.Time `json:"failed_at"`
+}
+
+// pushDeadLetter adds a permanently failed task to the dead-letter queue
+func pushDeadLetter(task *models.Task, cause error) error {
+	entry := DeadLetter{
+		TaskID:     task.ID,
+		CreatorID:  task.CreatorID,
+		Executor:   resolveExecutorName(task),
+		Error:      task.ErrorLog,
+		Retryable:  IsRetryable(cause),
+		RetryCount: task.RetryCount,
+		FailedAt:   time.Now(),
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	member := strconv.FormatUint(uint64(task.ID), 10)
+	_, err = database.RedisClient.TxPipelined(database.Ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(database.Ctx, DeadLetterEntriesKey, member, data)
+		pipe.ZAdd(database.Ctx, DeadLetterKey, &redis.Z{Score: float64(entry.FailedAt.Unix()), Member: member})
+		return nil
+	})
+	return err
+}
+
+// ListDeadLetters returns dead-lettered tasks, most recent first
+func ListDeadLetters(page, limit int) ([]DeadLetter, int64, error) {
+	total, err := database.RedisClient.ZCard(database.Ctx, DeadLetterKey).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	start := int64((page - 1) * limit)
+	ids, err := database.RedisClient.ZRevRange(database.Ctx, DeadLetterKey, start, start+int64(limit)-1).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := make([]DeadLetter, 0, len(ids))
+	if len(ids) == 0 {
+		return entries, total, nil
+	}
+
+	values, err := database.RedisClient.HMGet(database.Ctx, DeadLetterEntriesKey, ids...).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var entry DeadLetter
+		if err := json.Unmarshal([]byte(s), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, total, nil
+}
+
+// GetDeadLetter returns the dead-letter entry of a task
+func GetDeadLetter(taskID uint) (*DeadLetter, error) {
+	data, err := database.RedisClient.HGet(database.Ctx, DeadLetterEntriesKey, strconv.FormatUint(uint64(taskID), 10)).Result()
+	if err == redis.Nil {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entry DeadLetter
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return nil, fmt.Errorf("invalid dead-letter entry: %v", err)
+	}
+	return &entry, nil
+}
+
+// DeleteDeadLetter removes a task from the dead-letter queue without touching the task itself
+func DeleteDeadLetter(taskID uint) error {
+	member := strconv.FormatUint(uint64(taskID), 10)
+	var removed *redis.IntCmd
+	_, err := database.RedisClient.TxPipelined(database.Ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.HDel(database.Ctx, DeadLetterEntriesKey, member)
+		pipe.ZRem(database.Ctx, DeadLetterKey, member)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if removed.Val() == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+// PurgeDeadLetters empties the dead-letter queue and returns how many entries were removed
+func PurgeDeadLetters() (int64, error) {
+	total, err := database.RedisClient.ZCard(database.Ctx, DeadLetterKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	if err := database.RedisClient.Del(database.Ctx, DeadLetterKey, DeadLetterEntriesKey).Err(); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// RequeueDeadLetter takes a task out of the dead-letter queue and runs it again from scratch,
+// the same way RetryTask does for its owner
+func RequeueDeadLetter(taskID uint, actor string) (*models.Task, error) {
+	if _, err := GetDeadLetter(taskID); err != nil {
+		return nil, err
+	}
+
+	var task models.Task
+	if err := database.DB.First(&task, taskID).Error; err != nil {
+		return nil, err
+	}
+	if task.Status != models.TaskStatusFailed {
+		return nil, errors.New("task is not in a failed state")
+	}
+
+	task.RetryCount = 0
+	task.ErrorLog = ""
+	task.ResultURL = ""
+	if err := rerunFailedTask(&task, actor, "requeued from the dead-letter queue"); err != nil {
+		if errors.Is(err, ErrTransitionConflict) {
+			return nil, errors.New("task is not in a failed state")
+		}
+		return nil, err
+	}
+
+	if err := DeleteDeadLetter(taskID); err != nil && !errors.Is(err, ErrDeadLetterNotFound) {
+		fmt.Printf("Failed to remove task %d from the dead-letter queue: %v\n", taskID, err)
+	}
+
+	if err := Queue.Enqueue(&task); err != nil {
+		return &task, fmt.Errorf("task reset but failed to push to redis: %v", err)
+	}
+	return &task, nil
+}
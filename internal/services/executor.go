@@ -2,11 +2,75 @@ package services
 
 import (
 	"aigentools-backend/internal/models"
+	"context"
 	"sync"
+	"time"
 )
 
+// PollState is the state of a remote task as reported by an executor
+type PollState int
+
+const (
+	PollStatePending PollState = iota
+	PollStateSucceeded
+	PollStateFailed
+)
+
+// PollResult is the outcome of checking a remote task
+type PollResult struct {
+	State  PollState
+	Output map[string]interface{} // Set when succeeded, e.g. oss_url, original_url, remote_task_id
+	Err    error                  // Set when failed
+}
+
+// RemoteHandle identifies a task accepted by an upstream service
+type RemoteHandle struct {
+	RemoteTaskID string
+	QueryURL     string      // Status URL, if the executor knows it at submission time
+	Result       *PollResult // Set when the task finished inline and there is nothing to poll
+}
+
+// TaskExecutor runs tasks in two phases. Submit hands the task to the upstream
+// service and returns quickly; Poll checks on it later. The worker only submits,
+// PollingManager owns every status check afterwards.
 type TaskExecutor interface {
-	Execute(*models.Task) (map[string]interface{}, error)
+	Submit(ctx context.Context, task *models.Task) (*RemoteHandle, error)
+	Poll(ctx context.Context, task *models.Task) (*PollResult, error)
+}
+
+// defaultPollTimeout bounds how long a remote task may stay pending
+const defaultPollTimeout = 30 * time.Minute
+
+// pollTimeouter is implemented by executors whose remote tasks need a different poll timeout
+type pollTimeouter interface {
+	PollTimeout() time.Duration
+}
+
+// pollScheduler is implemented by executors whose poll interval and timeout depend on
+// the task. A zero value falls back to the defaults.
+type pollScheduler interface {
+	PollSchedule(task *models.Task) (interval, timeout time.Duration)
+}
+
+func executorPollTimeout(ex TaskExecutor, task *models.Task) time.Duration {
+	if s, ok := ex.(pollScheduler); ok {
+		if _, timeout := s.PollSchedule(task); timeout > 0 {
+			return timeout
+		}
+	}
+	if t, ok := ex.(pollTimeouter); ok {
+		return t.PollTimeout()
+	}
+	return defaultPollTimeout
+}
+
+func executorPollInterval(ex TaskExecutor, task *models.Task) time.Duration {
+	if s, ok := ex.(pollScheduler); ok {
+		if interval, _ := s.PollSchedule(task); interval > 0 {
+			return interval
+		}
+	}
+	return pollInterval
 }
 
 var executorMu sync.RWMutex
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const idempotencyPrefix = "idempotency:"
+
+const (
+	// IdempotencyTTL is how long the response to a request is kept for replays
+	IdempotencyTTL = 24 * time.Hour
+	// idempotencyLockTTL bounds how long a crashed request can hold its key
+	idempotencyLockTTL = 2 * time.Minute
+)
+
+var (
+	ErrIdempotencyKeyInUse    = errors.New("a request with this idempotency key is still being processed")
+	ErrIdempotencyKeyMismatch = errors.New("idempotency key was already used for a different request")
+)
+
+// IdempotentResponse is the stored outcome of a request made with an idempotency key
+type IdempotentResponse struct {
+	RequestHash string `json:"request_hash"`
+	Pending     bool   `json:"pending,omitempty"` // The first request is still running
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+func idempotencyRedisKey(scope, key string) string {
+	return idempotencyPrefix + scope + ":" + key
+}
+
+// BeginIdempotentRequest claims an idempotency key for a request with the given hash.
+// It returns nil when the caller should process the request, or the stored response
+// of an earlier identical request to replay instead.
+func BeginIdempotentRequest(scope, key, requestHash string) (*IdempotentResponse, error) {
+	redisKey := idempotencyRedisKey(scope, key)
+	pending, err := json.Marshal(IdempotentResponse{RequestHash: requestHash, Pending: true})
+	if err != nil {
+		return nil, err
+	}
+
+	claimed, err := database.RedisClient.SetNX(database.Ctx, redisKey, pending, idempotencyLockTTL).Result()
+	if err != nil {
+		return nil, err
+	}
+	if claimed {
+		return nil, nil
+	}
+
+	data, err := database.RedisClient.Get(database.Ctx, redisKey).Bytes()
+	if err == redis.Nil {
+		// Released between the two calls; let the client try again
+		return nil, ErrIdempotencyKeyInUse
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored IdempotentResponse
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	if stored.RequestHash != requestHash {
+		return nil, ErrIdempotencyKeyMismatch
+	}
+	if stored.Pending {
+		return nil, ErrIdempotencyKeyInUse
+	}
+	return &stored, nil
+}
+
+// CompleteIdempotentRequest stores the response of a claimed request for IdempotencyTTL
+func CompleteIdempotentRequest(scope, key string, resp IdempotentResponse) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return database.RedisClient.Set(database.Ctx, idempotencyRedisKey(scope, key), data, IdempotencyTTL).Err()
+}
+
+// ReleaseIdempotencyKey forgets a claimed key, so the request can be retried with it
+func ReleaseIdempotencyKey(scope, key string) error {
+	return database.RedisClient.Del(database.Ctx, idempotencyRedisKey(scope, key)).Err()
+}
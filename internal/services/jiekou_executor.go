@@ -1,81 +1,66 @@
 package services
 
 import (
-	"aigentools-backend/internal/database"
 	"aigentools-backend/internal/models"
 	"aigentools-backend/internal/utils"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
-
-	"github.com/google/uuid"
 )
 
+// jiekouMockTaskID is a special remote task used for end-to-end testing without calling jiekou.ai
+const jiekouMockTaskID = "1111-2222-3333-4444"
+
+const jiekouMockResultURL = "https://aigentools.oss-cn-beijing.aliyuncs.com/tasks/95fef7fc-77ca-4d5d-9734-c4c3ed3a1877.mp4"
+
 // JiekouExecutor implements the TaskExecutor interface for jiekou.ai style tasks
 type JiekouExecutor struct {
 	Uploader func(localPath string, objectKey string) (string, error)
 }
 
-// Execute performs the task logic
-func (e JiekouExecutor) Execute(task *models.Task) (map[string]interface{}, error) {
-	// Use default uploader if nil
-	uploader := e.Uploader
-	if uploader == nil {
-		uploader = UploadFile
-	}
+// PollTimeout allows long running video generation
+func (e JiekouExecutor) PollTimeout() time.Duration {
+	return 30 * time.Minute
+}
 
-	// 1. Parse Input Data
+// parseJiekouInput extracts the data and model sections of a jiekou task
+func parseJiekouInput(task *models.Task) (data, model map[string]interface{}, err error) {
 	var input map[string]interface{}
 	if err := json.Unmarshal(task.InputData, &input); err != nil {
-		return nil, fmt.Errorf("failed to parse input data: %v", err)
+		return nil, nil, fmt.Errorf("failed to parse input data: %v", err)
 	}
 
-	data, _ := input["data"].(map[string]interface{})
-	model, _ := input["model"].(map[string]interface{})
+	data, _ = input["data"].(map[string]interface{})
+	model, _ = input["model"].(map[string]interface{})
 	if data == nil || model == nil {
-		return nil, errors.New("missing data or model in input")
+		return nil, nil, errors.New("missing data or model in input")
+	}
+	return data, model, nil
+}
+
+// Submit sends the task to jiekou and returns the remote task ID
+func (e JiekouExecutor) Submit(ctx context.Context, task *models.Task) (*RemoteHandle, error) {
+	// 1. Parse Input Data
+	data, model, err := parseJiekouInput(task)
+	if err != nil {
+		return nil, Terminal(err)
 	}
 
 	modelURL, _ := model["model_url"].(string)
 	if modelURL == "" {
-		return nil, errors.New("missing model_url")
-	}
-
-	// --- 修复重点：将所有变量声明提到 goto 之前 ---
-	var (
-		remoteTaskID string
-		respData     map[string]interface{}
-		bodyBytes    []byte
-		resp         *http.Response
-		err          error
-		queryURL     string
-		// 以下是为了解决 goto 跳过声明错误而提升的变量
-		payloadBytes []byte
-		req          *http.Request
-		client       *http.Client
-	)
-
-	// 提前初始化 client，因为 Poll 部分也需要用到它
-	client = utils.NewHTTPClient(30 * time.Second)
-
-	// 2. Send Request / Check if already sent
-	// If RemoteTaskID exists, skip submission and go to polling
-	if task.RemoteTaskID != "" {
-		remoteTaskID = task.RemoteTaskID
-		// Need to reconstruct model info for polling URL generation
-		goto Poll
-	}
-
-	// --- 提交任务逻辑 (使用 assignment = 而不是 declaration :=) ---
-	payloadBytes, _ = json.Marshal(data)
-	req, err = http.NewRequest("POST", modelURL, bytes.NewBuffer(payloadBytes))
+		return nil, Terminal(errors.New("missing model_url"))
+	}
+
+	// 2. Send Request
+	payloadBytes, _ := json.Marshal(data)
+	req, err := http.NewRequestWithContext(ctx, "POST", modelURL, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -91,7 +76,10 @@ func (e JiekouExecutor) Execute(task *models.Task) (map[string]interface{}, erro
 		}
 	}
 
-	resp, err = client.Do(req)
+	// Sync models answer only once the result is ready
+	mode := taskExecutionMode(task)
+	client := upstreamClient(mode)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %v", err)
 	}
@@ -99,18 +87,41 @@ func (e JiekouExecutor) Execute(task *models.Task) (map[string]interface{}, erro
 
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("api returned error status: %d, body: %s", resp.StatusCode, string(body))
+		return nil, upstreamStatusError(resp.StatusCode, string(body))
+	}
+
+	if mode == models.ExecutionModeSyncBinary {
+		result, err := binaryResult(e.uploader(), task, resp)
+		if err != nil {
+			return nil, err
+		}
+		return &RemoteHandle{Result: result}, nil
 	}
 
 	// Read body first for debugging
-	bodyBytes, _ = io.ReadAll(resp.Body)
+	bodyBytes, _ := io.ReadAll(resp.Body)
 	fmt.Printf("Jiekou API Response: %s\n", string(bodyBytes))
 
+	var respData map[string]interface{}
 	if err := json.Unmarshal(bodyBytes, &respData); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %v", err)
 	}
 
+	// The response is the result itself, there is no task to poll
+	if mode == models.ExecutionModeSyncInline {
+		taskInfo, ok := respData["data"].(map[string]interface{})
+		if !ok {
+			taskInfo = respData
+		}
+		result, err := inlineResult(ctx, e.uploader(), task, respData, jiekouFileSources(respData, taskInfo))
+		if err != nil {
+			return nil, err
+		}
+		return &RemoteHandle{Result: result}, nil
+	}
+
 	// 3. Extract Task ID
+	var remoteTaskID string
 	if d, ok := respData["data"].(map[string]interface{}); ok {
 		if id, ok := d["id"].(string); ok {
 			remoteTaskID = id
@@ -146,189 +157,210 @@ func (e JiekouExecutor) Execute(task *models.Task) (map[string]interface{}, erro
 		return nil, fmt.Errorf("could not find task_id in response: %v", respData)
 	}
 
-	// Update Task with RemoteTaskID
-	task.RemoteTaskID = remoteTaskID
-	database.DB.Save(task)
-
-Poll:
-	// 4. Poll for Status
-	// Construct polling URL
+	// 4. Remember the polling URL if the API returned one
+	handle := &RemoteHandle{RemoteTaskID: remoteTaskID}
 	if d, ok := respData["data"].(map[string]interface{}); ok {
 		if url, ok := d["query_url"].(string); ok {
-			queryURL = url
+			handle.QueryURL = url
 		}
 	}
 
-	if queryURL == "" {
-		if t, ok := model["query_url_template"].(string); ok && t != "" {
-			queryURL = fmt.Sprintf(t, remoteTaskID)
-		} else {
-			// Updated default polling URL format based on user feedback
-			// Format: https://api.jiekou.ai/v3/async/task-result?task_id={id}
-			queryURL = fmt.Sprintf("https://api.jiekou.ai/v3/async/task-result?task_id=%s", remoteTaskID)
-		}
+	return handle, nil
+}
+
+// jiekouQueryURL builds the status URL of a submitted task
+func jiekouQueryURL(task *models.Task, model map[string]interface{}) string {
+	if task.RemoteQueryURL != "" {
+		return task.RemoteQueryURL
+	}
+	if t, ok := model["query_url_template"].(string); ok && t != "" {
+		return fmt.Sprintf(t, task.RemoteTaskID)
 	}
+	// Updated default polling URL format based on user feedback
+	// Format: https://api.jiekou.ai/v3/async/task-result?task_id={id}
+	return fmt.Sprintf("https://api.jiekou.ai/v3/async/task-result?task_id=%s", task.RemoteTaskID)
+}
 
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+// Poll checks the remote task once and uploads the result file when it has finished
+func (e JiekouExecutor) Poll(ctx context.Context, task *models.Task) (*PollResult, error) {
+	remoteTaskID := task.RemoteTaskID
+	if remoteTaskID == "" {
+		return nil, errors.New("task has not been submitted")
+	}
 
-	timeout := time.After(30 * time.Minute) // Video gen might take time
-	var fileURL string
+	if remoteTaskID == jiekouMockTaskID {
+		// This is the special task, mock the polling response by considering it complete
+		return &PollResult{
+			State: PollStateSucceeded,
+			Output: map[string]interface{}{
+				"oss_url":        jiekouMockResultURL,
+				"original_url":   jiekouMockResultURL,
+				"remote_task_id": remoteTaskID,
+				ArtifactsOutputKey: []models.TaskArtifact{
+					{URL: jiekouMockResultURL, OriginalURL: jiekouMockResultURL, MimeType: "video/mp4"},
+				},
+			},
+		}, nil
+	}
 
-	for {
-		select {
-		case <-timeout:
-			return nil, errors.New("task polling timed out")
-		case <-ticker.C:
-			if remoteTaskID == "1111-2222-3333-4444" {
-				// This is the special task, mock the polling response by considering it complete
-				// and jump to the download (which is also mocked).
-				fileURL = "https://aigentools.oss-cn-beijing.aliyuncs.com/tasks/95fef7fc-77ca-4d5d-9734-c4c3ed3a1877.mp4" // Set a dummy original_url
-				goto Download
-			}
-			statusReq, _ := http.NewRequest("GET", queryURL, nil)
-			// add headers
-			statusReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("JIEKOU_API")))
-			statusReq.Header.Set("Content-Type", "application/json")
-
-			statusResp, err := client.Do(statusReq)
-			if err != nil {
-				fmt.Printf("Polling error: %v\n", err)
-				continue
-			}
-			// Important: Close body manually inside loop
-			// defer works at function scope, not loop scope, so this would leak without manual close
-			// But since we use ReadAll immediately, we can close immediately.
-
-			bodyBytes, _ := io.ReadAll(statusResp.Body)
-			statusResp.Body.Close() // Explicit close
-
-			var statusData map[string]interface{}
-			json.Unmarshal(bodyBytes, &statusData)
-
-			// Check status
-			var taskInfo map[string]interface{}
-			if t, ok := statusData["task"].(map[string]interface{}); ok {
-				taskInfo = t
-			} else {
-				if d, ok := statusData["data"].(map[string]interface{}); ok {
-					taskInfo = d
-				} else {
-					taskInfo = statusData
-				}
-			}
+	_, model, err := parseJiekouInput(task)
+	if err != nil {
+		return nil, err
+	}
 
-			statusVal, _ := taskInfo["status"].(string)
-			statusValUpper := strings.ToUpper(statusVal)
-
-			switch statusValUpper {
-			case "TASK_STATUS_SUCCEED", "SUCCESS", "COMPLETED", "SUCCEEDED":
-				// Extract file URL
-				if videos, ok := statusData["videos"].([]interface{}); ok && len(videos) > 0 {
-					if v, ok := videos[0].(map[string]interface{}); ok {
-						if url, ok := v["video_url"].(string); ok && url != "" {
-							fileURL = url
-							goto Download
-						}
-					}
-				}
+	statusReq, err := http.NewRequestWithContext(ctx, "GET", jiekouQueryURL(task, model), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	// add headers
+	statusReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("JIEKOU_API")))
+	statusReq.Header.Set("Content-Type", "application/json")
 
-				if images, ok := statusData["images"].([]interface{}); ok && len(images) > 0 {
-					if img, ok := images[0].(map[string]interface{}); ok {
-						if url, ok := img["image_url"].(string); ok && url != "" {
-							fileURL = url
-							goto Download
-						}
-					}
-				}
+	client := utils.NewHTTPClient(30 * time.Second)
+	statusResp, err := client.Do(statusReq)
+	if err != nil {
+		return nil, fmt.Errorf("polling request failed: %v", err)
+	}
+	bodyBytes, _ := io.ReadAll(statusResp.Body)
+	statusResp.Body.Close()
+
+	var statusData map[string]interface{}
+	json.Unmarshal(bodyBytes, &statusData)
+
+	// Check status
+	var taskInfo map[string]interface{}
+	if t, ok := statusData["task"].(map[string]interface{}); ok {
+		taskInfo = t
+	} else {
+		if d, ok := statusData["data"].(map[string]interface{}); ok {
+			taskInfo = d
+		} else {
+			taskInfo = statusData
+		}
+	}
 
-				if audios, ok := statusData["audios"].([]interface{}); ok && len(audios) > 0 {
-					if a, ok := audios[0].(map[string]interface{}); ok {
-						if url, ok := a["audio_url"].(string); ok && url != "" {
-							fileURL = url
-							goto Download
-						}
-					}
-				}
+	statusVal, _ := taskInfo["status"].(string)
+	statusValUpper := strings.ToUpper(statusVal)
 
-				if url, ok := taskInfo["url"].(string); ok && url != "" {
-					fileURL = url
-					goto Download
-				} else if url, ok := taskInfo["file_url"].(string); ok && url != "" {
-					fileURL = url
-					goto Download
-				} else if url, ok := taskInfo["result_url"].(string); ok && url != "" {
-					fileURL = url
-					goto Download
-				} else if url, ok := taskInfo["output"].(string); ok && url != "" {
-					fileURL = url
-					goto Download
-				}
+	switch statusValUpper {
+	case "TASK_STATUS_SUCCEED", "SUCCESS", "COMPLETED", "SUCCEEDED":
+		sources := jiekouFileSources(statusData, taskInfo)
+		if len(sources) == 0 {
+			return &PollResult{State: PollStateFailed, Err: fmt.Errorf("completed but file url not found in response: %v", statusData)}, nil
+		}
 
-				return nil, fmt.Errorf("completed but file url not found in response: %v", statusData)
+		output, err := e.download(ctx, remoteTaskID, sources)
+		if err != nil {
+			return nil, err
+		}
+		return &PollResult{State: PollStateSucceeded, Output: output}, nil
 
-			case "TASK_STATUS_FAILED", "FAILED", "ERROR":
-				reason, _ := taskInfo["reason"].(string)
-				return nil, fmt.Errorf("remote task failed: %s (status: %s)", reason, statusVal)
+	case "TASK_STATUS_FAILED", "FAILED", "ERROR":
+		reason, _ := taskInfo["reason"].(string)
+		return &PollResult{State: PollStateFailed, Err: fmt.Errorf("remote task failed: %s (status: %s)", reason, statusVal)}, nil
 
-			default:
-				// No op
-			}
-		}
+	default:
+		return &PollResult{State: PollStatePending}, nil
 	}
+}
 
-Download:
-	if remoteTaskID == "1111-2222-3333-4444" {
-		return map[string]interface{}{
-			"oss_url":        "https://aigentools.oss-cn-beijing.aliyuncs.com/tasks/95fef7fc-77ca-4d5d-9734-c4c3ed3a1877.mp4",
-			"original_url":   fileURL,
-			"remote_task_id": remoteTaskID,
-		}, nil
+// Cancel stops a submitted task through the model's cancel_url_template, if it has one
+func (e JiekouExecutor) Cancel(ctx context.Context, task *models.Task) error {
+	if task.RemoteTaskID == "" || task.RemoteTaskID == jiekouMockTaskID {
+		return nil
 	}
 
-	// 5. Download File
-	fmt.Printf("Downloading file from %s...\n", fileURL)
-	fileResp, err := http.Get(fileURL)
+	_, model, err := parseJiekouInput(task)
 	if err != nil {
-		return nil, fmt.Errorf("failed to download file: %v", err)
+		return err
 	}
-	defer fileResp.Body.Close()
-
-	// 6. Upload to OSS with taskID as filename
-	ext := ".mp4" // Default for video
-	if idx := strings.LastIndex(fileURL, "."); idx != -1 {
-		possibleExt := fileURL[idx:]
-		if len(possibleExt) < 6 {
-			ext = possibleExt
-		}
+	cancelTemplate, _ := model["cancel_url_template"].(string)
+	if cancelTemplate == "" {
+		return ErrCancelNotSupported
 	}
 
-	fileName := remoteTaskID + ext
-	tmpName := filepath.Join(os.TempDir(), fmt.Sprintf("%s_%s", uuid.New().String(), fileName))
-	out, err := os.Create(tmpName)
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf(cancelTemplate, task.RemoteTaskID), nil)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to create request: %v", err)
 	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("JIEKOU_API")))
+	req.Header.Set("Content-Type", "application/json")
 
-	_, err = io.Copy(out, fileResp.Body)
-	out.Close()
+	client := utils.NewHTTPClient(30 * time.Second)
+	resp, err := client.Do(req)
 	if err != nil {
-		os.Remove(tmpName)
-		return nil, err
+		return fmt.Errorf("cancel request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return upstreamStatusError(resp.StatusCode, string(body))
 	}
-	defer os.Remove(tmpName)
+	return nil
+}
 
-	ossKey := fmt.Sprintf("tasks/%s", fileName)
+// jiekouFileSources extracts every result file of a finished task: all videos, images
+// and audios, or else the single URL of the task info
+func jiekouFileSources(statusData, taskInfo map[string]interface{}) []artifactSource {
+	var sources []artifactSource
+	for _, kind := range []struct{ list, urlKey string }{
+		{"videos", "video_url"},
+		{"images", "image_url"},
+		{"audios", "audio_url"},
+	} {
+		entries, _ := statusData[kind.list].([]interface{})
+		for _, e := range entries {
+			if entry, ok := e.(map[string]interface{}); ok {
+				if src, ok := artifactSourceFrom(entry, kind.urlKey); ok {
+					sources = append(sources, src)
+				}
+			}
+		}
+	}
+	if len(sources) > 0 {
+		return sources
+	}
 
-	ossURL, err := uploader(tmpName, ossKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to upload to oss: %v", err)
+	for _, key := range []string{"url", "file_url", "result_url", "output"} {
+		if url, ok := taskInfo[key].(string); ok && url != "" {
+			return []artifactSource{{URL: url}}
+		}
+	}
+	return nil
+}
+
+// uploader returns the function storing result files, UploadFile by default
+func (e JiekouExecutor) uploader() func(localPath, objectKey string) (string, error) {
+	if e.Uploader == nil {
+		return UploadFile
+	}
+	return e.Uploader
+}
+
+// download fetches every result file and uploads it to OSS. The first file is named
+// after the remote task ID, the others get their position appended.
+func (e JiekouExecutor) download(ctx context.Context, remoteTaskID string, sources []artifactSource) (map[string]interface{}, error) {
+	uploader := e.uploader()
+
+	artifacts := make([]models.TaskArtifact, 0, len(sources))
+	for i, src := range sources {
+		fileName := remoteTaskID + artifactExt(src.URL, ".mp4") // Default for video
+		if i > 0 {
+			fileName = fmt.Sprintf("%s_%d%s", remoteTaskID, i, artifactExt(src.URL, ".mp4"))
+		}
+		artifact, err := fetchArtifact(ctx, uploader, src, "tasks/"+fileName)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, *artifact)
 	}
 
 	return map[string]interface{}{
-		"oss_url":        ossURL,
-		"original_url":   fileURL,
-		"remote_task_id": remoteTaskID,
+		"oss_url":          artifacts[0].URL,
+		"original_url":     artifacts[0].OriginalURL,
+		"remote_task_id":   remoteTaskID,
+		ArtifactsOutputKey: artifacts,
 	}, nil
 }
 
@@ -3,7 +3,13 @@ package services
 import (
 	"aigentools-backend/internal/database"
 	"aigentools-backend/internal/models"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"image"
+	"image/png"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -19,14 +25,17 @@ func setupJiekouTestDB() {
 	if err != nil {
 		panic("failed to connect database")
 	}
-	db.Migrator().DropTable(&models.Task{})
-	db.AutoMigrate(&models.Task{})
+	db.Migrator().DropTable(&models.Task{}, &models.TaskEvent{}, &models.TaskArtifact{})
+	db.AutoMigrate(&models.Task{}, &models.TaskEvent{}, &models.TaskArtifact{})
 	database.DB = db
 }
 
 func TestJiekouExecutor_Execute(t *testing.T) {
 	setupJiekouTestDB() // Initialize DB for Save(task) call
 
+	// The file servers listen on loopback
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+
 	// 1. Mock Jiekou API
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
@@ -100,10 +109,113 @@ func TestJiekouExecutor_Execute(t *testing.T) {
 		Uploader: mockUploader,
 	}
 
-	// 4. Execute
-	result, err := executor.Execute(task)
+	// 4. Submit
+	handle, err := executor.Submit(context.Background(), task)
+	assert.NoError(t, err)
+	assert.Equal(t, "jk-task-888", handle.RemoteTaskID)
+	assert.Nil(t, handle.Result)
+	task.RemoteTaskID = handle.RemoteTaskID
+	task.RemoteQueryURL = handle.QueryURL
+
+	// 5. Poll
+	result, err := executor.Poll(context.Background(), task)
+	assert.NoError(t, err)
+	assert.Equal(t, PollStateSucceeded, result.State)
+	assert.Equal(t, "jk-task-888", result.Output["remote_task_id"])
+	assert.Equal(t, "https://oss.aliyun.com/tasks/jk-task-888.mp4", result.Output["oss_url"])
+}
+
+func TestJiekouExecutor_PollStates(t *testing.T) {
+	status := "TASK_STATUS_QUEUED"
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"task": map[string]string{
+				"status": status,
+				"reason": "content policy",
+			},
+		})
+	}))
+	defer mockServer.Close()
+
+	input := map[string]interface{}{
+		"data": map[string]interface{}{"prompt": "123"},
+		"model": map[string]interface{}{
+			"model_url":          mockServer.URL + "/create",
+			"query_url_template": mockServer.URL + "/query/%s",
+		},
+	}
+	inputBytes, _ := json.Marshal(input)
+	task := &models.Task{
+		ID:           101,
+		InputData:    datatypes.JSON(inputBytes),
+		RemoteTaskID: "jk-task-999",
+	}
+
+	executor := JiekouExecutor{}
+
+	result, err := executor.Poll(context.Background(), task)
+	assert.NoError(t, err)
+	assert.Equal(t, PollStatePending, result.State)
+
+	status = "TASK_STATUS_FAILED"
+	result, err = executor.Poll(context.Background(), task)
 	assert.NoError(t, err)
-	assert.NotNil(t, result)
-	assert.Equal(t, "jk-task-888", result["remote_task_id"])
-	assert.Equal(t, "https://oss.aliyun.com/tasks/jk-task-888.mp4", result["oss_url"])
+	assert.Equal(t, PollStateFailed, result.State)
+	assert.Contains(t, result.Err.Error(), "content policy")
+}
+
+func TestJiekouExecutor_PollKeepsEveryOutput(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true") // The file servers listen on loopback
+
+	encodePNG := func(w, h int) []byte {
+		var buf bytes.Buffer
+		png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h)))
+		return buf.Bytes()
+	}
+	files := map[string][]byte{"/a.png": encodePNG(4, 3), "/b.png": encodePNG(8, 6)}
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if content, ok := files[r.URL.Path]; ok {
+			w.Write(content)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"task": map[string]string{"status": "TASK_STATUS_SUCCEED"},
+			"images": []map[string]interface{}{
+				{"image_url": "http://" + r.Host + "/a.png"},
+				{"image_url": "http://" + r.Host + "/b.png"},
+			},
+		})
+	}))
+	defer mockServer.Close()
+
+	inputBytes, _ := json.Marshal(map[string]interface{}{
+		"data":  map[string]interface{}{"prompt": "cats"},
+		"model": map[string]interface{}{"model_url": mockServer.URL + "/create"},
+	})
+	task := &models.Task{ID: 101, InputData: datatypes.JSON(inputBytes), RemoteTaskID: "jk-4", RemoteQueryURL: mockServer.URL + "/query"}
+
+	var keys []string
+	executor := JiekouExecutor{Uploader: func(localPath, objectKey string) (string, error) {
+		keys = append(keys, objectKey)
+		return "https://oss.aliyun.com/" + objectKey, nil
+	}}
+
+	result, err := executor.Poll(context.Background(), task)
+	assert.NoError(t, err)
+	assert.Equal(t, PollStateSucceeded, result.State)
+	assert.Equal(t, []string{"tasks/jk-4.png", "tasks/jk-4_1.png"}, keys)
+	assert.Equal(t, "https://oss.aliyun.com/tasks/jk-4.png", result.Output["oss_url"])
+
+	artifacts := result.Output[ArtifactsOutputKey].([]models.TaskArtifact)
+	if assert.Len(t, artifacts, 2) {
+		sum := sha256.Sum256(files["/b.png"])
+		assert.Equal(t, "https://oss.aliyun.com/tasks/jk-4_1.png", artifacts[1].URL)
+		assert.Equal(t, "tasks/jk-4_1.png", artifacts[1].OSSKey)
+		assert.Equal(t, "image/png", artifacts[1].MimeType)
+		assert.Equal(t, int64(len(files["/b.png"])), artifacts[1].Size)
+		assert.Equal(t, hex.EncodeToString(sum[:]), artifacts[1].Checksum)
+		assert.Equal(t, 8, artifacts[1].Width)
+		assert.Equal(t, 6, artifacts[1].Height)
+	}
 }
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"fmt"
+)
+
+// openAPIVersion is the OpenAPI version of the model document; 3.1 uses JSON Schema 2020-12
+const openAPIVersion = "3.1.0"
+
+// ModelRequestSchema renders the request_body parameters of a model as a JSON Schema
+// document. Models without definitions accept any object.
+func ModelRequestSchema(model *models.AIModel) (map[string]interface{}, error) {
+	var defs []models.ParameterDefinition
+	if len(model.Parameters) > 0 {
+		params, err := models.ParseModelParameters(model.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		defs = params.RequestBody
+	}
+
+	schema := models.ParametersJSONSchema(defs)
+	schema["title"] = model.Name
+	if model.Description != "" {
+		schema["description"] = model.Description
+	}
+	return schema, nil
+}
+
+// GetOpenModelsWithParameters returns every open model with its parameter definitions
+func GetOpenModelsWithParameters() ([]models.AIModel, error) {
+	var modelsList []models.AIModel
+	if err := database.DB.Select("id, name, description, status, price, parameters, execution_mode").
+		Where("status = ?", models.AIModelStatusOpen).Order("id").Find(&modelsList).Error; err != nil {
+		return nil, err
+	}
+	return modelsList, nil
+}
+
+// BuildModelsOpenAPI describes task submission for each model as an OpenAPI operation
+// on POST /models/{id}/tasks, with the model's parameters as the schema of body.data.
+// serverURL is the base URL of the API, such as /api/v1.
+func BuildModelsOpenAPI(modelsList []models.AIModel, serverURL string) (map[string]interface{}, error) {
+	schemas := map[string]interface{}{
+		"TaskUser": map[string]interface{}{
+			"type":     "object",
+			"required": []string{"creatorId", "creatorName"},
+			"properties": map[string]interface{}{
+				"creatorId":   map[string]interface{}{"type": "integer"},
+				"creatorName": map[string]interface{}{"type": "string"},
+			},
+		},
+		"Response": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"status":  map[string]interface{}{"type": "integer"},
+				"message": map[string]interface{}{"type": "string"},
+				"data":    map[string]interface{}{},
+			},
+		},
+	}
+	paths := map[string]interface{}{}
+
+	for i := range modelsList {
+		model := &modelsList[i]
+		input, err := ModelRequestSchema(model)
+		if err != nil {
+			return nil, fmt.Errorf("model %d: %w", model.ID, err)
+		}
+		// The dialect is declared once for the whole document
+		delete(input, "$schema")
+		inputName := fmt.Sprintf("Model%dInput", model.ID)
+		schemas[inputName] = input
+
+		paths[fmt.Sprintf("/models/%d/tasks", model.ID)] = map[string]interface{}{
+			"post": modelOperation(model, inputName),
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi":           openAPIVersion,
+		"jsonSchemaDialect": models.JSONSchemaDialect,
+		"info": map[string]interface{}{
+			"title":       "AigenTools models",
+			"description": "Task submission for every open model",
+			"version":     "1.0",
+		},
+		"servers":  []interface{}{map[string]interface{}{"url": serverURL}},
+		"security": []interface{}{map[string]interface{}{"Bearer": []string{}}},
+		"paths":    paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"Bearer": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}, nil
+}
+
+// modelOperation is the submission operation of a model, shaped like CreateTaskRequest
+func modelOperation(model *models.AIModel, inputName string) map[string]interface{} {
+	request := map[string]interface{}{
+		"type":     "object",
+		"required": []string{"body", "user"},
+		"properties": map[string]interface{}{
+			"body": map[string]interface{}{
+				"type":     "object",
+				"required": []string{"data"},
+				"properties": map[string]interface{}{
+					"data": map[string]interface{}{"$ref": "#/components/schemas/" + inputName},
+				},
+			},
+			"user":         map[string]interface{}{"$ref": "#/components/schemas/TaskUser"},
+			"scheduled_at": map[string]interface{}{"type": "string", "format": "date-time"},
+			"callback_url": map[string]interface{}{"type": "string", "format": "uri", "maxLength": 500},
+			"quoted_price": map[string]interface{}{"type": "number"},
+		},
+	}
+	response := func(description string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": map[string]interface{}{"$ref": "#/components/schemas/Response"},
+				},
+			},
+		}
+	}
+
+	operation := map[string]interface{}{
+		"operationId": fmt.Sprintf("submitModel%dTask", model.ID),
+		"summary":     model.Name,
+		"tags":        []string{"models"},
+		"requestBody": map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": request},
+			},
+		},
+		"responses": map[string]interface{}{
+			"200": response("Task submitted"),
+			"400": response("Invalid task input"),
+			"409": response("Price differs from quoted_price"),
+		},
+	}
+	if model.Description != "" {
+		operation["description"] = model.Description
+	}
+	if model.ExecutionMode.IsSync() {
+		operation["parameters"] = []interface{}{map[string]interface{}{
+			"name":        "wait",
+			"in":          "query",
+			"description": "Seconds to wait for the task to finish",
+			"schema":      map[string]interface{}{"type": "integer", "minimum": 0, "maximum": 60},
+		}}
+	}
+	return operation
+}
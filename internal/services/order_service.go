@@ -1,9 +1,9 @@
 package services
 
 import (
-	"aigentools-backend/config"
 	"aigentools-backend/internal/database"
 	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
 	"errors"
 	"fmt"
 	"strings"
@@ -11,6 +11,7 @@ import (
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // 错误定义
@@ -19,6 +20,7 @@ var (
 	ErrOrderAlreadyPaid   = errors.New("order already paid")
 	ErrOrderCancelled     = errors.New("order has been cancelled")
 	ErrInvalidOrderStatus = errors.New("invalid order status for this operation")
+	ErrInvalidOrderAmount = errors.New("order amount must be positive with at most 2 decimal places")
 )
 
 // OrderFilter 订单查询过滤条件
@@ -28,8 +30,8 @@ type OrderFilter struct {
 	OrderType *string
 	StartTime *time.Time
 	EndTime   *time.Time
-	MinAmount *float64
-	MaxAmount *float64
+	MinAmount *money.Amount
+	MaxAmount *money.Amount
 	Page      int
 	Limit     int
 }
@@ -37,14 +39,17 @@ type OrderFilter struct {
 // CreateOrderRequest 创建订单请求
 type CreateOrderRequest struct {
 	UserID      uint
-	Amount      float64
-	OrderType   string // "payment" or "manual"
-	PaymentUUID string // 仅 payment 类型需要
+	Amount      money.Amount // Whole cents
+	OrderType   string       // "payment" or "manual"
+	PaymentUUID string       // 仅 payment 类型需要
 	Remark      string
 }
 
 // CreateOrder 创建订单（通用方法）
 func CreateOrder(req CreateOrderRequest) (*models.PaymentOrderRecord, error) {
+	if err := validateOrderAmount(req.Amount); err != nil {
+		return nil, err
+	}
 	order := &models.PaymentOrderRecord{
 		ID:          strings.ReplaceAll(uuid.New().String(), "-", ""),
 		UserID:      req.UserID,
@@ -64,7 +69,7 @@ func CreateOrder(req CreateOrderRequest) (*models.PaymentOrderRecord, error) {
 }
 
 // CreateManualOrder 管理员创建手动订单
-func CreateManualOrder(userID uint, amount float64, remark string) (*models.PaymentOrderRecord, error) {
+func CreateManualOrder(userID uint, amount money.Amount, remark string) (*models.PaymentOrderRecord, error) {
 	return CreateOrder(CreateOrderRequest{
 		UserID:    userID,
 		Amount:    amount,
@@ -78,7 +83,7 @@ func CompleteOrder(orderID string, operatorID uint, operatorName string) error {
 	return database.DB.Transaction(func(tx *gorm.DB) error {
 		// 1. 加锁查询订单
 		var order models.PaymentOrderRecord
-		if err := tx.Set("gorm:query_option", "FOR UPDATE").First(&order, "id = ?", orderID).Error; err != nil {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", orderID).Error; err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
 				return ErrOrderNotFound
 			}
@@ -93,28 +98,39 @@ func CompleteOrder(orderID string, operatorID uint, operatorName string) error {
 			return ErrOrderCancelled
 		}
 
-		// 3. 更新订单状态
+		// 3. 更新订单状态（仅当状态未被并发修改时）
 		now := time.Now()
-		order.Status = models.OrderStatusPaid
-		order.CompletedAt = &now
-		order.CompletedBy = operatorID
-		order.UpdatedAt = now
-		if err := tx.Save(&order).Error; err != nil {
-			return err
+		result := tx.Model(&order).Where("status = ?", order.Status).Updates(map[string]interface{}{
+			"status":       models.OrderStatusPaid,
+			"completed_at": now,
+			"completed_by": operatorID,
+			"updated_at":   now,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrOrderAlreadyPaid
 		}
 
 		// 4. 加锁查询用户
 		var user models.User
-		if err := tx.Set("gorm:query_option", "FOR UPDATE").First(&user, order.UserID).Error; err != nil {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, order.UserID).Error; err != nil {
 			return err
 		}
 
-		// 5. 更新用户余额
+		// 5. 更新用户余额，只写余额和版本号，并校验版本（乐观锁）
 		balanceBefore := user.Balance
-		user.Balance += order.Amount
-		user.Version++
-		if err := tx.Save(&user).Error; err != nil {
-			return err
+		balanceAfter := user.Balance + order.Amount
+		result = tx.Model(&user).Where("version = ?", user.Version).Updates(map[string]interface{}{
+			"balance": balanceAfter,
+			"version": gorm.Expr("version + 1"),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrOptimisticLock
 		}
 
 		// 6. 创建交易记录
@@ -132,30 +148,28 @@ func CompleteOrder(orderID string, operatorID uint, operatorName string) error {
 			UserID:        user.ID,
 			Amount:        order.Amount,
 			BalanceBefore: balanceBefore,
-			BalanceAfter:  user.Balance,
+			BalanceAfter:  balanceAfter,
 			Reason:        reason,
 			Operator:      operatorName,
 			OperatorID:    operatorID,
 			Type:          transactionType,
 			CreatedAt:     time.Now(),
+			OrderID:       order.ID,
 		}
 
-		// 生成 Hash
-		cfg, _ := config.LoadConfig()
-		secret := "default-secret"
-		if cfg != nil && cfg.JWTSecret != "" {
-			secret = cfg.JWTSecret
-		}
-		transaction.Hash = transaction.GenerateHash(secret)
-
-		if err := tx.Create(&transaction).Error; err != nil {
-			return err
-		}
-
-		return nil
+		// 生成 Hash 并写入用户的交易链
+		return recordTransactionTx(tx, &transaction)
 	})
 }
 
+// validateOrderAmount 校验订单金额：必须为正数且精确到分
+func validateOrderAmount(amount money.Amount) error {
+	if amount <= 0 || !amount.IsRounded(2) {
+		return ErrInvalidOrderAmount
+	}
+	return nil
+}
+
 // CancelOrder 取消订单
 func CancelOrder(orderID string) error {
 	var order models.PaymentOrderRecord
@@ -5,6 +5,7 @@ import (
 	"aigentools-backend/internal/models"
 	"aigentools-backend/internal/payment"
 	"aigentools-backend/internal/payment/epay"
+	"aigentools-backend/pkg/money"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -85,7 +86,10 @@ func DeletePaymentConfig(id uint) error {
 	return database.DB.Delete(&models.PaymentConfig{}, id).Error
 }
 
-func CreatePaymentOrder(userID uint, amount float64, paymentUUID string) (*models.PaymentOrderRecord, error) {
+func CreatePaymentOrder(userID uint, amount money.Amount, paymentUUID string) (*models.PaymentOrderRecord, error) {
+	if err := validateOrderAmount(amount); err != nil {
+		return nil, err
+	}
 	order := &models.PaymentOrderRecord{
 		ID:          strings.ReplaceAll(uuid.New().String(), "-", ""),
 		UserID:      userID,
@@ -0,0 +1,567 @@
+package services
+
+import (
+	"aigentools-backend/config"
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// MaxPipelineSteps is the largest number of steps accepted in one pipeline
+const MaxPipelineSteps = 20
+
+var ErrInvalidPipeline = errors.New("invalid pipeline")
+
+var (
+	pipelineStepIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	// pipelineRefPattern matches {{steps.<id>.<field>}} placeholders in step inputs
+	pipelineRefPattern = regexp.MustCompile(`\{\{\s*steps\.([A-Za-z0-9_-]+)\.([a-z_]+)\s*\}\}`)
+)
+
+// pipelineOutputFields are the step outputs a placeholder may reference
+var pipelineOutputFields = map[string]bool{"result_url": true, "task_id": true}
+
+// PipelineStepStatus is a step of a pipeline together with the task running it
+type PipelineStepStatus struct {
+	ID        string            `json:"id"`
+	ModelID   uint              `json:"model_id"`
+	DependsOn []string          `json:"depends_on,omitempty"`
+	TaskID    uint              `json:"task_id"`
+	Status    models.TaskStatus `json:"status"`
+	ResultURL string            `json:"result_url,omitempty"`
+	ErrorLog  string            `json:"error_log,omitempty"`
+}
+
+// PipelineArtifact is the output of a completed step
+type PipelineArtifact struct {
+	Step      string `json:"step"`
+	TaskID    uint   `json:"task_id"`
+	ResultURL string `json:"result_url"`
+}
+
+// PipelineDetail is a pipeline with the state of its steps and their outputs
+type PipelineDetail struct {
+	models.Pipeline
+	Steps     []PipelineStepStatus `json:"steps"`
+	Artifacts []PipelineArtifact   `json:"artifacts"`
+}
+
+func init() {
+	// Move a pipeline forward whenever one of its steps finishes
+	RegisterTaskTransitionHook(func(task *models.Task, from, to models.TaskStatus) {
+		if task.PipelineID != nil && isTaskFinished(to) {
+			advancePipeline(*task.PipelineID)
+		}
+	})
+}
+
+// validatePipeline checks the steps of a pipeline and returns them in dependency
+// order, each with its full list of dependencies
+func validatePipeline(steps []models.PipelineStep) ([]models.PipelineStep, error) {
+	if len(steps) == 0 || len(steps) > MaxPipelineSteps {
+		return nil, fmt.Errorf("%w: a pipeline must contain between 1 and %d steps", ErrInvalidPipeline, MaxPipelineSteps)
+	}
+
+	byID := make(map[string]models.PipelineStep, len(steps))
+	for _, step := range steps {
+		if !pipelineStepIDPattern.MatchString(step.ID) {
+			return nil, fmt.Errorf("%w: invalid step id %q", ErrInvalidPipeline, step.ID)
+		}
+		if _, ok := byID[step.ID]; ok {
+			return nil, fmt.Errorf("%w: duplicate step id %q", ErrInvalidPipeline, step.ID)
+		}
+		if step.ModelID == 0 {
+			return nil, fmt.Errorf("%w: step %q has no model_id", ErrInvalidPipeline, step.ID)
+		}
+		byID[step.ID] = step
+	}
+
+	// Collect explicit and referenced dependencies
+	resolved := make([]models.PipelineStep, len(steps))
+	for i, step := range steps {
+		deps := map[string]bool{}
+		for _, dep := range step.DependsOn {
+			deps[dep] = true
+		}
+		for _, ref := range pipelineRefs(step.Input) {
+			if !pipelineOutputFields[ref[1]] {
+				return nil, fmt.Errorf("%w: step %q references unknown output %q", ErrInvalidPipeline, step.ID, ref[1])
+			}
+			deps[ref[0]] = true
+		}
+
+		step.DependsOn = step.DependsOn[:0:0]
+		for dep := range deps {
+			if _, ok := byID[dep]; !ok {
+				return nil, fmt.Errorf("%w: step %q depends on unknown step %q", ErrInvalidPipeline, step.ID, dep)
+			}
+			step.DependsOn = append(step.DependsOn, dep)
+		}
+		sort.Strings(step.DependsOn)
+		resolved[i] = step
+	}
+
+	// Order topologically; anything left over is part of a cycle
+	done := make(map[string]bool, len(resolved))
+	ordered := make([]models.PipelineStep, 0, len(resolved))
+	for len(ordered) < len(resolved) {
+		progressed := false
+		for _, step := range resolved {
+			if done[step.ID] {
+				continue
+			}
+			ready := true
+			for _, dep := range step.DependsOn {
+				if !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				done[step.ID] = true
+				ordered = append(ordered, step)
+				progressed = true
+			}
+		}
+		if !progressed {
+			return nil, fmt.Errorf("%w: steps contain a dependency cycle", ErrInvalidPipeline)
+		}
+	}
+	return ordered, nil
+}
+
+// pipelineRefs returns the [step, field] pairs referenced anywhere in an input
+func pipelineRefs(value interface{}) [][2]string {
+	var refs [][2]string
+	switch v := value.(type) {
+	case string:
+		for _, m := range pipelineRefPattern.FindAllStringSubmatch(v, -1) {
+			refs = append(refs, [2]string{m[1], m[2]})
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			refs = append(refs, pipelineRefs(item)...)
+		}
+	case []interface{}:
+		for _, item := range v {
+			refs = append(refs, pipelineRefs(item)...)
+		}
+	}
+	return refs
+}
+
+// renderPipelineInput replaces the placeholders of an input with the outputs of completed steps.
+// A string made of a single placeholder takes the output value as is.
+func renderPipelineInput(value interface{}, outputs map[string]map[string]interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if m := pipelineRefPattern.FindStringSubmatch(v); m != nil && m[0] == strings.TrimSpace(v) {
+			return outputs[m[1]][m[2]]
+		}
+		return pipelineRefPattern.ReplaceAllStringFunc(v, func(ref string) string {
+			m := pipelineRefPattern.FindStringSubmatch(ref)
+			return fmt.Sprint(outputs[m[1]][m[2]])
+		})
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			rendered[key] = renderPipelineInput(item, outputs)
+		}
+		return rendered
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			rendered[i] = renderPipelineInput(item, outputs)
+		}
+		return rendered
+	}
+	return value
+}
+
+// CreatePipeline validates a pipeline and creates one task per step in a single
+// transaction, holding the cost of every step. Steps without dependencies start right
+// away; the others stay Blocked until their upstream steps complete.
+func CreatePipeline(name string, steps []models.PipelineStep, creatorID uint, creatorName string) (*PipelineDetail, error) {
+	cfg, _ := config.LoadConfig()
+
+	ordered, err := validatePipeline(steps)
+	if err != nil {
+		return nil, err
+	}
+
+	quotes := make([]*TaskQuote, len(ordered))
+	var total money.Amount
+	stepIndex := make(map[string]int, len(steps))
+	for i, step := range steps {
+		stepIndex[step.ID] = i
+	}
+	inputErr := &TaskInputError{}
+	// References to the outputs of other steps are only known once those complete
+	isRef := func(v interface{}) bool { return len(pipelineRefs(v)) > 0 }
+	for i, step := range ordered {
+		model, err := GetAIModelByID(step.ModelID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: step %q: invalid model_id: %v", ErrInvalidPipeline, step.ID, err)
+		}
+		if step.Input == nil {
+			step.Input = map[string]interface{}{}
+			ordered[i].Input = step.Input
+		}
+		var stepErr *TaskInputError
+		if err := validateTaskInput(model, step.Input, fmt.Sprintf("steps[%d].input.", stepIndex[step.ID]), isRef); errors.As(err, &stepErr) {
+			inputErr.Errors = append(inputErr.Errors, stepErr.Errors...)
+		}
+		if stepErr != nil {
+			continue
+		}
+		// The price cannot wait for the outputs of other steps
+		if param := unresolvedPricingParam(model, step.Input, isRef); param != "" {
+			return nil, fmt.Errorf("%w: step %q: pricing reads '%s', which comes from another step", ErrInvalidPipeline, step.ID, param)
+		}
+		quote, err := quoteTask(model, step.Input)
+		if err != nil {
+			return nil, fmt.Errorf("%w: step %q: %v", ErrInvalidPipeline, step.ID, err)
+		}
+		quotes[i] = quote
+		total += quote.Price
+	}
+	if len(inputErr.Errors) > 0 {
+		return nil, inputErr
+	}
+
+	stepsJSON, err := json.Marshal(ordered)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	pipeline := models.Pipeline{
+		CreatorID:   creatorID,
+		CreatorName: creatorName,
+		Name:        name,
+		Steps:       datatypes.JSON(stepsJSON),
+		Status:      models.PipelineStatusRunning,
+		TotalCost:   total,
+	}
+	if err := tx.Create(&pipeline).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tasks := make([]models.Task, 0, len(ordered))
+	for i, step := range ordered {
+		input := make(map[string]interface{}, len(step.Input)+1)
+		for k, v := range step.Input {
+			input[k] = v
+		}
+		input["model_id"] = step.ModelID
+
+		task, err := newTask(tx, cfg, input, creatorID, creatorName, quotes[i], nil)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		task.PipelineID = &pipeline.ID
+		task.PipelineStep = step.ID
+		if len(step.DependsOn) > 0 {
+			task.Status = models.TaskStatusBlocked
+		}
+		if err := insertTaskTx(tx, task); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if err := holdTaskCostTx(tx, task, fmt.Sprintf("Create step %q of pipeline %d", step.ID, pipeline.ID)); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		tasks = append(tasks, *task)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	// Invalidate user cache to ensure balance is updated
+	if database.RedisClient != nil {
+		database.RedisClient.Del(database.Ctx, fmt.Sprintf("user:%d", creatorID))
+	}
+
+	// Tasks that fail to queue are queued by the scheduler later, see requeueStrandedTasks
+	for i := range tasks {
+		if tasks[i].Status != models.TaskStatusPendingExecution {
+			continue
+		}
+		if err := Queue.Enqueue(&tasks[i]); err != nil {
+			fmt.Printf("Failed to queue task %d of pipeline %d: %v\n", tasks[i].ID, pipeline.ID, err)
+		}
+	}
+	return newPipelineDetail(pipeline, tasks), nil
+}
+
+// advancePipeline starts the Blocked steps whose dependencies all completed, skips
+// (cancels and refunds) those with a failed or cancelled dependency, and records the
+// pipeline's final status once every step has finished. It is safe to call any number
+// of times from any instance: every change is a conditional transition.
+func advancePipeline(pipelineID uint) {
+	var pipeline models.Pipeline
+	if err := database.DB.First(&pipeline, pipelineID).Error; err != nil {
+		fmt.Printf("Pipeline %d not found: %v\n", pipelineID, err)
+		return
+	}
+	if pipeline.Status != models.PipelineStatusRunning {
+		return
+	}
+
+	var steps []models.PipelineStep
+	if err := json.Unmarshal(pipeline.Steps, &steps); err != nil {
+		fmt.Printf("Pipeline %d has invalid steps: %v\n", pipelineID, err)
+		return
+	}
+
+	cfg, _ := config.LoadConfig()
+
+	for {
+		var tasks []models.Task
+		if err := database.DB.Where("pipeline_id = ?", pipelineID).Find(&tasks).Error; err != nil {
+			fmt.Printf("Failed to load tasks of pipeline %d: %v\n", pipelineID, err)
+			return
+		}
+		byStep := make(map[string]*models.Task, len(tasks))
+		for i := range tasks {
+			byStep[tasks[i].PipelineStep] = &tasks[i]
+		}
+
+		changed := false
+		for _, step := range steps {
+			task := byStep[step.ID]
+			if task == nil || task.Status != models.TaskStatusBlocked {
+				continue
+			}
+
+			ready := true
+			var failedDep string
+			for _, dep := range step.DependsOn {
+				upstream := byStep[dep]
+				if upstream == nil {
+					continue
+				}
+				switch upstream.Status {
+				case models.TaskStatusCompleted:
+				case models.TaskStatusFailed, models.TaskStatusCancelled:
+					failedDep = dep
+				default:
+					ready = false
+				}
+			}
+
+			if failedDep != "" {
+				err := transitionWithRefund(task, models.TaskStatusCancelled, ActorSystem, fmt.Sprintf("skipped: upstream step %s did not complete", failedDep),
+					fmt.Sprintf("Refund for task %d skipped in pipeline %d", task.ID, pipelineID))
+				if err != nil {
+					if !isTransitionRejected(err) {
+						fmt.Printf("Failed to skip task %d of pipeline %d: %v\n", task.ID, pipelineID, err)
+					}
+					continue
+				}
+				changed = true
+				continue
+			}
+			if ready {
+				if err := startPipelineStep(cfg, task, step, byStep); err != nil {
+					if !isTransitionRejected(err) {
+						fmt.Printf("Failed to start task %d of pipeline %d: %v\n", task.ID, pipelineID, err)
+					}
+					continue
+				}
+				changed = true
+			}
+		}
+
+		if !changed {
+			finishPipeline(&pipeline, tasks)
+			return
+		}
+	}
+}
+
+// startPipelineStep fills in the outputs of the upstream steps and releases a Blocked step.
+// The rendered input is checked like the input of CreateTask; a step it fails is cancelled
+// and refunded with the reason in its error log, which fails the pipeline.
+func startPipelineStep(cfg *config.Config, task *models.Task, step models.PipelineStep, byStep map[string]*models.Task) error {
+	outputs := make(map[string]map[string]interface{}, len(step.DependsOn))
+	for _, dep := range step.DependsOn {
+		if upstream := byStep[dep]; upstream != nil {
+			outputs[dep] = map[string]interface{}{
+				"result_url": upstream.ResultURL,
+				"task_id":    upstream.ID,
+			}
+		}
+	}
+
+	var input map[string]interface{}
+	if err := json.Unmarshal(task.InputData, &input); err != nil {
+		return err
+	}
+	input, _ = renderPipelineInput(input, outputs).(map[string]interface{})
+	if err := checkPipelineStepInput(task, step, input); err != nil {
+		task.ErrorLog = err.Error()
+		return transitionWithRefund(task, models.TaskStatusCancelled, ActorSystem, "invalid input after upstream steps completed",
+			fmt.Sprintf("Refund for task %d with invalid input in pipeline %d", task.ID, *task.PipelineID))
+	}
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	task.InputData = datatypes.JSON(inputJSON)
+
+	to := models.TaskStatusPendingAudit
+	if cfg != nil && cfg.AutoAudit {
+		to = models.TaskStatusPendingExecution
+	}
+	if err := TransitionTask(task, to, ActorSystem, "upstream steps completed", "input_data"); err != nil {
+		return err
+	}
+	if to == models.TaskStatusPendingExecution {
+		return Queue.Enqueue(task)
+	}
+	return nil
+}
+
+// checkPipelineStepInput validates the rendered input of a step against its model, filling
+// in defaults, and prices it again: it has to cost what was held when the pipeline was created
+func checkPipelineStepInput(task *models.Task, step models.PipelineStep, input map[string]interface{}) error {
+	model, err := GetAIModelByID(task.ModelID)
+	if err != nil {
+		return fmt.Errorf("invalid model_id: %v", err)
+	}
+	if err := validateTaskInput(model, input, fmt.Sprintf("steps.%s.input.", step.ID), nil); err != nil {
+		return err
+	}
+	quote, err := quoteTask(model, input)
+	if err != nil {
+		return err
+	}
+	return checkQuotedPrice(quote, &task.Cost)
+}
+
+// finishPipeline records the final status of a pipeline once all of its steps have finished
+func finishPipeline(pipeline *models.Pipeline, tasks []models.Task) {
+	status := models.PipelineStatusCompleted
+	for _, task := range tasks {
+		if !isTaskFinished(task.Status) {
+			return
+		}
+		if task.Status != models.TaskStatusCompleted {
+			status = models.PipelineStatusFailed
+		}
+	}
+
+	now := time.Now()
+	err := database.DB.Model(&models.Pipeline{}).
+		Where("id = ? AND status = ?", pipeline.ID, models.PipelineStatusRunning).
+		Updates(map[string]interface{}{"status": status, "finished_at": now}).Error
+	if err != nil {
+		fmt.Printf("Failed to finish pipeline %d: %v\n", pipeline.ID, err)
+		return
+	}
+	pipeline.Status = status
+	pipeline.FinishedAt = &now
+}
+
+// advanceRunningPipelines re-checks every running pipeline, in case an instance
+// stopped between finishing a step and starting the next one
+func advanceRunningPipelines() {
+	var ids []uint
+	if err := database.DB.Model(&models.Pipeline{}).
+		Where("status = ?", models.PipelineStatusRunning).
+		Limit(scheduleBatchSize).
+		Pluck("id", &ids).Error; err != nil {
+		fmt.Printf("Failed to load running pipelines: %v\n", err)
+		return
+	}
+	for _, id := range ids {
+		advancePipeline(id)
+	}
+}
+
+// GetPipeline returns a pipeline with the state of its steps
+func GetPipeline(id uint) (*PipelineDetail, error) {
+	var pipeline models.Pipeline
+	if err := database.DB.First(&pipeline, id).Error; err != nil {
+		return nil, err
+	}
+
+	var tasks []models.Task
+	if err := database.DB.Where("pipeline_id = ?", id).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	return newPipelineDetail(pipeline, tasks), nil
+}
+
+// GetPipelines lists pipelines, newest first. A creatorID of 0 lists every user's pipelines.
+func GetPipelines(page, pageSize int, creatorID uint) ([]models.Pipeline, int64, error) {
+	var pipelines []models.Pipeline
+	var total int64
+
+	db := database.DB.Model(&models.Pipeline{})
+	if creatorID != 0 {
+		db = db.Where("creator_id = ?", creatorID)
+	}
+
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * pageSize
+	if err := db.Offset(offset).Limit(pageSize).Order("created_at desc").Find(&pipelines).Error; err != nil {
+		return nil, 0, err
+	}
+	return pipelines, total, nil
+}
+
+func newPipelineDetail(pipeline models.Pipeline, tasks []models.Task) *PipelineDetail {
+	var steps []models.PipelineStep
+	json.Unmarshal(pipeline.Steps, &steps)
+
+	byStep := make(map[string]models.Task, len(tasks))
+	for _, task := range tasks {
+		byStep[task.PipelineStep] = task
+	}
+
+	detail := &PipelineDetail{
+		Pipeline:  pipeline,
+		Steps:     make([]PipelineStepStatus, 0, len(steps)),
+		Artifacts: []PipelineArtifact{},
+	}
+	for _, step := range steps {
+		task := byStep[step.ID]
+		detail.Steps = append(detail.Steps, PipelineStepStatus{
+			ID:        step.ID,
+			ModelID:   step.ModelID,
+			DependsOn: step.DependsOn,
+			TaskID:    task.ID,
+			Status:    task.Status,
+			ResultURL: task.ResultURL,
+			ErrorLog:  task.ErrorLog,
+		})
+		if task.Status == models.TaskStatusCompleted && task.ResultURL != "" {
+			detail.Artifacts = append(detail.Artifacts, PipelineArtifact{Step: step.ID, TaskID: task.ID, ResultURL: task.ResultURL})
+		}
+	}
+	return detail
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePipeline(t *testing.T) {
+	steps := []models.PipelineStep{
+		{ID: "up", ModelID: 3, Input: map[string]interface{}{"video": "{{steps.video.result_url}}"}},
+		{ID: "video", ModelID: 2, Input: map[string]interface{}{"image": "{{ steps.img.result_url }}"}},
+		{ID: "img", ModelID: 1, Input: map[string]interface{}{"prompt": "a cat"}},
+	}
+	ordered, err := validatePipeline(steps)
+	assert.NoError(t, err)
+	assert.Equal(t, "img", ordered[0].ID)
+	assert.Equal(t, "video", ordered[1].ID)
+	assert.Equal(t, []string{"img"}, ordered[1].DependsOn)
+	assert.Equal(t, "up", ordered[2].ID)
+
+	cases := map[string][]models.PipelineStep{
+		"cycle": {
+			{ID: "a", ModelID: 1, DependsOn: []string{"b"}},
+			{ID: "b", ModelID: 1, Input: map[string]interface{}{"x": "{{steps.a.result_url}}"}},
+		},
+		"unknown step":   {{ID: "a", ModelID: 1, DependsOn: []string{"missing"}}},
+		"unknown output": {{ID: "a", ModelID: 1}, {ID: "b", ModelID: 1, Input: map[string]interface{}{"x": "{{steps.a.secret}}"}}},
+		"duplicate":      {{ID: "a", ModelID: 1}, {ID: "a", ModelID: 1}},
+		"no model":       {{ID: "a"}},
+	}
+	for name, steps := range cases {
+		_, err := validatePipeline(steps)
+		assert.ErrorIs(t, err, ErrInvalidPipeline, name)
+	}
+}
+
+func TestRenderPipelineInput(t *testing.T) {
+	outputs := map[string]map[string]interface{}{
+		"img": {"result_url": "https://oss/img.png", "task_id": uint(7)},
+	}
+	input := map[string]interface{}{
+		"image":   "{{steps.img.result_url}}",
+		"source":  "task {{steps.img.task_id}}",
+		"list":    []interface{}{"{{steps.img.task_id}}"},
+		"options": map[string]interface{}{"fps": float64(24)},
+	}
+	rendered := renderPipelineInput(input, outputs).(map[string]interface{})
+	assert.Equal(t, "https://oss/img.png", rendered["image"])
+	assert.Equal(t, "task 7", rendered["source"])
+	assert.Equal(t, []interface{}{uint(7)}, rendered["list"])
+	assert.Equal(t, map[string]interface{}{"fps": float64(24)}, rendered["options"])
+}
+
+func TestPipeline_RunAndSkipWithRefund(t *testing.T) {
+	setupPaymentTestDB()
+	mr := setupPaymentTestRedis()
+	defer mr.Close()
+	t.Setenv("AUTO_AUDIT", "true")
+
+	img := models.AIModel{Name: "Image", Price: money.MustParse("5"), Status: models.AIModelStatusOpen}
+	video := models.AIModel{Name: "Video", Price: money.MustParse("10"), Status: models.AIModelStatusOpen}
+	upscale := models.AIModel{Name: "Upscale", Price: money.MustParse("3"), Status: models.AIModelStatusOpen}
+	database.DB.Create(&img)
+	database.DB.Create(&video)
+	database.DB.Create(&upscale)
+	user := models.User{Username: "director", Balance: money.MustParse("100"), Version: 1, IsActive: true}
+	database.DB.Create(&user)
+
+	detail, err := CreatePipeline("img2video", []models.PipelineStep{
+		{ID: "img", ModelID: img.ID, Input: map[string]interface{}{"prompt": "a cat"}},
+		{ID: "video", ModelID: video.ID, Input: map[string]interface{}{"image": "{{steps.img.result_url}}"}},
+		{ID: "up", ModelID: upscale.ID, Input: map[string]interface{}{"video": "{{steps.video.result_url}}"}},
+	}, user.ID, user.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, money.MustParse("18"), detail.TotalCost)
+	assert.Equal(t, models.TaskStatusPendingExecution, detail.Steps[0].Status)
+	assert.Equal(t, models.TaskStatusBlocked, detail.Steps[1].Status)
+	assert.Equal(t, models.TaskStatusBlocked, detail.Steps[2].Status)
+
+	var stored models.User
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("100"), stored.Balance)
+	assert.Equal(t, money.MustParse("18"), stored.HeldAmount)
+
+	// Only the first step is queued
+	id, err := Queue.Dequeue()
+	assert.NoError(t, err)
+	assert.Equal(t, detail.Steps[0].TaskID, id)
+	_, err = Queue.Dequeue()
+	assert.ErrorIs(t, err, ErrQueueEmpty)
+
+	// The image completes: the video step gets its URL and is queued
+	var task models.Task
+	database.DB.First(&task, detail.Steps[0].TaskID)
+	assert.NoError(t, TransitionTask(&task, models.TaskStatusProcessing, ActorSystem, "picked up by worker"))
+	completeTask(&task, map[string]interface{}{"result_url": "https://oss/img.png"})
+
+	var videoTask models.Task
+	database.DB.First(&videoTask, detail.Steps[1].TaskID)
+	assert.Equal(t, models.TaskStatusPendingExecution, videoTask.Status)
+	var input map[string]interface{}
+	json.Unmarshal(videoTask.InputData, &input)
+	assert.Equal(t, "https://oss/img.png", input["image"])
+	id, err = Queue.Dequeue()
+	assert.NoError(t, err)
+	assert.Equal(t, videoTask.ID, id)
+
+	// The video fails: the upscale step is skipped and refunded
+	assert.NoError(t, TransitionTask(&videoTask, models.TaskStatusProcessing, ActorSystem, "picked up by worker"))
+	failTask(&videoTask, Terminal(errors.New("content policy")))
+
+	detail, err = GetPipeline(detail.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.PipelineStatusFailed, detail.Status)
+	assert.NotNil(t, detail.FinishedAt)
+	assert.Equal(t, models.TaskStatusCompleted, detail.Steps[0].Status)
+	assert.Equal(t, models.TaskStatusFailed, detail.Steps[1].Status)
+	assert.Equal(t, models.TaskStatusCancelled, detail.Steps[2].Status)
+	assert.Equal(t, []PipelineArtifact{{Step: "img", TaskID: detail.Steps[0].TaskID, ResultURL: "https://oss/img.png"}}, detail.Artifacts)
+
+	// Only the image is charged; the holds of the failed video and skipped upscale are released
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("95"), stored.Balance)
+	assert.Equal(t, money.MustParse("0"), stored.HeldAmount)
+}
+
+func TestPipeline_InvalidRenderedInput(t *testing.T) {
+	setupPaymentTestDB()
+	mr := setupPaymentTestRedis()
+	defer mr.Close()
+	t.Setenv("AUTO_AUDIT", "true")
+
+	img := models.AIModel{Name: "Image", Price: money.MustParse("5"), Status: models.AIModelStatusOpen}
+	database.DB.Create(&img)
+	caption := inputTestModel(t)
+	database.DB.Create(caption)
+	user := models.User{Username: "captioner", Balance: money.MustParse("100"), Version: 1, IsActive: true}
+	database.DB.Create(&user)
+
+	detail, err := CreatePipeline("caption", []models.PipelineStep{
+		{ID: "img", ModelID: img.ID, Input: map[string]interface{}{"prompt": "a cat"}},
+		{ID: "caption", ModelID: caption.ID, Input: map[string]interface{}{"prompt": "{{steps.img.result_url}}"}},
+	}, user.ID, user.Username)
+	require.NoError(t, err)
+
+	// The URL is longer than the prompt may be: the step is not started
+	var task models.Task
+	database.DB.First(&task, detail.Steps[0].TaskID)
+	require.NoError(t, TransitionTask(&task, models.TaskStatusProcessing, ActorSystem, "picked up by worker"))
+	completeTask(&task, map[string]interface{}{"result_url": "https://oss/img.png"})
+
+	var captionTask models.Task
+	database.DB.First(&captionTask, detail.Steps[1].TaskID)
+	assert.Equal(t, models.TaskStatusCancelled, captionTask.Status)
+	assert.Contains(t, captionTask.ErrorLog, "steps.caption.input.prompt")
+	assert.NotNil(t, captionTask.RefundedAt)
+
+	detail, err = GetPipeline(detail.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.PipelineStatusFailed, detail.Status)
+
+	var stored models.User
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("95"), stored.Balance)
+	assert.Equal(t, money.MustParse("0"), stored.HeldAmount)
+}
@@ -3,94 +3,92 @@ package services
 import (
 	"aigentools-backend/internal/database"
 	"aigentools-backend/internal/models"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"sync"
+	"os"
 	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	pollInterval     = 30 * time.Second // Time between two polls of the same task
+	pollScanInterval = 5 * time.Second  // How often the database is checked for due tasks
+	pollBatchSize    = 100
+	pollLeaseTTL     = 2 * time.Minute
+	pollMaxErrors    = 5
 )
 
-// PollingManager handles background polling of tasks
+// pollLeaseKeyPrefix prefixes the Redis key that marks the instance currently polling a task
+const pollLeaseKeyPrefix = "task_poll_lease:"
+
+// extendLeaseScript refreshes a lease only if it is still held by the caller.
+var extendLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLeaseScript deletes a lease only if it is still held by the caller.
+var releaseLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// PollingManager handles background polling of submitted tasks.
+// It keeps no state of its own: a task is polled while it is Processing with a
+// RemoteTaskID, and NextPollAt in the database says when it is due. Every
+// instance scans for due tasks, and a per-task Redis lease guarantees only one
+// of them polls (and finalizes) a task at a time. A lease expires with its
+// owner, so another instance takes the task over on its next scan.
 type PollingManager struct {
-	mu         sync.RWMutex
-	tasks      map[uint]*PollingTask
-	processing sync.Map
-	addChan    chan uint
-	removeChan chan uint
+	instanceID string
 	stopChan   chan struct{}
 }
 
-type PollingTask struct {
-	ID           uint
-	RemoteTaskID string
-	RetryCount   int
-	LastPoll     time.Time
-	Executor     string // "jiekou_api" etc.
-}
-
 var PollingMgr *PollingManager
 
 func init() {
-	PollingMgr = &PollingManager{
-		tasks:      make(map[uint]*PollingTask),
-		addChan:    make(chan uint, 100),
-		removeChan: make(chan uint, 100),
+	PollingMgr = NewPollingManager()
+}
+
+// NewPollingManager creates a polling manager with a unique instance ID
+func NewPollingManager() *PollingManager {
+	hostname, _ := os.Hostname()
+	return &PollingManager{
+		instanceID: fmt.Sprintf("%s-%s", hostname, uuid.New().String()),
 		stopChan:   make(chan struct{}),
 	}
 }
 
-// Add adds a task ID to the polling manager
-func (pm *PollingManager) Add(taskID uint) {
-	pm.addChan <- taskID
+func pollLeaseKey(taskID uint) string {
+	return fmt.Sprintf("%s%d", pollLeaseKeyPrefix, taskID)
 }
 
-// Remove removes a task ID from the polling manager
-func (pm *PollingManager) Remove(taskID uint) {
-	pm.removeChan <- taskID
+// Add schedules an immediate poll of a submitted task
+func (pm *PollingManager) Add(taskID uint) {
+	now := time.Now()
+	if err := database.DB.Model(&models.Task{}).
+		Where("id = ? AND status = ?", taskID, models.TaskStatusProcessing).
+		Update("next_poll_at", now).Error; err != nil {
+		fmt.Printf("PollingManager: Failed to schedule task %d: %v\n", taskID, err)
+	}
 }
 
 // Start starts the polling loop
 func (pm *PollingManager) Start() {
-	fmt.Println("PollingManager started...")
-	ticker := time.NewTicker(30 * time.Second) // Poll every 30s as requested
+	fmt.Printf("PollingManager started (instance %s)...\n", pm.instanceID)
+	ticker := time.NewTicker(pollScanInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case id := <-pm.addChan:
-			pm.mu.Lock()
-			// Check if already exists
-			if _, exists := pm.tasks[id]; !exists {
-				// Load task details
-				var task models.Task
-				if err := database.DB.First(&task, id).Error; err == nil {
-					// Identify executor
-					executorName := "jiekou_api" // Default or detect
-					var input map[string]interface{}
-					json.Unmarshal(task.InputData, &input)
-					if name, ok := input["executor"].(string); ok && name != "" {
-						executorName = name
-					}
-
-					pm.tasks[id] = &PollingTask{
-						ID:           task.ID,
-						RemoteTaskID: task.RemoteTaskID,
-						RetryCount:   0,
-						LastPoll:     time.Now(),
-						Executor:     executorName,
-					}
-					fmt.Printf("PollingManager: Added task %d\n", id)
-				}
-			}
-			pm.mu.Unlock()
-
-		case id := <-pm.removeChan:
-			pm.mu.Lock()
-			delete(pm.tasks, id)
-			pm.mu.Unlock()
-			fmt.Printf("PollingManager: Removed task %d\n", id)
-
 		case <-ticker.C:
-			pm.pollAll()
+			pm.pollDue()
 
 		case <-pm.stopChan:
 			return
@@ -98,96 +96,155 @@ func (pm *PollingManager) Start() {
 	}
 }
 
-func (pm *PollingManager) pollAll() {
-	pm.mu.RLock()
-	tasks := make([]*PollingTask, 0, len(pm.tasks))
-	for _, t := range pm.tasks {
-		tasks = append(tasks, t)
+// Stop stops the polling loop
+func (pm *PollingManager) Stop() {
+	close(pm.stopChan)
+}
+
+// dueTasks returns the IDs of submitted tasks whose next poll is due
+func dueTasks(now time.Time) ([]uint, error) {
+	var ids []uint
+	err := database.DB.Model(&models.Task{}).
+		Where("status = ? AND remote_task_id <> ''", models.TaskStatusProcessing).
+		Where("next_poll_at IS NULL OR next_poll_at <= ?", now).
+		Order("next_poll_at").
+		Limit(pollBatchSize).
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+func (pm *PollingManager) pollDue() {
+	ids, err := dueTasks(time.Now())
+	if err != nil {
+		fmt.Printf("PollingManager: Failed to load due tasks: %v\n", err)
+		return
 	}
-	pm.mu.RUnlock()
 
-	for _, pt := range tasks {
-		go pm.pollTask(pt)
+	for _, id := range ids {
+		// Skip tasks polled by another instance, or still being polled here (e.g. a long download)
+		ok, err := pm.acquireLease(id)
+		if err != nil {
+			fmt.Printf("PollingManager: Failed to lease task %d: %v\n", id, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		go func(id uint) {
+			defer pm.releaseLease(id)
+			stop := pm.keepLease(id)
+			defer stop()
+			pm.pollTask(id)
+		}(id)
 	}
 }
 
-func (pm *PollingManager) pollTask(pt *PollingTask) {
-	fmt.Printf("PollingManager: Polling task %d (Remote: %s)...\n", pt.ID, pt.RemoteTaskID)
+// acquireLease takes the polling lease of a task
+func (pm *PollingManager) acquireLease(taskID uint) (bool, error) {
+	return database.RedisClient.SetNX(database.Ctx, pollLeaseKey(taskID), pm.instanceID, pollLeaseTTL).Result()
+}
 
-	// We need a way to execute the "Poll" logic specifically.
-	// Currently executors implement "Execute" which does full submission + polling.
-	// We should probably extend TaskExecutor interface or just use JiekouExecutor directly if we know it.
-	// For now, let's assume we can reuse JiekouExecutor logic or extract it.
-	// Since refactoring interface is bigger, I will instantiate JiekouExecutor and call a new method if possible,
-	// or just reuse Execute but logic needs to handle "Already Submitted".
+// releaseLease gives up the polling lease of a task if this instance still owns it
+func (pm *PollingManager) releaseLease(taskID uint) {
+	if err := releaseLeaseScript.Run(database.Ctx, database.RedisClient, []string{pollLeaseKey(taskID)}, pm.instanceID).Err(); err != nil {
+		fmt.Printf("PollingManager: Failed to release lease of task %d: %v\n", taskID, err)
+	}
+}
 
-	// Let's modify JiekouExecutor to handle "Poll Only" if RemoteTaskID is present?
-	// Or better, creating a dedicated Poll method in services is cleaner but requires code duplication.
-	// Let's try to use the registered executor.
+// keepLease refreshes the lease while a poll is running and returns a function that stops it
+func (pm *PollingManager) keepLease(taskID uint) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(pollLeaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := extendLeaseScript.Run(database.Ctx, database.RedisClient, []string{pollLeaseKey(taskID)}, pm.instanceID, pollLeaseTTL.Milliseconds()).Err()
+				if err != nil {
+					fmt.Printf("PollingManager: Failed to extend lease of task %d: %v\n", taskID, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// scheduleNextPoll persists the next due time and the error count of a task still being polled
+func scheduleNextPoll(task *models.Task, interval time.Duration) {
+	next := time.Now().Add(interval)
+	err := database.DB.Model(&models.Task{}).
+		Where("id = ? AND status = ?", task.ID, models.TaskStatusProcessing).
+		Updates(map[string]interface{}{
+			"next_poll_at":     next,
+			"poll_error_count": task.PollErrorCount,
+		}).Error
+	if err != nil {
+		fmt.Printf("PollingManager: Failed to schedule task %d: %v\n", task.ID, err)
+	}
+}
 
+func (pm *PollingManager) pollTask(taskID uint) {
 	var task models.Task
-	if err := database.DB.First(&task, pt.ID).Error; err != nil {
-		pm.Remove(pt.ID)
+	if err := database.DB.First(&task, taskID).Error; err != nil {
+		return
+	}
+
+	// Cancelled or finished by another instance since the scan
+	if task.Status != models.TaskStatusProcessing || task.RemoteTaskID == "" {
 		return
 	}
 
+	fmt.Printf("PollingManager: Polling task %d (Remote: %s)...\n", task.ID, task.RemoteTaskID)
+
 	// Determine executor
-	ex := getExecutor(pt.Executor)
+	ex := getExecutor(resolveExecutorName(&task))
 	if ex == nil {
 		// Fallback
 		ex = getExecutor("jiekou_api")
 	}
 
 	if ex == nil {
-		fmt.Printf("PollingManager: No executor found for task %d\n", pt.ID)
+		fmt.Printf("PollingManager: No executor found for task %d\n", task.ID)
 		return
 	}
 
-	// We need the executor to support "Resume" or "Poll".
-	// If we call Execute(task), standard JiekouExecutor will try to submit again because it doesn't check RemoteTaskID at start.
-	// We should modify JiekouExecutor to check task.RemoteTaskID first!
+	if task.SubmittedAt != nil && time.Since(*task.SubmittedAt) > executorPollTimeout(ex, &task) {
+		fmt.Printf("PollingManager: Task %d timed out.\n", task.ID)
+		finishTask(&task, &PollResult{State: PollStateFailed, Err: errors.New("task polling timed out")})
+		return
+	}
 
-	result, err := ex.Execute(&task)
-	if err != nil {
-		fmt.Printf("PollingManager: Task %d poll failed: %v\n", pt.ID, err)
-		pt.RetryCount++
-		if pt.RetryCount > 5 {
-			fmt.Printf("PollingManager: Task %d failed too many times, removing.\n", pt.ID)
-			// Mark as failed in DB
-			task.Status = models.TaskStatusFailed
-			task.ErrorLog = fmt.Sprintf("Polling failed after retries: %v", err)
-
-			// Refund if cost > 0
-			if task.Cost > 0 {
-				_, refundErr := AdjustBalance(task.CreatorID, task.Cost, fmt.Sprintf("Refund for task %d failure", task.ID), TransactionMetadata{
-					Operator: "system",
-					Type:     models.TransactionTypeUserRefund,
-				})
-				if refundErr != nil {
-					fmt.Printf("Refund failed for task %d: %v\n", task.ID, refundErr)
-					task.ErrorLog += fmt.Sprintf("; Refund failed: %v", refundErr)
-				}
-			}
+	// Interrupted when the task is cancelled
+	ctx, done := inflight.watch(task.ID)
+	defer done()
 
-			database.DB.Save(&task)
-			pm.Remove(pt.ID)
+	result, err := ex.Poll(ctx, &task)
+	if ctx.Err() != nil {
+		fmt.Printf("PollingManager: Task %d was cancelled while polling.\n", task.ID)
+		return
+	}
+	if err != nil {
+		fmt.Printf("PollingManager: Task %d poll failed: %v\n", task.ID, err)
+		task.PollErrorCount++
+		if task.PollErrorCount > pollMaxErrors {
+			fmt.Printf("PollingManager: Task %d failed too many times, giving up.\n", task.ID)
+			failTask(&task, fmt.Errorf("Polling failed after retries: %v", err))
+			return
 		}
+		scheduleNextPoll(&task, executorPollInterval(ex, &task))
 		return
 	}
 
-	// Success
-	fmt.Printf("PollingManager: Task %d completed.\n", pt.ID)
-	// Update DB (Execute usually returns map, but doesn't save final status?
-	// Wait, processTask saves status. Executor returns result map.
-	// We need to save result here.
+	task.PollErrorCount = 0
 
-	if hookErr := runAfterExecutionHooks(&task, result); hookErr != nil {
-		fmt.Printf("PollingManager: Task %d after hooks error: %v\n", pt.ID, hookErr)
-	}
-	task.Status = models.TaskStatusCompleted
-	if ossURL, ok := result["oss_url"].(string); ok && ossURL != "" {
-		task.ResultURL = ossURL
+	if result.State == PollStatePending {
+		scheduleNextPoll(&task, executorPollInterval(ex, &task))
+		return
 	}
-	database.DB.Save(&task)
-	pm.Remove(pt.ID)
+
+	fmt.Printf("PollingManager: Task %d finished.\n", task.ID)
+	finishTask(&task, result)
 }
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/datatypes"
+)
+
+// countingExecutor finishes every poll and counts how often it was asked
+type countingExecutor struct {
+	polls *int32
+}
+
+func (e countingExecutor) Submit(ctx context.Context, task *models.Task) (*RemoteHandle, error) {
+	return nil, errors.New("not used")
+}
+
+func (e countingExecutor) Poll(ctx context.Context, task *models.Task) (*PollResult, error) {
+	atomic.AddInt32(e.polls, 1)
+	return &PollResult{State: PollStateSucceeded, Output: map[string]interface{}{"result_url": "http://example.com/out.png"}}, nil
+}
+
+func TestPollingManager_LeaseAndSingleFinalize(t *testing.T) {
+	setupRetryTestDB()
+	mr := setupRetryTestRedis()
+	defer mr.Close()
+
+	var polls int32
+	RegisterExecutor("counting_test", countingExecutor{polls: &polls})
+
+	raw, _ := json.Marshal(map[string]interface{}{"executor": "counting_test"})
+	past := time.Now().Add(-time.Minute)
+	task := models.Task{
+		InputData:    datatypes.JSON(raw),
+		Status:       models.TaskStatusProcessing,
+		RemoteTaskID: "remote-1",
+		SubmittedAt:  &past,
+		NextPollAt:   &past,
+	}
+	database.DB.Create(&task)
+
+	ids, err := dueTasks(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{task.ID}, ids)
+
+	pm1 := NewPollingManager()
+	pm2 := NewPollingManager()
+
+	// Only one instance can hold the lease
+	ok, err := pm1.acquireLease(task.ID)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, _ = pm2.acquireLease(task.ID)
+	assert.False(t, ok)
+
+	// A lease of a dead owner expires and another instance takes over
+	mr.FastForward(pollLeaseTTL + time.Second)
+	ok, _ = pm2.acquireLease(task.ID)
+	assert.True(t, ok)
+
+	// The old owner cannot release a lease it lost
+	pm1.releaseLease(task.ID)
+	assert.True(t, mr.Exists(pollLeaseKey(task.ID)))
+	pm2.releaseLease(task.ID)
+	assert.False(t, mr.Exists(pollLeaseKey(task.ID)))
+
+	// Both instances see the same task; only the first poll finalizes it
+	pm1.pollTask(task.ID)
+	pm2.pollTask(task.ID)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&polls))
+
+	var updated models.Task
+	database.DB.First(&updated, task.ID)
+	assert.Equal(t, models.TaskStatusCompleted, updated.Status)
+	assert.Equal(t, "http://example.com/out.png", updated.ResultURL)
+	assert.Nil(t, updated.NextPollAt)
+
+	ids, _ = dueTasks(time.Now())
+	assert.Empty(t, ids)
+}
+
+func TestSaveIfStatus(t *testing.T) {
+	setupRetryTestDB()
+
+	task := models.Task{Status: models.TaskStatusProcessing}
+	database.DB.Create(&task)
+
+	// A stale copy loses against a concurrent update
+	stale := task
+	task.Status = models.TaskStatusCancelled
+	database.DB.Save(&task)
+
+	stale.Status = models.TaskStatusCompleted
+	applied, err := saveIfStatus(&stale, models.TaskStatusProcessing)
+	assert.NoError(t, err)
+	assert.False(t, applied)
+
+	var stored models.Task
+	database.DB.First(&stored, task.ID)
+	assert.Equal(t, models.TaskStatusCancelled, stored.Status)
+}
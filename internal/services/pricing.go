@@ -0,0 +1,108 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrPricing is returned when the pricing rule of a model cannot price a task input
+	ErrPricing = errors.New("cannot price task")
+	// ErrQuoteMismatch is returned when a task would cost something else than the price it was submitted with
+	ErrQuoteMismatch = errors.New("price differs from the quoted price")
+)
+
+// TaskQuote is the price a task input is charged
+type TaskQuote struct {
+	ModelID        uint                    `json:"model_id"`
+	Price          money.Amount            `json:"price"`
+	PricingVersion int                     `json:"pricing_version"`
+	Breakdown      []models.PriceComponent `json:"breakdown,omitempty"`
+}
+
+// quoteTask prices a task input that has already been validated against the model.
+// Models without a pricing rule cost their flat Price. Either way the price has to be
+// positive, or the task is not priced.
+func quoteTask(model *models.AIModel, input map[string]interface{}) (*TaskQuote, error) {
+	quote := &TaskQuote{ModelID: model.ID, Price: model.Price, PricingVersion: model.PricingVersion}
+	if model.Pricing == nil {
+		if quote.Price <= 0 {
+			return nil, fmt.Errorf("%w: model has a non-positive price %s", ErrPricing, quote.Price)
+		}
+		return quote, nil
+	}
+
+	body, _ := taskRequestBody(input)
+	price, breakdown, err := model.Pricing.Evaluate(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPricing, err)
+	}
+	if price <= 0 {
+		return nil, fmt.Errorf("%w: pricing gives a non-positive price %s", ErrPricing, price)
+	}
+	quote.Price = price
+	quote.Breakdown = breakdown
+	return quote, nil
+}
+
+// QuoteTask returns what a task input would be charged for a model when submitted now.
+// The input is validated and completed with defaults first, as on submission.
+func QuoteTask(modelID uint, input map[string]interface{}) (*TaskQuote, error) {
+	model, err := GetAIModelByID(modelID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTaskInput(model, input, "body.", nil); err != nil {
+		return nil, err
+	}
+	return quoteTask(model, input)
+}
+
+// unresolvedPricingParam returns the first parameter read by the pricing rule of a model
+// whose value in the task input is not known yet, or "" when the input can be priced
+func unresolvedPricingParam(model *models.AIModel, input map[string]interface{}, unresolved func(interface{}) bool) string {
+	if model.Pricing == nil {
+		return ""
+	}
+	body, _ := taskRequestBody(input)
+	for _, name := range model.Pricing.Params() {
+		param := strings.SplitN(name, ".", 2)[0]
+		if unresolved(body[param]) {
+			return param
+		}
+	}
+	return ""
+}
+
+// checkQuotedPrice rejects a quote that does not match the price the caller expects
+func checkQuotedPrice(quote *TaskQuote, quotedPrice *money.Amount) error {
+	if quotedPrice != nil && *quotedPrice != quote.Price {
+		return fmt.Errorf("%w: quoted %s, now %s (pricing version %d)", ErrQuoteMismatch, *quotedPrice, quote.Price, quote.PricingVersion)
+	}
+	return nil
+}
+
+// nextPricingVersion returns the pricing version of a model being saved: the stored
+// version, plus one when the price or the pricing rule changed
+func nextPricingVersion(model *models.AIModel) (int, error) {
+	var stored models.AIModel
+	if err := database.DB.Select("id, price, pricing, pricing_version").First(&stored, model.ID).Error; err != nil {
+		return 0, err
+	}
+	version := stored.PricingVersion
+	if version < 1 {
+		version = 1
+	}
+
+	before, _ := json.Marshal(stored.Pricing)
+	after, _ := json.Marshal(model.Pricing)
+	if stored.Price != model.Price || string(before) != string(after) {
+		version++
+	}
+	return version, nil
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/logger"
+	"aigentools-backend/pkg/money"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCreateTask_ChargesQuotedPrice(t *testing.T) {
+	logger.Log = zap.NewNop()
+	setupPaymentTestDB()
+	mr := setupPaymentTestRedis()
+	defer mr.Close()
+
+	model := inputTestModel(t)
+	model.Pricing = &models.PricingRule{
+		Base:        1,
+		PerUnit:     []models.PricingTerm{{Param: "steps", Price: 0.1}},
+		Multipliers: []models.PricingMultiplier{{Param: "size", Values: map[string]float64{"512x512": 1, "1024x1024": 2}}},
+	}
+	require.NoError(t, CreateAIModel(model))
+	assert.Equal(t, 1, model.PricingVersion)
+	user := models.User{Username: "payer", Balance: money.MustParse("100"), Version: 1, IsActive: true}
+	require.NoError(t, database.DB.Create(&user).Error)
+
+	// The default size is filled in before pricing: (1 + 0.1 × 20) × 2
+	quote, err := QuoteTask(model.ID, map[string]interface{}{"prompt": "a cat", "steps": 20.0})
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("6"), quote.Price)
+
+	task, err := CreateTask(map[string]interface{}{"model_id": float64(model.ID), "prompt": "a cat", "steps": 20.0}, user.ID, user.Username, nil, "", &quote.Price)
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("6"), task.Cost)
+	assert.Equal(t, 1, task.PricingVersion)
+	var stored models.User
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("6"), stored.HeldAmount)
+
+	// A new rule bumps the version, and the old quote is refused without holding anything
+	model.Pricing.Base = 2
+	require.NoError(t, UpdateAIModel(model))
+	assert.Equal(t, 2, model.PricingVersion)
+	_, err = CreateTask(map[string]interface{}{"model_id": float64(model.ID), "prompt": "a cat", "steps": 20.0}, user.ID, user.Username, nil, "", &quote.Price)
+	assert.True(t, errors.Is(err, ErrQuoteMismatch))
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("6"), stored.HeldAmount)
+
+	// Saving without a change keeps the version
+	require.NoError(t, UpdateAIModel(model))
+	assert.Equal(t, 2, model.PricingVersion)
+}
+
+func TestValidateModelPricing(t *testing.T) {
+	model := inputTestModel(t)
+	model.Pricing = &models.PricingRule{Expression: "0.1 * duration"}
+	assert.ErrorContains(t, ValidateModelPricing(model), "'duration'")
+
+	model.Pricing = &models.PricingRule{Expression: "size == '512x512' ? 1 : 2"}
+	assert.NoError(t, ValidateModelPricing(model))
+}
+
+func TestQuoteTask_RequiresPositivePrice(t *testing.T) {
+	model := inputTestModel(t)
+	model.Price = 0
+	_, err := quoteTask(model, map[string]interface{}{"prompt": "a cat"})
+	assert.ErrorIs(t, err, ErrPricing)
+
+	model.Price = money.MustParse("0.5")
+	quote, err := quoteTask(model, map[string]interface{}{"prompt": "a cat"})
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("0.5"), quote.Price)
+
+	model.Pricing = &models.PricingRule{PerUnit: []models.PricingTerm{{Param: "steps", Price: 0.1}}}
+	_, err = quoteTask(model, map[string]interface{}{"prompt": "a cat", "steps": 0.0})
+	assert.ErrorIs(t, err, ErrPricing)
+}
@@ -0,0 +1,500 @@
+package services
+
+import (
+	"aigentools-backend/config"
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	reconcileBatchSize     = 100
+	reconcileCheckInterval = 10 * time.Minute
+	reconcileLockKey       = "reconciliation:lock"
+	reconcileLockTTL       = time.Hour
+	reconcileUserAttempts  = 3
+	maxStoredMismatches    = 10000
+)
+
+// ReconciliationAlertEvent is the event of the alert posted for critical mismatches
+const ReconciliationAlertEvent = "reconciliation.critical"
+
+// alertClient posts to RECONCILIATION_ALERT_URL. The URL comes from the operator, who may
+// well point it at an internal service, so unlike webhookClient it may reach any address.
+var alertClient = &http.Client{Timeout: webhookTimeout}
+
+var (
+	ErrReconciliationRunning        = errors.New("a reconciliation is already running")
+	ErrReconciliationReportNotFound = errors.New("reconciliation report not found")
+)
+
+// ReconciliationAlert is the JSON body posted to RECONCILIATION_ALERT_URL
+type ReconciliationAlert struct {
+	Event      string                       `json:"event"`
+	OccurredAt time.Time                    `json:"occurred_at"`
+	Report     *models.ReconciliationReport `json:"report"`
+}
+
+// StartReconciler runs the balance reconciliation every RECONCILIATION_INTERVAL hours. It
+// blocks forever. The time of the last report is read from the database, so restarts keep
+// the cadence, and a Redis lock lets only one instance run it at a time.
+func StartReconciler(checkInterval time.Duration) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cfg, _ := config.LoadConfig()
+		if cfg == nil || cfg.ReconciliationInterval <= 0 {
+			continue
+		}
+		due, err := reconciliationDue(time.Now(), time.Duration(cfg.ReconciliationInterval)*time.Hour)
+		if err != nil {
+			fmt.Printf("Reconciler: Failed to read the last report: %v\n", err)
+			continue
+		}
+		if !due {
+			continue
+		}
+		report, err := RunReconciliation("scheduled")
+		if err != nil {
+			if !errors.Is(err, ErrReconciliationRunning) {
+				fmt.Printf("Reconciler: Failed to reconcile balances: %v\n", err)
+			}
+			continue
+		}
+		fmt.Printf("Reconciler: Report %d found %d mismatch(es), %d critical\n", report.ID, report.Mismatches, report.Critical)
+	}
+}
+
+// reconciliationDue reports whether the last reconciliation started more than interval before now
+func reconciliationDue(now time.Time, interval time.Duration) (bool, error) {
+	var last models.ReconciliationReport
+	err := database.DB.Order("created_at desc").First(&last).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return now.Sub(last.CreatedAt) >= interval, nil
+}
+
+// RunReconciliation reconciles every balance with the ledger and waits for the report.
+// trigger records who started it.
+func RunReconciliation(trigger string) (*models.ReconciliationReport, error) {
+	report, lockToken, err := beginReconciliation(trigger)
+	if err != nil {
+		return nil, err
+	}
+	finishReconciliation(report, lockToken)
+	return report, nil
+}
+
+// StartReconciliation starts a reconciliation in the background and returns its report,
+// which stays running until the checks are done
+func StartReconciliation(trigger string) (*models.ReconciliationReport, error) {
+	report, lockToken, err := beginReconciliation(trigger)
+	if err != nil {
+		return nil, err
+	}
+	started := *report
+	go finishReconciliation(report, lockToken)
+	return &started, nil
+}
+
+// beginReconciliation takes the reconciliation lock and records a running report. The
+// lock holds a token unique to the run, which releasing it requires.
+func beginReconciliation(trigger string) (*models.ReconciliationReport, string, error) {
+	lockToken := uuid.New().String()
+	if database.RedisClient != nil {
+		ok, err := database.RedisClient.SetNX(database.Ctx, reconcileLockKey, lockToken, reconcileLockTTL).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		if !ok {
+			return nil, "", ErrReconciliationRunning
+		}
+	}
+
+	report := &models.ReconciliationReport{Trigger: trigger, Status: models.ReconciliationStatusRunning}
+	if err := database.DB.Create(report).Error; err != nil {
+		releaseReconciliationLock(lockToken)
+		return nil, "", err
+	}
+	return report, lockToken, nil
+}
+
+// finishReconciliation runs the checks of a report, stores what they found, raises an
+// alert for critical mismatches and releases the lock
+func finishReconciliation(report *models.ReconciliationReport, lockToken string) {
+	defer releaseReconciliationLock(lockToken)
+
+	r := &reconciler{report: report}
+	err := r.run()
+
+	now := time.Now()
+	report.FinishedAt = &now
+	report.Status = models.ReconciliationStatusCompleted
+	if err != nil {
+		report.Status = models.ReconciliationStatusFailed
+		report.Error = err.Error()
+	}
+	if len(r.mismatches) > 0 {
+		if err := database.DB.CreateInBatches(r.mismatches, reconcileBatchSize).Error; err != nil {
+			fmt.Printf("Reconciler: Failed to store the mismatches of report %d: %v\n", report.ID, err)
+		}
+	}
+	if err := database.DB.Save(report).Error; err != nil {
+		fmt.Printf("Reconciler: Failed to save report %d: %v\n", report.ID, err)
+	}
+
+	if report.Critical > 0 {
+		raiseReconciliationAlert(report)
+	}
+}
+
+// releaseReconciliationLock releases the lock if the run holding lockToken still has it.
+// A run outliving reconcileLockTTL may have lost it to another, which it must not release.
+func releaseReconciliationLock(lockToken string) {
+	if database.RedisClient == nil {
+		return
+	}
+	if err := releaseLeaseScript.Run(database.Ctx, database.RedisClient, []string{reconcileLockKey}, lockToken).Err(); err != nil {
+		fmt.Printf("Reconciler: Failed to release the lock: %v\n", err)
+	}
+}
+
+// raiseReconciliationAlert logs a report with critical mismatches and posts it to
+// RECONCILIATION_ALERT_URL when one is configured
+func raiseReconciliationAlert(report *models.ReconciliationReport) {
+	fmt.Printf("Reconciler: ALERT report %d found %d critical mismatch(es)\n", report.ID, report.Critical)
+
+	cfg, _ := config.LoadConfig()
+	if cfg == nil || cfg.ReconciliationAlertURL == "" {
+		return
+	}
+	body, err := json.Marshal(ReconciliationAlert{Event: ReconciliationAlertEvent, OccurredAt: time.Now(), Report: report})
+	if err != nil {
+		return
+	}
+	resp, err := alertClient.Post(cfg.ReconciliationAlertURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Printf("Reconciler: Failed to send alert for report %d: %v\n", report.ID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		fmt.Printf("Reconciler: Alert receiver returned status %d for report %d\n", resp.StatusCode, report.ID)
+	}
+}
+
+// reconciler collects the mismatches of one report
+type reconciler struct {
+	report     *models.ReconciliationReport
+	mismatches []models.ReconciliationMismatch
+}
+
+// flag records a mismatch; only the first maxStoredMismatches are stored, all are counted
+func (r *reconciler) flag(m models.ReconciliationMismatch) {
+	m.ReportID = r.report.ID
+	r.report.Mismatches++
+	if m.Severity == models.MismatchSeverityCritical {
+		r.report.Critical++
+	}
+	if len(r.mismatches) < maxStoredMismatches {
+		r.mismatches = append(r.mismatches, m)
+	}
+}
+
+func (r *reconciler) run() error {
+	if err := r.reconcileUsers(); err != nil {
+		return err
+	}
+	return r.reconcileOrders()
+}
+
+// reconcileUsers recomputes every user's balance, consumption and held amount
+func (r *reconciler) reconcileUsers() error {
+	var lastID uint
+	for {
+		var users []models.User
+		if err := database.DB.Where("id > ?", lastID).Order("id").Limit(reconcileBatchSize).Find(&users).Error; err != nil {
+			return err
+		}
+		for i := range users {
+			if err := r.reconcileUserConsistently(&users[i]); err != nil {
+				return err
+			}
+			r.report.UsersChecked++
+			lastID = users[i].ID
+		}
+		if len(users) < reconcileBatchSize {
+			return nil
+		}
+	}
+}
+
+// reconcileUserConsistently reconciles a user whose row did not change meanwhile. Every
+// balance change bumps User.Version, so a user that changed is read again and rechecked,
+// rather than reporting the transactions recorded during the walk as drift.
+func (r *reconciler) reconcileUserConsistently(user *models.User) error {
+	for attempt := 1; ; attempt++ {
+		found, err := reconcileUser(user)
+		if err != nil {
+			return err
+		}
+		var current models.User
+		if err := database.DB.First(&current, user.ID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if current.Version == user.Version || attempt == reconcileUserAttempts {
+			for _, m := range found {
+				r.flag(m)
+			}
+			return nil
+		}
+		*user = current
+	}
+}
+
+// reconcileUser walks a user's transactions in order, checking each continues from the
+// one before, and compares the totals with the user row
+func reconcileUser(user *models.User) ([]models.ReconciliationMismatch, error) {
+	var found []models.ReconciliationMismatch
+	var balance, consumed, prevAfter money.Amount
+	var lastID uint
+	for {
+		var batch []models.Transaction
+		if err := database.DB.Where("user_id = ? AND id > ?", user.ID, lastID).
+			Order("id").Limit(chainVerifyBatchSize).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		for i := range batch {
+			t := &batch[i]
+			if t.BalanceBefore != prevAfter {
+				found = append(found, models.ReconciliationMismatch{
+					Kind: models.MismatchContinuity, Severity: models.MismatchSeverityCritical,
+					UserID: user.ID, TransactionID: &t.ID, Expected: prevAfter, Actual: t.BalanceBefore,
+					Detail: "balance_before differs from the balance_after of the previous transaction",
+				})
+			}
+			if t.BalanceAfter != t.BalanceBefore+t.Amount {
+				found = append(found, models.ReconciliationMismatch{
+					Kind: models.MismatchArithmetic, Severity: models.MismatchSeverityCritical,
+					UserID: user.ID, TransactionID: &t.ID, Expected: t.BalanceBefore + t.Amount, Actual: t.BalanceAfter,
+					Detail: "balance_after is not balance_before plus amount",
+				})
+			}
+
+			balance += t.Amount
+			consumed += consumedBy(t)
+			prevAfter = t.BalanceAfter
+			lastID = t.ID
+		}
+		if len(batch) < chainVerifyBatchSize {
+			break
+		}
+	}
+
+	if balance != user.Balance {
+		found = append(found, models.ReconciliationMismatch{
+			Kind: models.MismatchBalance, Severity: models.MismatchSeverityCritical,
+			UserID: user.ID, Expected: balance, Actual: user.Balance,
+			Detail: "balance differs from the sum of the user's transaction amounts",
+		})
+	}
+	if consumed != user.TotalConsumed {
+		found = append(found, models.ReconciliationMismatch{
+			Kind: models.MismatchTotalConsumed, Severity: models.MismatchSeverityWarning,
+			UserID: user.ID, Expected: consumed, Actual: user.TotalConsumed,
+			Detail: "total_consumed differs from the consumption recorded in the ledger",
+		})
+	}
+
+	var held []money.Amount
+	if err := database.DB.Model(&models.BalanceHold{}).
+		Where("user_id = ? AND status = ?", user.ID, models.HoldStatusActive).
+		Pluck("amount", &held).Error; err != nil {
+		return nil, err
+	}
+	var heldTotal money.Amount
+	for _, a := range held {
+		heldTotal += a
+	}
+	if heldTotal != user.HeldAmount {
+		found = append(found, models.ReconciliationMismatch{
+			Kind: models.MismatchHeldAmount, Severity: models.MismatchSeverityCritical,
+			UserID: user.ID, Expected: heldTotal, Actual: user.HeldAmount,
+			Detail: "held_amount differs from the sum of the user's active balance holds",
+		})
+	}
+	return found, nil
+}
+
+// consumedBy is how much a transaction adds to TotalConsumed: every debit counts as
+// consumption and a refund gives it back, as DeductBalanceTx and AdjustBalanceTx apply it
+func consumedBy(t *models.Transaction) money.Amount {
+	if t.Type == models.TransactionTypeUserRefund {
+		return -t.Amount
+	}
+	if t.Amount < 0 {
+		return -t.Amount
+	}
+	return 0
+}
+
+// reconcileOrders checks every paid order was credited by exactly one topup transaction of
+// its amount, and every topup transaction of an order belongs to a paid one
+func (r *reconciler) reconcileOrders() error {
+	lastID := ""
+	for {
+		var orders []models.PaymentOrderRecord
+		if err := database.DB.Where("status = ? AND id > ?", models.OrderStatusPaid, lastID).
+			Order("id").Limit(reconcileBatchSize).Find(&orders).Error; err != nil {
+			return err
+		}
+		for i := range orders {
+			if err := r.reconcileOrder(&orders[i]); err != nil {
+				return err
+			}
+			r.report.OrdersChecked++
+			lastID = orders[i].ID
+		}
+		if len(orders) < reconcileBatchSize {
+			break
+		}
+	}
+
+	var orphans []models.Transaction
+	if err := database.DB.Model(&models.Transaction{}).
+		Joins("LEFT JOIN payment_order_records o ON o.id = transactions.order_id").
+		Where("transactions.order_id <> '' AND (o.id IS NULL OR o.status <> ?)", models.OrderStatusPaid).
+		Find(&orphans).Error; err != nil {
+		return err
+	}
+	for i := range orphans {
+		t := &orphans[i]
+		r.flag(models.ReconciliationMismatch{
+			Kind: models.MismatchOrderTopup, Severity: models.MismatchSeverityCritical,
+			UserID: t.UserID, TransactionID: &t.ID, OrderID: t.OrderID, Actual: t.Amount,
+			Detail: "topup transaction of an order that is not paid",
+		})
+	}
+	return nil
+}
+
+func (r *reconciler) reconcileOrder(order *models.PaymentOrderRecord) error {
+	// Topups recorded before transactions kept their order ID only name it in the reason
+	var candidates []models.Transaction
+	if err := database.DB.
+		Where("type IN ?", []models.TransactionType{models.TransactionTypeUserTopup, models.TransactionTypeManualTopup}).
+		Where("order_id = ? OR (order_id = '' AND reason LIKE ?)", order.ID, "%"+order.ID+"%").
+		Find(&candidates).Error; err != nil {
+		return err
+	}
+
+	var topups []models.Transaction
+	var credited money.Amount
+	for _, t := range candidates {
+		if t.OrderID == order.ID || topupReasonNames(t.Reason, order.ID) {
+			topups = append(topups, t)
+			credited += t.Amount
+		}
+	}
+
+	switch {
+	case len(topups) != 1:
+		r.flag(models.ReconciliationMismatch{
+			Kind: models.MismatchOrderTopup, Severity: models.MismatchSeverityCritical,
+			UserID: order.UserID, OrderID: order.ID, Expected: order.Amount, Actual: credited,
+			Detail: fmt.Sprintf("paid order has %d topup transaction(s) instead of one", len(topups)),
+		})
+	case topups[0].Amount != order.Amount || topups[0].UserID != order.UserID:
+		r.flag(models.ReconciliationMismatch{
+			Kind: models.MismatchOrderTopup, Severity: models.MismatchSeverityCritical,
+			UserID: order.UserID, TransactionID: &topups[0].ID, OrderID: order.ID, Expected: order.Amount, Actual: topups[0].Amount,
+			Detail: fmt.Sprintf("topup transaction credits user %d with a different amount or user", topups[0].UserID),
+		})
+	}
+	return nil
+}
+
+// topupReasonNames reports whether a topup reason, as CompleteOrder writes it, names the order
+func topupReasonNames(reason, orderID string) bool {
+	for _, prefix := range []string{"充值订单: ", "管理员手动充值订单: "} {
+		if rest, ok := strings.CutPrefix(reason, prefix); ok {
+			return rest == orderID || strings.HasPrefix(rest, orderID+" (")
+		}
+	}
+	return false
+}
+
+// ListReconciliationReports returns a page of reports, most recent first
+func ListReconciliationReports(page, limit int) ([]models.ReconciliationReport, int64, error) {
+	var reports []models.ReconciliationReport
+	var total int64
+	query := database.DB.Model(&models.ReconciliationReport{})
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := query.Order("id desc").Limit(limit).Offset((page - 1) * limit).Find(&reports).Error; err != nil {
+		return nil, 0, err
+	}
+	return reports, total, nil
+}
+
+// GetReconciliationReport returns one report
+func GetReconciliationReport(id uint) (*models.ReconciliationReport, error) {
+	var report models.ReconciliationReport
+	if err := database.DB.First(&report, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReconciliationReportNotFound
+		}
+		return nil, err
+	}
+	return &report, nil
+}
+
+// MismatchFilter narrows the mismatches of a report
+type MismatchFilter struct {
+	Kind     models.MismatchKind
+	Severity models.MismatchSeverity
+	UserID   *uint
+	Page     int
+	Limit    int
+}
+
+// ListReconciliationMismatches returns a page of the mismatches of a report
+func ListReconciliationMismatches(reportID uint, filter MismatchFilter) ([]models.ReconciliationMismatch, int64, error) {
+	var mismatches []models.ReconciliationMismatch
+	var total int64
+	query := database.DB.Model(&models.ReconciliationMismatch{}).Where("report_id = ?", reportID)
+	if filter.Kind != "" {
+		query = query.Where("kind = ?", filter.Kind)
+	}
+	if filter.Severity != "" {
+		query = query.Where("severity = ?", filter.Severity)
+	}
+	if filter.UserID != nil {
+		query = query.Where("user_id = ?", *filter.UserID)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := query.Order("id").Limit(filter.Limit).Offset((filter.Page - 1) * filter.Limit).Find(&mismatches).Error; err != nil {
+		return nil, 0, err
+	}
+	return mismatches, total, nil
+}
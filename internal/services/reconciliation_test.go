@@ -0,0 +1,89 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunReconciliation(t *testing.T) {
+	setupPaymentTestDB()
+	mr := setupPaymentTestRedis()
+	defer mr.Close()
+	database.DB.Migrator().DropTable(&models.PaymentOrderRecord{}, &models.ReconciliationReport{}, &models.ReconciliationMismatch{})
+	database.DB.AutoMigrate(&models.PaymentOrderRecord{}, &models.ReconciliationReport{}, &models.ReconciliationMismatch{})
+
+	alerts := make(chan ReconciliationAlert, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert ReconciliationAlert
+		json.NewDecoder(r.Body).Decode(&alert)
+		alerts <- alert
+	}))
+	defer receiver.Close()
+	t.Setenv("RECONCILIATION_ALERT_URL", receiver.URL)
+
+	meta := TransactionMetadata{Operator: "admin", Type: models.TransactionTypeSystemAdmin}
+
+	// A user whose balance, consumption and topup all match the ledger
+	consistent := models.User{Username: "consistent", Version: 1, IsActive: true}
+	database.DB.Create(&consistent)
+	_, err := AdjustBalance(consistent.ID, money.MustParse("100"), "Adjust", meta)
+	require.NoError(t, err)
+	_, err = AdjustBalance(consistent.ID, money.MustParse("-30"), "Adjust", meta)
+	require.NoError(t, err)
+	order, err := CreateManualOrder(consistent.ID, money.MustParse("50"), "")
+	require.NoError(t, err)
+	require.NoError(t, CompleteOrder(order.ID, 0, "admin"))
+
+	// A user whose balance was changed outside the ledger, and an order paid without a topup
+	drifted := models.User{Username: "drifted", Version: 1, IsActive: true}
+	database.DB.Create(&drifted)
+	_, err = AdjustBalance(drifted.ID, money.MustParse("100"), "Adjust", meta)
+	require.NoError(t, err)
+	database.DB.Model(&models.User{}).Where("id = ?", drifted.ID).Update("balance", money.MustParse("120"))
+	unpaid, err := CreateManualOrder(drifted.ID, money.MustParse("20"), "")
+	require.NoError(t, err)
+	database.DB.Model(unpaid).Update("status", models.OrderStatusPaid)
+
+	report, err := RunReconciliation("test")
+	require.NoError(t, err)
+	assert.Equal(t, models.ReconciliationStatusCompleted, report.Status)
+	assert.Equal(t, 2, report.UsersChecked)
+	assert.Equal(t, 2, report.OrdersChecked)
+	assert.Equal(t, 2, report.Mismatches)
+	assert.Equal(t, 2, report.Critical)
+
+	mismatches, total, err := ListReconciliationMismatches(report.ID, MismatchFilter{Page: 1, Limit: 20})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, total)
+	kinds := map[models.MismatchKind]models.ReconciliationMismatch{}
+	for _, m := range mismatches {
+		assert.Equal(t, drifted.ID, m.UserID)
+		kinds[m.Kind] = m
+	}
+	assert.Equal(t, money.MustParse("100"), kinds[models.MismatchBalance].Expected)
+	assert.Equal(t, money.MustParse("120"), kinds[models.MismatchBalance].Actual)
+	assert.Equal(t, unpaid.ID, kinds[models.MismatchOrderTopup].OrderID)
+
+	alert := <-alerts
+	assert.Equal(t, ReconciliationAlertEvent, alert.Event)
+	assert.Equal(t, report.ID, alert.Report.ID)
+
+	// The finished run released its lock, and only one reconciliation runs at a time
+	assert.False(t, mr.Exists(reconcileLockKey))
+	mr.Set(reconcileLockKey, "other")
+	_, err = StartReconciliation("test")
+	assert.ErrorIs(t, err, ErrReconciliationRunning)
+
+	// A run whose lock expired does not release the lock of the next one
+	releaseReconciliationLock("expired-run")
+	lock, _ := mr.Get(reconcileLockKey)
+	assert.Equal(t, "other", lock)
+}
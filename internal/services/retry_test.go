@@ -3,6 +3,7 @@ package services
 import (
 	"aigentools-backend/internal/database"
 	"aigentools-backend/internal/models"
+	"errors"
 	"testing"
 
 	"github.com/alicebob/miniredis/v2"
@@ -17,8 +18,8 @@ func setupRetryTestDB() {
 	if err != nil {
 		panic("failed to connect database")
 	}
-	db.Migrator().DropTable(&models.Task{})
-	db.AutoMigrate(&models.Task{})
+	db.Migrator().DropTable(&models.Task{}, &models.TaskEvent{}, &models.TaskArtifact{})
+	db.AutoMigrate(&models.Task{}, &models.TaskEvent{}, &models.TaskArtifact{})
 	database.DB = db
 }
 
@@ -65,6 +66,7 @@ func TestRetryTask(t *testing.T) {
 	// 4. Test Success Retry
 	task.Status = models.TaskStatusFailed
 	database.DB.Save(&task)
+	assert.NoError(t, pushDeadLetter(&task, errors.New("Some error")))
 
 	retriedTask, err := RetryTask(task.ID, creatorID)
 	assert.NoError(t, err)
@@ -73,8 +75,12 @@ func TestRetryTask(t *testing.T) {
 	assert.Empty(t, retriedTask.ErrorLog)
 	assert.Empty(t, retriedTask.ResultURL)
 
+	// The retried task left the dead-letter queue
+	_, err = GetDeadLetter(task.ID)
+	assert.ErrorIs(t, err, ErrDeadLetterNotFound)
+
 	// 5. Verify Redis
-	val, err := database.RedisClient.RPop(database.Ctx, TaskQueueKey).Result()
+	id, err := Queue.Dequeue()
 	assert.NoError(t, err)
-	assert.Equal(t, "1", val) // Task ID 1
+	assert.Equal(t, uint(1), id) // Task ID 1
 }
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"aigentools-backend/internal/utils"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// ResultDataOutputKey is the executor output entry holding the inline result of a sync-inline task
+const ResultDataOutputKey = "result_data"
+
+const (
+	// upstreamRequestTimeout bounds an ordinary upstream call, such as an async submission or a status check
+	upstreamRequestTimeout = 30 * time.Second
+	// syncSubmitTimeout bounds a submission to a sync model, which answers once the result is ready
+	syncSubmitTimeout = 2 * time.Minute
+	// maxInlineResultSize caps the inline result kept on a task; larger results are only stored as an artifact
+	maxInlineResultSize = 64 << 10
+)
+
+// taskExecutionMode returns the execution mode of a task's model, async-poll when unknown
+func taskExecutionMode(task *models.Task) models.ExecutionMode {
+	if task.ModelID == 0 {
+		return models.ExecutionModeAsyncPoll
+	}
+	var model models.AIModel
+	if err := database.DB.Select("id", "execution_mode").First(&model, task.ModelID).Error; err != nil || !model.ExecutionMode.Valid() {
+		return models.ExecutionModeAsyncPoll
+	}
+	return model.ExecutionMode
+}
+
+// submitTimeout returns how long a submission in the given mode may take
+func submitTimeout(mode models.ExecutionMode) time.Duration {
+	if mode.IsSync() {
+		return syncSubmitTimeout
+	}
+	return upstreamRequestTimeout
+}
+
+// upstreamClient returns the client for a request to a model with the given execution mode.
+// A sync-binary response is the result file itself, which the logging client would read
+// into memory and print, so those requests get a plain client.
+func upstreamClient(mode models.ExecutionMode, redactHeaders ...string) *http.Client {
+	if mode == models.ExecutionModeSyncBinary {
+		return &http.Client{Timeout: submitTimeout(mode)}
+	}
+	return utils.NewHTTPClient(submitTimeout(mode), redactHeaders...)
+}
+
+// syncOutput builds the executor output of a task whose result came with the submission
+func syncOutput(artifacts []models.TaskArtifact) map[string]interface{} {
+	return map[string]interface{}{
+		"oss_url":          artifacts[0].URL,
+		"original_url":     artifacts[0].OriginalURL,
+		ArtifactsOutputKey: artifacts,
+	}
+}
+
+// inlineResult builds the result of a sync-inline task. Files referenced by the response
+// are downloaded as artifacts; when there are none, the inline result itself is stored
+// as a JSON artifact so the task still has a result file.
+func inlineResult(ctx context.Context, uploader func(localPath, objectKey string) (string, error), task *models.Task, result interface{}, sources []artifactSource) (*PollResult, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode inline result: %v", err)
+	}
+
+	artifacts := make([]models.TaskArtifact, 0, len(sources))
+	for _, src := range sources {
+		// Inline answers may hold plain text where a URL could be
+		if !strings.HasPrefix(src.URL, "http://") && !strings.HasPrefix(src.URL, "https://") {
+			continue
+		}
+		ossKey := fmt.Sprintf("tasks/%d/%d%s", task.ID, len(artifacts), artifactExt(src.URL, ".bin"))
+		artifact, err := fetchArtifact(ctx, uploader, src, ossKey)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, *artifact)
+	}
+	if len(artifacts) == 0 {
+		artifact, err := storeArtifact(uploader, bytes.NewReader(data), "application/json", artifactSource{}, fmt.Sprintf("tasks/%d/result.json", task.ID))
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, *artifact)
+	}
+
+	output := syncOutput(artifacts)
+	if len(data) <= maxInlineResultSize {
+		output[ResultDataOutputKey] = datatypes.JSON(data)
+	}
+	return &PollResult{State: PollStateSucceeded, Output: output}, nil
+}
+
+// commonMimeExts names the extension of the usual result types, since mime.ExtensionsByType
+// does not say which of several extensions is the customary one
+var commonMimeExts = map[string]string{
+	"image/png":        ".png",
+	"image/jpeg":       ".jpg",
+	"image/webp":       ".webp",
+	"image/gif":        ".gif",
+	"video/mp4":        ".mp4",
+	"audio/mpeg":       ".mp3",
+	"audio/wav":        ".wav",
+	"application/json": ".json",
+}
+
+// mimeExt returns a file extension for a Content-Type header
+func mimeExt(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ".bin"
+	}
+	if ext, ok := commonMimeExts[t]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(t); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// binaryResult stores the body of a sync-binary response as the only artifact of a task
+func binaryResult(uploader func(localPath, objectKey string) (string, error), task *models.Task, resp *http.Response) (*PollResult, error) {
+	contentType := resp.Header.Get("Content-Type")
+	ossKey := fmt.Sprintf("tasks/%d/result%s", task.ID, mimeExt(contentType))
+	artifact, err := storeArtifact(uploader, resp.Body, contentType, artifactSource{}, ossKey)
+	if err != nil {
+		return nil, err
+	}
+	if artifact.Size == 0 {
+		return &PollResult{State: PollStateFailed, Err: Terminal(errors.New("upstream returned an empty body"))}, nil
+	}
+	return &PollResult{State: PollStateSucceeded, Output: syncOutput([]models.TaskArtifact{*artifact})}, nil
+}
+
+// sourcesFromURLs wraps plain file URLs as artifact sources
+func sourcesFromURLs(urls []string) []artifactSource {
+	sources := make([]artifactSource, len(urls))
+	for i, u := range urls {
+		sources[i] = artifactSource{URL: u}
+	}
+	return sources
+}
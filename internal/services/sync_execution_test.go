@@ -0,0 +1,184 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+// createSyncTestTask stores a model with the given mode and adapter and a task for it
+func createSyncTestTask(t *testing.T, id uint, modelURL string, mode models.ExecutionMode, adapter *models.AdapterSpec, input map[string]interface{}) *models.Task {
+	model := models.AIModel{Name: "Sync Model", Status: models.AIModelStatusOpen, URL: modelURL, Parameters: models.JSON{}, Adapter: adapter, ExecutionMode: mode}
+	require.NoError(t, database.DB.Create(&model).Error)
+
+	input["model_id"] = model.ID
+	inputBytes, _ := json.Marshal(input)
+	task := &models.Task{ID: id, ModelID: model.ID, InputData: datatypes.JSON(inputBytes)}
+	require.NoError(t, database.DB.Create(task).Error)
+	return task
+}
+
+func recordingUploader(keys *[]string) func(localPath, objectKey string) (string, error) {
+	return func(localPath, objectKey string) (string, error) {
+		*keys = append(*keys, objectKey)
+		return "https://oss.example.com/" + objectKey, nil
+	}
+}
+
+func TestAdapterExecutor_SyncInline(t *testing.T) {
+	setupAdapterTestDB()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      "chat-1",
+			"choices": []map[string]interface{}{{"message": map[string]string{"content": "Hello!"}}},
+		})
+	}))
+	defer mockServer.Close()
+
+	spec := &models.AdapterSpec{ResultPath: "$.choices[0].message"}
+	require.NoError(t, spec.Validate(models.ExecutionModeSyncInline))
+	task := createSyncTestTask(t, 400, mockServer.URL, models.ExecutionModeSyncInline, spec, map[string]interface{}{"prompt": "hi"})
+
+	var keys []string
+	handle, err := AdapterExecutor{Uploader: recordingUploader(&keys)}.Submit(context.Background(), task)
+	require.NoError(t, err)
+	require.NotNil(t, handle.Result)
+	assert.Equal(t, PollStateSucceeded, handle.Result.State)
+
+	// No file in the answer, so the inline result itself is the artifact
+	assert.Equal(t, []string{"tasks/400/result.json"}, keys)
+	assert.JSONEq(t, `{"content":"Hello!"}`, string(handle.Result.Output[ResultDataOutputKey].(datatypes.JSON)))
+	artifacts := handle.Result.Output[ArtifactsOutputKey].([]models.TaskArtifact)
+	assert.Equal(t, "application/json", artifacts[0].MimeType)
+}
+
+func TestAdapterExecutor_SyncBinary(t *testing.T) {
+	setupAdapterTestDB()
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 8, 6)))
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img.Bytes())
+	}))
+	defer mockServer.Close()
+
+	task := createSyncTestTask(t, 401, mockServer.URL, models.ExecutionModeSyncBinary, &models.AdapterSpec{}, map[string]interface{}{"prompt": "a cat"})
+
+	var keys []string
+	handle, err := AdapterExecutor{Uploader: recordingUploader(&keys)}.Submit(context.Background(), task)
+	require.NoError(t, err)
+	require.NotNil(t, handle.Result)
+	assert.Equal(t, []string{"tasks/401/result.png"}, keys)
+
+	artifacts := handle.Result.Output[ArtifactsOutputKey].([]models.TaskArtifact)
+	require.Len(t, artifacts, 1)
+	assert.Equal(t, "image/png", artifacts[0].MimeType)
+	assert.Equal(t, 8, artifacts[0].Width)
+	assert.Equal(t, 6, artifacts[0].Height)
+
+	// The file is streamed, not read whole into the request log
+	client := upstreamClient(models.ExecutionModeSyncBinary)
+	assert.Nil(t, client.Transport)
+	assert.Equal(t, syncSubmitTimeout, client.Timeout)
+}
+
+func TestJiekouExecutor_SyncInline(t *testing.T) {
+	setupAdapterTestDB()
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true") // The file servers listen on loopback
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/create":
+			// Answers right away, without any task_id
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"images": []map[string]string{{"image_url": "http://" + r.Host + "/out.webp"}},
+			})
+		case "/out.webp":
+			w.Write([]byte("webp bytes"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mockServer.Close()
+
+	task := createSyncTestTask(t, 402, mockServer.URL+"/create", models.ExecutionModeSyncInline, nil, map[string]interface{}{
+		"data":  map[string]interface{}{"prompt": "a cat"},
+		"model": map[string]interface{}{"model_url": mockServer.URL + "/create"},
+	})
+	assert.Equal(t, "jiekou_api", resolveExecutorName(task))
+
+	var keys []string
+	handle, err := JiekouExecutor{Uploader: recordingUploader(&keys)}.Submit(context.Background(), task)
+	require.NoError(t, err)
+	require.NotNil(t, handle.Result)
+	assert.Equal(t, []string{"tasks/402/0.webp"}, keys)
+	assert.Equal(t, "https://oss.example.com/tasks/402/0.webp", handle.Result.Output["oss_url"])
+}
+
+func TestCompleteTaskStoresInlineResult(t *testing.T) {
+	setupRetryTestDB()
+	mr := setupRetryTestRedis()
+	defer mr.Close()
+
+	task := models.Task{Status: models.TaskStatusProcessing, InputData: datatypes.JSON(`{}`)}
+	require.NoError(t, database.DB.Create(&task).Error)
+
+	completeTask(&task, map[string]interface{}{
+		"oss_url":           "https://oss.example.com/tasks/1/result.json",
+		ResultDataOutputKey: datatypes.JSON(`{"content":"Hello!"}`),
+	})
+
+	var stored models.Task
+	require.NoError(t, database.DB.First(&stored, task.ID).Error)
+	assert.Equal(t, models.TaskStatusCompleted, stored.Status)
+	assert.JSONEq(t, `{"content":"Hello!"}`, string(stored.ResultData))
+}
+
+func TestWaitForTask(t *testing.T) {
+	setupRetryTestDB()
+	mr := setupRetryTestRedis()
+	defer mr.Close()
+
+	task := models.Task{CreatorID: 7, Status: models.TaskStatusProcessing, InputData: datatypes.JSON(`{}`)}
+	require.NoError(t, database.DB.Create(&task).Error)
+
+	// Still running when the time is up
+	detail, finished, err := WaitForTask(context.Background(), task.ID, 7, 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.False(t, finished)
+	assert.Equal(t, models.TaskStatusProcessing, detail.Status)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		database.DB.Model(&models.Task{}).Where("id = ?", task.ID).Update("status", models.TaskStatusCompleted)
+	}()
+	detail, finished, err = WaitForTask(context.Background(), task.ID, 7, 5*time.Second)
+	require.NoError(t, err)
+	assert.True(t, finished)
+	assert.Equal(t, models.TaskStatusCompleted, detail.Status)
+
+	// A client that goes away stops the wait long before the timeout
+	running := models.Task{CreatorID: 7, Status: models.TaskStatusProcessing, InputData: datatypes.JSON(`{}`)}
+	require.NoError(t, database.DB.Create(&running).Error)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	started := time.Now()
+	_, finished, err = WaitForTask(ctx, running.ID, 7, 5*time.Second)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, finished)
+	assert.Less(t, time.Since(started), 2*time.Second)
+}
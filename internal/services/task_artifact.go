@@ -0,0 +1,270 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Decoders for reading image dimensions
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ArtifactsOutputKey is the executor output entry holding every output file of a
+// task as []models.TaskArtifact. oss_url stays the URL of the first one.
+const ArtifactsOutputKey = "artifacts"
+
+const (
+	// sniffLen is how much of a file http.DetectContentType looks at
+	sniffLen = 512
+
+	// maxArtifactSize caps an output file, so a download cannot fill the disk
+	maxArtifactSize = 1 << 30
+)
+
+// errArtifactTooLarge is returned for an output file larger than maxArtifactSize
+var errArtifactTooLarge = fmt.Errorf("output file is larger than %d bytes", maxArtifactSize)
+
+// artifactClient downloads output files. Their URLs come from upstream responses, which
+// can echo a URL the user sent, so like webhookClient it refuses internal addresses when
+// it dials. Redirects are followed, since every hop is dialed the same way.
+var artifactClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: webhookTimeout, Control: webhookDialControl}).DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// artifactSource is an output file reported by an upstream service, with whatever
+// metadata it gave about it
+type artifactSource struct {
+	URL      string
+	Width    int
+	Height   int
+	Duration float64
+}
+
+// artifactSourceFrom reads the file URL under urlKey and the optional width, height
+// and duration of an upstream output entry
+func artifactSourceFrom(entry map[string]interface{}, urlKey string) (artifactSource, bool) {
+	u, _ := entry[urlKey].(string)
+	if u == "" {
+		return artifactSource{}, false
+	}
+	return artifactSource{
+		URL:      u,
+		Width:    int(numberField(entry, "width")),
+		Height:   int(numberField(entry, "height")),
+		Duration: numberField(entry, "duration"),
+	}, true
+}
+
+// numberField reads a number that may be encoded as a JSON number or string
+func numberField(m map[string]interface{}, key string) float64 {
+	switch v := m[key].(type) {
+	case float64:
+		return v
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+// artifactExt returns the file extension of a URL path, or fallback when it has none
+func artifactExt(fileURL, fallback string) string {
+	p := fileURL
+	if u, err := url.Parse(fileURL); err == nil {
+		p = u.Path
+	}
+	if ext := path.Ext(p); ext != "" && len(ext) < 10 {
+		return ext
+	}
+	return fallback
+}
+
+// headWriter keeps the first sniffLen bytes written to it
+type headWriter struct {
+	head []byte
+}
+
+func (w *headWriter) Write(b []byte) (int, error) {
+	if rest := sniffLen - len(w.head); rest > 0 {
+		if len(b) < rest {
+			rest = len(b)
+		}
+		w.head = append(w.head, b[:rest]...)
+	}
+	return len(b), nil
+}
+
+// fetchArtifact downloads an output file, uploads it to OSS under ossKey and
+// describes it. Image dimensions are read from the file; other metadata comes from upstream.
+func fetchArtifact(ctx context.Context, uploader func(localPath, objectKey string) (string, error), src artifactSource, ossKey string) (*models.TaskArtifact, error) {
+	fmt.Printf("Downloading file from %s...\n", src.URL)
+	req, err := http.NewRequestWithContext(ctx, "GET", src.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %v", err)
+	}
+	resp, err := artifactClient.Do(req)
+	if errors.Is(err, errWebhookAddressBlocked) {
+		return nil, Terminal(fmt.Errorf("failed to download file: %w", err))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("failed to download file: status %d", resp.StatusCode)
+	}
+
+	return storeArtifact(uploader, resp.Body, resp.Header.Get("Content-Type"), src, ossKey)
+}
+
+// storeArtifact uploads the content of an output file to OSS under ossKey and describes it.
+// Files larger than maxArtifactSize are refused.
+func storeArtifact(uploader func(localPath, objectKey string) (string, error), body io.Reader, declaredType string, src artifactSource, ossKey string) (*models.TaskArtifact, error) {
+	tmpName := filepath.Join(os.TempDir(), fmt.Sprintf("%s_%s", uuid.New().String(), path.Base(ossKey)))
+	out, err := os.Create(tmpName)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpName)
+
+	hash := sha256.New()
+	head := &headWriter{}
+	size, err := io.Copy(io.MultiWriter(out, hash, head), io.LimitReader(body, maxArtifactSize+1))
+	out.Close()
+	if err != nil {
+		return nil, err
+	}
+	if size > maxArtifactSize {
+		return nil, Terminal(errArtifactTooLarge)
+	}
+
+	ossURL, err := uploader(tmpName, ossKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload to oss: %v", err)
+	}
+
+	artifact := &models.TaskArtifact{
+		URL:         ossURL,
+		OSSKey:      ossKey,
+		OriginalURL: src.URL,
+		MimeType:    artifactMimeType(declaredType, head.head, ossKey),
+		Size:        size,
+		Checksum:    hex.EncodeToString(hash.Sum(nil)),
+		Width:       src.Width,
+		Height:      src.Height,
+		Duration:    src.Duration,
+	}
+	if strings.HasPrefix(artifact.MimeType, "image/") && artifact.Width == 0 {
+		if f, err := os.Open(tmpName); err == nil {
+			if cfg, _, err := image.DecodeConfig(f); err == nil {
+				artifact.Width, artifact.Height = cfg.Width, cfg.Height
+			}
+			f.Close()
+		}
+	}
+	return artifact, nil
+}
+
+// artifactMimeType prefers the type the server declared, then the content, then the extension
+func artifactMimeType(declared string, head []byte, name string) string {
+	if t, _, err := mime.ParseMediaType(declared); err == nil && t != "application/octet-stream" {
+		return t
+	}
+	if len(head) > 0 {
+		if t := http.DetectContentType(head); !strings.HasPrefix(t, "application/octet-stream") && !strings.HasPrefix(t, "text/plain") {
+			t, _, _ = mime.ParseMediaType(t)
+			return t
+		}
+	}
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		t, _, _ = mime.ParseMediaType(t)
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// outputArtifacts returns the artifacts of an executor output. Executors that only
+// report a single result URL get one artifact for it.
+func outputArtifacts(output map[string]interface{}) []models.TaskArtifact {
+	if artifacts, ok := output[ArtifactsOutputKey].([]models.TaskArtifact); ok {
+		return artifacts
+	}
+	u, _ := output["oss_url"].(string)
+	if u == "" {
+		u, _ = output["result_url"].(string)
+	}
+	if u == "" {
+		return nil
+	}
+	original, _ := output["original_url"].(string)
+	return []models.TaskArtifact{{URL: u, OriginalURL: original}}
+}
+
+// replaceTaskArtifactsTx stores the artifacts of the latest run of a task, dropping those of earlier runs
+func replaceTaskArtifactsTx(tx *gorm.DB, taskID uint, artifacts []models.TaskArtifact) error {
+	if err := tx.Where("task_id = ?", taskID).Delete(&models.TaskArtifact{}).Error; err != nil {
+		return err
+	}
+	if len(artifacts) == 0 {
+		return nil
+	}
+	for i := range artifacts {
+		artifacts[i].ID = 0
+		artifacts[i].TaskID = taskID
+		artifacts[i].Position = i
+	}
+	return tx.Create(&artifacts).Error
+}
+
+// GetTaskArtifacts returns the output files of a task, primary first
+func GetTaskArtifacts(taskID uint) ([]models.TaskArtifact, error) {
+	var artifacts []models.TaskArtifact
+	err := database.DB.Where("task_id = ?", taskID).Order("position").Find(&artifacts).Error
+	return artifacts, err
+}
+
+// TaskDetail is a task with all of its output files
+type TaskDetail struct {
+	models.Task
+	Artifacts []models.TaskArtifact `json:"artifacts"`
+}
+
+// GetTaskDetail returns a task and its artifacts
+func GetTaskDetail(id uint) (*TaskDetail, error) {
+	task, err := GetTaskByID(id)
+	if err != nil {
+		return nil, err
+	}
+	artifacts, err := GetTaskArtifacts(id)
+	if err != nil {
+		return nil, err
+	}
+	if artifacts == nil {
+		artifacts = []models.TaskArtifact{}
+	}
+	return &TaskDetail{Task: *task, Artifacts: artifacts}, nil
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompleteTaskStoresArtifacts(t *testing.T) {
+	setupRetryTestDB()
+	mr := setupRetryTestRedis()
+	defer mr.Close()
+
+	task := models.Task{CreatorID: 1, Status: models.TaskStatusProcessing}
+	require.NoError(t, database.DB.Create(&task).Error)
+
+	completeTask(&task, map[string]interface{}{
+		"oss_url": "https://oss/1.png",
+		ArtifactsOutputKey: []models.TaskArtifact{
+			{URL: "https://oss/1.png", MimeType: "image/png", Width: 64, Height: 64},
+			{URL: "https://oss/2.png", MimeType: "image/png"},
+			{URL: "https://oss/3.png", MimeType: "image/png"},
+		},
+	})
+
+	detail, err := GetTaskDetail(task.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.TaskStatusCompleted, detail.Status)
+	assert.Equal(t, "https://oss/1.png", detail.ResultURL)
+	require.Len(t, detail.Artifacts, 3)
+	for i, a := range detail.Artifacts {
+		assert.Equal(t, i, a.Position)
+		assert.Equal(t, task.ID, a.TaskID)
+	}
+	assert.Equal(t, 64, detail.Artifacts[0].Width)
+	assert.Equal(t, "https://oss/3.png", detail.Artifacts[2].URL)
+
+	// A later run replaces the artifacts of the earlier one; a bare result URL becomes the only artifact
+	database.DB.Model(&task).Update("status", models.TaskStatusProcessing)
+	task.Status = models.TaskStatusProcessing
+	completeTask(&task, map[string]interface{}{"result_url": "https://upstream/only.mp4", "original_url": "https://upstream/only.mp4"})
+
+	artifacts, err := GetTaskArtifacts(task.ID)
+	require.NoError(t, err)
+	require.Len(t, artifacts, 1)
+	assert.Equal(t, "https://upstream/only.mp4", artifacts[0].URL)
+}
+
+func TestArtifactMimeType(t *testing.T) {
+	assert.Equal(t, "video/mp4", artifactMimeType("video/mp4; codecs=avc1", nil, "a.bin"))
+	assert.Equal(t, "image/png", artifactMimeType("application/octet-stream", []byte("\x89PNG\r\n\x1a\n"), "a.bin"))
+	assert.Equal(t, "audio/mpeg", artifactMimeType("", []byte("plain"), "tasks/a.mp3"))
+	assert.Equal(t, "application/octet-stream", artifactMimeType("", nil, "tasks/a"))
+}
+
+func TestFetchArtifactBlocksInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+
+	uploaded := false
+	uploader := func(localPath, objectKey string) (string, error) {
+		uploaded = true
+		return "https://oss/" + objectKey, nil
+	}
+
+	// A URL echoed back by a model must not reach the internal network
+	_, err := fetchArtifact(context.Background(), uploader, artifactSource{URL: server.URL + "/secret"}, "tasks/1/0.bin")
+	assert.ErrorIs(t, err, errWebhookAddressBlocked)
+	assert.False(t, IsRetryable(err))
+	assert.False(t, uploaded)
+}
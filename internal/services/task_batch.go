@@ -0,0 +1,261 @@
+package services
+
+import (
+	"aigentools-backend/config"
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxTaskBatchSize is the largest number of tasks accepted in one batch
+const MaxTaskBatchSize = 100
+
+var (
+	ErrInvalidBatchItem = errors.New("invalid batch item")
+	ErrBatchSize        = fmt.Errorf("a batch must contain between 1 and %d tasks", MaxTaskBatchSize)
+)
+
+// TaskBatchProgress counts the tasks of a batch by stage
+type TaskBatchProgress struct {
+	Total      int `json:"total"`
+	Waiting    int `json:"waiting"` // Pending audit, scheduled or queued
+	Processing int `json:"processing"`
+	Completed  int `json:"completed"`
+	Failed     int `json:"failed"`
+	Cancelled  int `json:"cancelled"`
+}
+
+// TaskBatchDetail is a batch with its derived status, progress and tasks
+type TaskBatchDetail struct {
+	models.TaskBatch
+	Status   models.TaskBatchStatus `json:"status"`
+	Progress TaskBatchProgress      `json:"progress"`
+	Tasks    []models.Task          `json:"tasks"`
+}
+
+// TaskBatchCancelResult reports what cancelling a batch did
+type TaskBatchCancelResult struct {
+	Cancelled int          `json:"cancelled"` // Tasks cancelled before they started
+	Refunded  money.Amount `json:"refunded"`  // Total amount returned to the creator
+	Running   int          `json:"running"`   // Tasks already running, left to finish
+}
+
+// CreateTaskBatch validates every input against its model and creates the tasks under one
+// TaskBatch in a single transaction, holding the price of each. Either all tasks are
+// created and held for, or none.
+func CreateTaskBatch(inputs []map[string]interface{}, creatorID uint, creatorName string, scheduledAt *time.Time, callbackURL string) (*TaskBatchDetail, error) {
+	cfg, _ := config.LoadConfig()
+
+	if len(inputs) == 0 || len(inputs) > MaxTaskBatchSize {
+		return nil, ErrBatchSize
+	}
+	if scheduledAt != nil && !scheduledAt.After(time.Now()) {
+		return nil, ErrScheduleInPast
+	}
+	if callbackURL != "" {
+		if err := validateWebhookURL(callbackURL); err != nil {
+			return nil, err
+		}
+	}
+
+	// 1. Check every model and price before touching the balance
+	quotes := make([]*TaskQuote, len(inputs))
+	var total money.Amount
+	inputErr := &TaskInputError{}
+	for i, input := range inputs {
+		model, err := resolveTaskModel(input)
+		if err != nil {
+			return nil, fmt.Errorf("%w %d: %v", ErrInvalidBatchItem, i, err)
+		}
+		// Report the field errors of every item at once
+		var itemErr *TaskInputError
+		if err := validateTaskInput(model, input, fmt.Sprintf("items[%d].body.", i), nil); errors.As(err, &itemErr) {
+			inputErr.Errors = append(inputErr.Errors, itemErr.Errors...)
+		}
+		if itemErr != nil {
+			continue
+		}
+		quote, err := quoteTask(model, input)
+		if err != nil {
+			return nil, fmt.Errorf("%w %d: %w", ErrInvalidBatchItem, i, err)
+		}
+		quotes[i] = quote
+		total += quote.Price
+	}
+	if len(inputErr.Errors) > 0 {
+		return nil, inputErr
+	}
+
+	// 2. Create the batch and the tasks together, holding the price of each task
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	batch := models.TaskBatch{
+		CreatorID:   creatorID,
+		CreatorName: creatorName,
+		TaskCount:   len(inputs),
+		TotalCost:   total,
+	}
+	if err := tx.Create(&batch).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tasks := make([]models.Task, 0, len(inputs))
+	for i, input := range inputs {
+		task, err := newTask(tx, cfg, input, creatorID, creatorName, quotes[i], scheduledAt)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		task.BatchID = &batch.ID
+		task.CallbackURL = callbackURL
+		if err := insertTaskTx(tx, task); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if err := holdTaskCostTx(tx, task, fmt.Sprintf("Create task %d of batch %d", task.ID, batch.ID)); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		tasks = append(tasks, *task)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	// Invalidate user cache to ensure balance is updated
+	if database.RedisClient != nil {
+		database.RedisClient.Del(database.Ctx, fmt.Sprintf("user:%d", creatorID))
+	}
+
+	detail := newTaskBatchDetail(batch, tasks)
+
+	// Tasks that fail to queue are queued by the scheduler later, see requeueStrandedTasks
+	for i := range tasks {
+		if tasks[i].Status != models.TaskStatusPendingExecution {
+			continue
+		}
+		if err := Queue.Enqueue(&tasks[i]); err != nil {
+			fmt.Printf("Failed to queue task %d of batch %d: %v\n", tasks[i].ID, batch.ID, err)
+		}
+	}
+	return detail, nil
+}
+
+// GetTaskBatch returns a batch with its tasks and derived progress
+func GetTaskBatch(id uint) (*TaskBatchDetail, error) {
+	var batch models.TaskBatch
+	if err := database.DB.First(&batch, id).Error; err != nil {
+		return nil, err
+	}
+
+	var tasks []models.Task
+	if err := database.DB.Where("batch_id = ?", id).Order("id").Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	return newTaskBatchDetail(batch, tasks), nil
+}
+
+// CancelTaskBatch cancels and refunds every task of a batch that has not started yet.
+// Tasks already running are left to finish; they can still be cancelled one by one.
+func CancelTaskBatch(id uint, userID uint) (*TaskBatchCancelResult, error) {
+	var batch models.TaskBatch
+	if err := database.DB.First(&batch, id).Error; err != nil {
+		return nil, err
+	}
+	if batch.CreatorID != userID {
+		return nil, errors.New("unauthorized to cancel this batch")
+	}
+
+	var tasks []models.Task
+	if err := database.DB.Where("batch_id = ? AND status IN ?", id, waitingTaskStatuses).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+
+	result := &TaskBatchCancelResult{}
+	for i := range tasks {
+		task := &tasks[i]
+		err := transitionWithRefund(task, models.TaskStatusCancelled, UserActor(userID), fmt.Sprintf("batch %d cancelled by user", id),
+			fmt.Sprintf("Refund for task %d cancellation", task.ID))
+		if err != nil {
+			// Picked up by a worker in the meantime
+			if !errors.Is(err, ErrTransitionConflict) {
+				fmt.Printf("Failed to cancel task %d of batch %d: %v\n", task.ID, id, err)
+			}
+			continue
+		}
+		// A worker may have just dequeued it
+		publishCancel(task.ID)
+
+		result.Cancelled++
+		if task.RefundedAt != nil {
+			result.Refunded += task.Cost
+		}
+	}
+
+	if err := database.DB.Model(&models.TaskBatch{}).
+		Where("id = ?", id).
+		Update("cancelled_at", time.Now()).Error; err != nil {
+		return nil, err
+	}
+
+	var running int64
+	if err := database.DB.Model(&models.Task{}).
+		Where("batch_id = ? AND status = ?", id, models.TaskStatusProcessing).
+		Count(&running).Error; err != nil {
+		return nil, err
+	}
+	result.Running = int(running)
+	return result, nil
+}
+
+func newTaskBatchDetail(batch models.TaskBatch, tasks []models.Task) *TaskBatchDetail {
+	progress := TaskBatchProgress{Total: len(tasks)}
+	for _, t := range tasks {
+		switch t.Status {
+		case models.TaskStatusProcessing:
+			progress.Processing++
+		case models.TaskStatusCompleted:
+			progress.Completed++
+		case models.TaskStatusFailed:
+			progress.Failed++
+		case models.TaskStatusCancelled:
+			progress.Cancelled++
+		default:
+			progress.Waiting++
+		}
+	}
+
+	return &TaskBatchDetail{
+		TaskBatch: batch,
+		Status:    batchStatus(progress),
+		Progress:  progress,
+		Tasks:     tasks,
+	}
+}
+
+// batchStatus derives the status of a batch from the stages of its tasks
+func batchStatus(p TaskBatchProgress) models.TaskBatchStatus {
+	finished := p.Completed + p.Failed + p.Cancelled
+	switch {
+	case finished < p.Total && p.Processing == 0 && finished == 0:
+		return models.TaskBatchStatusPending
+	case finished < p.Total:
+		return models.TaskBatchStatusRunning
+	case p.Completed == p.Total:
+		return models.TaskBatchStatusCompleted
+	case p.Cancelled == p.Total:
+		return models.TaskBatchStatusCancelled
+	default:
+		return models.TaskBatchStatusFinished
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateTaskBatch_AllOrNothing(t *testing.T) {
+	setupPaymentTestDB()
+	mr := setupPaymentTestRedis()
+	defer mr.Close()
+
+	model := models.AIModel{Name: "Batch Model", Price: money.MustParse("10"), Status: models.AIModelStatusOpen}
+	database.DB.Create(&model)
+	user := models.User{Username: "batcher", Balance: money.MustParse("25"), Version: 1, IsActive: true}
+	database.DB.Create(&user)
+
+	item := map[string]interface{}{"model_id": float64(model.ID), "prompt": "variant"}
+
+	// One invalid item rejects the whole batch
+	_, err := CreateTaskBatch([]map[string]interface{}{item, {"prompt": "no model"}}, user.ID, user.Username, nil, "")
+	assert.ErrorIs(t, err, ErrInvalidBatchItem)
+
+	// An item that cannot be priced rejects the batch with the pricing error
+	unpriced := models.AIModel{Name: "Unpriced Model", Price: money.MustParse("-1"), Status: models.AIModelStatusOpen}
+	database.DB.Create(&unpriced)
+	_, err = CreateTaskBatch([]map[string]interface{}{item, {"model_id": float64(unpriced.ID)}}, user.ID, user.Username, nil, "")
+	assert.ErrorIs(t, err, ErrInvalidBatchItem)
+	assert.ErrorIs(t, err, ErrPricing)
+
+	// Not enough balance for the total: nothing is created
+	_, err = CreateTaskBatch([]map[string]interface{}{item, item, item}, user.ID, user.Username, nil, "")
+	assert.Error(t, err)
+
+	var count int64
+	database.DB.Model(&models.Task{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+	database.DB.Model(&models.TaskBatch{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+	var stored models.User
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("25"), stored.Balance)
+
+	// Enough balance: one hold per task
+	batch, err := CreateTaskBatch([]map[string]interface{}{item, item}, user.ID, user.Username, nil, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, batch.TaskCount)
+	assert.Equal(t, money.MustParse("20"), batch.TotalCost)
+	assert.Len(t, batch.Tasks, 2)
+	assert.Equal(t, models.TaskBatchStatusPending, batch.Status)
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("25"), stored.Balance)
+	assert.Equal(t, money.MustParse("20"), stored.HeldAmount)
+	database.DB.Model(&models.Transaction{}).Where("user_id = ? AND type = ?", user.ID, models.TransactionTypeHold).Count(&count)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestCancelTaskBatch(t *testing.T) {
+	setupPaymentTestDB()
+	mr := setupPaymentTestRedis()
+	defer mr.Close()
+
+	model := models.AIModel{Name: "Batch Model", Price: money.MustParse("10"), Status: models.AIModelStatusOpen}
+	database.DB.Create(&model)
+	user := models.User{Username: "batcher", Balance: money.MustParse("100"), Version: 1, IsActive: true}
+	database.DB.Create(&user)
+
+	item := map[string]interface{}{"model_id": float64(model.ID)}
+	batch, err := CreateTaskBatch([]map[string]interface{}{item, item, item}, user.ID, user.Username, nil, "")
+	assert.NoError(t, err)
+
+	// The first task has started running
+	running := batch.Tasks[0]
+	running.Status = models.TaskStatusProcessing
+	database.DB.Save(&running)
+
+	detail, err := GetTaskBatch(batch.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.TaskBatchStatusRunning, detail.Status)
+	assert.Equal(t, 1, detail.Progress.Processing)
+	assert.Equal(t, 2, detail.Progress.Waiting)
+
+	_, err = CancelTaskBatch(batch.ID, 999)
+	assert.Error(t, err)
+
+	result, err := CancelTaskBatch(batch.ID, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Cancelled)
+	assert.Equal(t, money.MustParse("20"), result.Refunded)
+	assert.Equal(t, 1, result.Running)
+
+	var stored models.User
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("100"), stored.Balance)
+	assert.Equal(t, money.MustParse("10"), stored.HeldAmount)
+
+	// Cancelling again refunds nothing more
+	result, err = CancelTaskBatch(batch.ID, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Cancelled)
+
+	running.Status = models.TaskStatusCompleted
+	database.DB.Save(&running)
+	detail, _ = GetTaskBatch(batch.ID)
+	assert.Equal(t, models.TaskBatchStatusFinished, detail.Status)
+	assert.NotNil(t, detail.CancelledAt)
+}
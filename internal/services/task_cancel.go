@@ -0,0 +1,196 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TaskCancelChannel is the Redis pub/sub channel announcing cancelled task IDs to every instance
+const TaskCancelChannel = "task_cancel"
+
+const upstreamCancelTimeout = 30 * time.Second
+
+// ErrCancelNotSupported is returned by a Canceler when the task's upstream has no cancel endpoint
+var ErrCancelNotSupported = errors.New("upstream does not support cancellation")
+
+// Canceler is implemented by executors that can stop a submitted task upstream
+type Canceler interface {
+	Cancel(ctx context.Context, task *models.Task) error
+}
+
+// inflightTasks tracks the contexts of tasks this instance is submitting or polling,
+// so a cancellation can interrupt them
+type inflightTasks struct {
+	mu      sync.Mutex
+	nextID  uint64
+	cancels map[uint]map[uint64]context.CancelFunc
+}
+
+var inflight = &inflightTasks{cancels: make(map[uint]map[uint64]context.CancelFunc)}
+
+// watch returns a context that is cancelled when the task is cancelled, and a function
+// to call once the work on the task is done
+func (r *inflightTasks) watch(taskID uint) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r.mu.Lock()
+	r.nextID++
+	id := r.nextID
+	if r.cancels[taskID] == nil {
+		r.cancels[taskID] = make(map[uint64]context.CancelFunc)
+	}
+	r.cancels[taskID][id] = cancel
+	r.mu.Unlock()
+
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.cancels[taskID], id)
+		if len(r.cancels[taskID]) == 0 {
+			delete(r.cancels, taskID)
+		}
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel interrupts all local work on a task and reports how many contexts were cancelled
+func (r *inflightTasks) cancel(taskID uint) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, cancel := range r.cancels[taskID] {
+		cancel()
+	}
+	return len(r.cancels[taskID])
+}
+
+// publishCancel interrupts work on a task on this and every other instance
+func publishCancel(taskID uint) {
+	inflight.cancel(taskID)
+	if database.RedisClient == nil {
+		return
+	}
+	if err := database.RedisClient.Publish(database.Ctx, TaskCancelChannel, taskID).Err(); err != nil {
+		fmt.Printf("Failed to publish cancellation of task %d: %v\n", taskID, err)
+	}
+}
+
+// StartCancelListener interrupts local work on tasks cancelled by any instance. It blocks forever.
+func StartCancelListener() {
+	sub := database.RedisClient.Subscribe(database.Ctx, TaskCancelChannel)
+	defer sub.Close()
+
+	for msg := range sub.Channel() {
+		id, err := strconv.ParseUint(msg.Payload, 10, 64)
+		if err != nil {
+			continue
+		}
+		if n := inflight.cancel(uint(id)); n > 0 {
+			fmt.Printf("Interrupted %d running operation(s) of cancelled task %d\n", n, id)
+		}
+	}
+}
+
+// cancelUpstream asks the executor to stop a submitted task. It is best effort:
+// the task is already cancelled locally and failures are only logged.
+func cancelUpstream(task models.Task) {
+	if task.RemoteTaskID == "" {
+		return
+	}
+	canceler, ok := getExecutor(resolveExecutorName(&task)).(Canceler)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamCancelTimeout)
+	defer cancel()
+	if err := canceler.Cancel(ctx, &task); err != nil && !errors.Is(err, ErrCancelNotSupported) {
+		fmt.Printf("Failed to cancel task %d upstream: %v\n", task.ID, err)
+	}
+}
+
+// refundTaskTx returns the cost of a task to its creator inside tx: its hold is released,
+// or for tasks paid up-front before holds existed, the cost is credited back. The refund
+// is claimed by setting RefundedAt with a conditional update, so it happens at most
+// once however many components finalize the task.
+func refundTaskTx(tx *gorm.DB, task *models.Task, reason string) error {
+	if task.Cost <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	res := tx.Model(&models.Task{}).Where("id = ? AND refunded_at IS NULL", task.ID).Update("refunded_at", now)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		// Already refunded
+		return nil
+	}
+
+	if task.HoldID != nil {
+		if err := releaseTaskHoldTx(tx, task, reason); err != nil {
+			return err
+		}
+	} else {
+		_, err := AdjustBalanceTx(tx, task.CreatorID, task.Cost, reason, TransactionMetadata{
+			Operator: "system",
+			Type:     models.TransactionTypeUserRefund,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	task.RefundedAt = &now
+	return nil
+}
+
+// refundAttempts is how often transitionWithRefund tries again when a concurrent balance
+// change wins the optimistic lock of the refund
+const refundAttempts = 3
+
+// transitionWithRefund moves a task to a final status and refunds its cost in the same
+// transaction. A refund losing the optimistic lock on the creator's balance is tried
+// again; if it still fails, the task keeps its status and the error is returned, so the
+// caller leaves the task to be redelivered rather than finalized without its refund.
+func transitionWithRefund(task *models.Task, to models.TaskStatus, actor, reason, refundReason string) error {
+	from := task.Status
+	before := *task
+	var err error
+	for attempt := 1; attempt <= refundAttempts; attempt++ {
+		err = database.DB.Transaction(func(tx *gorm.DB) error {
+			if err := TransitionTaskTx(tx, task, to, actor, reason); err != nil {
+				return err
+			}
+			return refundTaskTx(tx, task, refundReason)
+		})
+		if err == nil {
+			break
+		}
+		// The transaction rolled back, so neither change was made
+		*task = before
+		if !errors.Is(err, ErrOptimisticLock) {
+			break
+		}
+	}
+	if err != nil {
+		if !isTransitionRejected(err) {
+			fmt.Printf("Refund failed for task %d, leaving it unfinished: %v\n", task.ID, err)
+		}
+		return err
+	}
+
+	if task.RefundedAt != nil && database.RedisClient != nil {
+		// Invalidate user cache to ensure balance is updated
+		database.RedisClient.Del(database.Ctx, fmt.Sprintf("user:%d", task.CreatorID))
+	}
+	runTaskTransitionHooks(task, from, to)
+	return nil
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/datatypes"
+)
+
+func TestCancelTask_InterruptsAndRefundsOnce(t *testing.T) {
+	setupPaymentTestDB()
+	mr := setupPaymentTestRedis()
+	defer mr.Close()
+
+	cancelled := make(chan string, 1)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancelled <- r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockServer.Close()
+
+	user := models.User{Username: "cancel_user", Balance: money.MustParse("90"), TotalConsumed: money.MustParse("10"), Version: 1, IsActive: true}
+	database.DB.Create(&user)
+
+	raw, _ := json.Marshal(map[string]interface{}{
+		"executor":            "remote_api",
+		"target_url":          mockServer.URL + "/submit",
+		"cancel_url_template": mockServer.URL + "/cancel/%s",
+	})
+	task := models.Task{
+		InputData:    datatypes.JSON(raw),
+		CreatorID:    user.ID,
+		Status:       models.TaskStatusProcessing,
+		RemoteTaskID: "remote-7",
+		Cost:         money.MustParse("10"),
+		MaxRetries:   3,
+	}
+	database.DB.Create(&task)
+
+	// A poller is working on the task, holding a copy loaded before the cancel
+	ctx, done := inflight.watch(task.ID)
+	defer done()
+	stale := task
+
+	result, err := CancelTask(task.ID, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.TaskStatusCancelled, result.Status)
+	assert.NotNil(t, result.RefundedAt)
+
+	// Local work is interrupted and the upstream job is cancelled
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("running poll was not interrupted")
+	}
+	select {
+	case path := <-cancelled:
+		assert.Equal(t, "/cancel/remote-7", path)
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream cancel was not called")
+	}
+
+	// The poller reaching a final state afterwards neither wins nor refunds again
+	stale.RetryCount = stale.MaxRetries
+	failTask(&stale, errors.New("remote task failed"))
+
+	var updatedUser models.User
+	database.DB.First(&updatedUser, user.ID)
+	assert.Equal(t, money.MustParse("100"), updatedUser.Balance)
+	assert.Equal(t, money.MustParse("0"), updatedUser.TotalConsumed)
+
+	var refunds int64
+	database.DB.Model(&models.Transaction{}).Where("user_id = ? AND type = ?", user.ID, models.TransactionTypeUserRefund).Count(&refunds)
+	assert.Equal(t, int64(1), refunds)
+
+	var stored models.Task
+	database.DB.First(&stored, task.ID)
+	assert.Equal(t, models.TaskStatusCancelled, stored.Status)
+
+	// Cancelling again is rejected
+	_, err = CancelTask(task.ID, user.ID)
+	assert.EqualError(t, err, "task cannot be cancelled in its current state")
+}
+
+func TestTransitionWithRefund_KeepsTaskWhenRefundFails(t *testing.T) {
+	setupPaymentTestDB()
+	mr := setupPaymentTestRedis()
+	defer mr.Close()
+
+	user := models.User{Username: "refund_user", Balance: money.MustParse("100"), Version: 1, IsActive: true}
+	database.DB.Create(&user)
+	missingHold := uint(999)
+	task := models.Task{CreatorID: user.ID, Status: models.TaskStatusProcessing, Cost: money.MustParse("10"), HoldID: &missingHold}
+	database.DB.Create(&task)
+
+	// The hold cannot be released, so the task is not failed without its refund
+	failTask(&task, errors.New("remote task failed"))
+	assert.Equal(t, models.TaskStatusProcessing, task.Status)
+	assert.Nil(t, task.RefundedAt)
+
+	var stored models.Task
+	database.DB.First(&stored, task.ID)
+	assert.Equal(t, models.TaskStatusProcessing, stored.Status)
+	assert.Nil(t, stored.RefundedAt)
+}
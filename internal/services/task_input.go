@@ -0,0 +1,82 @@
+package services
+
+import (
+	"aigentools-backend/internal/models"
+	"aigentools-backend/internal/utils"
+	"fmt"
+	"strings"
+)
+
+// TaskInputError lists the fields of a task body that do not match the request
+// parameters of its model
+type TaskInputError struct {
+	Errors []utils.ValidationErrorDetail
+}
+
+func (e *TaskInputError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, detail := range e.Errors {
+		messages[i] = detail.Message
+	}
+	return "invalid task input: " + strings.Join(messages, "; ")
+}
+
+// taskRequestBody returns the part of a task input sent upstream: the data section
+// when there is one, otherwise the input itself. The prefix names it in field errors.
+func taskRequestBody(input map[string]interface{}) (map[string]interface{}, string) {
+	if data, ok := input["data"].(map[string]interface{}); ok {
+		return data, "data."
+	}
+	return input, ""
+}
+
+// validateTaskInput checks a task input against the request_body parameters of its
+// model before anything is charged, and fills in the default of every missing optional
+// parameter. Models without parameter definitions accept any input. Values for which
+// unresolved returns true are only known later and count as present.
+func validateTaskInput(model *models.AIModel, input map[string]interface{}, fieldPrefix string, unresolved func(interface{}) bool) error {
+	if len(model.Parameters) == 0 {
+		return nil
+	}
+	params, err := models.ParseModelParameters(model.Parameters)
+	if err != nil || len(params.RequestBody) == 0 {
+		// Definitions that cannot be read were rejected when the model was saved
+		return nil
+	}
+
+	body, bodyPrefix := taskRequestBody(input)
+	var details []utils.ValidationErrorDetail
+	for _, def := range params.RequestBody {
+		field := fieldPrefix + bodyPrefix + def.Name
+		value, present := body[def.Name]
+		if !present || value == nil {
+			if def.IsRequired() {
+				details = append(details, utils.ValidationErrorDetail{
+					Field:    field,
+					Message:  fmt.Sprintf("Field '%s' is required", field),
+					Expected: "not null",
+					Received: nil,
+				})
+			} else if def.Default != nil {
+				body[def.Name] = def.Default
+			}
+			continue
+		}
+		if unresolved != nil && unresolved(value) {
+			continue
+		}
+		if msg, expected := def.CheckValue(value); msg != "" {
+			details = append(details, utils.ValidationErrorDetail{
+				Field:    field,
+				Message:  fmt.Sprintf("Field '%s' %s", field, msg),
+				Expected: expected,
+				Received: value,
+			})
+		}
+	}
+
+	if len(details) > 0 {
+		return &TaskInputError{Errors: details}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// inputTestModel has a required prompt, an enum, a bounded integer and a default
+func inputTestModel(t *testing.T) *models.AIModel {
+	var params models.JSON
+	err := json.Unmarshal([]byte(`{
+		"request_header": [],
+		"request_body": [
+			{"name": "prompt", "type": "string", "required": true, "description": "Prompt", "example": "a cat", "max": 10},
+			{"name": "size", "type": "string", "required": false, "description": "Size", "example": "1024x1024", "enum": ["512x512", "1024x1024"], "default": "1024x1024"},
+			{"name": "steps", "type": "integer", "required": false, "description": "Steps", "example": 20, "min": 1, "max": 50}
+		],
+		"response_parameters": []
+	}`), &params)
+	require.NoError(t, err)
+	require.NoError(t, models.ValidateModelParameters(params))
+	return &models.AIModel{Name: "Input Model", Status: models.AIModelStatusOpen, Price: money.MustParse("10"), Parameters: params}
+}
+
+func inputErrorFields(t *testing.T, err error) map[string]string {
+	var inputErr *TaskInputError
+	require.True(t, errors.As(err, &inputErr), "expected a TaskInputError, got %v", err)
+	fields := make(map[string]string)
+	for _, d := range inputErr.Errors {
+		fields[d.Field] = d.Expected
+	}
+	return fields
+}
+
+func TestValidateTaskInput(t *testing.T) {
+	model := inputTestModel(t)
+
+	// Defaults are filled into the data section
+	input := map[string]interface{}{"data": map[string]interface{}{"prompt": "a cat"}}
+	require.NoError(t, validateTaskInput(model, input, "body.", nil))
+	assert.Equal(t, "1024x1024", input["data"].(map[string]interface{})["size"])
+
+	// Every bad field is reported
+	input = map[string]interface{}{"data": map[string]interface{}{"size": "4k", "steps": 2.5}}
+	fields := inputErrorFields(t, validateTaskInput(model, input, "body.", nil))
+	assert.Equal(t, map[string]string{
+		"body.data.prompt": "not null",
+		"body.data.size":   `one of ["512x512","1024x1024"]`,
+		"body.data.steps":  "integer",
+	}, fields)
+
+	// Flat inputs are checked as a whole, including bounds
+	input = map[string]interface{}{"model_id": 1.0, "prompt": "a very long prompt", "steps": 100.0}
+	fields = inputErrorFields(t, validateTaskInput(model, input, "", nil))
+	assert.Equal(t, map[string]string{"prompt": "max 10 characters", "steps": "max 50"}, fields)
+
+	// Values resolved later are not checked
+	input = map[string]interface{}{"prompt": "{{steps.a.result_url}}", "steps": "{{steps.a.remote_task_id}}"}
+	require.NoError(t, validateTaskInput(model, input, "", func(v interface{}) bool { return len(pipelineRefs(v)) > 0 }))
+
+	// Models without definitions accept anything
+	require.NoError(t, validateTaskInput(&models.AIModel{}, map[string]interface{}{"anything": 1.0}, "", nil))
+}
+
+func TestCreateTask_RejectsInvalidInputWithoutCharging(t *testing.T) {
+	setupPaymentTestDB()
+	mr := setupPaymentTestRedis()
+	defer mr.Close()
+
+	model := inputTestModel(t)
+	require.NoError(t, database.DB.Create(model).Error)
+	user := models.User{Username: "payer", Balance: money.MustParse("100"), Version: 1, IsActive: true}
+	require.NoError(t, database.DB.Create(&user).Error)
+
+	_, err := CreateTask(map[string]interface{}{"model_id": float64(model.ID), "steps": 0.0}, user.ID, user.Username, nil, "", nil)
+	fields := inputErrorFields(t, err)
+	assert.Contains(t, fields, "body.prompt")
+	assert.Contains(t, fields, "body.steps")
+
+	var stored models.User
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("100"), stored.Balance)
+	var count int64
+	database.DB.Model(&models.Task{}).Count(&count)
+	assert.Zero(t, count)
+
+	// Batches report the errors of every item
+	_, err = CreateTaskBatch([]map[string]interface{}{
+		{"model_id": float64(model.ID), "prompt": "ok"},
+		{"model_id": float64(model.ID)},
+	}, user.ID, user.Username, nil, "")
+	fields = inputErrorFields(t, err)
+	assert.Equal(t, map[string]string{"items[1].body.prompt": "not null"}, fields)
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"aigentools-backend/internal/database"
 	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
 	"errors"
 	"testing"
 
@@ -19,8 +20,8 @@ func setupPaymentTestDB() {
 		panic("failed to connect database")
 	}
 
-	db.Migrator().DropTable(&models.User{}, &models.AIModel{}, &models.Task{}, &models.Transaction{})
-	db.AutoMigrate(&models.User{}, &models.AIModel{}, &models.Task{}, &models.Transaction{})
+	db.Migrator().DropTable(&models.User{}, &models.AIModel{}, &models.Task{}, &models.TaskEvent{}, &models.TaskArtifact{}, &models.TaskBatch{}, &models.Pipeline{}, &models.Transaction{}, &models.BalanceHold{})
+	db.AutoMigrate(&models.User{}, &models.AIModel{}, &models.Task{}, &models.TaskEvent{}, &models.TaskArtifact{}, &models.TaskBatch{}, &models.Pipeline{}, &models.Transaction{}, &models.BalanceHold{})
 
 	database.DB = db
 }
@@ -45,7 +46,7 @@ func TestCreateTask_Payment(t *testing.T) {
 	// Seed Model
 	model := models.AIModel{
 		Name:   "Test Model",
-		Price:  10.0,
+		Price:  money.MustParse("10"),
 		Status: models.AIModelStatusOpen,
 	}
 	database.DB.Create(&model)
@@ -53,8 +54,8 @@ func TestCreateTask_Payment(t *testing.T) {
 	// Seed User
 	user := models.User{
 		Username:    "payer",
-		Balance:     100.0,
-		CreditLimit: 0.0,
+		Balance:     money.MustParse("100"),
+		CreditLimit: money.MustParse("0"),
 		Version:     1,
 		IsActive:    true,
 	}
@@ -66,20 +67,24 @@ func TestCreateTask_Payment(t *testing.T) {
 		"prompt":   "test",
 	}
 
-	task, err := CreateTask(inputData, user.ID, user.Username)
+	task, err := CreateTask(inputData, user.ID, user.Username, nil, "", nil)
 	assert.NoError(t, err)
 	assert.NotNil(t, task)
-	assert.Equal(t, 10.0, task.Cost)
+	assert.Equal(t, money.MustParse("10"), task.Cost)
 
-	// Verify Deduction
+	// Verify Hold
 	var updatedUser models.User
 	database.DB.First(&updatedUser, user.ID)
-	assert.Equal(t, 90.0, updatedUser.Balance)
+	assert.Equal(t, money.MustParse("100"), updatedUser.Balance)
+	assert.Equal(t, money.MustParse("10"), updatedUser.HeldAmount)
+	assert.NotNil(t, task.HoldID)
 
 	// Verify Transaction
 	var trans models.Transaction
 	database.DB.Last(&trans)
-	assert.Equal(t, -10.0, trans.Amount)
+	assert.Equal(t, models.TransactionTypeHold, trans.Type)
+	assert.Equal(t, money.MustParse("0"), trans.Amount)
+	assert.Equal(t, money.MustParse("10"), trans.HeldAmount)
 	assert.Equal(t, user.ID, trans.UserID)
 
 	// Case 2: Insufficient Balance but Sufficient Credit
@@ -92,34 +97,36 @@ func TestCreateTask_Payment(t *testing.T) {
 	// Refresh user
 	database.DB.First(&updatedUser, user.ID)
 
-	task2, err := CreateTask(inputData, user.ID, user.Username)
+	task2, err := CreateTask(inputData, user.ID, user.Username, nil, "", nil)
 	assert.NoError(t, err)
 	assert.NotNil(t, task2)
 
 	database.DB.First(&updatedUser, user.ID)
-	assert.Equal(t, -5.0, updatedUser.Balance) // 5 - 10 = -5
+	assert.Equal(t, money.MustParse("5"), updatedUser.Balance)
+	assert.Equal(t, money.MustParse("20"), updatedUser.HeldAmount) // Available = 5 + 50 - 20 = 35
 
 	// Case 3: Insufficient Funds
-	// Update user balance to -45 (Credit used up mostly), Limit 50. Available = -45 + 50 = 5. Price 10.
+	// Update user balance to -25, Limit 50. Available = -25 + 50 - 20 = 5. Price 10.
 	database.DB.Model(&updatedUser).Where("id = ?", user.ID).Updates(map[string]interface{}{
-		"balance": -45.0,
+		"balance": -25.0,
 		"version": updatedUser.Version + 1,
 	})
 
-	task3, err := CreateTask(inputData, user.ID, user.Username)
+	task3, err := CreateTask(inputData, user.ID, user.Username, nil, "", nil)
 	assert.Error(t, err)
 	assert.Nil(t, task3)
 	// ErrInsufficientBalance is not exported or we need to check string
 	assert.Contains(t, err.Error(), "insufficient balance")
 
 	database.DB.First(&updatedUser, user.ID)
-	assert.Equal(t, -45.0, updatedUser.Balance) // Unchanged
+	assert.Equal(t, money.MustParse("-25"), updatedUser.Balance) // Unchanged
+	assert.Equal(t, money.MustParse("20"), updatedUser.HeldAmount)
 
 	// Case 4: Missing Model ID
 	inputDataMissingID := map[string]interface{}{
 		"prompt": "test",
 	}
-	task4, err := CreateTask(inputDataMissingID, user.ID, user.Username)
+	task4, err := CreateTask(inputDataMissingID, user.ID, user.Username, nil, "", nil)
 	assert.Error(t, err)
 	assert.Nil(t, task4)
 	assert.Contains(t, err.Error(), "model_id is required")
@@ -141,15 +148,16 @@ func TestCreateTask_Payment(t *testing.T) {
 			"model_url": "http://example.com/model",
 		},
 	}
-	task5, err := CreateTask(inputDataURL, user.ID, user.Username)
+	task5, err := CreateTask(inputDataURL, user.ID, user.Username, nil, "", nil)
 	assert.NoError(t, err)
 	assert.NotNil(t, task5)
-	assert.Equal(t, 10.0, task5.Cost)
+	assert.Equal(t, money.MustParse("10"), task5.Cost)
 
-	// Verify Deduction
+	// Verify Hold; nothing is consumed before completion
 	database.DB.First(&updatedUser, user.ID)
-	assert.Equal(t, 90.0, updatedUser.Balance)
-	assert.Equal(t, 30.0, updatedUser.TotalConsumed)
+	assert.Equal(t, money.MustParse("100"), updatedUser.Balance)
+	assert.Equal(t, money.MustParse("30"), updatedUser.HeldAmount)
+	assert.Equal(t, money.MustParse("0"), updatedUser.TotalConsumed)
 }
 
 func TestTask_FailureRefund(t *testing.T) {
@@ -160,7 +168,7 @@ func TestTask_FailureRefund(t *testing.T) {
 	// Seed Model
 	model := models.AIModel{
 		Name:   "Refund Test Model",
-		Price:  10.0,
+		Price:  money.MustParse("10"),
 		Status: models.AIModelStatusOpen,
 	}
 	database.DB.Create(&model)
@@ -168,7 +176,7 @@ func TestTask_FailureRefund(t *testing.T) {
 	// Seed User
 	user := models.User{
 		Username: "refund_user",
-		Balance:  100.0,
+		Balance:  money.MustParse("100"),
 		Version:  1,
 		IsActive: true,
 	}
@@ -180,30 +188,35 @@ func TestTask_FailureRefund(t *testing.T) {
 		"prompt":   "test",
 	}
 
-	task, err := CreateTask(inputData, user.ID, user.Username)
+	task, err := CreateTask(inputData, user.ID, user.Username, nil, "", nil)
 	assert.NoError(t, err)
 
-	// 2. Verify Deduction
+	// 2. Verify Hold
 	var updatedUser models.User
 	database.DB.First(&updatedUser, user.ID)
-	assert.Equal(t, 90.0, updatedUser.Balance)
+	assert.Equal(t, money.MustParse("10"), updatedUser.HeldAmount)
 
 	// 3. Simulate Failure
 	// We need to import errors to use errors.New
 	// But errors is standard lib.
 	// Since we are in same package, we can call handleFailure.
 	// But we need to make sure we set max retries.
+	// The worker has picked the task up before it fails
+	task.Status = models.TaskStatusProcessing
+	database.DB.Save(task)
 	task.RetryCount = task.MaxRetries
 	handleFailure(task, errors.New("simulated fatal error"))
 
-	// 4. Verify Refund
+	// 4. Verify Release
 	database.DB.First(&updatedUser, user.ID)
-	assert.Equal(t, 100.0, updatedUser.Balance)
+	assert.Equal(t, money.MustParse("100"), updatedUser.Balance)
+	assert.Equal(t, money.MustParse("0"), updatedUser.HeldAmount)
 
-	// Verify Refund Transaction
+	// Verify Release Transaction
 	var trans models.Transaction
-	database.DB.Where("type = ?", models.TransactionTypeUserRefund).Last(&trans)
-	assert.Equal(t, 10.0, trans.Amount)
+	database.DB.Where("type = ?", models.TransactionTypeHoldRelease).Last(&trans)
+	assert.Equal(t, money.MustParse("0"), trans.Amount)
+	assert.Equal(t, money.MustParse("-10"), trans.HeldAmount)
 	assert.Equal(t, user.ID, trans.UserID)
 	assert.Contains(t, trans.Reason, "Refund")
 }
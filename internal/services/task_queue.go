@@ -0,0 +1,385 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis keys backing the reliable task queue.
+// Ready task IDs wait in one list per user and priority level
+// (task_queue:user:<user id>:<level>). For each level, task_queue:users:<level>
+// is a ring of the users that have tasks waiting at that level. A dequeue picks
+// a level by weight and takes the next task of the next user in its ring, so a
+// user with a thousand waiting tasks gets one turn like everybody else.
+// task_queue:meta remembers the user and level of every queued ID.
+//
+// A dequeued ID is moved atomically to TaskProcessingKey and gets a visibility
+// deadline in TaskLeaseKey (a sorted set scored by unix time). The ID stays
+// there until it is acknowledged, so a worker crash never loses it: the reaper
+// puts expired deliveries back. Retries wait in TaskDelayedKey, scored by the
+// unix time they become due.
+//
+// TaskQueueKey was the single FIFO list used before per-user scheduling; it is
+// drained into the new layout when the worker starts.
+const (
+	TaskQueueKey      = "task_queue"
+	TaskProcessingKey = "task_queue:processing"
+	TaskLeaseKey      = "task_queue:leases"
+	TaskDelayedKey    = "task_queue:delayed"
+	TaskQueueMetaKey  = "task_queue:meta"
+)
+
+// taskQueuePrefix prefixes the per-user lists and per-level rings built inside the scripts
+const taskQueuePrefix = TaskQueueKey + ":"
+
+const defaultVisibilityTimeout = 5 * time.Minute
+
+var ErrQueueEmpty = errors.New("task queue is empty")
+
+// pushReadyLua is shared by the scripts that make an ID ready. It appends the ID to
+// its user's list for its level (or prepends it for redeliveries) and puts the
+// user in the level's ring if the list was empty.
+const pushReadyLua = `
+local function push_ready(prefix, id, front)
+	local meta = redis.call('HGET', prefix .. 'meta', id) or ''
+	local uid, level = string.match(meta, '^(%d+):(%d+)$')
+	if not uid then
+		uid, level = '0', '2'
+	end
+	local list = prefix .. 'user:' .. uid .. ':' .. level
+	local ring = prefix .. 'users:' .. level
+	local push = 'LPUSH'
+	if front then
+		push = 'RPUSH'
+	end
+	if redis.call(push, list, id) == 1 then
+		redis.call(push, ring, uid)
+	end
+end
+`
+
+// enqueueScript records the user and level of an ID and makes it ready.
+var enqueueScript = redis.NewScript(pushReadyLua + `
+redis.call('HSET', ARGV[1] .. 'meta', ARGV[2], ARGV[3])
+push_ready(ARGV[1], ARGV[2], false)
+return 1
+`)
+
+// enqueueMissingScript enqueues an ID like enqueueScript unless it is already waiting,
+// delayed or delivered. It returns 1 when the ID was enqueued.
+var enqueueMissingScript = redis.NewScript(pushReadyLua + `
+if redis.call('HEXISTS', ARGV[1] .. 'meta', ARGV[2]) == 1
+	or redis.call('ZSCORE', KEYS[1], ARGV[2])
+	or redis.call('ZSCORE', KEYS[2], ARGV[2]) then
+	return 0
+end
+redis.call('HSET', ARGV[1] .. 'meta', ARGV[2], ARGV[3])
+push_ready(ARGV[1], ARGV[2], false)
+return 1
+`)
+
+// dequeueScript takes the next task of the next user at the first non-empty level
+// in ARGV[3..], moves it to the processing list and leases it in one step.
+var dequeueScript = redis.NewScript(`
+local prefix = ARGV[2]
+for i = 3, #ARGV do
+	local ring = prefix .. 'users:' .. ARGV[i]
+	local uid = redis.call('RPOP', ring)
+	if uid then
+		local list = prefix .. 'user:' .. uid .. ':' .. ARGV[i]
+		local id = redis.call('RPOP', list)
+		if redis.call('LLEN', list) > 0 then
+			redis.call('LPUSH', ring, uid)
+		end
+		if id then
+			redis.call('LPUSH', KEYS[1], id)
+			redis.call('ZADD', KEYS[2], ARGV[1], id)
+			return id
+		end
+	end
+end
+return false
+`)
+
+// ackScript drops a delivery from the processing list, its lease and its metadata.
+var ackScript = redis.NewScript(`
+redis.call('LREM', KEYS[1], 1, ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+return 1
+`)
+
+// requeueScript acknowledges the current delivery and makes the ID ready again atomically.
+var requeueScript = redis.NewScript(pushReadyLua + `
+redis.call('LREM', KEYS[1], 1, ARGV[2])
+redis.call('ZREM', KEYS[2], ARGV[2])
+push_ready(ARGV[1], ARGV[2], false)
+return 1
+`)
+
+// requeueAfterScript acknowledges the current delivery and schedules the ID for later atomically.
+// A task handed over to polling was acknowledged already and lost its metadata, so the
+// user and level are recorded again unless a priority change set them meanwhile.
+var requeueAfterScript = redis.NewScript(`
+redis.call('LREM', KEYS[1], 1, ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('HSETNX', KEYS[4], ARGV[1], ARGV[3])
+redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+return 1
+`)
+
+// promoteScript makes every delayed ID that is due ready.
+var promoteScript = redis.NewScript(pushReadyLua + `
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+for _, id in ipairs(due) do
+	redis.call('ZREM', KEYS[1], id)
+	push_ready(ARGV[2], id, false)
+end
+return #due
+`)
+
+// reapScript returns every delivery whose lease expired to the front of its user's list.
+var reapScript = redis.NewScript(pushReadyLua + `
+local expired = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+local count = 0
+for _, id in ipairs(expired) do
+	redis.call('ZREM', KEYS[1], id)
+	if redis.call('LREM', KEYS[2], 1, id) > 0 then
+		push_ready(ARGV[2], id, true)
+		count = count + 1
+	end
+end
+return count
+`)
+
+// setPriorityScript changes the level of a queued ID and, if it is waiting, moves it
+// to the list of the new level. It returns 1 when a waiting ID was moved.
+var setPriorityScript = redis.NewScript(pushReadyLua + `
+local prefix = ARGV[1]
+local old = redis.call('HGET', prefix .. 'meta', ARGV[2])
+if not old then
+	return 0
+end
+redis.call('HSET', prefix .. 'meta', ARGV[2], ARGV[3])
+local uid, level = string.match(old, '^(%d+):(%d+)$')
+if not uid then
+	return 0
+end
+local list = prefix .. 'user:' .. uid .. ':' .. level
+if redis.call('LREM', list, 0, ARGV[2]) == 0 then
+	return 0
+end
+if redis.call('LLEN', list) == 0 then
+	redis.call('LREM', prefix .. 'users:' .. level, 0, uid)
+end
+push_ready(prefix, ARGV[2], false)
+return 1
+`)
+
+// taskPriorities lists the levels from highest to lowest. While every level has
+// work, a level is picked with a probability proportional to its value, so high
+// priority tasks get three times the share of low priority ones without starving them.
+var taskPriorities = []models.TaskPriority{models.TaskPriorityHigh, models.TaskPriorityNormal, models.TaskPriorityLow}
+
+// levelOrder returns the levels a dequeue tries, the weighted pick first
+func levelOrder() []interface{} {
+	total := 0
+	for _, p := range taskPriorities {
+		total += int(p)
+	}
+	pick := rand.Intn(total)
+	first := taskPriorities[0]
+	for _, p := range taskPriorities {
+		if pick < int(p) {
+			first = p
+			break
+		}
+		pick -= int(p)
+	}
+
+	order := []interface{}{int(first)}
+	for _, p := range taskPriorities {
+		if p != first {
+			order = append(order, int(p))
+		}
+	}
+	return order
+}
+
+func queueMeta(userID uint, priority models.TaskPriority) string {
+	if !priority.Valid() {
+		priority = models.TaskPriorityNormal
+	}
+	return fmt.Sprintf("%d:%d", userID, priority)
+}
+
+// TaskQueue is an at-least-once, per-user fair task queue on top of Redis lists.
+// Every delivery must be acknowledged with Ack (or handed back with Requeue)
+// once the task reaches a final state; otherwise it is redelivered after
+// VisibilityTimeout.
+type TaskQueue struct {
+	VisibilityTimeout time.Duration
+}
+
+// Queue is the task queue used by the API and the worker.
+var Queue = NewTaskQueue(defaultVisibilityTimeout)
+
+// NewTaskQueue creates a task queue with the given visibility timeout.
+func NewTaskQueue(visibilityTimeout time.Duration) *TaskQueue {
+	if visibilityTimeout <= 0 {
+		visibilityTimeout = defaultVisibilityTimeout
+	}
+	return &TaskQueue{VisibilityTimeout: visibilityTimeout}
+}
+
+// Enqueue makes a task available to the workers, behind the other tasks of its creator at its priority.
+func (q *TaskQueue) Enqueue(task *models.Task) error {
+	return enqueueScript.Run(database.Ctx, database.RedisClient, []string{TaskQueueMetaKey},
+		taskQueuePrefix, task.ID, queueMeta(task.CreatorID, task.Priority)).Err()
+}
+
+// EnqueueMissing enqueues a task that is not in the queue in any form, and reports
+// whether it did. It puts back tasks whose enqueue failed after they were saved.
+func (q *TaskQueue) EnqueueMissing(task *models.Task) (bool, error) {
+	n, err := enqueueMissingScript.Run(database.Ctx, database.RedisClient, []string{TaskDelayedKey, TaskLeaseKey},
+		taskQueuePrefix, task.ID, queueMeta(task.CreatorID, task.Priority)).Int()
+	return n == 1, err
+}
+
+// SetPriority changes the priority of a queued task. A waiting task moves to the back
+// of its creator's list at the new level; a delivered or delayed task keeps the new
+// level for its next delivery. Tasks not in the queue are left alone.
+func (q *TaskQueue) SetPriority(task *models.Task) error {
+	return setPriorityScript.Run(database.Ctx, database.RedisClient, []string{TaskQueueMetaKey},
+		taskQueuePrefix, task.ID, queueMeta(task.CreatorID, task.Priority)).Err()
+}
+
+// Dequeue leases the next ready task: a level is chosen by weight, then the next user
+// in that level's ring gets its oldest task delivered. It returns ErrQueueEmpty when nothing is waiting.
+func (q *TaskQueue) Dequeue() (uint, error) {
+	deadline := time.Now().Add(q.VisibilityTimeout).Unix()
+	args := append([]interface{}{deadline, taskQueuePrefix}, levelOrder()...)
+	val, err := dequeueScript.Run(database.Ctx, database.RedisClient,
+		[]string{TaskProcessingKey, TaskLeaseKey}, args...).Text()
+	if err == redis.Nil {
+		return 0, ErrQueueEmpty
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		// Drop the malformed entry so it is not redelivered forever
+		q.ack(val)
+		return 0, fmt.Errorf("invalid task ID in queue: %s", val)
+	}
+	return uint(id), nil
+}
+
+// Ack confirms that a delivered task reached a final state and must not be redelivered.
+func (q *TaskQueue) Ack(taskID uint) error {
+	return q.ack(strconv.FormatUint(uint64(taskID), 10))
+}
+
+func (q *TaskQueue) ack(member string) error {
+	return ackScript.Run(database.Ctx, database.RedisClient,
+		[]string{TaskProcessingKey, TaskLeaseKey, TaskQueueMetaKey}, member).Err()
+}
+
+// Requeue acknowledges the current delivery and puts the task back on the queue.
+func (q *TaskQueue) Requeue(taskID uint) error {
+	return requeueScript.Run(database.Ctx, database.RedisClient,
+		[]string{TaskProcessingKey, TaskLeaseKey, TaskQueueMetaKey}, taskQueuePrefix, taskID).Err()
+}
+
+// RequeueAfter acknowledges the current delivery, if any, and puts the task back on the
+// queue once delay has passed, behind the other tasks of its creator at its priority.
+func (q *TaskQueue) RequeueAfter(task *models.Task, delay time.Duration) error {
+	due := time.Now().Add(delay).Unix()
+	return requeueAfterScript.Run(database.Ctx, database.RedisClient,
+		[]string{TaskProcessingKey, TaskLeaseKey, TaskDelayedKey, TaskQueueMetaKey},
+		task.ID, due, queueMeta(task.CreatorID, task.Priority)).Err()
+}
+
+// PromoteDue moves delayed tasks whose time has come to the queue and returns how many were moved.
+func (q *TaskQueue) PromoteDue() (int, error) {
+	return promoteScript.Run(database.Ctx, database.RedisClient,
+		[]string{TaskDelayedKey, TaskQueueMetaKey}, time.Now().Unix(), taskQueuePrefix).Int()
+}
+
+// StartPromoter periodically moves due delayed tasks to the queue. It blocks forever.
+func (q *TaskQueue) StartPromoter(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := q.PromoteDue(); err != nil {
+			fmt.Printf("Task queue promoter error: %v\n", err)
+		}
+	}
+}
+
+// Extend pushes the visibility deadline of a leased task forward.
+func (q *TaskQueue) Extend(taskID uint) error {
+	deadline := float64(time.Now().Add(q.VisibilityTimeout).Unix())
+	return database.RedisClient.ZAddXX(database.Ctx, TaskLeaseKey, &redis.Z{Score: deadline, Member: taskID}).Err()
+}
+
+// IsLeased reports whether the task is currently delivered to a worker.
+func (q *TaskQueue) IsLeased(taskID uint) (bool, error) {
+	err := database.RedisClient.ZScore(database.Ctx, TaskLeaseKey, strconv.FormatUint(uint64(taskID), 10)).Err()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// KeepAlive extends the lease of a task until the returned stop function is called.
+// Use it while a worker is busy with a task that may outlive VisibilityTimeout.
+func (q *TaskQueue) KeepAlive(taskID uint) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(q.VisibilityTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := q.Extend(taskID); err != nil {
+					fmt.Printf("Failed to extend lease for task %d: %v\n", taskID, err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// ReapExpired redelivers tasks whose lease has expired and returns how many were redelivered.
+func (q *TaskQueue) ReapExpired() (int, error) {
+	return reapScript.Run(database.Ctx, database.RedisClient,
+		[]string{TaskLeaseKey, TaskProcessingKey, TaskQueueMetaKey}, time.Now().Unix(), taskQueuePrefix).Int()
+}
+
+// StartReaper periodically redelivers expired leases. It blocks forever.
+func (q *TaskQueue) StartReaper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := q.ReapExpired()
+		if err != nil {
+			fmt.Printf("Task queue reaper error: %v\n", err)
+			continue
+		}
+		if n > 0 {
+			fmt.Printf("Task queue reaper redelivered %d task(s)\n", n)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskQueue_DequeueAck(t *testing.T) {
+	mr := setupTestRedis()
+	defer mr.Close()
+
+	q := NewTaskQueue(time.Minute)
+
+	_, err := q.Dequeue()
+	assert.ErrorIs(t, err, ErrQueueEmpty)
+
+	assert.NoError(t, q.Enqueue(&models.Task{ID: 1}))
+	assert.NoError(t, q.Enqueue(&models.Task{ID: 2}))
+
+	// FIFO order
+	id, err := q.Dequeue()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), id)
+
+	// Delivered task is tracked until acknowledged
+	leased, err := q.IsLeased(1)
+	assert.NoError(t, err)
+	assert.True(t, leased)
+	processing, _ := database.RedisClient.LRange(database.Ctx, TaskProcessingKey, 0, -1).Result()
+	assert.Equal(t, []string{"1"}, processing)
+
+	assert.NoError(t, q.Ack(1))
+	leased, _ = q.IsLeased(1)
+	assert.False(t, leased)
+	processing, _ = database.RedisClient.LRange(database.Ctx, TaskProcessingKey, 0, -1).Result()
+	assert.Empty(t, processing)
+
+	id, err = q.Dequeue()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), id)
+}
+
+func TestTaskQueue_ReapExpired(t *testing.T) {
+	mr := setupTestRedis()
+	defer mr.Close()
+
+	q := NewTaskQueue(time.Minute)
+	assert.NoError(t, q.Enqueue(&models.Task{ID: 7}))
+	assert.NoError(t, q.Enqueue(&models.Task{ID: 8}))
+
+	id, err := q.Dequeue()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(7), id)
+
+	// Lease still valid: nothing to reap
+	n, err := q.ReapExpired()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Simulate a crashed worker whose lease expired
+	database.RedisClient.ZAdd(database.Ctx, TaskLeaseKey, &redis.Z{Score: float64(time.Now().Add(-time.Second).Unix()), Member: "7"})
+
+	n, err = q.ReapExpired()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// Redelivered task goes before tasks that were already waiting
+	id, err = q.Dequeue()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(7), id)
+}
+
+func TestTaskQueue_Requeue(t *testing.T) {
+	mr := setupTestRedis()
+	defer mr.Close()
+
+	q := NewTaskQueue(time.Minute)
+	assert.NoError(t, q.Enqueue(&models.Task{ID: 3}))
+
+	id, err := q.Dequeue()
+	assert.NoError(t, err)
+	assert.NoError(t, q.Requeue(id))
+
+	processing, _ := database.RedisClient.LRange(database.Ctx, TaskProcessingKey, 0, -1).Result()
+	assert.Empty(t, processing)
+	leased, _ := q.IsLeased(3)
+	assert.False(t, leased)
+
+	id, err = q.Dequeue()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(3), id)
+}
+
+func TestTaskQueue_RequeueAfter(t *testing.T) {
+	mr := setupTestRedis()
+	defer mr.Close()
+
+	q := NewTaskQueue(time.Minute)
+	task := &models.Task{ID: 9, CreatorID: 5, Priority: models.TaskPriorityHigh}
+	assert.NoError(t, q.Enqueue(task))
+	q.Dequeue()
+
+	assert.NoError(t, q.RequeueAfter(task, time.Hour))
+	leased, _ := q.IsLeased(9)
+	assert.False(t, leased)
+
+	// Not due yet
+	n, err := q.PromoteDue()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	_, err = q.Dequeue()
+	assert.ErrorIs(t, err, ErrQueueEmpty)
+
+	// Due: moved back to the ready list
+	database.RedisClient.ZAdd(database.Ctx, TaskDelayedKey, &redis.Z{Score: float64(time.Now().Add(-time.Second).Unix()), Member: "9"})
+	n, err = q.PromoteDue()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	id, err := q.Dequeue()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(9), id)
+
+	// A task acknowledged on its way to polling keeps its user and level when retried
+	assert.NoError(t, q.Ack(id))
+	assert.NoError(t, q.RequeueAfter(task, 0))
+	n, err = q.PromoteDue()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"9"}, database.RedisClient.LRange(database.Ctx, "task_queue:user:5:3", 0, -1).Val())
+}
+
+func TestTaskQueue_FairAcrossUsers(t *testing.T) {
+	mr := setupTestRedis()
+	defer mr.Close()
+
+	q := NewTaskQueue(time.Minute)
+	// User 1 floods the queue before user 2 submits a single task
+	for id := uint(1); id <= 5; id++ {
+		assert.NoError(t, q.Enqueue(&models.Task{ID: id, CreatorID: 1}))
+	}
+	assert.NoError(t, q.Enqueue(&models.Task{ID: 100, CreatorID: 2}))
+
+	var order []uint
+	for i := 0; i < 6; i++ {
+		id, err := q.Dequeue()
+		assert.NoError(t, err)
+		order = append(order, id)
+	}
+	// Users take turns; each user's own tasks stay in order
+	assert.Equal(t, []uint{1, 100, 2, 3, 4, 5}, order)
+
+	_, err := q.Dequeue()
+	assert.ErrorIs(t, err, ErrQueueEmpty)
+	rings, _ := database.RedisClient.Keys(database.Ctx, "task_queue:users:*").Result()
+	assert.Empty(t, rings)
+}
+
+func TestTaskQueue_PriorityLevels(t *testing.T) {
+	mr := setupTestRedis()
+	defer mr.Close()
+
+	q := NewTaskQueue(time.Minute)
+	const n = 300
+	for id := uint(1); id <= n; id++ {
+		assert.NoError(t, q.Enqueue(&models.Task{ID: id, CreatorID: 1, Priority: models.TaskPriorityLow}))
+		assert.NoError(t, q.Enqueue(&models.Task{ID: n + id, CreatorID: 1, Priority: models.TaskPriorityHigh}))
+	}
+
+	// High gets about three times the share of low, but low is not starved
+	high := 0
+	for i := 0; i < 200; i++ {
+		id, err := q.Dequeue()
+		assert.NoError(t, err)
+		if id > n {
+			high++
+		}
+	}
+	assert.Greater(t, high, 120)
+	assert.Less(t, high, 200)
+}
+
+func TestTaskQueue_SetPriority(t *testing.T) {
+	mr := setupTestRedis()
+	defer mr.Close()
+
+	q := NewTaskQueue(time.Minute)
+	task := &models.Task{ID: 1, CreatorID: 1, Priority: models.TaskPriorityLow}
+	assert.NoError(t, q.Enqueue(task))
+
+	task.Priority = models.TaskPriorityHigh
+	assert.NoError(t, q.SetPriority(task))
+
+	assert.Equal(t, int64(0), database.RedisClient.LLen(database.Ctx, "task_queue:user:1:1").Val())
+	assert.Equal(t, int64(0), database.RedisClient.LLen(database.Ctx, "task_queue:users:1").Val())
+	assert.Equal(t, []string{"1"}, database.RedisClient.LRange(database.Ctx, "task_queue:user:1:3", 0, -1).Val())
+
+	// Redeliveries keep the new level
+	id, err := q.Dequeue()
+	assert.NoError(t, err)
+	assert.NoError(t, q.Requeue(id))
+	assert.Equal(t, int64(1), database.RedisClient.LLen(database.Ctx, "task_queue:user:1:3").Val())
+
+	// Acknowledged tasks leave no metadata behind
+	id, _ = q.Dequeue()
+	assert.NoError(t, q.Ack(id))
+	assert.Equal(t, int64(0), database.RedisClient.HLen(database.Ctx, TaskQueueMetaKey).Val())
+
+	// Unknown tasks are ignored
+	assert.NoError(t, q.SetPriority(&models.Task{ID: 42, Priority: models.TaskPriorityHigh}))
+	assert.Equal(t, int64(0), database.RedisClient.HLen(database.Ctx, TaskQueueMetaKey).Val())
+}
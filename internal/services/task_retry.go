@@ -0,0 +1,87 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// TerminalError marks a task failure that another attempt cannot fix,
+// such as an upstream validation error. The task fails without retries.
+type TerminalError struct {
+	Err error
+}
+
+func (e *TerminalError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TerminalError) Unwrap() error {
+	return e.Err
+}
+
+// Terminal wraps err so the task is not retried
+func Terminal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &TerminalError{Err: err}
+}
+
+// IsRetryable reports whether a failed task may be attempted again.
+// Errors are retryable unless they are marked with Terminal.
+func IsRetryable(err error) bool {
+	var terminal *TerminalError
+	return !errors.As(err, &terminal)
+}
+
+// upstreamStatusError builds the error for an upstream HTTP error response.
+// Client errors mean the request itself was rejected, so they are terminal;
+// timeouts, rate limits and server errors are worth another attempt.
+func upstreamStatusError(status int, body string) error {
+	err := fmt.Errorf("api returned error status: %d", status)
+	if body != "" {
+		err = fmt.Errorf("api returned error status: %d, body: %s", status, body)
+	}
+	if status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests {
+		return Terminal(err)
+	}
+	return err
+}
+
+const (
+	defaultRetryBaseDelay = 5 * time.Second
+	defaultRetryMaxDelay  = 10 * time.Minute
+)
+
+// RetryPolicy computes how long a failed task waits before its next attempt
+type RetryPolicy struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Retry is the retry policy used by the worker.
+var Retry = RetryPolicy{BaseDelay: defaultRetryBaseDelay, MaxDelay: defaultRetryMaxDelay}
+
+// Backoff returns the delay before the given attempt (starting at 1).
+// The delay doubles with every attempt up to MaxDelay, and a random half of it
+// is jittered away so tasks that failed together do not retry together.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Second, MaxDelay: time.Minute}
+
+	for i := 0; i < 20; i++ {
+		d := p.Backoff(1)
+		assert.GreaterOrEqual(t, d, 5*time.Second)
+		assert.LessOrEqual(t, d, 10*time.Second)
+
+		d = p.Backoff(3)
+		assert.GreaterOrEqual(t, d, 20*time.Second)
+		assert.LessOrEqual(t, d, 40*time.Second)
+
+		// Capped at MaxDelay
+		d = p.Backoff(10)
+		assert.GreaterOrEqual(t, d, 30*time.Second)
+		assert.LessOrEqual(t, d, time.Minute)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, IsRetryable(errors.New("connection reset")))
+	assert.False(t, IsRetryable(Terminal(errors.New("bad input"))))
+	assert.False(t, IsRetryable(fmt.Errorf("submit: %w", Terminal(errors.New("bad input")))))
+
+	assert.False(t, IsRetryable(upstreamStatusError(400, "invalid prompt")))
+	assert.False(t, IsRetryable(upstreamStatusError(422, "")))
+	assert.True(t, IsRetryable(upstreamStatusError(429, "")))
+	assert.True(t, IsRetryable(upstreamStatusError(408, "")))
+	assert.True(t, IsRetryable(upstreamStatusError(503, "")))
+}
+
+func TestHandleFailure_BackoffAndDeadLetter(t *testing.T) {
+	setupRetryTestDB()
+	mr := setupRetryTestRedis()
+	defer mr.Close()
+
+	task := models.Task{Status: models.TaskStatusProcessing, MaxRetries: 3}
+	database.DB.Create(&task)
+
+	// A retryable error schedules the task for later instead of queueing it right away
+	handleFailure(&task, errors.New("upstream timeout"))
+	var stored models.Task
+	database.DB.First(&stored, task.ID)
+	assert.Equal(t, models.TaskStatusPendingExecution, stored.Status)
+	assert.Equal(t, 1, stored.RetryCount)
+	_, err := Queue.Dequeue()
+	assert.ErrorIs(t, err, ErrQueueEmpty)
+	assert.Equal(t, int64(1), database.RedisClient.ZCard(database.Ctx, TaskDelayedKey).Val())
+
+	// A terminal error fails at once and dead-letters the task
+	stored.Status = models.TaskStatusProcessing
+	database.DB.Save(&stored)
+	handleFailure(&stored, upstreamStatusError(400, "invalid prompt"))
+	database.DB.First(&stored, task.ID)
+	assert.Equal(t, models.TaskStatusFailed, stored.Status)
+	assert.Equal(t, 1, stored.RetryCount)
+
+	entry, err := GetDeadLetter(task.ID)
+	assert.NoError(t, err)
+	assert.False(t, entry.Retryable)
+	assert.Contains(t, entry.Error, "400")
+
+	items, total, err := ListDeadLetters(1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Len(t, items, 1)
+
+	// Requeue resets the task and removes the entry
+	requeued, err := RequeueDeadLetter(task.ID, ActorSystem)
+	assert.NoError(t, err)
+	assert.Equal(t, models.TaskStatusPendingExecution, requeued.Status)
+	assert.Equal(t, 0, requeued.RetryCount)
+	_, err = GetDeadLetter(task.ID)
+	assert.ErrorIs(t, err, ErrDeadLetterNotFound)
+	id, err := Queue.Dequeue()
+	assert.NoError(t, err)
+	assert.Equal(t, task.ID, id)
+
+	// Purge empties the queue
+	assert.NoError(t, pushDeadLetter(&stored, errors.New("boom")))
+	purged, err := PurgeDeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	assert.ErrorIs(t, DeleteDeadLetter(task.ID), ErrDeadLetterNotFound)
+}
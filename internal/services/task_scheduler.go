@@ -0,0 +1,158 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const scheduleBatchSize = 100
+
+// A PendingExecution task unchanged for strandedTaskGrace that is not in the queue
+// lost its enqueue, e.g. to a Redis error right after it was saved. The scheduler
+// looks for such tasks every strandedTaskScanInterval.
+const (
+	strandedTaskGrace        = time.Minute
+	strandedTaskScanInterval = time.Minute
+)
+
+var (
+	ErrScheduleInPast = errors.New("scheduled_at must be in the future")
+	ErrNotScheduled   = errors.New("task is not scheduled or has already started")
+)
+
+// StartScheduler periodically queues Scheduled tasks whose time has come, moves
+// running pipelines forward, retries webhook deliveries and queues stranded tasks
+// again. It blocks forever.
+// Like the poller it keeps no state of its own, so every instance can run it: the
+// conditional transitions let exactly one of them start each task.
+func StartScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastStrandedScan time.Time
+	for range ticker.C {
+		if n, err := fireDueTasks(time.Now()); err != nil {
+			fmt.Printf("Scheduler: Failed to queue due tasks: %v\n", err)
+		} else if n > 0 {
+			fmt.Printf("Scheduler: Queued %d scheduled task(s)\n", n)
+		}
+		advanceRunningPipelines()
+		deliverDueWebhooks(time.Now())
+
+		if time.Since(lastStrandedScan) >= strandedTaskScanInterval {
+			lastStrandedScan = time.Now()
+			if n, err := requeueStrandedTasks(time.Now()); err != nil {
+				fmt.Printf("Scheduler: Failed to queue stranded tasks: %v\n", err)
+			} else if n > 0 {
+				fmt.Printf("Scheduler: Queued %d stranded task(s)\n", n)
+			}
+		}
+	}
+}
+
+// requeueStrandedTasks queues the PendingExecution tasks unchanged since before
+// now - strandedTaskGrace that are missing from the queue, and returns how many it queued
+func requeueStrandedTasks(now time.Time) (int, error) {
+	queued := 0
+	var tasks []models.Task
+	err := database.DB.
+		Where("status = ? AND updated_at <= ?", models.TaskStatusPendingExecution, now.Add(-strandedTaskGrace)).
+		FindInBatches(&tasks, scheduleBatchSize, func(tx *gorm.DB, batch int) error {
+			for i := range tasks {
+				enqueued, err := Queue.EnqueueMissing(&tasks[i])
+				if err != nil {
+					return err
+				}
+				if enqueued {
+					queued++
+				}
+			}
+			return nil
+		}).Error
+	return queued, err
+}
+
+// fireDueTasks moves Scheduled tasks due at now to PendingExecution, queues them
+// and returns how many were queued
+func fireDueTasks(now time.Time) (int, error) {
+	var tasks []models.Task
+	err := database.DB.
+		Where("status = ? AND scheduled_at <= ?", models.TaskStatusScheduled, now).
+		Order("scheduled_at").
+		Limit(scheduleBatchSize).
+		Find(&tasks).Error
+	if err != nil {
+		return 0, err
+	}
+
+	fired := 0
+	for i := range tasks {
+		task := &tasks[i]
+		if err := fireScheduledTask(task, now); err != nil {
+			if !errors.Is(err, ErrTransitionConflict) {
+				fmt.Printf("Scheduler: Failed to fire task %d: %v\n", task.ID, err)
+			}
+			continue
+		}
+		if err := Queue.Enqueue(task); err != nil {
+			fmt.Printf("Scheduler: Failed to queue task %d: %v\n", task.ID, err)
+			continue
+		}
+		fired++
+	}
+	return fired, nil
+}
+
+// fireScheduledTask transitions a due task to PendingExecution. The task is claimed
+// on its stored scheduled time, so a reschedule made since the scan wins.
+func fireScheduledTask(task *models.Task, now time.Time) error {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Task{}).
+			Where("id = ? AND status = ? AND scheduled_at <= ?", task.ID, models.TaskStatusScheduled, now).
+			Update("updated_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrTransitionConflict
+		}
+		return TransitionTaskTx(tx, task, models.TaskStatusPendingExecution, ActorSystem, "scheduled time reached")
+	})
+	if err == nil {
+		runTaskTransitionHooks(task, models.TaskStatusScheduled, models.TaskStatusPendingExecution)
+	}
+	return err
+}
+
+// RescheduleTask moves the start time of a scheduled task that has not fired yet
+func RescheduleTask(id uint, userID uint, scheduledAt time.Time) (*models.Task, error) {
+	var task models.Task
+	if err := database.DB.First(&task, id).Error; err != nil {
+		return nil, err
+	}
+
+	if task.CreatorID != userID {
+		return nil, errors.New("unauthorized to reschedule this task")
+	}
+	if !scheduledAt.After(time.Now()) {
+		return nil, ErrScheduleInPast
+	}
+
+	res := database.DB.Model(&models.Task{}).
+		Where("id = ? AND status IN ? AND scheduled_at IS NOT NULL", id,
+			[]models.TaskStatus{models.TaskStatusPendingAudit, models.TaskStatusScheduled}).
+		Update("scheduled_at", scheduledAt)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrNotScheduled
+	}
+
+	task.ScheduledAt = &scheduledAt
+	return &task, nil
+}
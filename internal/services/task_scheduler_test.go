@@ -0,0 +1,135 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduledTask_FireRescheduleCancel(t *testing.T) {
+	setupPaymentTestDB()
+	mr := setupPaymentTestRedis()
+	defer mr.Close()
+	t.Setenv("AUTO_AUDIT", "true")
+
+	model := models.AIModel{Name: "Scheduled Model", Price: money.MustParse("10"), Status: models.AIModelStatusOpen}
+	database.DB.Create(&model)
+	user := models.User{Username: "night_owl", Balance: money.MustParse("100"), Version: 1, IsActive: true}
+	database.DB.Create(&user)
+	input := map[string]interface{}{"model_id": float64(model.ID)}
+
+	past := time.Now().Add(-time.Minute)
+	_, err := CreateTask(input, user.ID, user.Username, &past, "", nil)
+	assert.ErrorIs(t, err, ErrScheduleInPast)
+
+	// Held at creation but not queued
+	at := time.Now().Add(time.Hour)
+	task, err := CreateTask(input, user.ID, user.Username, &at, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, models.TaskStatusScheduled, task.Status)
+	var stored models.User
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("10"), stored.HeldAmount)
+	_, err = Queue.Dequeue()
+	assert.ErrorIs(t, err, ErrQueueEmpty)
+
+	// Not due yet
+	n, err := fireDueTasks(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Rescheduling moves the due time
+	later := time.Now().Add(2 * time.Hour)
+	_, err = RescheduleTask(task.ID, 999, later)
+	assert.Error(t, err)
+	_, err = RescheduleTask(task.ID, user.ID, later)
+	assert.NoError(t, err)
+	n, _ = fireDueTasks(at.Add(time.Minute))
+	assert.Equal(t, 0, n)
+
+	n, err = fireDueTasks(later.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	id, err := Queue.Dequeue()
+	assert.NoError(t, err)
+	assert.Equal(t, task.ID, id)
+
+	// Fired tasks can no longer be rescheduled
+	_, err = RescheduleTask(task.ID, user.ID, later.Add(time.Hour))
+	assert.ErrorIs(t, err, ErrNotScheduled)
+
+	// Cancelling before the task fires releases its hold
+	task2, err := CreateTask(input, user.ID, user.Username, &at, "", nil)
+	assert.NoError(t, err)
+	_, err = CancelTask(task2.ID, user.ID)
+	assert.NoError(t, err)
+	database.DB.First(&stored, user.ID)
+	assert.Equal(t, money.MustParse("10"), stored.HeldAmount)
+	n, _ = fireDueTasks(later.Add(time.Minute))
+	assert.Equal(t, 0, n)
+}
+
+func TestApproveTask_Scheduled(t *testing.T) {
+	setupRetryTestDB()
+	mr := setupRetryTestRedis()
+	defer mr.Close()
+
+	at := time.Now().Add(time.Hour)
+	task := models.Task{CreatorID: 1, Status: models.TaskStatusPendingAudit, ScheduledAt: &at}
+	database.DB.Create(&task)
+
+	approved, err := ApproveTask(task.ID, ActorSystem)
+	assert.NoError(t, err)
+	assert.Equal(t, models.TaskStatusScheduled, approved.Status)
+	_, err = Queue.Dequeue()
+	assert.ErrorIs(t, err, ErrQueueEmpty)
+
+	// Approved after its time: queued right away
+	past := time.Now().Add(-time.Minute)
+	late := models.Task{CreatorID: 1, Status: models.TaskStatusPendingAudit, ScheduledAt: &past}
+	database.DB.Create(&late)
+	approved, err = ApproveTask(late.ID, ActorSystem)
+	assert.NoError(t, err)
+	assert.Equal(t, models.TaskStatusPendingExecution, approved.Status)
+	id, err := Queue.Dequeue()
+	assert.NoError(t, err)
+	assert.Equal(t, late.ID, id)
+}
+
+func TestRequeueStrandedTasks(t *testing.T) {
+	setupRetryTestDB()
+	mr := setupRetryTestRedis()
+	defer mr.Close()
+
+	// Saved but never queued, e.g. Redis failed right after the commit
+	stranded := models.Task{CreatorID: 1, Status: models.TaskStatusPendingExecution}
+	database.DB.Create(&stranded)
+	// Waiting for a retry
+	delayed := models.Task{CreatorID: 1, Status: models.TaskStatusPendingExecution}
+	database.DB.Create(&delayed)
+	assert.NoError(t, Queue.Enqueue(&delayed))
+	Queue.Dequeue()
+	assert.NoError(t, Queue.RequeueAfter(&delayed, time.Hour))
+
+	// Too recent to tell from a task being queued right now
+	n, err := requeueStrandedTasks(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	n, err = requeueStrandedTasks(time.Now().Add(strandedTaskGrace))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	id, err := Queue.Dequeue()
+	assert.NoError(t, err)
+	assert.Equal(t, stranded.ID, id)
+
+	// Delivered tasks are not queued twice
+	n, _ = requeueStrandedTasks(time.Now().Add(strandedTaskGrace))
+	assert.Equal(t, 0, n)
+	_, err = Queue.Dequeue()
+	assert.ErrorIs(t, err, ErrQueueEmpty)
+}
@@ -4,28 +4,108 @@ import (
 	"aigentools-backend/config"
 	"aigentools-backend/internal/database"
 	"aigentools-backend/internal/models"
-	"context"
+	"aigentools-backend/pkg/money"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
-	"strings"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
-const TaskQueueKey = "task_queue"
+const (
+	taskQueuePollInterval    = 500 * time.Millisecond
+	taskQueueReapInterval    = 30 * time.Second
+	taskQueuePromoteInterval = time.Second
+	taskScheduleScanInterval = 5 * time.Second
 
-// CreateTask creates a new task and optionally pushes it to the queue
-func CreateTask(inputData map[string]interface{}, creatorID uint, creatorName string) (*models.Task, error) {
+	// executorBusyDelay is how long a task waits in the queue when its executor is full
+	executorBusyDelay = time.Second
+)
+
+// CreateTask creates a new task and optionally pushes it to the queue.
+// Its price is held on the creator's balance right away, and only charged once it completes.
+// A task with a scheduledAt time waits until then before it is queued.
+// With a quotedPrice the task is only created if it still costs that much.
+func CreateTask(inputData map[string]interface{}, creatorID uint, creatorName string, scheduledAt *time.Time, callbackURL string, quotedPrice *money.Amount) (*models.Task, error) {
 	cfg, _ := config.LoadConfig()
 
-	// 1. Check Model and Price
-	var price float64
-	var modelID uint
-	var modelName string
+	if scheduledAt != nil && !scheduledAt.After(time.Now()) {
+		return nil, ErrScheduleInPast
+	}
+	if callbackURL != "" {
+		if err := validateWebhookURL(callbackURL); err != nil {
+			return nil, err
+		}
+	}
+
+	// 1. Check Model, Input and Price
+	model, err := resolveTaskModel(inputData)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTaskInput(model, inputData, "body.", nil); err != nil {
+		return nil, err
+	}
+	quote, err := quoteTask(model, inputData)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkQuotedPrice(quote, quotedPrice); err != nil {
+		return nil, err
+	}
+
+	// 2. Start Transaction
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
 
+	task, err := newTask(tx, cfg, inputData, creatorID, creatorName, quote, scheduledAt)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	task.CallbackURL = callbackURL
+	if err := insertTaskTx(tx, task); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// 3. Hold the price on the balance
+	if err := holdTaskCostTx(tx, task, fmt.Sprintf("Create task for model: %s", model.Name)); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	// Invalidate user cache to ensure balance is updated
+	if database.RedisClient != nil {
+		cacheKey := fmt.Sprintf("user:%d", creatorID)
+		database.RedisClient.Del(database.Ctx, cacheKey)
+	}
+
+	if task.Status == models.TaskStatusPendingExecution {
+		if err := Queue.Enqueue(task); err != nil {
+			// The task is saved and paid for, so creating it succeeded; the scheduler
+			// queues it once it notices the task is missing from the queue
+			fmt.Printf("Failed to queue task %d, leaving it to the scheduler: %v\n", task.ID, err)
+		}
+	}
+
+	return task, nil
+}
+
+// resolveTaskModel finds the AIModel a task input refers to, by model_id, modelId or model.model_url
+func resolveTaskModel(inputData map[string]interface{}) (*models.AIModel, error) {
 	// Helper to extract ID
 	extractID := func(key string) uint {
 		if val, ok := inputData[key]; ok {
@@ -43,7 +123,7 @@ func CreateTask(inputData map[string]interface{}, creatorID uint, creatorName st
 		return 0
 	}
 
-	modelID = extractID("model_id")
+	modelID := extractID("model_id")
 	if modelID == 0 {
 		modelID = extractID("modelId")
 	}
@@ -64,45 +144,17 @@ func CreateTask(inputData map[string]interface{}, creatorID uint, creatorName st
 		return nil, errors.New("model_id is required")
 	}
 
-	// 2. Start Transaction
-	tx := database.DB.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
-
-	if modelID > 0 {
-		// We need to call GetAIModelByID but inside the transaction to be safe?
-		// Actually GetAIModelByID uses database.DB. We can use it, but if we want to be part of transaction
-		// we should probably query using tx. But GetAIModelByID is a read operation, it's fine to be outside or independent.
-		// However, to ensure consistency (e.g. price doesn't change), maybe we should query inside.
-		// But for now, using the service function is fine.
-		model, err := GetAIModelByID(modelID)
-		if err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("invalid model_id: %v", err)
-		}
-		price = model.Price
-		modelName = model.Name
-	}
-
-	// 3. Deduct Balance
-	if price > 0 {
-		_, err := DeductBalanceTx(tx, creatorID, price, fmt.Sprintf("Create task for model: %s", modelName), TransactionMetadata{
-			Operator:   "system",
-			OperatorID: 0,
-			Type:       models.TransactionTypeUserConsume,
-		})
-		if err != nil {
-			tx.Rollback()
-			return nil, err
-		}
+	model, err := GetAIModelByID(modelID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid model_id: %v", err)
 	}
+	return model, nil
+}
 
+// newTask builds a task costing its quote; the caller places the hold once it is inserted. The initial status follows AutoAudit and scheduledAt.
+func newTask(tx *gorm.DB, cfg *config.Config, inputData map[string]interface{}, creatorID uint, creatorName string, quote *TaskQuote, scheduledAt *time.Time) (*models.Task, error) {
 	inputJSON, err := json.Marshal(inputData)
 	if err != nil {
-		tx.Rollback()
 		return nil, err
 	}
 
@@ -110,47 +162,81 @@ func CreateTask(inputData map[string]interface{}, creatorID uint, creatorName st
 		InputData:   datatypes.JSON(inputJSON),
 		CreatorID:   creatorID,
 		CreatorName: creatorName,
+		ModelID:     quote.ModelID,
 		Status:      models.TaskStatusPendingAudit,
 		MaxRetries:  3,
-		Cost:        price,
+		Cost:        quote.Price,
+		Priority:    defaultTaskPriority(tx, cfg, creatorID),
+		ScheduledAt: scheduledAt,
+
+		PricingVersion: quote.PricingVersion,
 	}
 
 	if cfg.AutoAudit {
 		task.Status = models.TaskStatusPendingExecution
+		if scheduledAt != nil {
+			task.Status = models.TaskStatusScheduled
+		}
 	}
+	return &task, nil
+}
 
-	if err := tx.Create(&task).Error; err != nil {
-		tx.Rollback()
-		return nil, err
+// insertTaskTx inserts a task and its "created" event inside tx; queueing is left to the caller
+func insertTaskTx(tx *gorm.DB, task *models.Task) error {
+	if err := tx.Create(task).Error; err != nil {
+		return err
 	}
 
-	if err := tx.Commit().Error; err != nil {
-		return nil, err
+	// Start the history with the initial status
+	return tx.Create(&models.TaskEvent{TaskID: task.ID, ToStatus: task.Status, Actor: UserActor(task.CreatorID), Reason: "created"}).Error
+}
+
+// defaultTaskPriority returns the priority configured for the creator's role, or Normal
+func defaultTaskPriority(tx *gorm.DB, cfg *config.Config, creatorID uint) models.TaskPriority {
+	if cfg == nil || len(cfg.TaskRolePriorities) == 0 {
+		return models.TaskPriorityNormal
+	}
+	var user models.User
+	if err := tx.Select("id", "role").First(&user, creatorID).Error; err != nil {
+		return models.TaskPriorityNormal
 	}
+	if p := models.TaskPriority(cfg.TaskRolePriorities[user.Role]); p.Valid() {
+		return p
+	}
+	return models.TaskPriorityNormal
+}
 
-	// Invalidate user cache to ensure balance is updated
-	if database.RedisClient != nil {
-		cacheKey := fmt.Sprintf("user:%d", creatorID)
-		database.RedisClient.Del(database.Ctx, cacheKey)
+// SetTaskPriority changes the priority of a task that has not started yet
+func SetTaskPriority(id uint, priority models.TaskPriority) (*models.Task, error) {
+	if !priority.Valid() {
+		return nil, errors.New("invalid priority")
 	}
 
-	if cfg.AutoAudit {
-		// Push to Redis
-		err := database.RedisClient.RPush(database.Ctx, TaskQueueKey, task.ID).Err()
-		if err != nil {
-			// If redis fails, we might want to rollback or mark task as failed?
-			// For now, just return error, but task is created.
-			// Ideally we should transactionally do this or have a sweeper.
-			// But for this simple implementation:
-			return &task, fmt.Errorf("task created but failed to push to redis: %v", err)
-		}
+	var task models.Task
+	if err := database.DB.First(&task, id).Error; err != nil {
+		return nil, err
 	}
 
+	res := database.DB.Model(&models.Task{}).
+		Where("id = ? AND status IN ?", id, waitingTaskStatuses).
+		Update("priority", priority)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, errors.New("only tasks that have not started can be reprioritized")
+	}
+	task.Priority = priority
+
+	if err := Queue.SetPriority(&task); err != nil {
+		return &task, fmt.Errorf("priority saved but failed to update the queue: %v", err)
+	}
 	return &task, nil
 }
 
-// ApproveTask approves a task and pushes it to the queue
-func ApproveTask(id uint) (*models.Task, error) {
+// ApproveTask approves a task and pushes it to the queue, or leaves it Scheduled
+// if its scheduled time has not come yet
+func ApproveTask(id uint, actor string) (*models.Task, error) {
 	var task models.Task
 	if err := database.DB.First(&task, id).Error; err != nil {
 		return nil, err
@@ -160,12 +246,23 @@ func ApproveTask(id uint) (*models.Task, error) {
 		return nil, errors.New("task is not pending audit")
 	}
 
-	task.Status = models.TaskStatusPendingExecution
-	if err := database.DB.Save(&task).Error; err != nil {
+	to := models.TaskStatusPendingExecution
+	if task.ScheduledAt != nil && task.ScheduledAt.After(time.Now()) {
+		to = models.TaskStatusScheduled
+	}
+
+	if err := TransitionTask(&task, to, actor, "approved"); err != nil {
+		if errors.Is(err, ErrTransitionConflict) {
+			return nil, errors.New("task is not pending audit")
+		}
 		return nil, err
 	}
 
-	if err := database.RedisClient.RPush(database.Ctx, TaskQueueKey, task.ID).Err(); err != nil {
+	if to == models.TaskStatusScheduled {
+		return &task, nil
+	}
+
+	if err := Queue.Enqueue(&task); err != nil {
 		return &task, fmt.Errorf("task approved but failed to push to redis: %v", err)
 	}
 
@@ -179,18 +276,31 @@ func UpdateTask(id uint, inputData map[string]interface{}) (*models.Task, error)
 		return nil, err
 	}
 
-	if task.Status >= models.TaskStatusProcessing {
+	if !isTaskWaiting(task.Status) {
 		return nil, errors.New("cannot update task in processing or later state")
 	}
+	if task.ModelID != 0 {
+		model, err := GetAIModelByID(task.ModelID)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateTaskInput(model, inputData, "body.", nil); err != nil {
+			return nil, err
+		}
+	}
 
 	inputJSON, err := json.Marshal(inputData)
 	if err != nil {
 		return nil, err
 	}
 
-	task.InputData = datatypes.JSON(inputJSON)
-	if err := database.DB.Save(&task).Error; err != nil {
-		return nil, err
+	// Only update the input; the status may not have moved on in the meantime
+	res := database.DB.Model(&task).Where("status = ?", task.Status).Update("input_data", datatypes.JSON(inputJSON))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, errors.New("cannot update task in processing or later state")
 	}
 
 	return &task, nil
@@ -255,25 +365,64 @@ func RetryTask(id uint, userID uint) (*models.Task, error) {
 		return nil, errors.New("task is not in a failed state")
 	}
 
-	// Reset status and counters
-	task.Status = models.TaskStatusPendingExecution
+	// Reset counters
 	task.RetryCount = 0
 	task.ErrorLog = "" // Clear previous error
 	task.ResultURL = ""
 
-	if err := database.DB.Save(&task).Error; err != nil {
+	if err := rerunFailedTask(&task, UserActor(userID), "retried by user"); err != nil {
+		if errors.Is(err, ErrTransitionConflict) {
+			return nil, errors.New("task is not in a failed state")
+		}
 		return nil, err
 	}
 
+	// A task that ran out of retries is also in the dead-letter queue; it no longer belongs there
+	if err := DeleteDeadLetter(task.ID); err != nil && !errors.Is(err, ErrDeadLetterNotFound) {
+		fmt.Printf("Failed to remove task %d from the dead-letter queue: %v\n", task.ID, err)
+	}
+
 	// Push back to Redis
-	if err := database.RedisClient.RPush(database.Ctx, TaskQueueKey, task.ID).Err(); err != nil {
+	if err := Queue.Enqueue(&task); err != nil {
 		return &task, fmt.Errorf("task reset but failed to push to redis: %v", err)
 	}
 
 	return &task, nil
 }
 
-// CancelTask cancels a task
+// rerunFailedTask moves a failed task back to PendingExecution. A task whose cost was
+// refunded when it failed places a new hold in the same transaction, so the new run is
+// paid for like the first one.
+func rerunFailedTask(task *models.Task, actor, reason string) error {
+	from := task.Status
+	refundedAt := task.RefundedAt
+	holdID := task.HoldID
+	held := false
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if task.RefundedAt != nil && task.Cost > 0 {
+			if err := holdTaskCostTx(tx, task, fmt.Sprintf("Rerun task %d", task.ID)); err != nil {
+				return err
+			}
+			task.RefundedAt = nil
+			held = true
+		}
+		return TransitionTaskTx(tx, task, models.TaskStatusPendingExecution, actor, reason)
+	})
+	if err != nil {
+		task.RefundedAt = refundedAt
+		task.HoldID = holdID
+		return err
+	}
+
+	if held && database.RedisClient != nil {
+		// Invalidate user cache to ensure balance is updated
+		database.RedisClient.Del(database.Ctx, fmt.Sprintf("user:%d", task.CreatorID))
+	}
+	runTaskTransitionHooks(task, from, models.TaskStatusPendingExecution)
+	return nil
+}
+
+// CancelTask cancels a task, interrupts any work on it and refunds its cost
 func CancelTask(id uint, userID uint) (*models.Task, error) {
 	var task models.Task
 	if err := database.DB.First(&task, id).Error; err != nil {
@@ -286,19 +435,28 @@ func CancelTask(id uint, userID uint) (*models.Task, error) {
 	}
 
 	// Check if cancellable
-	if task.Status == models.TaskStatusCompleted || task.Status == models.TaskStatusFailed || task.Status == models.TaskStatusCancelled {
+	if !CanTransition(task.Status, models.TaskStatusCancelled) {
 		return nil, errors.New("task cannot be cancelled in its current state")
 	}
 
-	task.Status = models.TaskStatusCancelled
-	if err := database.DB.Save(&task).Error; err != nil {
+	task.NextPollAt = nil
+	err := transitionWithRefund(&task, models.TaskStatusCancelled, UserActor(userID), "cancelled by user",
+		fmt.Sprintf("Refund for task %d cancellation", task.ID))
+	if err != nil {
+		if errors.Is(err, ErrTransitionConflict) {
+			return nil, errors.New("task cannot be cancelled in its current state")
+		}
 		return nil, err
 	}
 
+	// Stop the worker or poller working on it, wherever it runs, and the upstream job
+	publishCancel(task.ID)
+	go cancelUpstream(task)
+
 	return &task, nil
 }
 
-// ResumeProcessingTasks finds tasks stuck in processing state and re-queues them or polls their status
+// ResumeProcessingTasks finds tasks stuck in processing state before submission and re-queues them
 func ResumeProcessingTasks() {
 	var processingTasks []models.Task
 	// Find tasks that are 'Processing' but not completed.
@@ -312,61 +470,120 @@ func ResumeProcessingTasks() {
 	fmt.Printf("Found %d tasks in Processing state. Attempting to resume...\n", len(processingTasks))
 
 	for _, task := range processingTasks {
-		// If task has RemoteTaskID, we can add it to PollingManager (to be implemented)
-		// Or push back to Redis for Worker to pick up.
-		// If we push back to Redis, the worker will call executeTaskLogic again.
-		// executeTaskLogic usually starts a new execution (submits new request).
-		// But for Jiekou/Remote tasks, we might want to check status instead of re-submitting if we have RemoteTaskID.
-		// Currently executeTaskLogic doesn't support "Resume" mode directly, it re-executes.
-
 		// Strategy:
-		// 1. If RemoteTaskID exists, it means we submitted successfully. We should poll.
+		// 1. If RemoteTaskID exists, it means we submitted successfully. PollingManager picks it up from the database.
 		// 2. If no RemoteTaskID, maybe it crashed before submission or during submission. Safe to re-try (re-queue).
 
 		if task.RemoteTaskID != "" {
-			fmt.Printf("Task %d has RemoteTaskID %s. Adding to polling queue...\n", task.ID, task.RemoteTaskID)
-			// TODO: Add to PollingManager
-			// For now, we can just push to Redis, but we need executeTaskLogic to handle "already submitted" case?
-			// Or we create a specific "PollTask" function?
-			// Let's implement PollingManager as requested.
-			PollingMgr.Add(task.ID)
-		} else {
-			fmt.Printf("Task %d has no RemoteTaskID. Re-queuing for execution...\n", task.ID)
-			// Reset status to PendingExecution to be picked up by worker normally
-			task.Status = models.TaskStatusPendingExecution
-			database.DB.Save(&task)
-			database.RedisClient.RPush(database.Ctx, TaskQueueKey, task.ID)
+			continue
+		}
+
+		// A leased task is still owned by the task queue; the reaper redelivers it if its worker died.
+		if leased, err := Queue.IsLeased(task.ID); err == nil && leased {
+			fmt.Printf("Task %d is leased by the task queue. Skipping...\n", task.ID)
+			continue
+		}
+		fmt.Printf("Task %d has no RemoteTaskID. Re-queuing for execution...\n", task.ID)
+		// Reset status to PendingExecution to be picked up by worker normally
+		if err := TransitionTask(&task, models.TaskStatusPendingExecution, ActorSystem, "resumed after restart"); err != nil {
+			continue
+		}
+		if err := Queue.Enqueue(&task); err != nil {
+			fmt.Printf("Failed to re-queue task %d: %v\n", task.ID, err)
+		}
+	}
+}
+
+// migrateLegacyQueue re-enqueues the task IDs left in the old single-list queue
+func migrateLegacyQueue() {
+	moved := 0
+	for {
+		val, err := database.RedisClient.RPop(database.Ctx, TaskQueueKey).Result()
+		if err != nil {
+			if err != redis.Nil {
+				fmt.Printf("Failed to drain legacy task queue: %v\n", err)
+			}
+			break
+		}
+		id, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			continue
+		}
+		var task models.Task
+		if err := database.DB.First(&task, id).Error; err != nil {
+			fmt.Printf("Dropping unknown task %s from legacy task queue: %v\n", val, err)
+			continue
+		}
+		if err := Queue.Enqueue(&task); err != nil {
+			fmt.Printf("Failed to re-queue task %d: %v\n", task.ID, err)
+			continue
 		}
+		moved++
+	}
+	if moved > 0 {
+		fmt.Printf("Moved %d task(s) from the legacy task queue\n", moved)
 	}
 }
 
 // StartWorker starts the background worker
 func StartWorker() {
+	cfg, _ := config.LoadConfig()
+	if cfg != nil {
+		if cfg.TaskVisibilityTimeout > 0 {
+			Queue.VisibilityTimeout = time.Duration(cfg.TaskVisibilityTimeout) * time.Second
+		}
+		Pool.Configure(cfg.WorkerConcurrency, cfg.ExecutorConcurrency)
+		if cfg.TaskRetryBaseDelay > 0 {
+			Retry.BaseDelay = time.Duration(cfg.TaskRetryBaseDelay) * time.Second
+		}
+		if cfg.TaskRetryMaxDelay > 0 {
+			Retry.MaxDelay = time.Duration(cfg.TaskRetryMaxDelay) * time.Second
+		}
+	}
+
 	// Start Polling Manager
 	go PollingMgr.Start()
 
+	// Redeliver tasks whose worker died before acknowledging them
+	go Queue.StartReaper(taskQueueReapInterval)
+
+	// Move retries whose backoff has passed back to the queue
+	go Queue.StartPromoter(taskQueuePromoteInterval)
+
+	// Interrupt local work on tasks cancelled through any instance
+	go StartCancelListener()
+
+	// Move tasks queued before per-user scheduling to their user's list
+	migrateLegacyQueue()
+
+	// Queue scheduled tasks once their time has come and move pipelines forward
+	go StartScheduler(taskScheduleScanInterval)
+
+	// Reconcile balances with the ledger every RECONCILIATION_INTERVAL hours
+	go StartReconciler(reconcileCheckInterval)
+
 	// Resume tasks
 	go ResumeProcessingTasks()
 
 	fmt.Println("Worker started...")
 	for {
-		// BLPop blocks until an item is available
-		result, err := database.RedisClient.BLPop(context.Background(), 0*time.Second, TaskQueueKey).Result()
-		if err != nil {
-			fmt.Printf("Redis BLPop error: %v\n", err)
-			time.Sleep(1 * time.Second) // Prevent tight loop on error
-			continue
-		}
+		// Only pull a task when a slot is free; the rest wait in the queue
+		Pool.AcquireSlot()
 
-		// result[0] is the key, result[1] is the value
-		taskIDStr := result[1]
-		taskID, err := strconv.Atoi(taskIDStr)
+		taskID, err := Queue.Dequeue()
 		if err != nil {
-			fmt.Printf("Invalid task ID: %s\n", taskIDStr)
+			Pool.ReleaseSlot()
+			if !errors.Is(err, ErrQueueEmpty) {
+				fmt.Printf("Task queue dequeue error: %v\n", err)
+			}
+			time.Sleep(taskQueuePollInterval) // Prevent tight loop when idle or on error
 			continue
 		}
 
-		go processTask(uint(taskID))
+		go func() {
+			defer Pool.ReleaseSlot()
+			processTask(taskID)
+		}()
 	}
 }
 
@@ -374,95 +591,257 @@ func processTask(taskID uint) {
 	var task models.Task
 	if err := database.DB.First(&task, taskID).Error; err != nil {
 		fmt.Printf("Task %d not found: %v\n", taskID, err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			Queue.Ack(taskID)
+		}
+		// Other errors leave the delivery unacknowledged so the reaper retries it later
+		return
+	}
+
+	// A redelivered task may already have been finished or cancelled
+	if task.Status != models.TaskStatusPendingExecution && task.Status != models.TaskStatusProcessing {
+		fmt.Printf("Task %d is in status %d, skipping delivery\n", taskID, task.Status)
+		Queue.Ack(taskID)
 		return
 	}
 
-	// Update status to Processing
-	task.Status = models.TaskStatusProcessing
-	database.DB.Save(&task)
+	// Submitted before the previous worker died: polling takes it from here
+	if task.Status == models.TaskStatusProcessing && task.RemoteTaskID != "" {
+		Queue.Ack(taskID)
+		return
+	}
 
-	fmt.Printf("Processing task %d...\n", taskID)
+	// Respect the executor's concurrency limit before touching the upstream API. A task
+	// of a busy executor goes back to the queue rather than holding its global slot.
+	executorName := resolveExecutorName(&task)
+	releaseExecutor, ok := Pool.TryAcquireExecutor(executorName)
+	if !ok {
+		if err := Queue.RequeueAfter(&task, executorBusyDelay); err != nil {
+			fmt.Printf("Failed to defer task %d: %v\n", taskID, err)
+		}
+		return
+	}
+	defer releaseExecutor()
+
+	stopKeepAlive := Queue.KeepAlive(taskID)
+	defer stopKeepAlive()
+
+	// Interrupted when the task is cancelled
+	ctx, done := inflight.watch(taskID)
+	defer done()
+
+	// Update status to Processing; a redelivered task that was never submitted already is
+	if task.Status != models.TaskStatusProcessing {
+		if err := TransitionTask(&task, models.TaskStatusProcessing, ActorSystem, "picked up by worker"); err != nil {
+			fmt.Printf("Task %d could not be started: %v\n", taskID, err)
+			if !isTransitionRejected(err) {
+				// Leave unacknowledged so the reaper retries it later
+				return
+			}
+			Queue.Ack(taskID)
+			return
+		}
+	}
 
-	result, err := executeTaskLogic(&task)
+	fmt.Printf("Processing task %d...\n", taskID)
 
+	executor := getExecutor(executorName)
+	handle, err := executor.Submit(ctx, &task)
 	if err != nil {
 		fmt.Printf("Task %d failed: %v\n", taskID, err)
 		handleFailure(&task, err)
-	} else {
-		if hookErr := runAfterExecutionHooks(&task, result); hookErr != nil {
-			fmt.Printf("Task %d after hooks error: %v\n", taskID, hookErr)
-		}
-		fmt.Printf("Task %d completed\n", taskID)
-		task.Status = models.TaskStatusCompleted
+		return
+	}
 
-		// Use OSS URL if available, otherwise fallback to simulated
-		if ossURL, ok := result["oss_url"].(string); ok && ossURL != "" {
-			task.ResultURL = ossURL
-		} else if resultURL, ok := result["result_url"].(string); ok && resultURL != "" {
-			task.ResultURL = resultURL
-		} else {
-			task.ResultURL = fmt.Sprintf("http://oss.example.com/result/%d", taskID)
-		}
+	// Finished inline, nothing to poll
+	if handle.Result != nil {
+		finishTask(&task, handle.Result)
+		return
+	}
 
-		database.DB.Save(&task)
+	now := time.Now()
+	nextPoll := now.Add(executorPollInterval(executor, &task))
+	task.RemoteTaskID = handle.RemoteTaskID
+	task.RemoteQueryURL = handle.QueryURL
+	task.SubmittedAt = &now
+	task.NextPollAt = &nextPoll
+	task.PollErrorCount = 0
+	applied, err := saveIfStatus(&task, models.TaskStatusProcessing)
+	if err != nil {
+		// Leave unacknowledged so the task is redelivered instead of lost
+		fmt.Printf("Failed to save submitted task %d: %v\n", taskID, err)
+		return
 	}
+	if !applied {
+		// Cancelled while submitting: the upstream job was started anyway, stop it
+		fmt.Printf("Task %d changed state during submission, not polling it\n", taskID)
+		go cancelUpstream(task)
+	}
+
+	// PollingManager owns the task from now on
+	Queue.Ack(taskID)
 }
 
-func executeTaskLogic(task *models.Task) (map[string]interface{}, error) {
-	time.Sleep(5 * time.Second)
+// builtinExecutorName identifies tasks handled by the BuiltinExecutor
+const builtinExecutorName = "builtin"
 
-	// Simulate failure if input contains "fail"
+// resolveExecutorName picks the registered executor for a task based on its input and model
+func resolveExecutorName(task *models.Task) string {
 	var input map[string]interface{}
 	json.Unmarshal(task.InputData, &input)
 
 	if name, ok := input["executor"].(string); ok && name != "" {
 		if ex := getExecutor(name); ex != nil {
-			return ex.Execute(task)
+			return name
 		}
 	}
 
+	// Models configured with an adapter spec run through the generic executor
+	if hasAdapter(task) {
+		return adapterExecutorName
+	}
+
 	// Auto-detect Jiekou/Model task structure
 	if _, ok := input["model"]; ok {
 		if ex := getExecutor("jiekou_api"); ex != nil {
-			return ex.Execute(task)
+			return "jiekou_api"
 		}
 	}
 
-	if prompt, ok := input["prompt"].(string); ok && strings.Contains(prompt, "fail") {
-		return nil, errors.New("simulated failure")
+	return builtinExecutorName
+}
+
+// finishTask applies a final poll result to a task
+func finishTask(task *models.Task, result *PollResult) {
+	switch result.State {
+	case PollStateSucceeded:
+		completeTask(task, result.Output)
+	case PollStateFailed:
+		fmt.Printf("Task %d failed: %v\n", task.ID, result.Err)
+		// A new attempt has to be submitted from scratch
+		task.RemoteTaskID = ""
+		task.RemoteQueryURL = ""
+		task.SubmittedAt = nil
+		task.NextPollAt = nil
+		task.PollErrorCount = 0
+		handleFailure(task, result.Err)
+	}
+}
+
+// completeTask marks a task as completed with the executor's output
+func completeTask(task *models.Task, output map[string]interface{}) {
+	if hookErr := runAfterExecutionHooks(task, output); hookErr != nil {
+		fmt.Printf("Task %d after hooks error: %v\n", task.ID, hookErr)
+	}
+	fmt.Printf("Task %d completed\n", task.ID)
+
+	// The primary artifact stays in ResultURL; use OSS URL if available, otherwise fallback to simulated
+	artifacts := outputArtifacts(output)
+	if ossURL, ok := output["oss_url"].(string); ok && ossURL != "" {
+		task.ResultURL = ossURL
+	} else if resultURL, ok := output["result_url"].(string); ok && resultURL != "" {
+		task.ResultURL = resultURL
+	} else if len(artifacts) > 0 {
+		task.ResultURL = artifacts[0].URL
+	} else {
+		task.ResultURL = fmt.Sprintf("http://oss.example.com/result/%d", task.ID)
 	}
 
-	return map[string]interface{}{"status": "ok"}, nil
+	task.ResultData, _ = output[ResultDataOutputKey].(datatypes.JSON)
+	task.NextPollAt = nil
+
+	from := task.Status
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := TransitionTaskTx(tx, task, models.TaskStatusCompleted, ActorSystem, "completed"); err != nil {
+			return err
+		}
+		if err := captureTaskHoldTx(tx, task, output); err != nil {
+			return err
+		}
+		return replaceTaskArtifactsTx(tx, task.ID, artifacts)
+	})
+	if err != nil {
+		if !isTransitionRejected(err) {
+			// Leave unacknowledged so the task is redelivered instead of lost
+			fmt.Printf("Failed to save completed task %d: %v\n", task.ID, err)
+			return
+		}
+		fmt.Printf("Task %d was already finalized elsewhere\n", task.ID)
+	} else {
+		if task.HoldID != nil && database.RedisClient != nil {
+			// Invalidate user cache to ensure balance is updated
+			database.RedisClient.Del(database.Ctx, fmt.Sprintf("user:%d", task.CreatorID))
+		}
+		runTaskTransitionHooks(task, from, models.TaskStatusCompleted)
+	}
+	Queue.Ack(task.ID)
 }
 
 func handleFailure(task *models.Task, err error) {
+	if !IsRetryable(err) {
+		fmt.Printf("Task %d failed with a terminal error, not retrying\n", task.ID)
+		failTask(task, err)
+		return
+	}
+	if task.RetryCount >= task.MaxRetries {
+		fmt.Printf("Task %d failed permanently after %d retries\n", task.ID, task.MaxRetries)
+		failTask(task, err)
+		return
+	}
+
 	task.ErrorLog = err.Error()
+	task.RetryCount++
+	delay := Retry.Backoff(task.RetryCount)
+	fmt.Printf("Retrying task %d in %s (attempt %d/%d)...\n", task.ID, delay, task.RetryCount, task.MaxRetries)
+
+	reason := fmt.Sprintf("retry %d/%d: %v", task.RetryCount, task.MaxRetries, err)
+	if transitionErr := TransitionTask(task, models.TaskStatusPendingExecution, ActorSystem, reason); transitionErr != nil {
+		if !isTransitionRejected(transitionErr) {
+			fmt.Printf("Failed to save task %d: %v\n", task.ID, transitionErr)
+			return
+		}
+		// Cancelled or finalized elsewhere, drop this delivery
+		Queue.Ack(task.ID)
+		return
+	}
 
-	if task.RetryCount < task.MaxRetries {
-		task.RetryCount++
-		task.Status = models.TaskStatusPendingExecution
-		fmt.Printf("Retrying task %d (attempt %d/%d)...\n", task.ID, task.RetryCount, task.MaxRetries)
+	// Hand the delivery back to the queue once the backoff has passed
+	if err := Queue.RequeueAfter(task, delay); err != nil {
+		fmt.Printf("Failed to re-queue task %d: %v\n", task.ID, err)
+	}
+}
 
-		database.DB.Save(task)
+// failTask marks a processing task as permanently failed, refunds its cost and
+// moves it to the dead-letter queue. Only the caller whose transition wins does so.
+func failTask(task *models.Task, cause error) {
+	task.ErrorLog = cause.Error()
+	task.NextPollAt = nil
 
-		// Push back to Redis
-		database.RedisClient.RPush(database.Ctx, TaskQueueKey, task.ID)
-	} else {
-		task.Status = models.TaskStatusFailed
-		fmt.Printf("Task %d failed permanently after %d retries\n", task.ID, task.MaxRetries)
+	err := transitionWithRefund(task, models.TaskStatusFailed, ActorSystem, cause.Error(),
+		fmt.Sprintf("Refund for task %d failure", task.ID))
+	if err != nil && !isTransitionRejected(err) {
+		fmt.Printf("Failed to save failed task %d: %v\n", task.ID, err)
+		return
+	}
 
-		// Refund if cost > 0
-		if task.Cost > 0 {
-			_, refundErr := AdjustBalance(task.CreatorID, task.Cost, fmt.Sprintf("Refund for task %d failure", task.ID), TransactionMetadata{
-				Operator: "system",
-				Type:     models.TransactionTypeUserRefund,
-			})
-			if refundErr != nil {
-				fmt.Printf("Refund failed for task %d: %v\n", task.ID, refundErr)
-				task.ErrorLog += fmt.Sprintf("; Refund failed: %v", refundErr)
-			}
+	if err == nil {
+		if err := pushDeadLetter(task, cause); err != nil {
+			fmt.Printf("Failed to dead-letter task %d: %v\n", task.ID, err)
 		}
-
-		database.DB.Save(task)
 	}
+
+	Queue.Ack(task.ID)
+}
+
+// isTransitionRejected reports whether a transition failed because the task had
+// already moved on, as opposed to a database error worth retrying
+func isTransitionRejected(err error) bool {
+	return errors.Is(err, ErrTransitionConflict) || errors.Is(err, ErrInvalidTransition)
+}
+
+// saveIfStatus saves the run state of a task only if its stored status is still expected.
+// It is meant for updates that keep the status; status changes go through TransitionTask.
+func saveIfStatus(task *models.Task, expected models.TaskStatus) (bool, error) {
+	res := database.DB.Model(task).Where("status = ?", expected).Select(taskRunColumns).Updates(task)
+	return res.RowsAffected == 1, res.Error
 }
@@ -2,6 +2,7 @@ package services
 
 import (
 	"aigentools-backend/internal/models"
+	"context"
 	"encoding/json"
 	"testing"
 	"time"
@@ -23,9 +24,11 @@ func TestAfterExecutionHookIsCalled(t *testing.T) {
 	task := &models.Task{
 		InputData: datatypes.JSON(raw),
 	}
-	result, err := executeTaskLogic(task)
+	handle, err := getExecutor(resolveExecutorName(task)).Submit(context.Background(), task)
 	assert.NoError(t, err)
-	err = runAfterExecutionHooks(task, result)
+	assert.NotNil(t, handle.Result)
+	assert.Equal(t, PollStateSucceeded, handle.Result.State)
+	err = runAfterExecutionHooks(task, handle.Result.Output)
 	assert.NoError(t, err)
 	select {
 	case gotID := <-ch:
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"errors"
+	"fmt"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// ActorSystem is the actor of transitions made by the worker, the poller and other background jobs
+const ActorSystem = "system"
+
+// UserActor is the actor of transitions requested by a user
+func UserActor(userID uint) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+var (
+	ErrInvalidTransition  = errors.New("invalid task status transition")
+	ErrTransitionConflict = errors.New("task status was changed concurrently")
+)
+
+// taskTransitions lists the legal status changes. Completed and Cancelled are final;
+// Failed tasks can only be sent back for another run.
+var taskTransitions = map[models.TaskStatus][]models.TaskStatus{
+	models.TaskStatusPendingAudit: {
+		models.TaskStatusPendingExecution,
+		models.TaskStatusScheduled, // Approved before its scheduled time
+		models.TaskStatusCancelled,
+	},
+	models.TaskStatusScheduled: {
+		models.TaskStatusPendingExecution,
+		models.TaskStatusCancelled,
+	},
+	models.TaskStatusBlocked: {
+		models.TaskStatusPendingAudit,     // Upstream steps completed
+		models.TaskStatusPendingExecution, // Upstream steps completed
+		models.TaskStatusCancelled,        // Skipped after an upstream step failed
+	},
+	models.TaskStatusPendingExecution: {
+		models.TaskStatusProcessing,
+		models.TaskStatusCancelled,
+	},
+	models.TaskStatusProcessing: {
+		models.TaskStatusCompleted,
+		models.TaskStatusFailed,
+		models.TaskStatusPendingExecution, // Retry or recovery after a restart
+		models.TaskStatusCancelled,
+	},
+	models.TaskStatusFailed: {
+		models.TaskStatusPendingExecution,
+	},
+}
+
+// waitingTaskStatuses are the statuses of tasks that have not started running yet
+var waitingTaskStatuses = []models.TaskStatus{
+	models.TaskStatusPendingAudit,
+	models.TaskStatusPendingExecution,
+	models.TaskStatusScheduled,
+	models.TaskStatusBlocked,
+}
+
+// isTaskWaiting reports whether a task with the given status has not started running yet
+func isTaskWaiting(status models.TaskStatus) bool {
+	for _, s := range waitingTaskStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// isTaskFinished reports whether a status is final for the current run of a task
+func isTaskFinished(status models.TaskStatus) bool {
+	return status == models.TaskStatusCompleted || status == models.TaskStatusFailed || status == models.TaskStatusCancelled
+}
+
+// taskRunColumns are the columns a run of a task writes as it moves between statuses.
+// Transitions save only these, so a priority, input or schedule changed meanwhile is kept.
+var taskRunColumns = []string{
+	"status", "result_url", "result_data", "retry_count", "error_log", "remote_task_id", "cost",
+	"refunded_at", "hold_id", "remote_query_url", "submitted_at", "next_poll_at", "poll_error_count",
+}
+
+// TaskTransitionHook is called after a status change of a task has been committed
+type TaskTransitionHook func(task *models.Task, from, to models.TaskStatus)
+
+var transitionHookMu sync.RWMutex
+var transitionHooks []TaskTransitionHook
+
+// RegisterTaskTransitionHook adds a hook run after every committed status change
+func RegisterTaskTransitionHook(h TaskTransitionHook) {
+	transitionHookMu.Lock()
+	transitionHooks = append(transitionHooks, h)
+	transitionHookMu.Unlock()
+}
+
+func runTaskTransitionHooks(task *models.Task, from, to models.TaskStatus) {
+	transitionHookMu.RLock()
+	hooks := make([]TaskTransitionHook, len(transitionHooks))
+	copy(hooks, transitionHooks)
+	transitionHookMu.RUnlock()
+	for _, h := range hooks {
+		h(task, from, to)
+	}
+}
+
+// CanTransition reports whether a task may move from one status to another
+func CanTransition(from, to models.TaskStatus) bool {
+	for _, s := range taskTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionTask moves a task from its current in-memory status to the given one
+// and saves its run state along with it, plus any other columns the caller names.
+// The update only applies if the stored status still equals the in-memory one;
+// otherwise ErrTransitionConflict is returned and the task is left untouched.
+// Each transition is recorded as a TaskEvent.
+func TransitionTask(task *models.Task, to models.TaskStatus, actor, reason string, columns ...string) error {
+	from := task.Status
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return TransitionTaskTx(tx, task, to, actor, reason, columns...)
+	})
+	if err == nil {
+		runTaskTransitionHooks(task, from, to)
+	}
+	return err
+}
+
+// TransitionTaskTx is TransitionTask inside an existing transaction. The caller
+// runs the transition hooks once the transaction has committed.
+func TransitionTaskTx(tx *gorm.DB, task *models.Task, to models.TaskStatus, actor, reason string, columns ...string) error {
+	from := task.Status
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: %d -> %d", ErrInvalidTransition, from, to)
+	}
+
+	task.Status = to
+	res := tx.Model(task).Where("status = ?", from).Select(append(columns[:len(columns):len(columns)], taskRunColumns...)).Updates(task)
+	if res.Error != nil {
+		task.Status = from
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		task.Status = from
+		return ErrTransitionConflict
+	}
+
+	event := models.TaskEvent{
+		TaskID:     task.ID,
+		FromStatus: from,
+		ToStatus:   to,
+		Actor:      actor,
+		Reason:     reason,
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		task.Status = from
+		return err
+	}
+	return nil
+}
+
+// GetTaskEvents returns the status history of a task, oldest first
+func GetTaskEvents(taskID uint) ([]models.TaskEvent, error) {
+	var events []models.TaskEvent
+	err := database.DB.Where("task_id = ?", taskID).Order("created_at, id").Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/datatypes"
+)
+
+func TestCanTransition(t *testing.T) {
+	assert.True(t, CanTransition(models.TaskStatusPendingAudit, models.TaskStatusPendingExecution))
+	assert.True(t, CanTransition(models.TaskStatusProcessing, models.TaskStatusCompleted))
+	assert.True(t, CanTransition(models.TaskStatusFailed, models.TaskStatusPendingExecution))
+
+	assert.False(t, CanTransition(models.TaskStatusPendingAudit, models.TaskStatusCompleted))
+	assert.False(t, CanTransition(models.TaskStatusCancelled, models.TaskStatusCompleted))
+	assert.False(t, CanTransition(models.TaskStatusCompleted, models.TaskStatusPendingExecution))
+	assert.False(t, CanTransition(models.TaskStatusFailed, models.TaskStatusCancelled))
+}
+
+func TestTransitionTask_ConflictAndEvents(t *testing.T) {
+	setupRetryTestDB()
+
+	task := models.Task{CreatorID: 3, Status: models.TaskStatusProcessing}
+	database.DB.Create(&task)
+
+	// The poller still holds a copy loaded before the user cancelled
+	stale := task
+
+	cancelled, err := CancelTask(task.ID, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, models.TaskStatusCancelled, cancelled.Status)
+
+	stale.ResultURL = "http://example.com/out.png"
+	err = TransitionTask(&stale, models.TaskStatusCompleted, ActorSystem, "completed")
+	assert.ErrorIs(t, err, ErrTransitionConflict)
+	assert.Equal(t, models.TaskStatusProcessing, stale.Status)
+
+	var stored models.Task
+	database.DB.First(&stored, task.ID)
+	assert.Equal(t, models.TaskStatusCancelled, stored.Status)
+	assert.Empty(t, stored.ResultURL)
+
+	// Final states cannot be left
+	err = TransitionTask(&stored, models.TaskStatusPendingExecution, ActorSystem, "")
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	events, err := GetTaskEvents(task.ID)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, models.TaskStatusProcessing, events[0].FromStatus)
+	assert.Equal(t, models.TaskStatusCancelled, events[0].ToStatus)
+	assert.Equal(t, "user:3", events[0].Actor)
+	assert.Equal(t, "cancelled by user", events[0].Reason)
+}
+
+func TestTransitionTask_KeepsConcurrentEdits(t *testing.T) {
+	setupRetryTestDB()
+	mr := setupRetryTestRedis()
+	defer mr.Close()
+
+	task := models.Task{CreatorID: 3, Status: models.TaskStatusPendingAudit, Priority: models.TaskPriorityNormal, InputData: datatypes.JSON(`{"prompt":"a"}`)}
+	database.DB.Create(&task)
+
+	// The copy is loaded before the priority and the input change
+	stale := task
+	_, err := SetTaskPriority(task.ID, models.TaskPriorityHigh)
+	assert.NoError(t, err)
+	database.DB.Model(&models.Task{}).Where("id = ?", task.ID).Update("input_data", datatypes.JSON(`{"prompt":"b"}`))
+
+	stale.ErrorLog = "note"
+	assert.NoError(t, TransitionTask(&stale, models.TaskStatusPendingExecution, ActorSystem, "approved"))
+
+	var stored models.Task
+	database.DB.First(&stored, task.ID)
+	assert.Equal(t, models.TaskStatusPendingExecution, stored.Status)
+	assert.Equal(t, models.TaskPriorityHigh, stored.Priority)
+	assert.JSONEq(t, `{"prompt":"b"}`, string(stored.InputData))
+	assert.Equal(t, "note", stored.ErrorLog)
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TaskUpdatesChannel is the Redis pub/sub channel carrying every task status change,
+// so any instance can push updates to its connected clients
+const TaskUpdatesChannel = "task_updates"
+
+// taskUpdateBuffer is how many updates a slow client may lag behind before updates are dropped
+const taskUpdateBuffer = 32
+
+// TaskUpdate is a status change of a task as pushed to clients
+type TaskUpdate struct {
+	TaskID     uint              `json:"task_id"`
+	CreatorID  uint              `json:"creator_id"`
+	FromStatus models.TaskStatus `json:"from_status"`
+	Status     models.TaskStatus `json:"status"`
+	RetryCount int               `json:"retry_count"`
+	ResultURL  string            `json:"result_url,omitempty"`
+	ErrorLog   string            `json:"error_log,omitempty"`
+	At         time.Time         `json:"at"`
+}
+
+func init() {
+	RegisterTaskTransitionHook(publishTaskUpdate)
+}
+
+// publishTaskUpdate announces a status change to every instance
+func publishTaskUpdate(task *models.Task, from, to models.TaskStatus) {
+	if database.RedisClient == nil {
+		return
+	}
+	data, err := json.Marshal(TaskUpdate{
+		TaskID:     task.ID,
+		CreatorID:  task.CreatorID,
+		FromStatus: from,
+		Status:     to,
+		RetryCount: task.RetryCount,
+		ResultURL:  task.ResultURL,
+		ErrorLog:   task.ErrorLog,
+		At:         time.Now(),
+	})
+	if err != nil {
+		return
+	}
+	if err := database.RedisClient.Publish(database.Ctx, TaskUpdatesChannel, data).Err(); err != nil {
+		fmt.Printf("Failed to publish update of task %d: %v\n", task.ID, err)
+	}
+}
+
+// taskUpdateHub fans the updates received on this instance out to the local subscribers
+// of each user. A single Redis subscription serves all of them.
+type taskUpdateHub struct {
+	mu      sync.Mutex
+	once    sync.Once
+	streams map[uint]map[chan TaskUpdate]struct{}
+}
+
+var updateHub = &taskUpdateHub{streams: make(map[uint]map[chan TaskUpdate]struct{})}
+
+// SubscribeTaskUpdates returns the status changes of a user's tasks as they happen
+// on any instance, and a function to call when the client goes away
+func SubscribeTaskUpdates(userID uint) (<-chan TaskUpdate, func()) {
+	updateHub.once.Do(func() { go updateHub.relay() })
+	return updateHub.subscribe(userID)
+}
+
+func (h *taskUpdateHub) subscribe(userID uint) (<-chan TaskUpdate, func()) {
+	ch := make(chan TaskUpdate, taskUpdateBuffer)
+
+	h.mu.Lock()
+	if h.streams[userID] == nil {
+		h.streams[userID] = make(map[chan TaskUpdate]struct{})
+	}
+	h.streams[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.streams[userID], ch)
+		if len(h.streams[userID]) == 0 {
+			delete(h.streams, userID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// dispatch hands an update to the subscribers of the task's creator without blocking
+func (h *taskUpdateHub) dispatch(update TaskUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.streams[update.CreatorID] {
+		select {
+		case ch <- update:
+		default:
+			fmt.Printf("Dropping update of task %d for a slow subscriber\n", update.TaskID)
+		}
+	}
+}
+
+// relay forwards the updates published by every instance to the local subscribers. It blocks forever.
+func (h *taskUpdateHub) relay() {
+	sub := database.RedisClient.Subscribe(database.Ctx, TaskUpdatesChannel)
+	defer sub.Close()
+
+	for msg := range sub.Channel() {
+		var update TaskUpdate
+		if err := json.Unmarshal([]byte(msg.Payload), &update); err != nil {
+			continue
+		}
+		h.dispatch(update)
+	}
+}
+
+// waitRecheckInterval is how often WaitForTask reads the task again, in case an update was missed
+const waitRecheckInterval = time.Second
+
+// WaitForTask waits up to timeout for the current run of a task to finish and returns
+// the task with its artifacts, and whether it has finished. It gives up with the error
+// of ctx once ctx is done, such as when the client waiting for the task disconnects.
+func WaitForTask(ctx context.Context, taskID, creatorID uint, timeout time.Duration) (*TaskDetail, bool, error) {
+	// Subscribe before the first read so a change in between is not missed
+	updates, unsubscribe := SubscribeTaskUpdates(creatorID)
+	defer unsubscribe()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	recheck := time.NewTicker(waitRecheckInterval)
+	defer recheck.Stop()
+
+	for {
+		task, err := GetTaskByID(taskID)
+		if err != nil {
+			return nil, false, err
+		}
+		if isTaskFinished(task.Status) {
+			detail, err := GetTaskDetail(taskID)
+			return detail, err == nil, err
+		}
+
+	wait:
+		for {
+			select {
+			case update := <-updates:
+				if update.TaskID == taskID && isTaskFinished(update.Status) {
+					break wait
+				}
+			case <-recheck.C:
+				break wait
+			case <-deadline.C:
+				detail, err := GetTaskDetail(taskID)
+				return detail, false, err
+			case <-ctx.Done():
+				return nil, false, ctx.Err()
+			}
+		}
+	}
+}
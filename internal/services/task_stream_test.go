@@ -0,0 +1,81 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskUpdateHubDispatchesToCreator(t *testing.T) {
+	hub := &taskUpdateHub{streams: make(map[uint]map[chan TaskUpdate]struct{})}
+
+	mine, unsubscribe := hub.subscribe(1)
+	other, unsubscribeOther := hub.subscribe(2)
+	defer unsubscribeOther()
+
+	hub.dispatch(TaskUpdate{TaskID: 10, CreatorID: 1, Status: models.TaskStatusProcessing})
+
+	select {
+	case update := <-mine:
+		assert.Equal(t, uint(10), update.TaskID)
+	default:
+		t.Fatal("creator did not receive the update")
+	}
+	select {
+	case <-other:
+		t.Fatal("another user received the update")
+	default:
+	}
+
+	// Unsubscribed clients get nothing and leave no state behind
+	unsubscribe()
+	hub.dispatch(TaskUpdate{TaskID: 11, CreatorID: 1})
+	assert.Empty(t, mine)
+	_, ok := hub.streams[1]
+	assert.False(t, ok)
+}
+
+func TestTaskUpdateHubDropsForSlowSubscriber(t *testing.T) {
+	hub := &taskUpdateHub{streams: make(map[uint]map[chan TaskUpdate]struct{})}
+	ch, unsubscribe := hub.subscribe(1)
+	defer unsubscribe()
+
+	for i := 0; i < taskUpdateBuffer+5; i++ {
+		hub.dispatch(TaskUpdate{TaskID: uint(i), CreatorID: 1})
+	}
+	assert.Len(t, ch, taskUpdateBuffer)
+}
+
+func TestTransitionPublishesTaskUpdate(t *testing.T) {
+	setupRetryTestDB()
+	mr := setupRetryTestRedis()
+	defer mr.Close()
+
+	sub := database.RedisClient.Subscribe(database.Ctx, TaskUpdatesChannel)
+	defer sub.Close()
+	_, err := sub.Receive(database.Ctx)
+	require.NoError(t, err)
+
+	task := models.Task{CreatorID: 7, Status: models.TaskStatusProcessing}
+	require.NoError(t, database.DB.Create(&task).Error)
+	task.ResultURL = "https://oss.example.com/result.png"
+	require.NoError(t, TransitionTask(&task, models.TaskStatusCompleted, ActorSystem, "done"))
+
+	select {
+	case msg := <-sub.Channel():
+		var update TaskUpdate
+		require.NoError(t, json.Unmarshal([]byte(msg.Payload), &update))
+		assert.Equal(t, task.ID, update.TaskID)
+		assert.Equal(t, uint(7), update.CreatorID)
+		assert.Equal(t, models.TaskStatusProcessing, update.FromStatus)
+		assert.Equal(t, models.TaskStatusCompleted, update.Status)
+		assert.Equal(t, "https://oss.example.com/result.png", update.ResultURL)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no update was published")
+	}
+}
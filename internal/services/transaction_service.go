@@ -1,12 +1,17 @@
 package services
 
 import (
+	"aigentools-backend/config"
 	"aigentools-backend/internal/database"
 	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
 	"bytes"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // TransactionFilter defines criteria for filtering transactions
@@ -15,8 +20,8 @@ type TransactionFilter struct {
 	Type      *models.TransactionType
 	StartTime *time.Time
 	EndTime   *time.Time
-	MinAmount *float64
-	MaxAmount *float64
+	MinAmount *money.Amount
+	MaxAmount *money.Amount
 	Page      int
 	Limit     int
 }
@@ -59,6 +64,380 @@ func FindTransactions(filter TransactionFilter) ([]models.Transaction, int64, er
 	return transactions, total, nil
 }
 
+// recordTransactionTx hashes a transaction into its user's chain and inserts it inside tx.
+// Callers update the user row first, so its row lock keeps the chain of a user in order.
+func recordTransactionTx(tx *gorm.DB, transaction *models.Transaction) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+	keyID, secret, err := cfg.LedgerSigningKey()
+	if err != nil {
+		return err
+	}
+
+	var prev []string
+	if err := tx.Model(&models.Transaction{}).Where("user_id = ?", transaction.UserID).
+		Order("id desc").Limit(1).Pluck("hash", &prev).Error; err != nil {
+		return err
+	}
+	if len(prev) > 0 {
+		transaction.PrevHash = prev[0]
+	}
+
+	// CreatedAt is stored with millisecond precision; the hash must cover what is stored
+	if transaction.CreatedAt.IsZero() {
+		transaction.CreatedAt = time.Now()
+	}
+	transaction.CreatedAt = transaction.CreatedAt.Truncate(time.Millisecond)
+	transaction.HashVersion = models.CurrentHashVersion
+	transaction.KeyID = keyID
+	transaction.Hash = transaction.GenerateHash(secret)
+	return tx.Create(transaction).Error
+}
+
+// ChainBreakReason says why a transaction breaks its user's hash chain
+type ChainBreakReason string
+
+const (
+	ChainBreakHashMismatch     ChainBreakReason = "hash_mismatch"      // The hash does not match the transaction
+	ChainBreakPrevHashMismatch ChainBreakReason = "prev_hash_mismatch" // A transaction before it was removed, reordered or altered
+	ChainBreakUnchained        ChainBreakReason = "unchained"          // A transaction without a chained hash after the chain started
+	ChainBreakUnknownKey       ChainBreakReason = "unknown_key"        // The transaction was signed with a key no longer in the keyring
+)
+
+// ChainBreak is the first transaction of a user's chain that fails verification
+type ChainBreak struct {
+	TransactionID    uint             `json:"transaction_id"`
+	Reason           ChainBreakReason `json:"reason"`
+	KeyID            string           `json:"key_id,omitempty"`
+	ExpectedPrevHash string           `json:"expected_prev_hash,omitempty"`
+	PrevHash         string           `json:"prev_hash,omitempty"`
+}
+
+// ChainVerification is the result of walking a user's transaction chain
+type ChainVerification struct {
+	UserID   uint        `json:"user_id"`
+	Valid    bool        `json:"valid"`
+	Checked  int         `json:"checked"`  // Transactions verified before the walk stopped
+	Legacy   int         `json:"legacy"`   // Transactions hashed before chaining, verified on their own
+	Migrated int         `json:"migrated"` // Transactions hashed before chaining and re-signed into the chain by the legacy migration
+	Break    *ChainBreak `json:"broken_link,omitempty"`
+}
+
+// chainVerifyBatchSize is how many transactions a chain walk loads at a time
+const chainVerifyBatchSize = 500
+
+// VerifyTransactionChain walks a user's transactions in order, recomputes every hash with
+// the key it was signed with and checks each chained transaction points at the one before
+// it. It stops at the first broken link. Transactions hashed before chaining only have
+// their own hash checked.
+func VerifyTransactionChain(userID uint) (*ChainVerification, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return walkTransactionChain(database.DB, cfg, userID, nil)
+}
+
+// walkTransactionChain verifies a user's transaction chain within db, calling visit, when
+// given, with every transaction that verifies. visit may change the transaction it gets;
+// the chain is checked against the stored hashes.
+func walkTransactionChain(db *gorm.DB, cfg *config.Config, userID uint, visit func(t *models.Transaction) error) (*ChainVerification, error) {
+	result := &ChainVerification{UserID: userID, Valid: true}
+	prevHash := ""
+	chained := false
+	var lastID uint
+
+	for {
+		var batch []models.Transaction
+		if err := db.Where("user_id = ? AND id > ?", userID, lastID).
+			Order("id").Limit(chainVerifyBatchSize).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+
+		for i := range batch {
+			t := &batch[i]
+			var broken *ChainBreak
+			secret, known := cfg.LedgerKey(t.KeyID)
+			switch {
+			case !known:
+				broken = &ChainBreak{TransactionID: t.ID, Reason: ChainBreakUnknownKey, KeyID: t.KeyID}
+			case !t.VerifyHash(secret):
+				broken = &ChainBreak{TransactionID: t.ID, Reason: ChainBreakHashMismatch, KeyID: t.KeyID}
+			case t.HashVersion < models.HashVersionChained:
+				if chained {
+					broken = &ChainBreak{TransactionID: t.ID, Reason: ChainBreakUnchained}
+				}
+				result.Legacy++
+			case t.PrevHash != prevHash:
+				broken = &ChainBreak{
+					TransactionID:    t.ID,
+					Reason:           ChainBreakPrevHashMismatch,
+					ExpectedPrevHash: prevHash,
+					PrevHash:         t.PrevHash,
+				}
+			default:
+				chained = true
+				if t.HashVersion == models.HashVersionMigrated {
+					result.Migrated++
+				}
+			}
+			if broken != nil {
+				result.Valid = false
+				result.Break = broken
+				return result, nil
+			}
+
+			result.Checked++
+			prevHash = t.Hash
+			lastID = t.ID
+			if visit != nil {
+				if err := visit(t); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if len(batch) < chainVerifyBatchSize {
+			return result, nil
+		}
+	}
+}
+
+// ResignResult reports a re-sign of the ledger with the current signing key
+type ResignResult struct {
+	KeyID    string              `json:"key_id"`
+	Users    int                 `json:"users"`             // Users whose chain was re-signed
+	Resigned int                 `json:"resigned"`          // Transactions re-signed
+	Skipped  []ChainVerification `json:"skipped,omitempty"` // Chains left as they are because they do not verify
+}
+
+// ResignTransactions re-signs every transaction not signed with the current ledger signing
+// key, after a key rotation. Each user's chain is verified first and re-signed in one
+// database transaction, updating the PrevHash links along the way; a chain that does not
+// verify is left untouched and reported, so re-signing never covers up tampering.
+func ResignTransactions() (*ResignResult, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	keyID, secret, err := cfg.LedgerSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var userIDs []uint
+	if err := database.DB.Model(&models.Transaction{}).Where("key_id <> ?", keyID).
+		Distinct("user_id").Order("user_id").Pluck("user_id", &userIDs).Error; err != nil {
+		return nil, err
+	}
+
+	result := &ResignResult{KeyID: keyID}
+	for _, userID := range userIDs {
+		var verification *ChainVerification
+		resigned := 0
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			// Take the user row lock transactions are recorded under, so none are appended meanwhile
+			if err := tx.Model(&models.User{}).Where("id = ?", userID).
+				UpdateColumn("version", gorm.Expr("version")).Error; err != nil {
+				return err
+			}
+
+			newPrevHash := ""
+			var err error
+			verification, err = walkTransactionChain(tx, cfg, userID, func(t *models.Transaction) error {
+				relinked := t.HashVersion >= models.HashVersionChained && t.PrevHash != newPrevHash
+				if t.KeyID != keyID || relinked {
+					if relinked {
+						t.PrevHash = newPrevHash
+					}
+					t.KeyID = keyID
+					t.Hash = t.GenerateHash(secret)
+					if err := tx.Model(&models.Transaction{}).Where("id = ?", t.ID).Updates(map[string]interface{}{
+						"hash":      t.Hash,
+						"prev_hash": t.PrevHash,
+						"key_id":    t.KeyID,
+					}).Error; err != nil {
+						return err
+					}
+					resigned++
+				}
+				newPrevHash = t.Hash
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if !verification.Valid {
+				return errChainBroken
+			}
+			return nil
+		})
+		if errors.Is(err, errChainBroken) {
+			result.Skipped = append(result.Skipped, *verification)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Users++
+		result.Resigned += resigned
+	}
+	return result, nil
+}
+
+// errChainBroken rolls back the re-sign of a chain that does not verify
+var errChainBroken = errors.New("transaction chain is broken")
+
+// ErrLedgerMigrationNotFound is returned for an unknown ledger migration
+var ErrLedgerMigrationNotFound = errors.New("ledger migration not found")
+
+// MigrateLegacyTransactions re-signs the transactions recorded before hash chaining into
+// their users' chains with the current ledger signing key, marked as HashVersionMigrated.
+// Their hashes covered the creation time in nanoseconds while the database keeps less, so
+// they would otherwise be reported as tampered. Since re-signing vouches for them, every
+// transaction of a user must first pass the continuity and arithmetic checks of
+// reconciliation, and the chained transactions after them must verify; a user failing
+// any check is left untouched. The run is stored as a LedgerMigration, with one entry per
+// user saying what was migrated or why not. Later runs only find users left behind.
+func MigrateLegacyTransactions(operator string) (*models.LedgerMigration, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	keyID, secret, err := cfg.LedgerSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var userIDs []uint
+	if err := database.DB.Model(&models.Transaction{}).Where("hash_version < ?", models.HashVersionChained).
+		Distinct("user_id").Order("user_id").Pluck("user_id", &userIDs).Error; err != nil {
+		return nil, err
+	}
+
+	migration := &models.LedgerMigration{Operator: operator, KeyID: keyID}
+	for _, userID := range userIDs {
+		entry := models.LedgerMigrationEntry{UserID: userID}
+		skip := func(reason models.LedgerMigrationSkip, t *models.Transaction, detail string) error {
+			entry = models.LedgerMigrationEntry{UserID: userID, Skip: reason, TransactionID: &t.ID, Detail: detail}
+			return errChainBroken
+		}
+
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			// Take the user row lock transactions are recorded under, so none are appended meanwhile
+			if err := tx.Model(&models.User{}).Where("id = ?", userID).
+				UpdateColumn("version", gorm.Expr("version")).Error; err != nil {
+				return err
+			}
+
+			oldPrevHash, newPrevHash := "", ""
+			var prevAfter money.Amount
+			var lastID uint
+			for {
+				var batch []models.Transaction
+				if err := tx.Where("user_id = ? AND id > ?", userID, lastID).
+					Order("id").Limit(chainVerifyBatchSize).Find(&batch).Error; err != nil {
+					return err
+				}
+
+				for i := range batch {
+					t := &batch[i]
+					if t.BalanceBefore != prevAfter {
+						return skip(models.LedgerMigrationSkipContinuity, t,
+							fmt.Sprintf("balance_before %s differs from the previous balance_after %s", t.BalanceBefore, prevAfter))
+					}
+					if t.BalanceAfter != t.BalanceBefore+t.Amount {
+						return skip(models.LedgerMigrationSkipArithmetic, t,
+							fmt.Sprintf("balance_after %s is not balance_before plus amount %s", t.BalanceAfter, t.BalanceBefore+t.Amount))
+					}
+
+					storedHash := t.Hash
+					legacy := t.HashVersion < models.HashVersionChained
+					if legacy {
+						t.HashVersion = models.HashVersionMigrated
+						entry.Migrated++
+						if entry.FirstID == 0 {
+							entry.FirstID = t.ID
+						}
+						entry.LastID = t.ID
+					} else {
+						secret, known := cfg.LedgerKey(t.KeyID)
+						if !known || !t.VerifyHash(secret) || t.PrevHash != oldPrevHash {
+							return skip(models.LedgerMigrationSkipChain, t, "chained transaction does not verify")
+						}
+					}
+					if legacy || t.PrevHash != newPrevHash {
+						t.PrevHash = newPrevHash
+						t.KeyID = keyID
+						t.Hash = t.GenerateHash(secret)
+						if err := tx.Model(&models.Transaction{}).Where("id = ?", t.ID).Updates(map[string]interface{}{
+							"hash":         t.Hash,
+							"hash_version": t.HashVersion,
+							"prev_hash":    t.PrevHash,
+							"key_id":       t.KeyID,
+						}).Error; err != nil {
+							return err
+						}
+					}
+					oldPrevHash = storedHash
+					newPrevHash = t.Hash
+					prevAfter = t.BalanceAfter
+					lastID = t.ID
+				}
+
+				if len(batch) < chainVerifyBatchSize {
+					return nil
+				}
+			}
+		})
+		switch {
+		case errors.Is(err, errChainBroken):
+			migration.Skipped++
+		case err != nil:
+			return nil, err
+		default:
+			migration.Users++
+			migration.Migrated += entry.Migrated
+		}
+		migration.Entries = append(migration.Entries, entry)
+	}
+
+	if err := database.DB.Create(migration).Error; err != nil {
+		return nil, err
+	}
+	return migration, nil
+}
+
+// ListLedgerMigrations returns a page of ledger migrations without their entries, most recent first
+func ListLedgerMigrations(page, limit int) ([]models.LedgerMigration, int64, error) {
+	var migrations []models.LedgerMigration
+	var total int64
+	query := database.DB.Model(&models.LedgerMigration{})
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := query.Order("id desc").Limit(limit).Offset((page - 1) * limit).Find(&migrations).Error; err != nil {
+		return nil, 0, err
+	}
+	return migrations, total, nil
+}
+
+// GetLedgerMigration returns one ledger migration with its entries
+func GetLedgerMigration(id uint) (*models.LedgerMigration, error) {
+	var migration models.LedgerMigration
+	if err := database.DB.Preload("Entries", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).First(&migration, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrLedgerMigrationNotFound
+		}
+		return nil, err
+	}
+	return &migration, nil
+}
+
 // GenerateTransactionCSV generates a CSV file content for transactions
 func GenerateTransactionCSV(transactions []models.Transaction) ([]byte, error) {
 	b := &bytes.Buffer{}
@@ -81,9 +460,9 @@ func GenerateTransactionCSV(transactions []models.Transaction) ([]byte, error) {
 			t.CreatedAt.Format(time.RFC3339Nano),
 			fmt.Sprintf("%d", t.UserID),
 			string(t.Type),
-			fmt.Sprintf("%.2f", t.Amount),
-			fmt.Sprintf("%.2f", t.BalanceBefore),
-			fmt.Sprintf("%.2f", t.BalanceAfter),
+			csvAmount(t.Amount),
+			csvAmount(t.BalanceBefore),
+			csvAmount(t.BalanceAfter),
 			t.Reason,
 			t.Operator,
 			t.IPAddress,
@@ -102,3 +481,11 @@ func GenerateTransactionCSV(transactions []models.Transaction) ([]byte, error) {
 
 	return b.Bytes(), nil
 }
+
+// csvAmount formats an amount with two decimal places, or exactly when it has more
+func csvAmount(a money.Amount) string {
+	if a.IsRounded(2) {
+		return a.StringFixed(2)
+	}
+	return a.String()
+}
@@ -1,9 +1,9 @@
 package services
 
 import (
-	"aigentools-backend/config"
 	"aigentools-backend/internal/database"
 	"aigentools-backend/internal/models"
+	"aigentools-backend/pkg/money"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -20,7 +20,7 @@ var ErrInsufficientBalance = errors.New("insufficient balance")
 // ...
 
 // DeductBalance decreases user's balance and checks for sufficient funds.
-func DeductBalance(userID uint, amount float64, reason string, meta TransactionMetadata) (*models.User, error) {
+func DeductBalance(userID uint, amount money.Amount, reason string, meta TransactionMetadata) (*models.User, error) {
 	tx := database.DB.Begin()
 	defer func() {
 		if r := recover(); r != nil {
@@ -49,7 +49,7 @@ func DeductBalance(userID uint, amount float64, reason string, meta TransactionM
 }
 
 // DeductBalanceTx executes the deduction logic within a provided transaction.
-func DeductBalanceTx(tx *gorm.DB, userID uint, amount float64, reason string, meta TransactionMetadata) (*models.User, error) {
+func DeductBalanceTx(tx *gorm.DB, userID uint, amount money.Amount, reason string, meta TransactionMetadata) (*models.User, error) {
 	if amount <= 0 {
 		return nil, errors.New("amount must be positive")
 	}
@@ -62,9 +62,8 @@ func DeductBalanceTx(tx *gorm.DB, userID uint, amount float64, reason string, me
 		return nil, err
 	}
 
-	// Calculate available balance (Balance + CreditLimit)
-	availableBalance := user.Balance + user.CreditLimit
-	if availableBalance < amount {
+	// Calculate available balance (Balance + CreditLimit - HeldAmount)
+	if user.AvailableBalance() < amount {
 		return nil, ErrInsufficientBalance
 	}
 
@@ -104,15 +103,7 @@ func DeductBalanceTx(tx *gorm.DB, userID uint, amount float64, reason string, me
 		CreatedAt:     time.Now(),
 	}
 
-	// Generate hash
-	cfg, _ := config.LoadConfig()
-	secret := "default-secret"
-	if cfg != nil && cfg.JWTSecret != "" {
-		secret = cfg.JWTSecret
-	}
-	transaction.Hash = transaction.GenerateHash(secret)
-
-	if err := tx.Create(&transaction).Error; err != nil {
+	if err := recordTransactionTx(tx, &transaction); err != nil {
 		return nil, err
 	}
 
@@ -237,7 +228,7 @@ func UpdateUser(id uint, updates map[string]interface{}, operator string) (*mode
 		}
 	}
 
-	if creditLimit, ok := updates["credit_limit"].(float64); ok {
+	if creditLimit, ok := updates["credit_limit"].(money.Amount); ok {
 		updates["credit_limit"] = creditLimit
 	}
 
@@ -287,7 +278,7 @@ type TransactionMetadata struct {
 }
 
 // AdjustBalance updates user's balance and records the transaction.
-func AdjustBalance(userID uint, amount float64, reason string, meta TransactionMetadata) (*models.User, error) {
+func AdjustBalance(userID uint, amount money.Amount, reason string, meta TransactionMetadata) (*models.User, error) {
 	tx := database.DB.Begin()
 	defer func() {
 		if r := recover(); r != nil {
@@ -295,9 +286,34 @@ func AdjustBalance(userID uint, amount float64, reason string, meta TransactionM
 		}
 	}()
 
+	if _, err := AdjustBalanceTx(tx, userID, amount, reason, meta); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Commit
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	// Invalidate cache
+	if database.RedisClient != nil {
+		cacheKey := fmt.Sprintf("user:%d", userID)
+		database.RedisClient.Del(database.Ctx, cacheKey)
+	}
+
+	// Fetch updated user
+	var user models.User
+	database.DB.First(&user, userID)
+
+	return &user, nil
+}
+
+// AdjustBalanceTx executes the adjustment logic within a provided transaction.
+// The caller is responsible for invalidating the user cache after commit.
+func AdjustBalanceTx(tx *gorm.DB, userID uint, amount money.Amount, reason string, meta TransactionMetadata) (*models.User, error) {
 	var user models.User
 	if err := tx.First(&user, userID).Error; err != nil {
-		tx.Rollback()
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrUserNotFound
 		}
@@ -335,11 +351,9 @@ func AdjustBalance(userID uint, amount float64, reason string, meta TransactionM
 	// Apply updates with optimistic lock
 	result := tx.Model(&user).Where("version = ?", currentVersion).Updates(updates)
 	if result.Error != nil {
-		tx.Rollback()
 		return nil, result.Error
 	}
 	if result.RowsAffected == 0 {
-		tx.Rollback()
 		return nil, ErrOptimisticLock
 	}
 
@@ -358,38 +372,19 @@ func AdjustBalance(userID uint, amount float64, reason string, meta TransactionM
 		CreatedAt:     time.Now(),
 	}
 
-	// Generate hash for tamper-proofing
-	// In production, secret should come from config/env
-	cfg, _ := config.LoadConfig() // Assuming LoadConfig is cheap or cached
-	secret := "default-secret"
-	if cfg != nil && cfg.JWTSecret != "" {
-		secret = cfg.JWTSecret
-	}
-	transaction.Hash = transaction.GenerateHash(secret)
-
-	if err := tx.Create(&transaction).Error; err != nil {
-		tx.Rollback()
-		return nil, err
-	}
-
-	// Commit
-	if err := tx.Commit().Error; err != nil {
+	if err := recordTransactionTx(tx, &transaction); err != nil {
 		return nil, err
 	}
 
-	// Invalidate cache
-	if database.RedisClient != nil {
-		cacheKey := fmt.Sprintf("user:%d", userID)
-		database.RedisClient.Del(database.Ctx, cacheKey)
-	}
-
-	// Fetch updated user
-	database.DB.First(&user, userID)
-
+	user.Balance = balanceAfter
+	user.Version++
 	return &user, nil
 }
 
-// DeleteUser permanently deletes a user and their transactions.
+// DeleteUser permanently deletes a user. The user row is removed rather than soft-deleted,
+// so the username can be registered again. Their transactions stay in the ledger under the
+// old user ID, with the client details that are not part of the hash cleared, so the hash
+// chain still verifies; reconciliation walks the existing users and no longer checks them.
 func DeleteUser(id uint) error {
 	tx := database.DB.Begin()
 	defer func() {
@@ -408,8 +403,11 @@ func DeleteUser(id uint) error {
 		return err
 	}
 
-	// Delete all transactions for the user
-	if err := tx.Where("user_id = ?", id).Delete(&models.Transaction{}).Error; err != nil {
+	// Anonymize the transactions of the user
+	if err := tx.Model(&models.Transaction{}).Where("user_id = ?", id).Updates(map[string]interface{}{
+		"ip_address":  "",
+		"device_info": "",
+	}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
@@ -432,7 +430,7 @@ func DeleteUser(id uint) error {
 	}
 
 	// Log operation (placeholder for audit log)
-	fmt.Printf("User %d and their transactions permanently deleted.\n", id)
+	fmt.Printf("User %d permanently deleted; their transactions were kept and anonymized.\n", id)
 
 	return nil
 }
@@ -0,0 +1,414 @@
+package services
+
+import (
+	"aigentools-backend/config"
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	MaxWebhookEndpoints = 5
+
+	webhookMaxAttempts = 8
+	webhookTimeout     = 10 * time.Second
+	webhookBatchSize   = 100
+)
+
+// Headers sent with every webhook delivery
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+var (
+	ErrInvalidWebhookURL = errors.New("webhook url must be an absolute http or https url")
+	ErrTooManyWebhooks   = fmt.Errorf("at most %d webhook endpoints are allowed", MaxWebhookEndpoints)
+	ErrDeliveryPending   = errors.New("webhook delivery is still being attempted")
+
+	errWebhookAddressBlocked = errors.New("webhook address is not public")
+	errWebhookRedirect       = errors.New("webhook receivers may not redirect")
+)
+
+// WebhookRetry spaces out the attempts of a delivery whose receiver is failing;
+// together with webhookMaxAttempts it covers roughly a day
+var WebhookRetry = RetryPolicy{BaseDelay: 30 * time.Second, MaxDelay: 6 * time.Hour}
+
+// webhookClient delivers to user-supplied URLs, so it must not become a way into the
+// internal network: every address is checked when it is dialed, after DNS resolution,
+// and redirects are not followed. It dials directly, since a proxy would hide the address.
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: webhookTimeout, Control: webhookDialControl}).DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return errWebhookRedirect
+	},
+}
+
+// webhookDialControl refuses connections to addresses that are not public
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !webhookAddressAllowed(ip) {
+		return fmt.Errorf("%w: %s", errWebhookAddressBlocked, host)
+	}
+	return nil
+}
+
+// webhookAddressAllowed reports whether webhooks may be sent to ip: loopback, private,
+// link-local, multicast and unspecified addresses are refused unless
+// WEBHOOK_ALLOW_PRIVATE_NETWORKS is set
+func webhookAddressAllowed(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		cfg, _ := config.LoadConfig()
+		return cfg != nil && cfg.WebhookAllowPrivateNetworks
+	}
+	return true
+}
+
+// WebhookPayload is the JSON body of a delivery
+type WebhookPayload struct {
+	Event      models.WebhookEvent `json:"event"`
+	OccurredAt time.Time           `json:"occurred_at"`
+	Task       *models.Task        `json:"task"`
+}
+
+func init() {
+	RegisterTaskTransitionHook(enqueueTaskWebhooks)
+}
+
+// webhookEventFor returns the event reported for a transition, if any
+func webhookEventFor(to models.TaskStatus) (models.WebhookEvent, bool) {
+	switch to {
+	case models.TaskStatusCompleted:
+		return models.WebhookEventTaskCompleted, true
+	case models.TaskStatusFailed:
+		return models.WebhookEventTaskFailed, true
+	case models.TaskStatusCancelled:
+		return models.WebhookEventTaskCancelled, true
+	}
+	return "", false
+}
+
+// enqueueTaskWebhooks records a delivery for the task's callback_url and for each
+// endpoint of its creator once the task has finished, and sends them right away.
+// Deliveries that fail are retried by the scheduler.
+func enqueueTaskWebhooks(task *models.Task, from, to models.TaskStatus) {
+	event, ok := webhookEventFor(to)
+	if !ok || database.DB == nil {
+		return
+	}
+
+	var endpoints []models.WebhookEndpoint
+	if err := database.DB.Where("user_id = ?", task.CreatorID).Order("id").Find(&endpoints).Error; err != nil {
+		fmt.Printf("Webhook: Failed to load endpoints of user %d: %v\n", task.CreatorID, err)
+		return
+	}
+	if task.CallbackURL == "" && len(endpoints) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(WebhookPayload{Event: event, OccurredAt: time.Now(), Task: task})
+	if err != nil {
+		fmt.Printf("Webhook: Failed to encode payload of task %d: %v\n", task.ID, err)
+		return
+	}
+
+	now := time.Now()
+	var deliveries []models.WebhookDelivery
+	newDelivery := func(endpointID *uint, url string) models.WebhookDelivery {
+		return models.WebhookDelivery{
+			UserID:        task.CreatorID,
+			TaskID:        task.ID,
+			EndpointID:    endpointID,
+			URL:           url,
+			Event:         event,
+			Payload:       string(payload),
+			Status:        models.WebhookDeliveryPending,
+			NextAttemptAt: &now,
+		}
+	}
+	if task.CallbackURL != "" {
+		deliveries = append(deliveries, newDelivery(nil, task.CallbackURL))
+	}
+	for i := range endpoints {
+		deliveries = append(deliveries, newDelivery(&endpoints[i].ID, endpoints[i].URL))
+	}
+
+	if err := database.DB.Create(&deliveries).Error; err != nil {
+		fmt.Printf("Webhook: Failed to record deliveries of task %d: %v\n", task.ID, err)
+		return
+	}
+	for _, d := range deliveries {
+		go attemptWebhookDelivery(d.ID, time.Now())
+	}
+}
+
+// deliverDueWebhooks attempts every pending delivery whose next attempt is due at now
+func deliverDueWebhooks(now time.Time) {
+	var ids []uint
+	err := database.DB.Model(&models.WebhookDelivery{}).
+		Where("status = ? AND next_attempt_at <= ?", models.WebhookDeliveryPending, now).
+		Order("next_attempt_at").
+		Limit(webhookBatchSize).
+		Pluck("id", &ids).Error
+	if err != nil {
+		fmt.Printf("Webhook: Failed to load due deliveries: %v\n", err)
+		return
+	}
+	for _, id := range ids {
+		attemptWebhookDelivery(id, now)
+	}
+}
+
+// attemptWebhookDelivery sends a pending delivery once and records the outcome.
+// The attempt is claimed by pushing next_attempt_at past the request timeout, so
+// instances scanning at the same time do not send it twice.
+func attemptWebhookDelivery(id uint, now time.Time) {
+	lease := now.Add(2 * webhookTimeout)
+	res := database.DB.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", id, models.WebhookDeliveryPending, now).
+		Updates(map[string]interface{}{"next_attempt_at": lease, "attempts": gorm.Expr("attempts + 1")})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return
+	}
+
+	var delivery models.WebhookDelivery
+	if err := database.DB.First(&delivery, id).Error; err != nil {
+		return
+	}
+	secret, err := GetWebhookSecret(delivery.UserID)
+	if err != nil {
+		fmt.Printf("Webhook: Failed to load secret of user %d: %v\n", delivery.UserID, err)
+		return
+	}
+
+	status, sendErr := sendWebhook(&delivery, secret)
+
+	updates := map[string]interface{}{"response_status": status}
+	switch {
+	case sendErr == nil:
+		delivered := time.Now()
+		updates["status"] = models.WebhookDeliverySucceeded
+		updates["delivered_at"] = &delivered
+		updates["next_attempt_at"] = nil
+		updates["last_error"] = ""
+	case delivery.Attempts >= webhookMaxAttempts:
+		updates["status"] = models.WebhookDeliveryFailed
+		updates["next_attempt_at"] = nil
+		updates["last_error"] = sendErr.Error()
+	default:
+		next := time.Now().Add(WebhookRetry.Backoff(delivery.Attempts))
+		updates["next_attempt_at"] = &next
+		updates["last_error"] = sendErr.Error()
+	}
+	if err := database.DB.Model(&delivery).Updates(updates).Error; err != nil {
+		fmt.Printf("Webhook: Failed to record attempt of delivery %d: %v\n", id, err)
+	}
+}
+
+// sendWebhook posts a delivery and returns the response status. Anything but a 2xx answer is an error.
+func sendWebhook(delivery *models.WebhookDelivery, secret string) (int, error) {
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, string(delivery.Event))
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, "sha256="+delivery.GenerateSignature(secret, timestamp))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// ReplayWebhookDelivery sends a delivery of the user's again once it has succeeded or failed,
+// and returns it with the result of the new attempt. Deliveries still pending are left to
+// their schedule with ErrDeliveryPending, so a replay never races an attempt in progress.
+func ReplayWebhookDelivery(id, userID uint) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	if err := database.DB.Where("id = ? AND user_id = ?", id, userID).First(&delivery).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := database.DB.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status IN ?", id, []models.WebhookDeliveryStatus{models.WebhookDeliverySucceeded, models.WebhookDeliveryFailed}).
+		Updates(map[string]interface{}{
+			"status":          models.WebhookDeliveryPending,
+			"attempts":        0,
+			"next_attempt_at": &now,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrDeliveryPending
+	}
+
+	attemptWebhookDelivery(id, time.Now())
+
+	if err := database.DB.First(&delivery, id).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// GetWebhookDeliveries returns a user's deliveries, newest first, optionally for one task
+func GetWebhookDeliveries(page, pageSize int, userID, taskID uint) ([]models.WebhookDelivery, int64, error) {
+	var deliveries []models.WebhookDelivery
+	var total int64
+
+	db := database.DB.Model(&models.WebhookDelivery{}).Where("user_id = ?", userID)
+	if taskID != 0 {
+		db = db.Where("task_id = ?", taskID)
+	}
+
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * pageSize
+	if err := db.Offset(offset).Limit(pageSize).Order("created_at desc, id desc").Find(&deliveries).Error; err != nil {
+		return nil, 0, err
+	}
+	return deliveries, total, nil
+}
+
+// validateWebhookURL accepts absolute http and https URLs only. Hosts that are
+// obviously internal are refused right away; names resolving to internal addresses
+// are refused when a delivery dials them.
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	host := u.Hostname()
+	ip := net.ParseIP(host)
+	if strings.EqualFold(host, "localhost") {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+	if ip != nil && !webhookAddressAllowed(ip) {
+		return fmt.Errorf("%w: %s is not a public address", ErrInvalidWebhookURL, host)
+	}
+	return nil
+}
+
+// CreateWebhookEndpoint registers a URL notified about every finished task of the user
+func CreateWebhookEndpoint(userID uint, rawURL string) (*models.WebhookEndpoint, error) {
+	if err := validateWebhookURL(rawURL); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := database.DB.Model(&models.WebhookEndpoint{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count >= MaxWebhookEndpoints {
+		return nil, ErrTooManyWebhooks
+	}
+
+	endpoint := models.WebhookEndpoint{UserID: userID, URL: rawURL}
+	if err := database.DB.Create(&endpoint).Error; err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// GetWebhookEndpoints returns a user's endpoints
+func GetWebhookEndpoints(userID uint) ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	err := database.DB.Where("user_id = ?", userID).Order("id").Find(&endpoints).Error
+	return endpoints, err
+}
+
+// DeleteWebhookEndpoint removes one of the user's endpoints; its past deliveries are kept
+func DeleteWebhookEndpoint(id, userID uint) error {
+	res := database.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&models.WebhookEndpoint{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetWebhookSecret returns the key the user's deliveries are signed with, creating it on first use
+func GetWebhookSecret(userID uint) (string, error) {
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return "", err
+	}
+	// Keep the existing secret if another request created it first
+	row := models.WebhookSecret{UserID: userID, Secret: secret}
+	if err := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+		return "", err
+	}
+	if err := database.DB.First(&row, userID).Error; err != nil {
+		return "", err
+	}
+	return row.Secret, nil
+}
+
+// RotateWebhookSecret replaces the user's signing key; later attempts, including retries, use the new one
+func RotateWebhookSecret(userID uint) (string, error) {
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return "", err
+	}
+	row := models.WebhookSecret{UserID: userID, Secret: secret}
+	err = database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"secret", "updated_at"}),
+	}).Create(&row).Error
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
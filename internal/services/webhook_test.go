@@ -0,0 +1,196 @@
+package services
+
+import (
+	"aigentools-backend/internal/database"
+	"aigentools-backend/internal/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupWebhookTestDB() {
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	if err != nil {
+		panic("failed to connect database")
+	}
+
+	tables := []interface{}{&models.Task{}, &models.TaskEvent{}, &models.TaskArtifact{}, &models.WebhookSecret{}, &models.WebhookEndpoint{}, &models.WebhookDelivery{}}
+	db.Migrator().DropTable(tables...)
+	db.AutoMigrate(tables...)
+
+	database.DB = db
+}
+
+// waitForDelivery waits until the first attempt of a task's delivery has been recorded with the given outcome
+func waitForDelivery(t *testing.T, taskID uint, status models.WebhookDeliveryStatus) models.WebhookDelivery {
+	var delivery models.WebhookDelivery
+	require.Eventually(t, func() bool {
+		return database.DB.Where("task_id = ? AND status = ? AND response_status <> 0", taskID, status).First(&delivery).Error == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	return delivery
+}
+
+func TestTaskWebhookDeliveredAndSigned(t *testing.T) {
+	setupWebhookTestDB()
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true") // The receivers listen on loopback
+
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header.Clone()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	task := models.Task{CreatorID: 1, Status: models.TaskStatusProcessing, CallbackURL: server.URL}
+	require.NoError(t, database.DB.Create(&task).Error)
+	task.ResultURL = "https://oss.example.com/result.png"
+	require.NoError(t, TransitionTask(&task, models.TaskStatusCompleted, ActorSystem, "completed"))
+
+	delivery := waitForDelivery(t, task.ID, models.WebhookDeliverySucceeded)
+	assert.Equal(t, models.WebhookEventTaskCompleted, delivery.Event)
+	assert.Equal(t, http.StatusNoContent, delivery.ResponseStatus)
+	assert.Equal(t, delivery.Payload, string(body))
+	assert.Contains(t, delivery.Payload, "https://oss.example.com/result.png")
+
+	// The signature covers the timestamp and the exact body
+	secret, err := GetWebhookSecret(1)
+	require.NoError(t, err)
+	timestamp, err := strconv.ParseInt(header.Get(WebhookTimestampHeader), 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256="+delivery.GenerateSignature(secret, timestamp), header.Get(WebhookSignatureHeader))
+	assert.Equal(t, "task.completed", header.Get(WebhookEventHeader))
+}
+
+func TestTaskWebhookFansOutToEndpoints(t *testing.T) {
+	setupWebhookTestDB()
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true") // The receivers listen on loopback
+
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer server.Close()
+
+	_, err := CreateWebhookEndpoint(2, server.URL+"/a")
+	require.NoError(t, err)
+	_, err = CreateWebhookEndpoint(2, "ftp://example.com")
+	assert.ErrorIs(t, err, ErrInvalidWebhookURL)
+
+	// Failures and cancellations are reported too; other transitions are not
+	task := models.Task{CreatorID: 2, Status: models.TaskStatusPendingExecution}
+	require.NoError(t, database.DB.Create(&task).Error)
+	require.NoError(t, TransitionTask(&task, models.TaskStatusProcessing, ActorSystem, "started"))
+	require.NoError(t, TransitionTask(&task, models.TaskStatusFailed, ActorSystem, "failed"))
+
+	delivery := waitForDelivery(t, task.ID, models.WebhookDeliverySucceeded)
+	assert.Equal(t, models.WebhookEventTaskFailed, delivery.Event)
+	require.NotNil(t, delivery.EndpointID)
+
+	var count int64
+	database.DB.Model(&models.WebhookDelivery{}).Where("task_id = ?", task.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+}
+
+func TestTaskWebhookRetryAndReplay(t *testing.T) {
+	setupWebhookTestDB()
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true") // The receivers listen on loopback
+
+	var healthy int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	task := models.Task{CreatorID: 3, Status: models.TaskStatusPendingExecution, CallbackURL: server.URL}
+	require.NoError(t, database.DB.Create(&task).Error)
+	require.NoError(t, TransitionTask(&task, models.TaskStatusCancelled, UserActor(3), "cancelled"))
+
+	// A failed attempt is scheduled again with backoff
+	delivery := waitForDelivery(t, task.ID, models.WebhookDeliveryPending)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, http.StatusBadGateway, delivery.ResponseStatus)
+	require.NotNil(t, delivery.NextAttemptAt)
+	assert.True(t, delivery.NextAttemptAt.After(time.Now()))
+
+	// A pending delivery cannot be replayed
+	_, err := ReplayWebhookDelivery(delivery.ID, 3)
+	assert.ErrorIs(t, err, ErrDeliveryPending)
+
+	// Not due yet
+	deliverDueWebhooks(time.Now())
+	database.DB.First(&delivery, delivery.ID)
+	assert.Equal(t, 1, delivery.Attempts)
+
+	// The last allowed attempt gives up
+	past := time.Now().Add(-time.Second)
+	database.DB.Model(&delivery).Updates(map[string]interface{}{"attempts": webhookMaxAttempts - 1, "next_attempt_at": &past})
+	deliverDueWebhooks(time.Now())
+	var failed models.WebhookDelivery
+	database.DB.First(&failed, delivery.ID)
+	assert.Equal(t, models.WebhookDeliveryFailed, failed.Status)
+	assert.Nil(t, failed.NextAttemptAt)
+
+	// Only the owner can replay
+	_, err = ReplayWebhookDelivery(delivery.ID, 4)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	atomic.StoreInt32(&healthy, 1)
+	replayed, err := ReplayWebhookDelivery(delivery.ID, 3)
+	require.NoError(t, err)
+	assert.Equal(t, models.WebhookDeliverySucceeded, replayed.Status)
+	assert.Equal(t, 1, replayed.Attempts)
+	assert.NotNil(t, replayed.DeliveredAt)
+}
+
+func TestRotateWebhookSecret(t *testing.T) {
+	setupWebhookTestDB()
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true") // The receivers listen on loopback
+
+	first, err := GetWebhookSecret(5)
+	require.NoError(t, err)
+	again, err := GetWebhookSecret(5)
+	require.NoError(t, err)
+	assert.Equal(t, first, again)
+
+	rotated, err := RotateWebhookSecret(5)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, rotated)
+	current, _ := GetWebhookSecret(5)
+	assert.Equal(t, rotated, current)
+}
+
+func TestWebhookBlocksInternalAddresses(t *testing.T) {
+	for _, raw := range []string{"http://127.0.0.1:8080/hook", "http://localhost/hook", "http://10.0.0.5/hook", "http://169.254.169.254/latest/meta-data", "http://[::1]/hook"} {
+		assert.ErrorIs(t, validateWebhookURL(raw), ErrInvalidWebhookURL, raw)
+	}
+	assert.NoError(t, validateWebhookURL("https://hooks.example.com/aigentools"))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/", http.StatusFound)
+	}))
+	defer server.Close()
+
+	// Names resolving to internal addresses are refused when dialed
+	_, err := webhookClient.Get(strings.Replace(server.URL, "127.0.0.1", "localhost", 1))
+	assert.ErrorIs(t, err, errWebhookAddressBlocked)
+
+	// Redirects are not followed, even from allowed receivers
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+	_, err = webhookClient.Get(server.URL)
+	assert.ErrorIs(t, err, errWebhookRedirect)
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"sort"
+	"sync"
+)
+
+const defaultWorkerConcurrency = 10
+
+// WorkerPool bounds how many tasks run at once, globally and per executor.
+// The worker takes a global slot before pulling a task from the queue, so
+// tasks beyond the cap stay queued instead of spawning goroutines. A task then
+// needs a slot of its executor before calling the upstream API; when there is
+// none it goes back to the queue, so a busy executor never holds global slots
+// that tasks of other executors could use.
+type WorkerPool struct {
+	mu             sync.Mutex
+	cond           *sync.Cond
+	globalLimit    int
+	executorLimits map[string]int
+	running        int
+	inFlight       map[string]int
+	deferred       map[string]int
+}
+
+// ExecutorStats describes the load of a single executor.
+type ExecutorStats struct {
+	Name     string `json:"name"`
+	Limit    int    `json:"limit"` // 0 means only the global limit applies
+	InFlight int    `json:"in_flight"`
+	Deferred int    `json:"deferred"` // Tasks sent back to the queue because the executor was full, since start
+}
+
+// WorkerPoolStats is a snapshot of the worker pool.
+type WorkerPoolStats struct {
+	GlobalLimit int             `json:"global_limit"`
+	Running     int             `json:"running"`
+	Executors   []ExecutorStats `json:"executors"`
+}
+
+// Pool is the worker pool used by StartWorker.
+var Pool = NewWorkerPool(defaultWorkerConcurrency, nil)
+
+// NewWorkerPool creates a pool with a global limit and optional per-executor limits.
+func NewWorkerPool(globalLimit int, executorLimits map[string]int) *WorkerPool {
+	p := &WorkerPool{
+		inFlight: make(map[string]int),
+		deferred: make(map[string]int),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	p.Configure(globalLimit, executorLimits)
+	return p
+}
+
+// Configure replaces the limits. Tasks already running are not interrupted.
+func (p *WorkerPool) Configure(globalLimit int, executorLimits map[string]int) {
+	if globalLimit <= 0 {
+		globalLimit = defaultWorkerConcurrency
+	}
+	limits := make(map[string]int, len(executorLimits))
+	for name, limit := range executorLimits {
+		limits[name] = limit
+	}
+
+	p.mu.Lock()
+	p.globalLimit = globalLimit
+	p.executorLimits = limits
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// AcquireSlot blocks until a global slot is free.
+func (p *WorkerPool) AcquireSlot() {
+	p.mu.Lock()
+	for p.running >= p.globalLimit {
+		p.cond.Wait()
+	}
+	p.running++
+	p.mu.Unlock()
+}
+
+// ReleaseSlot frees a global slot.
+func (p *WorkerPool) ReleaseSlot() {
+	p.mu.Lock()
+	p.running--
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// TryAcquireExecutor takes a slot of the executor if one is free. It reports false,
+// counting the task as deferred, when the executor is at its limit.
+func (p *WorkerPool) TryAcquireExecutor(name string) (release func(), ok bool) {
+	p.mu.Lock()
+	if limit := p.executorLimits[name]; limit > 0 && p.inFlight[name] >= limit {
+		p.deferred[name]++
+		p.mu.Unlock()
+		return nil, false
+	}
+	p.inFlight[name]++
+	p.mu.Unlock()
+	return p.executorRelease(name), true
+}
+
+// executorRelease returns the function freeing a slot of the executor, once
+func (p *WorkerPool) executorRelease(name string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			p.inFlight[name]--
+			p.mu.Unlock()
+			p.cond.Broadcast()
+		})
+	}
+}
+
+// Stats returns the current limits and in-flight counts of every known executor.
+func (p *WorkerPool) Stats() WorkerPoolStats {
+	names := make(map[string]struct{})
+	executorMu.RLock()
+	for name := range executorRegistry {
+		names[name] = struct{}{}
+	}
+	executorMu.RUnlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for name := range p.executorLimits {
+		names[name] = struct{}{}
+	}
+	for name := range p.inFlight {
+		names[name] = struct{}{}
+	}
+	for name := range p.deferred {
+		names[name] = struct{}{}
+	}
+
+	stats := WorkerPoolStats{
+		GlobalLimit: p.globalLimit,
+		Running:     p.running,
+		Executors:   make([]ExecutorStats, 0, len(names)),
+	}
+	for name := range names {
+		stats.Executors = append(stats.Executors, ExecutorStats{
+			Name:     name,
+			Limit:    p.executorLimits[name],
+			InFlight: p.inFlight[name],
+			Deferred: p.deferred[name],
+		})
+	}
+	sort.Slice(stats.Executors, func(i, j int) bool {
+		return stats.Executors[i].Name < stats.Executors[j].Name
+	})
+	return stats
+}
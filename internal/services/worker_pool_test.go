@@ -0,0 +1,97 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func findExecutorStats(stats WorkerPoolStats, name string) ExecutorStats {
+	for _, s := range stats.Executors {
+		if s.Name == name {
+			return s
+		}
+	}
+	return ExecutorStats{Name: name}
+}
+
+func TestWorkerPool_ExecutorLimit(t *testing.T) {
+	pool := NewWorkerPool(10, map[string]int{"jiekou_api": 1})
+
+	release, ok := pool.TryAcquireExecutor("jiekou_api")
+	assert.True(t, ok)
+	assert.Equal(t, 1, findExecutorStats(pool.Stats(), "jiekou_api").InFlight)
+
+	// Releasing twice frees the slot only once
+	release()
+	release()
+	stats := findExecutorStats(pool.Stats(), "jiekou_api")
+	assert.Equal(t, 0, stats.InFlight)
+	assert.Equal(t, 1, stats.Limit)
+
+	// A raised limit applies to the next task
+	release, _ = pool.TryAcquireExecutor("jiekou_api")
+	pool.Configure(10, map[string]int{"jiekou_api": 2})
+	releaseSecond, ok := pool.TryAcquireExecutor("jiekou_api")
+	assert.True(t, ok)
+	_, ok = pool.TryAcquireExecutor("jiekou_api")
+	assert.False(t, ok)
+	release()
+	releaseSecond()
+
+	stats = findExecutorStats(pool.Stats(), "jiekou_api")
+	assert.Equal(t, 0, stats.InFlight)
+	assert.Equal(t, 2, stats.Limit)
+	assert.Equal(t, 1, stats.Deferred)
+}
+
+func TestWorkerPool_GlobalLimit(t *testing.T) {
+	pool := NewWorkerPool(2, nil)
+	pool.AcquireSlot()
+	pool.AcquireSlot()
+
+	acquired := make(chan struct{})
+	go func() {
+		pool.AcquireSlot()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("global limit was not enforced")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Equal(t, 2, pool.Stats().Running)
+
+	pool.ReleaseSlot()
+	select {
+	case <-acquired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiting slot was not released")
+	}
+	assert.Equal(t, 2, pool.Stats().GlobalLimit)
+}
+
+func TestWorkerPool_TryAcquireExecutor(t *testing.T) {
+	pool := NewWorkerPool(10, map[string]int{"jiekou_api": 1})
+
+	release, ok := pool.TryAcquireExecutor("jiekou_api")
+	assert.True(t, ok)
+
+	// A full executor turns the task away instead of blocking
+	_, ok = pool.TryAcquireExecutor("jiekou_api")
+	assert.False(t, ok)
+	stats := findExecutorStats(pool.Stats(), "jiekou_api")
+	assert.Equal(t, 1, stats.InFlight)
+	assert.Equal(t, 1, stats.Deferred)
+
+	_, ok = pool.TryAcquireExecutor("remote_api")
+	assert.True(t, ok)
+
+	release()
+	release, ok = pool.TryAcquireExecutor("jiekou_api")
+	assert.True(t, ok)
+	release()
+	assert.Equal(t, 0, findExecutorStats(pool.Stats(), "jiekou_api").InFlight)
+}
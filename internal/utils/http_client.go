@@ -8,9 +8,26 @@ import (
 	"time"
 )
 
+// sensitiveHeaders carry credentials; their values are never logged
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
 // LoggingTransport implements http.RoundTripper and logs requests and responses
 type LoggingTransport struct {
-	Transport http.RoundTripper
+	Transport     http.RoundTripper
+	RedactHeaders []string // Further headers whose values are left out of the log
+}
+
+// loggedHeaders returns the request headers with the values of credentials replaced
+func (t *LoggingTransport) loggedHeaders(header http.Header) http.Header {
+	logged := header.Clone()
+	for _, names := range [][]string{sensitiveHeaders, t.RedactHeaders} {
+		for _, name := range names {
+			if logged.Get(name) != "" {
+				logged.Set(name, "[REDACTED]")
+			}
+		}
+	}
+	return logged
 }
 
 // RoundTrip executes a single HTTP transaction and logs the request and response
@@ -24,7 +41,7 @@ func (t *LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error)
 			reqBodyLog = string(bodyBytes)
 		}
 	}
-	fmt.Printf("[HTTP Request] %s %s | Headers: %v | Body: %s\n", req.Method, req.URL, req.Header, reqBodyLog)
+	fmt.Printf("[HTTP Request] %s %s | Headers: %v | Body: %s\n", req.Method, req.URL, t.loggedHeaders(req.Header), reqBodyLog)
 
 	start := time.Now()
 
@@ -62,12 +79,14 @@ func (t *LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error)
 	return resp, nil
 }
 
-// NewHTTPClient returns a new http.Client with logging enabled
-func NewHTTPClient(timeout time.Duration) *http.Client {
+// NewHTTPClient returns a new http.Client with logging enabled. Credentials in the
+// Authorization, Cookie and X-Api-Key headers, and in any of redactHeaders, are not logged.
+func NewHTTPClient(timeout time.Duration, redactHeaders ...string) *http.Client {
 	return &http.Client{
 		Timeout: timeout,
 		Transport: &LoggingTransport{
-			Transport: http.DefaultTransport,
+			Transport:     http.DefaultTransport,
+			RedactHeaders: redactHeaders,
 		},
 	}
 }
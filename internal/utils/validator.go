@@ -26,6 +26,18 @@ type ValidationErrorData struct {
 
 const DocumentationLink = "https://localhost:8080/swagger/index.html" // Replace with actual documentation link
 
+// NewValidationErrorResponse builds the 400 response listing every field that failed validation
+func NewValidationErrorResponse(message string, errors []ValidationErrorDetail) Response {
+	return Response{
+		Status:  http.StatusBadRequest,
+		Message: message,
+		Data: ValidationErrorData{
+			Errors:        errors,
+			Documentation: DocumentationLink,
+		},
+	}
+}
+
 // BindAndValidate binds the request body to the given object and validates it.
 // If validation fails, it sends a formatted error response and returns false.
 // If validation succeeds, it returns true.
@@ -96,16 +108,7 @@ func BindAndValidate(c *gin.Context, obj interface{}) bool {
 			validationErrors = append(validationErrors, detail)
 		}
 
-		response := Response{
-			Status:  http.StatusBadRequest,
-			Message: "Invalid request parameters",
-			Data: ValidationErrorData{
-				Errors:        validationErrors,
-				Documentation: DocumentationLink,
-			},
-		}
-
-		c.JSON(http.StatusBadRequest, response)
+		c.JSON(http.StatusBadRequest, NewValidationErrorResponse("Invalid request parameters", validationErrors))
 		return false
 	}
 	return true
@@ -46,10 +46,22 @@ func main() {
 	err = database.DB.AutoMigrate(
 		&models.User{},
 		&models.Transaction{},
+		&models.BalanceHold{},
 		&models.AIModel{},
 		&models.Task{},
+		&models.TaskEvent{},
+		&models.TaskArtifact{},
+		&models.TaskBatch{},
+		&models.Pipeline{},
+		&models.WebhookSecret{},
+		&models.WebhookEndpoint{},
+		&models.WebhookDelivery{},
 		&models.PaymentConfig{},
 		&models.PaymentOrderRecord{},
+		&models.ReconciliationReport{},
+		&models.ReconciliationMismatch{},
+		&models.LedgerMigration{},
+		&models.LedgerMigrationEntry{},
 		&models.Prompt{},
 		&models.PromptTemplate{},
 	)
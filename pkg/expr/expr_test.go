@@ -0,0 +1,74 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEval(t *testing.T) {
+	vars := map[string]interface{}{
+		"duration":   5.0,
+		"resolution": "1080p",
+		"n":          2,
+		"audio":      true,
+		"camera":     map[string]interface{}{"moves": 3.0},
+	}
+
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"-duration + 10", 5.0},
+		{"0.1 * duration * n", 1.0},
+		{"7 % 4", 3.0},
+		{"resolution == '1080p' ? 1.5 : 1", 1.5},
+		{`resolution == "720p" ? 1.5 : 1`, 1.0},
+		{"duration > 4 && !audio", false},
+		{"duration > 4 || audio", true},
+		{"camera.moves * 2", 6.0},
+		{"max(1, duration, 3)", 5.0},
+		{"min(duration, 2)", 2.0},
+		{"ceil(duration / 2)", 3.0},
+		{"round(2.5) + floor(1.9) + abs(-1)", 5.0},
+		{"resolution + 'x'", "1080px"},
+		{"a ? 1 : 0", nil},
+		{"false && missing", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			e, err := Compile(tt.src)
+			require.NoError(t, err)
+			got, err := e.Eval(vars)
+			if tt.want == nil {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{"", "1 +", "(1", "1 2", "foo(1)", "min()", "ceil(1, 2)", "'open", "1 # 2", "a ? 1"} {
+		_, err := Compile(src)
+		assert.Error(t, err, src)
+	}
+}
+
+func TestEvalNumber(t *testing.T) {
+	e, _ := Compile("duration / 0")
+	_, err := e.EvalNumber(map[string]interface{}{"duration": 1.0})
+	assert.ErrorContains(t, err, "division by zero")
+
+	e, _ = Compile("resolution")
+	_, err = e.EvalNumber(map[string]interface{}{"resolution": "720p"})
+	assert.Error(t, err)
+
+	e, _ = Compile("base + 0.2 * seconds")
+	assert.Equal(t, []string{"base", "seconds"}, e.Vars())
+}
@@ -0,0 +1,157 @@
+// Package jsonpath evaluates the subset of JSONPath needed to read upstream API
+// responses: the root "$", children ".name" or "['name']", array indexes "[0]"
+// (negative counts from the end) and wildcards "[*]" or ".*".
+package jsonpath
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type stepKind int
+
+const (
+	stepChild stepKind = iota
+	stepIndex
+	stepWildcard
+)
+
+type step struct {
+	kind  stepKind
+	name  string
+	index int
+}
+
+// Path is a compiled JSONPath expression
+type Path struct {
+	raw   string
+	steps []step
+}
+
+// String returns the expression the path was compiled from
+func (p Path) String() string {
+	return p.raw
+}
+
+// Compile parses a JSONPath expression. The leading "$" is optional.
+func Compile(expr string) (Path, error) {
+	p := Path{raw: expr}
+	s := strings.TrimSpace(expr)
+	if s == "" {
+		return p, fmt.Errorf("empty path")
+	}
+	s = strings.TrimPrefix(s, "$")
+
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end == -1 {
+				end = len(s)
+			}
+			name := s[:end]
+			if name == "" {
+				return p, fmt.Errorf("empty name in %q", expr)
+			}
+			if name == "*" {
+				p.steps = append(p.steps, step{kind: stepWildcard})
+			} else {
+				p.steps = append(p.steps, step{kind: stepChild, name: name})
+			}
+			s = s[end:]
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end == -1 {
+				return p, fmt.Errorf("unclosed bracket in %q", expr)
+			}
+			inner := strings.TrimSpace(s[1:end])
+			s = s[end+1:]
+			switch {
+			case inner == "*":
+				p.steps = append(p.steps, step{kind: stepWildcard})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				p.steps = append(p.steps, step{kind: stepChild, name: inner[1 : len(inner)-1]})
+			default:
+				n, err := strconv.Atoi(inner)
+				if err != nil {
+					return p, fmt.Errorf("invalid index %q in %q", inner, expr)
+				}
+				p.steps = append(p.steps, step{kind: stepIndex, index: n})
+			}
+		default:
+			// A bare first name, as in "data.id"
+			if len(p.steps) > 0 {
+				return p, fmt.Errorf("unexpected %q in %q", s[0], expr)
+			}
+			s = "." + s
+		}
+	}
+	return p, nil
+}
+
+// Get returns every value the path matches in a decoded JSON document. Array elements come
+// in order; a decoded object does not keep the order of its members, so a wildcard visits
+// them sorted by key and the result is the same on every call.
+func (p Path) Get(doc interface{}) []interface{} {
+	current := []interface{}{doc}
+	for _, st := range p.steps {
+		var next []interface{}
+		for _, v := range current {
+			switch st.kind {
+			case stepChild:
+				if m, ok := v.(map[string]interface{}); ok {
+					if child, ok := m[st.name]; ok {
+						next = append(next, child)
+					}
+				}
+			case stepIndex:
+				if a, ok := v.([]interface{}); ok {
+					i := st.index
+					if i < 0 {
+						i += len(a)
+					}
+					if i >= 0 && i < len(a) {
+						next = append(next, a[i])
+					}
+				}
+			case stepWildcard:
+				switch c := v.(type) {
+				case []interface{}:
+					next = append(next, c...)
+				case map[string]interface{}:
+					keys := make([]string, 0, len(c))
+					for key := range c {
+						keys = append(keys, key)
+					}
+					sort.Strings(keys)
+					for _, key := range keys {
+						next = append(next, c[key])
+					}
+				}
+			}
+		}
+		current = next
+	}
+	return current
+}
+
+// First returns the first match of the path, if any
+func (p Path) First(doc interface{}) (interface{}, bool) {
+	matches := p.Get(doc)
+	if len(matches) == 0 {
+		return nil, false
+	}
+	return matches[0], true
+}
+
+// Lookup compiles expr and returns its matches in doc
+func Lookup(doc interface{}, expr string) ([]interface{}, error) {
+	p, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return p.Get(doc), nil
+}
@@ -0,0 +1,54 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decode(t *testing.T, s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestLookup(t *testing.T) {
+	doc := decode(t, `{
+		"data": {"task_id": "abc", "n": 7},
+		"videos": [{"video_url": "a.mp4"}, {"video_url": "b.mp4"}, {"other": 1}],
+		"odd key": true,
+		"outputs": {"c": "3.png", "a": "1.png", "b": "2.png"}
+	}`)
+
+	tests := []struct {
+		path string
+		want []interface{}
+	}{
+		{"$.data.task_id", []interface{}{"abc"}},
+		{"data.n", []interface{}{float64(7)}},
+		{"$['data']['task_id']", []interface{}{"abc"}},
+		{"$.videos[*].video_url", []interface{}{"a.mp4", "b.mp4"}},
+		{"$.videos[0].video_url", []interface{}{"a.mp4"}},
+		{"$.videos[-2].video_url", []interface{}{"b.mp4"}},
+		{"$.videos[5].video_url", nil},
+		{"$['odd key']", []interface{}{true}},
+		{"$.outputs.*", []interface{}{"1.png", "2.png", "3.png"}},
+		{"$.missing.deeper", nil},
+		{"$", []interface{}{doc}},
+	}
+	for _, tt := range tests {
+		got, err := Lookup(doc, tt.path)
+		assert.NoError(t, err, tt.path)
+		assert.Equal(t, tt.want, got, tt.path)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, path := range []string{"", "$.", "$.a[", "$.a[x]", "$.a..b"} {
+		_, err := Compile(path)
+		assert.Error(t, err, path)
+	}
+}
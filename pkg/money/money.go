@@ -0,0 +1,263 @@
+// Package money represents amounts of money exactly. An Amount is a whole number of
+// 10^-8 units, the precision of the decimal(20,8) ledger columns, so sums and
+// differences never drift the way float64 does.
+//
+// Wherever precision is lost, when converting a float64 or rounding to fewer decimal
+// places, the result is rounded half away from zero.
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Scale is the number of decimal places an Amount keeps
+const Scale = 8
+
+const unitsPerOne = 100000000
+
+var (
+	// ErrSyntax is returned when a string is not a decimal number
+	ErrSyntax = errors.New("money: invalid amount")
+	// ErrPrecision is returned when an exact amount has more than Scale decimal places
+	ErrPrecision = errors.New("money: more than 8 decimal places")
+	// ErrRange is returned when an amount does not fit
+	ErrRange = errors.New("money: amount out of range")
+)
+
+// Amount is an amount of money in units of 10^-8. The zero value is zero; amounts are
+// added, subtracted and compared with the usual operators.
+type Amount int64
+
+// New returns whole + the given number of 10^-8 units, e.g. New(12, 50000000) is 12.5
+func New(whole int64, units int64) Amount {
+	return Amount(whole*unitsPerOne + units)
+}
+
+// Parse reads a decimal string such as "12.5" or "-0.00000001" exactly. More than
+// Scale decimal places is an error rather than being rounded away.
+func Parse(s string) (Amount, error) {
+	return parse(s, false)
+}
+
+// MustParse is Parse for constants; it panics on invalid input
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// FromFloat converts a float64, rounded to Scale decimal places. The float is read as
+// its shortest decimal representation, so 0.1 becomes exactly 0.1. NaN and infinities
+// are ErrSyntax, floats beyond the range of an Amount ErrRange.
+func FromFloat(f float64) (Amount, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%w: %g", ErrSyntax, f)
+	}
+	return parse(strconv.FormatFloat(f, 'f', -1, 64), true)
+}
+
+// parse reads a plain decimal string; with round, extra decimal places are rounded
+func parse(s string, round bool) (Amount, error) {
+	s = strings.TrimSpace(s)
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" {
+		return 0, fmt.Errorf("%w: %q", ErrSyntax, s)
+	}
+	for _, part := range []string{intPart, fracPart} {
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return 0, fmt.Errorf("%w: %q", ErrSyntax, s)
+			}
+		}
+	}
+
+	roundUp := false
+	if len(fracPart) > Scale {
+		extra := strings.TrimRight(fracPart[Scale:], "0")
+		if extra != "" {
+			if !round {
+				return 0, fmt.Errorf("%w: %q", ErrPrecision, s)
+			}
+			roundUp = extra[0] >= '5'
+		}
+		fracPart = fracPart[:Scale]
+	}
+	fracPart += strings.Repeat("0", Scale-len(fracPart))
+
+	var units uint64
+	for _, c := range intPart + fracPart {
+		if units > (math.MaxInt64-9)/10 {
+			return 0, ErrRange
+		}
+		units = units*10 + uint64(c-'0')
+	}
+	if roundUp {
+		units++
+	}
+	if neg {
+		return -Amount(units), nil
+	}
+	return Amount(units), nil
+}
+
+// Float64 returns the amount as a float64, for ratios and display only
+func (a Amount) Float64() float64 {
+	return float64(a) / unitsPerOne
+}
+
+// Round rounds to the given number of decimal places, 0 to Scale, half away from zero.
+// It returns ErrRange when rounding away from zero leaves the range of an Amount.
+func (a Amount) Round(places int) (Amount, error) {
+	neg, u := a.abs()
+	u = roundUnits(u, places)
+	switch {
+	case neg && u == math.MaxInt64+1:
+		return math.MinInt64, nil
+	case u > math.MaxInt64:
+		return 0, ErrRange
+	case neg:
+		return -Amount(u), nil
+	}
+	return Amount(u), nil
+}
+
+// IsRounded reports whether the amount has at most the given number of decimal places
+func (a Amount) IsRounded(places int) bool {
+	_, u := a.abs()
+	return roundUnits(u, places) == u
+}
+
+// abs returns the sign and magnitude of the amount; the magnitude of the smallest
+// Amount does not fit an int64
+func (a Amount) abs() (bool, uint64) {
+	if a < 0 {
+		return true, uint64(-(a + 1)) + 1
+	}
+	return false, uint64(a)
+}
+
+// roundUnits rounds a magnitude in 10^-8 units to the given number of decimal places,
+// half up. Magnitudes come from an Amount, so the result always fits a uint64.
+func roundUnits(u uint64, places int) uint64 {
+	if places >= Scale {
+		return u
+	}
+	if places < 0 {
+		places = 0
+	}
+	step := uint64(math.Pow10(Scale - places))
+	return (u + step/2) / step * step
+}
+
+// String returns the shortest exact decimal representation, e.g. "12.5" or "0"
+func (a Amount) String() string {
+	return strings.TrimSuffix(strings.TrimRight(a.StringFixed(Scale), "0"), ".")
+}
+
+// StringFixed rounds to the given number of decimal places and always prints that
+// many, e.g. "12.50" for two
+func (a Amount) StringFixed(places int) string {
+	if places > Scale {
+		places = Scale
+	}
+	if places < 0 {
+		places = 0
+	}
+	neg, u := a.abs()
+	u = roundUnits(u, places)
+	sign := ""
+	if neg && u != 0 {
+		sign = "-"
+	}
+	whole := u / unitsPerOne
+	if places == 0 {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+	frac := fmt.Sprintf("%08d", u%unitsPerOne)[:places]
+	return fmt.Sprintf("%s%d.%s", sign, whole, frac)
+}
+
+// MarshalJSON writes the amount as a JSON number with its exact decimal digits
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number or a string holding one, exactly
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	// JSON numbers may use an exponent, which Parse does not read
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrSyntax, s)
+		}
+		v, err := FromFloat(f)
+		if err != nil {
+			return err
+		}
+		*a = v
+		return nil
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// Value stores the amount as an exact decimal string
+func (a Amount) Value() (driver.Value, error) {
+	return a.StringFixed(Scale), nil
+}
+
+// Scan reads a decimal column. Drivers return decimals as strings, except SQLite,
+// which may return integers and floats.
+func (a *Amount) Scan(src interface{}) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+	case int64:
+		if v > math.MaxInt64/unitsPerOne || v < math.MinInt64/unitsPerOne {
+			return ErrRange
+		}
+		*a = Amount(v * unitsPerOne)
+	case float64:
+		*a, err = FromFloat(v)
+	case []byte:
+		*a, err = parse(string(v), true)
+	case string:
+		*a, err = parse(v, true)
+	default:
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+	return err
+}
+
+// GormDataType is the column type of amounts without an explicit type tag
+func (Amount) GormDataType() string {
+	return "decimal(20,8)"
+}
@@ -0,0 +1,126 @@
+package money
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+		err  error
+	}{
+		{"12.5", New(12, 50000000), nil},
+		{"-0.00000001", -1, nil},
+		{"+3", New(3, 0), nil},
+		{".25", New(0, 25000000), nil},
+		{"1.100000000", New(1, 10000000), nil},
+		{"0.000000001", 0, ErrPrecision},
+		{"1e3", 0, ErrSyntax},
+		{"", 0, ErrSyntax},
+		{"99999999999999999999", 0, ErrRange},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if tt.err != nil {
+			assert.ErrorIs(t, err, tt.err, tt.in)
+			continue
+		}
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want Amount
+		err  error
+	}{
+		{0.1 + 0.2, MustParse("0.3"), nil},
+		{1.005, MustParse("1.005"), nil},
+		{1.0 / 3, MustParse("0.33333333"), nil},
+		{0.000000005, MustParse("0.00000001"), nil},
+		{-0.000000005, MustParse("-0.00000001"), nil},
+		{1e30, 0, ErrRange},
+		{-1e30, 0, ErrRange},
+		{math.NaN(), 0, ErrSyntax},
+		{math.Inf(1), 0, ErrSyntax},
+	}
+	for _, tt := range tests {
+		got, err := FromFloat(tt.in)
+		if tt.err != nil {
+			assert.ErrorIs(t, err, tt.err, tt.in)
+			continue
+		}
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+}
+
+func TestRoundAndFormat(t *testing.T) {
+	round := func(a Amount, places int) Amount {
+		r, err := a.Round(places)
+		assert.NoError(t, err)
+		return r
+	}
+	assert.Equal(t, MustParse("1.01"), round(MustParse("1.005"), 2))
+	assert.Equal(t, MustParse("-1.01"), round(MustParse("-1.005"), 2))
+	assert.Equal(t, MustParse("1"), round(round(MustParse("1.00499999"), 2), 0))
+	assert.Equal(t, Amount(math.MinInt64), round(math.MinInt64, Scale))
+	_, err := Amount(math.MaxInt64).Round(2)
+	assert.ErrorIs(t, err, ErrRange)
+	_, err = Amount(math.MinInt64).Round(0)
+	assert.ErrorIs(t, err, ErrRange)
+	assert.False(t, Amount(math.MaxInt64).IsRounded(2))
+	assert.True(t, MustParse("10.5").IsRounded(2))
+	assert.False(t, MustParse("10.505").IsRounded(2))
+
+	assert.Equal(t, "12.5", MustParse("12.50").String())
+	assert.Equal(t, "0", Amount(0).String())
+	assert.Equal(t, "-0.00000001", Amount(-1).String())
+	assert.Equal(t, "12.50", MustParse("12.5").StringFixed(2))
+	assert.Equal(t, "-1.01", MustParse("-1.005").StringFixed(2))
+	assert.Equal(t, "3.00000000", MustParse("3").StringFixed(Scale))
+	assert.Equal(t, "92233720368.55", Amount(math.MaxInt64).StringFixed(2))
+	assert.Equal(t, "0.00", MustParse("-0.001").StringFixed(2))
+}
+
+func TestJSON(t *testing.T) {
+	var v struct {
+		A Amount  `json:"a"`
+		B Amount  `json:"b"`
+		C *Amount `json:"c"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"a": 0.1, "b": "19.99", "c": 2e1}`), &v))
+	assert.Equal(t, MustParse("0.1"), v.A)
+	assert.Equal(t, MustParse("19.99"), v.B)
+	assert.Equal(t, MustParse("20"), *v.C)
+
+	out, err := json.Marshal(v)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"a": 0.1, "b": 19.99, "c": 20}`, string(out))
+
+	assert.ErrorIs(t, json.Unmarshal([]byte(`{"a": 0.123456789}`), &v), ErrPrecision)
+	assert.ErrorIs(t, json.Unmarshal([]byte(`{"a": 1e300}`), &v), ErrRange)
+}
+
+func TestScan(t *testing.T) {
+	var a Amount
+	assert.NoError(t, a.Scan([]byte("100.12345678")))
+	assert.Equal(t, MustParse("100.12345678"), a)
+	assert.NoError(t, a.Scan(int64(7)))
+	assert.Equal(t, MustParse("7"), a)
+	assert.NoError(t, a.Scan(0.3))
+	assert.Equal(t, MustParse("0.3"), a)
+	assert.NoError(t, a.Scan(nil))
+	assert.Equal(t, Amount(0), a)
+
+	v, err := MustParse("-2.5").Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "-2.50000000", v)
+}